@@ -14,24 +14,25 @@ import (
 
 const level string = "level"
 const bolt string = "bolt"
+const badger string = "badger"
 
 func main() {
 	fmt.Println("Usage:")
-	fmt.Println("BlockExtractor level/bolt [ChainID-To-Extract]")
+	fmt.Println("BlockExtractor level/bolt/badger [ChainID-To-Extract]")
 	fmt.Println("Leave out the last one to export basic chains (A, D, EC, F)")
-	if len(os.Args) < 1 {
+	if len(os.Args) < 2 {
 		fmt.Println("\nNot enough arguments passed")
 		os.Exit(1)
 	}
-	if len(os.Args) > 2 {
+	if len(os.Args) > 3 {
 		fmt.Println("\nToo many arguments passed")
 		os.Exit(1)
 	}
 
 	levelBolt := os.Args[1]
 
-	if levelBolt != level && levelBolt != bolt {
-		fmt.Println("\nFirst argument should be `level` or `bolt`")
+	if levelBolt != level && levelBolt != bolt && levelBolt != badger {
+		fmt.Println("\nFirst argument should be `level`, `bolt` or `badger`")
 		os.Exit(1)
 	}
 
@@ -42,21 +43,23 @@ func main() {
 
 	be := new(BlockExtractor)
 
-	state := new(state.State)
-	state.Cfg = util.ReadConfig("")
-	if levelBolt == level {
-		err := state.InitLevelDB()
-		if err != nil {
-			panic(err)
-		}
+	// The database is opened read only, so this can run against a live node.
+	s := new(state.State)
+	s.LoadConfig(util.GetConfigFilename("m2"), "")
+	switch levelBolt {
+	case level:
+		s.DBType = "LDB"
+	case bolt:
+		s.DBType = "Bolt"
+	case badger:
+		s.DBType = "Badger"
 	}
-	if levelBolt == bolt {
-		err := state.InitBoltDB()
-		if err != nil {
-			panic(err)
-		}
+	err := s.InitReadOnlyDB()
+	if err != nil {
+		panic(err)
 	}
-	dbo := state.GetDB()
+	dbo := s.DB
+	defer dbo.Close()
 
 	if chainID != "" {
 		err := be.ExportEChain(chainID, dbo)
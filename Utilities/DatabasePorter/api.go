@@ -100,6 +100,17 @@ func GetDBlockHead() (string, error) {
 	//return "cde346e7ed87957edfd68c432c984f35596f29c7d23de6f279351cddecd5dc66", nil //100
 	//return "d13472838f0156a8773d78af137ca507c91caf7bf3b73124d6b09ebb0a98e4d9", nil //200
 
+	if source != nil {
+		head, err := source.FetchDirectoryBlockHead()
+		if err != nil {
+			return "", err
+		}
+		if head == nil {
+			return "", fmt.Errorf("The source database holds no directory blocks")
+		}
+		return head.GetKeyMR().String(), nil
+	}
+
 	resp, err := http.Get(
 		fmt.Sprintf("http://%s/v1/directory-block-head/", server))
 	if err != nil {
@@ -125,6 +136,9 @@ type Data struct {
 }
 
 func GetRaw(keymr string) ([]byte, error) {
+	if source != nil {
+		return GetRawFromSource(keymr)
+	}
 	resp, err := http.Get(
 		fmt.Sprintf("http://%s/v1/get-raw-data/%s", server, keymr))
 	if err != nil {
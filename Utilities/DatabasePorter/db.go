@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
 	"github.com/FactomProject/factomd/database/databaseOverlay"
 	"github.com/FactomProject/factomd/database/hybridDB"
 	"github.com/FactomProject/factomd/database/mapdb"
@@ -44,3 +45,44 @@ func InitMapDB(cfg *util.FactomdConfig) interfaces.DBOverlay {
 	dbase.Init(nil)
 	return databaseOverlay.NewOverlay(dbase)
 }
+
+// source, if set, is the database of a local node we port the blocks from,
+// instead of the API of server.
+var source interfaces.DBOverlay
+
+// InitSource opens the database of a local node read only, so the node can keep
+// running while we port its blocks.
+func InitSource(dbType string, path string) interfaces.DBOverlay {
+	fmt.Println("InitSource")
+	dbo, err := databaseOverlay.NewReadOnlyOverlay(dbType, path)
+	if err != nil {
+		panic(err)
+	}
+	return dbo
+}
+
+// GetRawFromSource looks the block or entry up in source the way the API's
+// get-raw-data does.
+func GetRawFromSource(keymr string) ([]byte, error) {
+	h, err := primitives.HexToHash(keymr)
+	if err != nil {
+		return nil, err
+	}
+	fetches := []func(interfaces.IHash) (interfaces.BinaryMarshallable, error){
+		func(h interfaces.IHash) (interfaces.BinaryMarshallable, error) { return source.FetchFBlockByKeyMR(h) },
+		func(h interfaces.IHash) (interfaces.BinaryMarshallable, error) { return source.FetchDBlockByKeyMR(h) },
+		func(h interfaces.IHash) (interfaces.BinaryMarshallable, error) { return source.FetchABlockByKeyMR(h) },
+		func(h interfaces.IHash) (interfaces.BinaryMarshallable, error) { return source.FetchEBlockByKeyMR(h) },
+		func(h interfaces.IHash) (interfaces.BinaryMarshallable, error) {
+			return source.FetchECBlockByHeaderHash(h)
+		},
+		func(h interfaces.IHash) (interfaces.BinaryMarshallable, error) { return source.FetchEntryByHash(h) },
+		func(h interfaces.IHash) (interfaces.BinaryMarshallable, error) { return source.FetchECBlockByHash(h) },
+	}
+	for _, fetch := range fetches {
+		if block, _ := fetch(h); block != nil {
+			return block.MarshalBinary()
+		}
+	}
+	return nil, fmt.Errorf("%s not found", keymr)
+}
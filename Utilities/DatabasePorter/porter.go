@@ -5,6 +5,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 
 	"github.com/FactomProject/factomd/common/interfaces"
@@ -14,8 +15,17 @@ import (
 func main() {
 	fmt.Println("DatabasePorter")
 
+	sourceType := flag.String("sourcetype", "LDB", "Type of the source database (LDB, Bolt or Badger)")
+	sourceDB := flag.String("sourcedb", "", "Port from the database at this path, read only, instead of from the API")
+	flag.Parse()
+
 	cfg := util.ReadConfig("", "")
 
+	if *sourceDB != "" {
+		source = InitSource(*sourceType, *sourceDB)
+		defer source.Close()
+	}
+
 	var dbo interfaces.DBOverlay
 
 mainloop:
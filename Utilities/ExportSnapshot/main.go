@@ -0,0 +1,51 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/FactomProject/factomd/state"
+	"github.com/FactomProject/factomd/util"
+)
+
+func main() {
+	fmt.Println("Usage:")
+	fmt.Println("ExportSnapshot height snapshot-file")
+	fmt.Println("Writes every block up to height to the snapshot file, signed with the LocalServerPrivKey")
+	fmt.Println("of factomd.conf.  Start a new node with -snapshot=snapshot-file to bootstrap from it.")
+	if len(os.Args) != 3 {
+		fmt.Println("\nExpected two arguments")
+		os.Exit(1)
+	}
+
+	height, err := strconv.Atoi(os.Args[1])
+	if err != nil || height < 0 {
+		fmt.Println("\nFirst argument should be a block height")
+		os.Exit(1)
+	}
+
+	// The database is opened read only, so this can run against a live node.
+	s := new(state.State)
+	s.LoadConfig(util.GetConfigFilename("m2"), "")
+	if err := s.InitReadOnly(); err != nil {
+		panic(err)
+	}
+	defer s.DB.Close()
+
+	file, err := os.Create(os.Args[2])
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	err = s.ExportSnapshot(uint32(height), file)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Exported %d Directory Blocks to %s\n", height+1, os.Args[2])
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/FactomProject/factomd/engine"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("LoadGenerator [-s localhost:8088] [-rate 10] [-time 60] [-wait 60] [-mix 6,1,3] [-keys 4]")
+	fmt.Println("Puts load on a factomd on a LOCAL network through its API: commit/reveal pairs")
+	fmt.Println("that start chains or add entries to them, and factoid transactions, from keys")
+	fmt.Println("it funds from the genesis address.  It then reports the throughput, and how")
+	fmt.Println("long the load took to be acknowledged and to be saved in a directory block.")
+	fmt.Println()
+	flag.PrintDefaults()
+}
+
+func main() {
+	server := flag.String("s", "localhost:8088", "Address of the factomd API")
+	rate := flag.Float64("rate", 10, "Submissions a second; a commit and its reveal are one")
+	seconds := flag.Int("time", 60, "Seconds to submit for")
+	wait := flag.Int("wait", 60, "Seconds to wait after the last submission for the load to be saved")
+	mix := flag.String("mix", "6,1,3", "Weights of entries, chains and factoid transactions in the load")
+	keys := flag.Uint64("keys", 4, "Number of keys to spread the load over")
+	flag.Usage = usage
+	flag.Parse()
+	if len(flag.Args()) != 0 || *rate <= 0 || *keys == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	load := engine.NewLoadGenerator(&engine.WSAPISubmitter{Server: *server}, *rate, time.Duration(*seconds)*time.Second)
+	load.Keys = *keys
+	if _, err := fmt.Sscanf(*mix, "%d,%d,%d", &load.Entries, &load.Chains, &load.Transactions); err != nil ||
+		load.Entries < 0 || load.Chains < 0 || load.Transactions < 0 || load.Entries+load.Chains+load.Transactions == 0 {
+		fmt.Println("Bad -mix:", *mix)
+		os.Exit(1)
+	}
+
+	report, err := load.Run(time.Duration(*wait) * time.Second)
+	fmt.Print(report)
+	if err != nil {
+		os.Exit(1)
+	}
+}
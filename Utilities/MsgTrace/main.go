@@ -0,0 +1,97 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/FactomProject/factomd/engine"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("MsgTrace [-chrome file] trace-file [hash]")
+	fmt.Println("Reads a trace written by factomd -trace.  Given the hash of a message, or a prefix of it,")
+	fmt.Println("shows each hop of the message with its latency, and a Mermaid sequence diagram of each")
+	fmt.Println("minute it was seen in.  Otherwise lists every message, with how long it took to be")
+	fmt.Println("acknowledged, processed and saved in a block.")
+	fmt.Println()
+	fmt.Println("  -chrome file    Also write the trace to file in the format of chrome://tracing")
+}
+
+func main() {
+	chrome := flag.String("chrome", "", "Write the trace in the format of chrome://tracing to this file")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 || 2 < len(args) {
+		usage()
+		os.Exit(1)
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	events, err := engine.ReadTrace(file)
+	file.Close()
+	if err != nil {
+		fmt.Println(args[0]+":", err)
+		os.Exit(1)
+	}
+
+	if *chrome != "" {
+		out, err := os.Create(*chrome)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := engine.WriteChromeTrace(out, events); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		out.Close()
+	}
+
+	if len(args) == 2 {
+		hops := engine.FollowMessage(events, args[1])
+		if len(hops) == 0 {
+			fmt.Println("No message with hash", args[1])
+			os.Exit(1)
+		}
+		fmt.Printf("%12s %12s %-10s %-10s %-10s %-20s %8s\n", "Since", "Latency", "Node", "Stage", "Peer", "Type", "Minute")
+		for _, h := range hops {
+			fmt.Printf("%12s %12s %-10s %-10s %-10s %-20s %5d:%d\n", h.Since, h.Latency, h.Node, h.Stage, h.Peer, h.Type, h.Height, h.Minute)
+		}
+		fmt.Println()
+		fmt.Print(engine.SequenceDiagrams(hops))
+		return
+	}
+
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if !seen[e.Hash] {
+			seen[e.Hash] = true
+			hashes = append(hashes, e.Hash)
+		}
+	}
+	fmt.Printf("%-16s %-20s %5s %12s %12s %12s\n", "Hash", "Type", "Nodes", "Ack", "Processed", "Block")
+	for _, hash := range hashes {
+		s := engine.SummarizeMessage(engine.FollowMessage(events, hash))
+		fmt.Printf("%-16.16s %-20s %5d %12s %12s %12s\n", s.Hash, s.Type, s.Nodes, never(s.Ack), never(s.Processed), never(s.Block))
+	}
+}
+
+// never shows a stage the message never reached as "-".
+func never(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.String()
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/FactomProject/factomd/engine"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("Orchestrator [-factomd factomd] [-count 3] [-dir orchestrator] [-net long] [-port 9000] [-control addr] [-- factomd flags]")
+	fmt.Println("Runs a network of factomd processes on this machine, a node to each, talking")
+	fmt.Println("over real p2p.  Each node gets a folder of -dir with its config, database and")
+	fmt.Println("factomd.log; every node's output also goes to all.log there.  Node i serves the")
+	fmt.Println("WSAPI on -port+10*i, p2p on the port after, and the control API on the next.")
+	fmt.Println("Flags after -- are passed to every node, e.g. -- -blktime=10 -db=LDB")
+	fmt.Println()
+	fmt.Println("Commands, followed by <enter>:")
+	fmt.Println("  s    Show the summary of the nodes")
+	fmt.Println("  v    Show the first height where the nodes' blocks diverge")
+	fmt.Println("  q    Stop the nodes and quit")
+	fmt.Println()
+	flag.PrintDefaults()
+}
+
+func main() {
+	binary := flag.String("factomd", "factomd", "The factomd to run")
+	count := flag.Int("count", 3, "The number of nodes")
+	dir := flag.String("dir", "orchestrator", "Directory to put the nodes' folders in")
+	net := flag.String("net", "long", "How to connect the nodes: "+strings.Join(engine.Topologies, ", "))
+	netFile := flag.String("netfile", "", "Connect the nodes as this file says, each line the indexes of two nodes, rather than by -net")
+	degree := flag.Int("degree", 4, "Connections of each node, for the kregular and smallworld networks")
+	rewire := flag.Float64("rewire", 0.1, "Chance each connection of a smallworld network is moved to a random node")
+	port := flag.Int("port", 9000, "Port of node 0's WSAPI; the other ports follow it")
+	control := flag.String("control", "", "Address to serve the nodes' status and divergence on, e.g. localhost:8099.  Off if not set.")
+	watch := flag.Int("watch", 5, "Seconds between checks that the nodes' blocks agree; 0 to only check with v")
+	flag.Usage = usage
+	flag.Parse()
+	if *count < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	var topology *engine.Topology
+	var err error
+	if *netFile != "" {
+		topology, err = engine.LoadTopology(*netFile, *count)
+	} else {
+		topology, err = engine.MakeTopology(*net, *count, *degree, *rewire, rand.New(rand.NewSource(time.Now().UnixNano())))
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	o, err := engine.NewOrchestrator(*binary, *dir, topology, *port)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	o.Args = flag.Args()
+	if err := os.MkdirAll(o.Dir, 0755); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	log, err := os.Create(filepath.Join(o.Dir, "all.log"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer log.Close()
+	o.Log = log
+
+	fmt.Printf("Using %s Network: %s\n", topology.Name, topology.Stats())
+	for i, n := range o.Nodes {
+		fmt.Printf("%2d %-10s wsapi %d p2p %d control %s peers %v\n", i, n.Name, n.Port, n.P2PPort, n.Control, n.Peers)
+	}
+	if err := o.Start(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Logs in", o.Dir)
+
+	if *control != "" {
+		go func() {
+			fmt.Println("Control API:", http.ListenAndServe(*control, o.Handler()))
+		}()
+	}
+	if 0 < *watch {
+		go o.Watch(time.Duration(*watch) * time.Second)
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		switch strings.TrimSpace(in.Text()) {
+		case "s":
+			fmt.Print(o.Summary())
+		case "v":
+			status := o.CheckDivergence()
+			if status.Divergence == nil {
+				fmt.Println("No divergence found")
+			} else {
+				fmt.Print(status.Divergence.String() + "\n" + status.Report)
+			}
+			for name, d := range status.Peers {
+				fmt.Printf("%s found %s\n", name, d.String())
+			}
+		case "q":
+			o.Stop(10 * time.Second)
+			return
+		default:
+			usage()
+		}
+	}
+	// Stdin closed; run until interrupted.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	o.Stop(10 * time.Second)
+}
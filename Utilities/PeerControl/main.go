@@ -0,0 +1,122 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/p2p"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("PeerControl [-s localhost:8088] command [argument]")
+	fmt.Println("Manages the peers of a running factomd through its API.")
+	fmt.Println()
+	fmt.Println("  list                    List connections")
+	fmt.Println("  dial address            Connect to a peer, eg 10.0.0.1:8108 or [2001:db8::1]:8108")
+	fmt.Println("  disconnect peer         Disconnect a peer, by hash or address")
+	fmt.Println("  ban peer                Disconnect and ban a peer, by hash or address")
+	fmt.Println("  unban peer              Lift the ban on a peer, by hash or address")
+	fmt.Println("  loglevel level          Set the p2p log level: silence, fatal, errors, notes, debugging or verbose")
+	fmt.Println()
+	fmt.Println("Only list works from another machine; factomd serves the other commands to localhost only.")
+}
+
+var commands = map[string]string{
+	"list":       "peers",
+	"dial":       "peer-dial",
+	"disconnect": "peer-disconnect",
+	"ban":        "peer-ban",
+	"unban":      "peer-unban",
+	"loglevel":   "network-log-level",
+}
+
+func main() {
+	server := flag.String("s", "localhost:8088", "Address of the factomd API")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+	method, ok := commands[args[0]]
+	if !ok || (args[0] == "list") != (len(args) == 1) || 2 < len(args) {
+		usage()
+		os.Exit(1)
+	}
+
+	var params interface{}
+	switch args[0] {
+	case "list":
+	case "loglevel":
+		params = map[string]string{"level": args[1]}
+	default:
+		params = map[string]string{"peer": args[1]}
+	}
+
+	result, err := call(*server, method, params)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if args[0] != "list" {
+		response := new(struct {
+			Message string `json:"message"`
+		})
+		json.Unmarshal(result, response)
+		fmt.Println(response.Message)
+		return
+	}
+	response := new(struct {
+		Connections []p2p.ConnectionMetrics `json:"connections"`
+	})
+	if err := json.Unmarshal(result, response); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%-46s %-12s %8s %12s %12s %10s  %s\n", "Address", "State", "Quality", "Bytes in", "Bytes out", "Last msg", "Hash")
+	for _, c := range response.Connections {
+		last := "never"
+		if !c.LastMessage.IsZero() {
+			last = time.Since(c.LastMessage).Truncate(time.Second).String()
+		}
+		fmt.Printf("%-46s %-12s %8d %12d %12d %10s  %s\n", c.Address, c.State, c.QualityScore, c.BytesReceived, c.BytesSent, last, c.PeerHash)
+	}
+}
+
+// call makes a JSON-RPC call to the v2 API, and returns its result.
+func call(server string, method string, params interface{}) (json.RawMessage, error) {
+	request := primitives.NewJSON2Request(method, 0, params)
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(fmt.Sprintf("http://%s/v2", server), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := new(struct {
+		Error  *primitives.JSONError `json:"error"`
+		Result json.RawMessage       `json:"result"`
+	})
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("%s: %v", response.Error.Message, response.Error.Data)
+	}
+	return response.Result, nil
+}
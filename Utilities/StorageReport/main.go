@@ -0,0 +1,69 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/FactomProject/factomd/state"
+	"github.com/FactomProject/factomd/util"
+)
+
+const level string = "level"
+const bolt string = "bolt"
+const badger string = "badger"
+
+func main() {
+	fmt.Println("Usage:")
+	fmt.Println("StorageReport level/bolt/badger")
+	fmt.Println("Prints the key count, value bytes and average value size of every database table.")
+	fmt.Println("A running factomd reports the same with the storage-usage API call, made on its own machine.")
+	if len(os.Args) != 2 {
+		fmt.Println("\nExpected one argument")
+		os.Exit(1)
+	}
+
+	levelBolt := os.Args[1]
+
+	if levelBolt != level && levelBolt != bolt && levelBolt != badger {
+		fmt.Println("\nFirst argument should be `level`, `bolt` or `badger`")
+		os.Exit(1)
+	}
+
+	// The database is opened read only, so this can run against a live node.
+	s := new(state.State)
+	s.LoadConfig(util.GetConfigFilename("m2"), "")
+	switch levelBolt {
+	case level:
+		s.DBType = "LDB"
+	case bolt:
+		s.DBType = "Bolt"
+	case badger:
+		s.DBType = "Badger"
+	}
+	err := s.InitReadOnlyDB()
+	if err != nil {
+		panic(err)
+	}
+	dbo := s.DB
+	defer dbo.Close()
+
+	buckets, err := dbo.GetStorageReport()
+	if err != nil {
+		panic(err)
+	}
+
+	var keys int
+	var bytes int64
+	fmt.Println()
+	fmt.Printf("%-26s %12s %16s %12s\n", "Table", "Keys", "Value bytes", "Average")
+	for _, b := range buckets {
+		fmt.Printf("%-26s %12d %16d %12.1f\n", b.Name, b.Keys, b.ValueBytes, b.AverageSize)
+		keys += b.Keys
+		bytes += b.ValueBytes
+	}
+	fmt.Printf("%-26s %12d %16d\n", "Total", keys, bytes)
+}
@@ -19,7 +19,7 @@ var _ interfaces.IABEntry = (*AddFederatedServerSigningKey)(nil)
 var _ interfaces.BinaryMarshallable = (*AddFederatedServerSigningKey)(nil)
 
 func (c *AddFederatedServerSigningKey) UpdateState(state interfaces.IState) {
-
+	state.SetServerSigningKey(c.IdentityChainID, c.PublicKey[:])
 }
 
 // Create a new DB Signature Entry
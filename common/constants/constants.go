@@ -33,6 +33,9 @@ const (
 	DBSTATE_MSG         // 19
 	DBSTATE_MISSING_MSG // 20
 	ADDSERVER_MSG       // 21
+	INVENTORY_MSG       // 22
+	GET_DATA_MSG        // 23
+	BLOCK_DIGEST_MSG    // 24
 )
 
 const (
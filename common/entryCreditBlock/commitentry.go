@@ -104,7 +104,7 @@ func (c *CommitEntry) GetMilliTime() int64 {
 // InTime checks the CommitEntry.MilliTime and returns true if the timestamp is
 // whitin +/- 12 hours of the current time.
 func (c *CommitEntry) InTime() bool {
-	now := time.Now()
+	now := interfaces.Now()
 	sec := c.GetMilliTime() / 1000
 	t := time.Unix(sec, 0)
 
@@ -182,6 +182,9 @@ type DBOverlay interface {
 
 	FetchPaidFor(hash IHash) (IHash, error)
 
+	SaveChainScopeHeight(chainID IHash, height uint32) error
+	FetchChainScopeHeight(chainID IHash) (uint32, error)
+
 	FetchFactoidTransactionByHash(hash IHash) (ITransaction, error)
 	FetchECTransactionByHash(hash IHash) (IECBlockEntry, error)
 }
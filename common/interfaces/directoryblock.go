@@ -32,6 +32,8 @@ type IDirectoryBlockHeader interface {
 
 	GetVersion() byte
 	SetVersion(byte)
+	GetNetworkID() uint32
+	SetNetworkID(uint32)
 	GetFullHash() IHash
 	SetFullHash(IHash)
 	GetPrevFullHash() IHash
@@ -29,6 +29,20 @@ type IMsg interface {
 	GetOrigin() int
 	SetOrigin(int)
 
+	// Returns the hash of the network peer this message came from, so the
+	// network can be told how the peer behaves.  "" if the message did not
+	// come from the p2p network.  For a peer to peer message going out, it is
+	// the network peer to send it to; "" sends it to the whole network.
+	GetNetworkOrigin() string
+	SetNetworkOrigin(string)
+
+	// Returns the identity chain of the federated server whose signing key
+	// the network peer this message came from authenticated with in the p2p
+	// handshake.  nil if the peer is not a federated server, or the message
+	// did not come from the p2p network.
+	GetNetworkIdentity() IHash
+	SetNetworkIdentity(IHash)
+
 	// Returns the timestamp for a message
 	GetTimestamp() Timestamp
 
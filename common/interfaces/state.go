@@ -27,6 +27,8 @@ type IState interface {
 	GetDBHeightComplete() uint32
 	GetEBDBHeightComplete() uint32
 	SetEBDBHeightComplete(uint32)
+	IsChainScoped() bool               // True if this node only syncs the EBlocks and entries of some chains
+	IsChainInScope(chainID IHash) bool // False if this node doesn't sync the EBlocks and entries of the chain
 	DatabaseContains(hash IHash) bool
 	SetOut(bool)  // Output is turned on if set to true
 	GetOut() bool // Return true if Print or Println write output
@@ -40,6 +42,8 @@ type IState interface {
 	AddPrefix(string)
 	AddFedServer(uint32, IHash) int
 	GetFedServers(uint32) []IFctServer
+	SetServerSigningKey(identityChainID IHash, key []byte) // Signing key an Admin Block gives a server identity
+	GetFederatedServerByKey(key []byte) IHash              // Federated server signing with key, nil if none; safe from any goroutine
 	AddAuditServer(uint32, IHash) int
 	GetAuditServers(uint32) []IFctServer
 
@@ -120,6 +124,7 @@ type IState interface {
 	// Database
 	GetAndLockDB() DBOverlay
 	UnlockDB()
+	OpenReadOnlyDB() (DBOverlay, error) // A snapshot of the database, closed by the caller
 
 	// Web Services
 	// ============
@@ -142,6 +147,7 @@ type IState interface {
 	FollowerExecuteAck(m IMsg) (bool, error) // Ack Msg calls this function.
 	FollowerExecuteDBState(IMsg) error       // Add the given DBState to this server
 	FollowerExecuteAddData(m IMsg) error     // Add the entry or eblock to this Server
+	FollowerExecuteBlockDigest(m IMsg) error // Compare a peer's blocks with ours
 
 	ProcessAddServer(dbheight uint32, addServerMsg IMsg) bool
 	ProcessCommitChain(dbheight uint32, commitChain IMsg) bool
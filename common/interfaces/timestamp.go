@@ -10,12 +10,16 @@ import (
 	"time"
 )
 
+// Now is where factomd gets the time.  The simulator replaces it with a virtual
+// clock shared by all of its nodes, so a run can be repeated exactly.
+var Now = time.Now
+
 func GetTimeMilli() uint64 {
-	return uint64(time.Now().UnixNano()) / 1000000 // 10^-9 >> 10^-3
+	return uint64(Now().UnixNano()) / 1000000 // 10^-9 >> 10^-3
 }
 
 func GetTime() uint64 {
-	return uint64(time.Now().Unix())
+	return uint64(Now().Unix())
 }
 
 //A structure for handling timestamps for messages
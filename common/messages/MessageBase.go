@@ -10,9 +10,11 @@ import (
 )
 
 type MessageBase struct {
-	Origin    int  // Set and examined on a server, not marshaled with the message
-	Peer2Peer bool // The nature of this message type, not marshaled with the message
-	LocalOnly bool // This message is only a local message, is not broadcasted and may skip verification
+	Origin          int              // Set and examined on a server, not marshaled with the message
+	NetworkOrigin   string           // Hash of the p2p peer this came from, or a peer to peer message goes to; not marshaled
+	NetworkIdentity interfaces.IHash // Federated server the p2p peer this came from authenticated as, nil if none; not marshaled
+	Peer2Peer       bool             // The nature of this message type, not marshaled with the message
+	LocalOnly       bool             // This message is only a local message, is not broadcasted and may skip verification
 
 	LeaderChainID interfaces.IHash
 	MsgHash       interfaces.IHash // Cash of the hash of a message
@@ -29,6 +31,22 @@ func (m *MessageBase) SetOrigin(o int) {
 	m.Origin = o
 }
 
+func (m *MessageBase) GetNetworkIdentity() interfaces.IHash {
+	return m.NetworkIdentity
+}
+
+func (m *MessageBase) SetNetworkIdentity(identity interfaces.IHash) {
+	m.NetworkIdentity = identity
+}
+
+func (m *MessageBase) GetNetworkOrigin() string {
+	return m.NetworkOrigin
+}
+
+func (m *MessageBase) SetNetworkOrigin(peerHash string) {
+	m.NetworkOrigin = peerHash
+}
+
 // Returns true if this is a response to a peer to peer
 // request.
 func (m *MessageBase) IsPeer2Peer() bool {
@@ -0,0 +1,300 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/FactomProject/factomd/common/constants"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
+)
+
+// A node broadcasts a BlockDigestMsg when it saves a directory block: the hashes
+// of the blocks it saved at that height, and of the messages each VM of its
+// process list acknowledged.  A node that has saved the same height compares
+// the digest with its own, so nodes that diverge are caught at the first
+// block they disagree on.  Digests are signed, and only those of the federated
+// servers are taken.
+type BlockDigestMsg struct {
+	MessageBase
+	Timestamp interfaces.Timestamp
+
+	DBHeight uint32
+	Identity interfaces.IHash   // Identity chain of the node that saved the blocks
+	KeyMR    interfaces.IHash   // Directory block
+	ABHash   interfaces.IHash   // Admin block
+	FBHash   interfaces.IHash   // Factoid block
+	ECHash   interfaces.IHash   // Entry credit block
+	VMs      []interfaces.IHash // Of the messages in each VM, if the process list was still held
+
+	Signature interfaces.IFullSignature
+
+	//Not marshalled
+	hash interfaces.IHash
+}
+
+var _ interfaces.IMsg = (*BlockDigestMsg)(nil)
+var _ Signable = (*BlockDigestMsg)(nil)
+
+func (a *BlockDigestMsg) IsSameAs(b *BlockDigestMsg) bool {
+	if b == nil {
+		return false
+	}
+	if a.Timestamp != b.Timestamp || a.DBHeight != b.DBHeight || len(a.VMs) != len(b.VMs) {
+		return false
+	}
+	hashes := []interfaces.IHash{a.Identity, a.KeyMR, a.ABHash, a.FBHash, a.ECHash}
+	others := []interfaces.IHash{b.Identity, b.KeyMR, b.ABHash, b.FBHash, b.ECHash}
+	hashes = append(hashes, a.VMs...)
+	others = append(others, b.VMs...)
+	for i := range hashes {
+		if hashes[i] == nil || others[i] == nil {
+			if hashes[i] != others[i] {
+				return false
+			}
+			continue
+		}
+		if !hashes[i].IsSameAs(others[i]) {
+			return false
+		}
+	}
+
+	if a.Signature == nil && b.Signature != nil {
+		return false
+	}
+	if a.Signature != nil {
+		if a.Signature.IsSameAs(b.Signature) == false {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *BlockDigestMsg) Process(uint32, interfaces.IState) bool {
+	return true
+}
+
+func (m *BlockDigestMsg) GetHash() interfaces.IHash {
+	if m.hash == nil {
+		data, err := m.MarshalForSignature()
+		if err != nil {
+			panic(fmt.Sprintf("Error in BlockDigestMsg.GetHash(): %s", err.Error()))
+		}
+		m.hash = primitives.Sha(data)
+	}
+	return m.hash
+}
+
+func (m *BlockDigestMsg) GetMsgHash() interfaces.IHash {
+	if m.MsgHash == nil {
+		data, err := m.MarshalForSignature()
+		if err != nil {
+			return nil
+		}
+		m.MsgHash = primitives.Sha(data)
+	}
+	return m.MsgHash
+}
+
+func (m *BlockDigestMsg) GetTimestamp() interfaces.Timestamp {
+	return m.Timestamp
+}
+
+func (m *BlockDigestMsg) Type() byte {
+	return constants.BLOCK_DIGEST_MSG
+}
+
+func (m *BlockDigestMsg) Int() int {
+	return -1
+}
+
+func (m *BlockDigestMsg) Bytes() []byte {
+	return nil
+}
+
+func (m *BlockDigestMsg) UnmarshalBinaryData(data []byte) (newData []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Error unmarshalling Block Digest Message: %v", r)
+		}
+	}()
+	newData = data
+	if newData[0] != m.Type() {
+		return nil, fmt.Errorf("Invalid Message type")
+	}
+	newData = newData[1:]
+
+	newData, err = m.Timestamp.UnmarshalBinaryData(newData)
+	if err != nil {
+		return nil, err
+	}
+
+	m.DBHeight, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+
+	for _, h := range []*interfaces.IHash{&m.Identity, &m.KeyMR, &m.ABHash, &m.FBHash, &m.ECHash} {
+		*h = primitives.NewHash(constants.ZERO_HASH)
+		if newData, err = (*h).UnmarshalBinaryData(newData); err != nil {
+			return nil, err
+		}
+	}
+
+	var count uint32
+	count, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+	if count > 64 {
+		return nil, fmt.Errorf("Too many VMs in Block Digest Message: %d", count)
+	}
+	m.VMs = nil
+	for i := uint32(0); i < count; i++ {
+		h := primitives.NewHash(constants.ZERO_HASH)
+		if newData, err = h.UnmarshalBinaryData(newData); err != nil {
+			return nil, err
+		}
+		m.VMs = append(m.VMs, h)
+	}
+
+	if len(newData) > 0 {
+		sig := new(primitives.Signature)
+		if newData, err = sig.UnmarshalBinaryData(newData); err != nil {
+			return nil, err
+		}
+		m.Signature = sig
+	}
+
+	return newData, nil
+}
+
+func (m *BlockDigestMsg) UnmarshalBinary(data []byte) error {
+	_, err := m.UnmarshalBinaryData(data)
+	return err
+}
+
+func (m *BlockDigestMsg) MarshalForSignature() ([]byte, error) {
+	var buf primitives.Buffer
+	buf.Write([]byte{m.Type()})
+	if d, err := m.Timestamp.MarshalBinary(); err != nil {
+		return nil, err
+	} else {
+		buf.Write(d)
+	}
+
+	binary.Write(&buf, binary.BigEndian, m.DBHeight)
+
+	for _, h := range []interfaces.IHash{m.Identity, m.KeyMR, m.ABHash, m.FBHash, m.ECHash} {
+		if h == nil {
+			h = primitives.NewZeroHash()
+		}
+		if d, err := h.MarshalBinary(); err != nil {
+			return nil, err
+		} else {
+			buf.Write(d)
+		}
+	}
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(m.VMs)))
+	for _, h := range m.VMs {
+		if d, err := h.MarshalBinary(); err != nil {
+			return nil, err
+		} else {
+			buf.Write(d)
+		}
+	}
+
+	return buf.DeepCopyBytes(), nil
+}
+
+func (m *BlockDigestMsg) MarshalBinary() ([]byte, error) {
+	var buf primitives.Buffer
+	resp, err := m.MarshalForSignature()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(resp)
+
+	sig := m.GetSignature()
+	if sig != nil {
+		sigBytes, err := sig.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(sigBytes)
+	}
+	return buf.DeepCopyBytes(), nil
+}
+
+func (m *BlockDigestMsg) String() string {
+	return fmt.Sprintf("BlockDigest: %d KeyMR %x from %x", m.DBHeight, m.KeyMR.Bytes()[:3], m.Identity.Bytes()[:3])
+}
+
+// Validate the message, given the state.  Three possible results:
+//
+//	< 0 -- Message is invalid.  Discard
+//	0   -- Cannot tell if message is Valid
+//	1   -- Message is valid
+func (m *BlockDigestMsg) Validate(state interfaces.IState) int {
+	if m.KeyMR == nil || m.Identity == nil {
+		return -1
+	}
+	// Only digests from federated servers are valid.
+	if found, _ := state.GetVirtualServers(state.GetLeaderHeight(), 9, m.Identity); !found {
+		return -1
+	}
+	signed, err := m.VerifySignature()
+	if err != nil || !signed {
+		return -1
+	}
+	return 1
+}
+
+// Returns true if this is a message for this server to execute as
+// a leader.
+func (m *BlockDigestMsg) Leader(state interfaces.IState) bool {
+	return false
+}
+
+// Execute the leader functions of the given message
+func (m *BlockDigestMsg) LeaderExecute(state interfaces.IState) error {
+	return nil
+}
+
+// Returns true if this is a message for this server to execute as a follower
+func (m *BlockDigestMsg) Follower(interfaces.IState) bool {
+	return true
+}
+
+func (m *BlockDigestMsg) FollowerExecute(state interfaces.IState) error {
+	return state.FollowerExecuteBlockDigest(m)
+}
+
+func (m *BlockDigestMsg) Sign(key interfaces.Signer) error {
+	signature, err := SignSignable(m, key)
+	if err != nil {
+		return err
+	}
+	m.Signature = signature
+	return nil
+}
+
+func (m *BlockDigestMsg) GetSignature() interfaces.IFullSignature {
+	return m.Signature
+}
+
+func (m *BlockDigestMsg) VerifySignature() (bool, error) {
+	return VerifyMessage(m)
+}
+
+func (e *BlockDigestMsg) JSONByte() ([]byte, error) {
+	return primitives.EncodeJSON(e)
+}
+
+func (e *BlockDigestMsg) JSONString() (string, error) {
+	return primitives.EncodeJSONString(e)
+}
+
+func (e *BlockDigestMsg) JSONBuffer(b *bytes.Buffer) error {
+	return primitives.EncodeJSONToBuffer(e, b)
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package messages_test
+
+import (
+	"testing"
+
+	"github.com/FactomProject/factomd/common/constants"
+	"github.com/FactomProject/factomd/common/interfaces"
+	. "github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/common/primitives"
+)
+
+func TestMarshalUnmarshalBlockDigestMsg(t *testing.T) {
+	msg := newSignedBlockDigestMsg()
+
+	hex, err := msg.MarshalBinary()
+	if err != nil {
+		t.Error(err)
+	}
+	t.Logf("Marshalled - %x", hex)
+
+	msg2, err := UnmarshalMessage(hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	str := msg2.String()
+	t.Logf("str - %v", str)
+
+	if msg2.Type() != constants.BLOCK_DIGEST_MSG {
+		t.Error("Invalid message type unmarshalled")
+	}
+
+	hex2, err := msg2.(*BlockDigestMsg).MarshalBinary()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(hex) != len(hex2) {
+		t.Error("Hexes aren't of identical length")
+	}
+	for i := range hex {
+		if hex[i] != hex2[i] {
+			t.Error("Hexes do not match")
+		}
+	}
+
+	if msg.IsSameAs(msg2.(*BlockDigestMsg)) != true {
+		t.Errorf("Block Digest messages are not identical")
+	}
+
+	msg2.(*BlockDigestMsg).VMs[1] = primitives.Sha([]byte("other"))
+	if msg.IsSameAs(msg2.(*BlockDigestMsg)) {
+		t.Errorf("Block Digest messages with different VMs are identical")
+	}
+
+	if _, err := UnmarshalMessage(hex[:len(hex)-1]); err == nil {
+		t.Errorf("A truncated Block Digest message unmarshalled")
+	}
+}
+
+func TestSignAndVerifyBlockDigestMsg(t *testing.T) {
+	msg := newSignedBlockDigestMsg()
+	hex, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg2, err := UnmarshalMessage(hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := msg2.(*BlockDigestMsg).VerifySignature()
+	if err != nil || !valid {
+		t.Errorf("Signature is not valid: %v", err)
+	}
+
+	// A digest changed after it was signed doesn't verify.
+	msg2.(*BlockDigestMsg).KeyMR = primitives.Sha([]byte("other"))
+	if valid, _ := msg2.(*BlockDigestMsg).VerifySignature(); valid {
+		t.Errorf("Signature of a changed digest is valid")
+	}
+}
+
+func newSignedBlockDigestMsg() *BlockDigestMsg {
+	msg := newBlockDigestMsg()
+	key, err := primitives.NewPrivateKeyFromHex("07c0d52cb74f4ca3106d80c4a70488426886bccc6ebc10c6bafb37bf8a65f4c38cee85c62a9e48039d4ac294da97943c2001be1539809ea5f54721f0c5477a0a")
+	if err != nil {
+		panic(err)
+	}
+	if err := msg.Sign(&key); err != nil {
+		panic(err)
+	}
+	return msg
+}
+
+func newBlockDigestMsg() *BlockDigestMsg {
+	msg := new(BlockDigestMsg)
+	msg.Timestamp.SetTimeNow()
+	msg.DBHeight = 12
+
+	hash := func(s string) interfaces.IHash { return primitives.Sha([]byte(s)) }
+	msg.Identity = hash("identity")
+	msg.KeyMR = hash("keymr")
+	msg.ABHash = hash("admin")
+	msg.FBHash = hash("factoid")
+	msg.ECHash = hash("entry credit")
+	msg.VMs = []interfaces.IHash{hash("vm 0"), hash("vm 1"), hash("vm 2")}
+
+	return msg
+}
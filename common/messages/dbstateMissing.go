@@ -119,6 +119,7 @@ func (m *DBStateMissing) FollowerExecute(state interfaces.IState) error {
 		msg, err := state.LoadDBState(dbs)
 		if msg != nil && err == nil { // If I don't have this block, ignore.
 			msg.SetOrigin(m.GetOrigin())
+			msg.SetNetworkOrigin(m.GetNetworkOrigin())
 			state.NetworkOutMsgQueue() <- msg
 		}
 	}
@@ -61,6 +61,12 @@ func UnmarshalMessage(data []byte) (interfaces.IMsg, error) {
 		msg = new(DBStateMsg)
 	case constants.ADDSERVER_MSG:
 		msg = new(AddServerMsg)
+	case constants.INVENTORY_MSG:
+		msg = new(InventoryMsg)
+	case constants.GET_DATA_MSG:
+		msg = new(GetDataMsg)
+	case constants.BLOCK_DIGEST_MSG:
+		msg = new(BlockDigestMsg)
 	default:
 		fmt.Sprintf("Transaction Failed to Validate %x", data[0])
 		return nil, fmt.Errorf("Unknown message type %d %x", messageType, data[0])
@@ -116,6 +122,12 @@ func MessageName(Type byte) string {
 		return "DBState Missing"
 	case constants.DBSTATE_MSG:
 		return "DBState"
+	case constants.INVENTORY_MSG:
+		return "Inventory"
+	case constants.GET_DATA_MSG:
+		return "Get Data"
+	case constants.BLOCK_DIGEST_MSG:
+		return "Block Digest"
 	default:
 		return "Unknown:" + fmt.Sprintf(" %d", Type)
 	}
@@ -0,0 +1,199 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/FactomProject/factomd/common/constants"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
+)
+
+// Asks the peer that sent us an InventoryMsg for the message it announced.  The
+// peer answers with the message itself, if it still has it.
+type GetDataMsg struct {
+	MessageBase
+	Timestamp interfaces.Timestamp
+
+	RequestHash interfaces.IHash // MsgHash of the message we want
+
+	//No signature!
+
+	//Not marshalled
+	hash interfaces.IHash
+}
+
+var _ interfaces.IMsg = (*GetDataMsg)(nil)
+
+func (a *GetDataMsg) IsSameAs(b *GetDataMsg) bool {
+	if b == nil {
+		return false
+	}
+	if a.Timestamp != b.Timestamp {
+		return false
+	}
+
+	if a.RequestHash == nil && b.RequestHash != nil {
+		return false
+	}
+	if a.RequestHash != nil {
+		if a.RequestHash.IsSameAs(b.RequestHash) == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *GetDataMsg) Process(uint32, interfaces.IState) bool {
+	return true
+}
+
+func (m *GetDataMsg) GetHash() interfaces.IHash {
+	if m.hash == nil {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			panic(fmt.Sprintf("Error in GetDataMsg.GetHash(): %s", err.Error()))
+		}
+		m.hash = primitives.Sha(data)
+	}
+	return m.hash
+}
+
+func (m *GetDataMsg) GetMsgHash() interfaces.IHash {
+	if m.MsgHash == nil {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return nil
+		}
+		m.MsgHash = primitives.Sha(data)
+	}
+	return m.MsgHash
+}
+
+func (m *GetDataMsg) GetTimestamp() interfaces.Timestamp {
+	return m.Timestamp
+}
+
+func (m *GetDataMsg) Type() byte {
+	return constants.GET_DATA_MSG
+}
+
+func (m *GetDataMsg) Int() int {
+	return -1
+}
+
+func (m *GetDataMsg) Bytes() []byte {
+	return nil
+}
+
+func (m *GetDataMsg) UnmarshalBinaryData(data []byte) (newData []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Error unmarshalling: %v", r)
+		}
+	}()
+	newData = data
+	if newData[0] != m.Type() {
+		return nil, fmt.Errorf("Invalid Message type")
+	}
+	newData = newData[1:]
+
+	newData, err = m.Timestamp.UnmarshalBinaryData(newData)
+	if err != nil {
+		return nil, err
+	}
+
+	m.RequestHash = primitives.NewHash(constants.ZERO_HASH)
+	newData, err = m.RequestHash.UnmarshalBinaryData(newData)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Peer2Peer = true // Always a peer2peer request.
+
+	return newData, nil
+}
+
+func (m *GetDataMsg) UnmarshalBinary(data []byte) error {
+	_, err := m.UnmarshalBinaryData(data)
+	return err
+}
+
+func (m *GetDataMsg) MarshalBinary() ([]byte, error) {
+	var buf primitives.Buffer
+	buf.Write([]byte{m.Type()})
+	if d, err := m.Timestamp.MarshalBinary(); err != nil {
+		return nil, err
+	} else {
+		buf.Write(d)
+	}
+
+	if d, err := m.RequestHash.MarshalBinary(); err != nil {
+		return nil, err
+	} else {
+		buf.Write(d)
+	}
+
+	return buf.DeepCopyBytes(), nil
+}
+
+func (m *GetDataMsg) String() string {
+	return fmt.Sprintf("GetData: %x", m.RequestHash.Bytes()[:3])
+}
+
+// Validate the message, given the state.  Three possible results:
+//  < 0 -- Message is invalid.  Discard
+//  0   -- Cannot tell if message is Valid
+//  1   -- Message is valid
+func (m *GetDataMsg) Validate(state interfaces.IState) int {
+	return 1
+}
+
+// Returns true if this is a message for this server to execute as
+// a leader.
+func (m *GetDataMsg) Leader(state interfaces.IState) bool {
+	return false
+}
+
+// Execute the leader functions of the given message
+func (m *GetDataMsg) LeaderExecute(state interfaces.IState) error {
+	return nil
+}
+
+// Returns true if this is a message for this server to execute as a follower
+func (m *GetDataMsg) Follower(interfaces.IState) bool {
+	return true
+}
+
+// The network layer answers get data requests, so there is nothing left to do here.
+func (m *GetDataMsg) FollowerExecute(state interfaces.IState) error {
+	return nil
+}
+
+func (e *GetDataMsg) JSONByte() ([]byte, error) {
+	return primitives.EncodeJSON(e)
+}
+
+func (e *GetDataMsg) JSONString() (string, error) {
+	return primitives.EncodeJSONString(e)
+}
+
+func (e *GetDataMsg) JSONBuffer(b *bytes.Buffer) error {
+	return primitives.EncodeJSONToBuffer(e, b)
+}
+
+func NewGetDataMsg(state interfaces.IState, requestHash interfaces.IHash) interfaces.IMsg {
+
+	msg := new(GetDataMsg)
+
+	msg.Peer2Peer = true // Always a peer2peer request.
+	msg.Timestamp = state.GetTimestamp()
+	msg.RequestHash = requestHash
+
+	return msg
+}
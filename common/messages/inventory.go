@@ -0,0 +1,200 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/FactomProject/factomd/common/constants"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
+)
+
+// Announces to a peer that we have a message, by its MsgHash.  A peer that has
+// not seen the message asks for it with a GetDataMsg.  Both are consumed by the
+// network layer of the engine, and never reach the state.
+type InventoryMsg struct {
+	MessageBase
+	Timestamp interfaces.Timestamp
+
+	Announced interfaces.IHash // MsgHash of the message we have
+
+	//No signature!
+
+	//Not marshalled
+	hash interfaces.IHash
+}
+
+var _ interfaces.IMsg = (*InventoryMsg)(nil)
+
+func (a *InventoryMsg) IsSameAs(b *InventoryMsg) bool {
+	if b == nil {
+		return false
+	}
+	if a.Timestamp != b.Timestamp {
+		return false
+	}
+
+	if a.Announced == nil && b.Announced != nil {
+		return false
+	}
+	if a.Announced != nil {
+		if a.Announced.IsSameAs(b.Announced) == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *InventoryMsg) Process(uint32, interfaces.IState) bool {
+	return true
+}
+
+func (m *InventoryMsg) GetHash() interfaces.IHash {
+	if m.hash == nil {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			panic(fmt.Sprintf("Error in InventoryMsg.GetHash(): %s", err.Error()))
+		}
+		m.hash = primitives.Sha(data)
+	}
+	return m.hash
+}
+
+func (m *InventoryMsg) GetMsgHash() interfaces.IHash {
+	if m.MsgHash == nil {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return nil
+		}
+		m.MsgHash = primitives.Sha(data)
+	}
+	return m.MsgHash
+}
+
+func (m *InventoryMsg) GetTimestamp() interfaces.Timestamp {
+	return m.Timestamp
+}
+
+func (m *InventoryMsg) Type() byte {
+	return constants.INVENTORY_MSG
+}
+
+func (m *InventoryMsg) Int() int {
+	return -1
+}
+
+func (m *InventoryMsg) Bytes() []byte {
+	return nil
+}
+
+func (m *InventoryMsg) UnmarshalBinaryData(data []byte) (newData []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Error unmarshalling: %v", r)
+		}
+	}()
+	newData = data
+	if newData[0] != m.Type() {
+		return nil, fmt.Errorf("Invalid Message type")
+	}
+	newData = newData[1:]
+
+	newData, err = m.Timestamp.UnmarshalBinaryData(newData)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Announced = primitives.NewHash(constants.ZERO_HASH)
+	newData, err = m.Announced.UnmarshalBinaryData(newData)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Peer2Peer = true // Only ever sent to a peer, never broadcast.
+
+	return newData, nil
+}
+
+func (m *InventoryMsg) UnmarshalBinary(data []byte) error {
+	_, err := m.UnmarshalBinaryData(data)
+	return err
+}
+
+func (m *InventoryMsg) MarshalBinary() ([]byte, error) {
+	var buf primitives.Buffer
+	buf.Write([]byte{m.Type()})
+	if d, err := m.Timestamp.MarshalBinary(); err != nil {
+		return nil, err
+	} else {
+		buf.Write(d)
+	}
+
+	if d, err := m.Announced.MarshalBinary(); err != nil {
+		return nil, err
+	} else {
+		buf.Write(d)
+	}
+
+	return buf.DeepCopyBytes(), nil
+}
+
+func (m *InventoryMsg) String() string {
+	return fmt.Sprintf("Inventory: %x", m.Announced.Bytes()[:3])
+}
+
+// Validate the message, given the state.  Three possible results:
+//  < 0 -- Message is invalid.  Discard
+//  0   -- Cannot tell if message is Valid
+//  1   -- Message is valid
+func (m *InventoryMsg) Validate(state interfaces.IState) int {
+	return 1
+}
+
+// Returns true if this is a message for this server to execute as
+// a leader.
+func (m *InventoryMsg) Leader(state interfaces.IState) bool {
+	return false
+}
+
+// Execute the leader functions of the given message
+func (m *InventoryMsg) LeaderExecute(state interfaces.IState) error {
+	return nil
+}
+
+// Returns true if this is a message for this server to execute as a follower
+func (m *InventoryMsg) Follower(interfaces.IState) bool {
+	return true
+}
+
+// The network layer answers inventory, so there is nothing left to do here.
+func (m *InventoryMsg) FollowerExecute(state interfaces.IState) error {
+	return nil
+}
+
+func (e *InventoryMsg) JSONByte() ([]byte, error) {
+	return primitives.EncodeJSON(e)
+}
+
+func (e *InventoryMsg) JSONString() (string, error) {
+	return primitives.EncodeJSONString(e)
+}
+
+func (e *InventoryMsg) JSONBuffer(b *bytes.Buffer) error {
+	return primitives.EncodeJSONToBuffer(e, b)
+}
+
+func NewInventoryMsg(state interfaces.IState, announced interfaces.IHash) interfaces.IMsg {
+
+	msg := new(InventoryMsg)
+
+	msg.Peer2Peer = true // Only ever sent to a peer, never broadcast.
+	msg.Timestamp = state.GetTimestamp()
+	msg.Announced = announced
+
+	return msg
+}
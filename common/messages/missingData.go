@@ -189,6 +189,7 @@ func (m *MissingData) FollowerExecute(state interfaces.IState) error {
 		msg := NewDataResponse(state, dataObject, dataType, m.RequestHash)
 
 		msg.SetOrigin(m.GetOrigin())
+		msg.SetNetworkOrigin(m.GetNetworkOrigin())
 		state.NetworkOutMsgQueue() <- msg
 	} else {
 		return err
@@ -199,8 +199,10 @@ func (m *MissingMsg) FollowerExecute(state interfaces.IState) error {
 
 	if msg != nil && ackMsg != nil && err == nil { // If I don't have this message, ignore.
 		msg.SetOrigin(m.GetOrigin())
+		msg.SetNetworkOrigin(m.GetNetworkOrigin())
 		msg.SetPeer2Peer(true)
 		ackMsg.SetOrigin(m.GetOrigin())
+		ackMsg.SetNetworkOrigin(m.GetNetworkOrigin())
 		ackMsg.SetPeer2Peer(true)
 		state.NetworkOutMsgQueue() <- msg
 		state.NetworkOutMsgQueue() <- ackMsg
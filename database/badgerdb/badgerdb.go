@@ -0,0 +1,244 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package badgerdb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
+)
+
+// Options holds the tunables of the Badger LSM store.  Sizes are in megabytes.
+type Options struct {
+	Compression        string  // None | Snappy | ZSTD, applied per table block
+	BlockCacheMB       int64   // Cache of decompressed table blocks
+	BloomFalsePositive float64 // False positive rate of the per table bloom filters
+	MemTableMB         int64   // Size of each memtable, and so of each level 0 table
+	NumMemtables       int     // Memtables held in memory before writes stall
+	ValueLogFileMB     int64   // Size of each value log (write-ahead log) file
+	SyncWrites         bool    // Sync the value log on every write
+}
+
+// DefaultOptions returns the options factomd uses when none are configured.
+func DefaultOptions() Options {
+	return Options{
+		Compression:        "Snappy",
+		BlockCacheMB:       256,
+		BloomFalsePositive: 0.01,
+		MemTableMB:         64,
+		NumMemtables:       5,
+		ValueLogFileMB:     1024,
+		SyncWrites:         true,
+	}
+}
+
+func (o Options) compression() (options.CompressionType, error) {
+	switch strings.ToLower(o.Compression) {
+	case "", "none":
+		return options.None, nil
+	case "snappy":
+		return options.Snappy, nil
+	case "zstd":
+		return options.ZSTD, nil
+	}
+	return options.None, fmt.Errorf("Unknown Badger compression %s (must be None, Snappy or ZSTD)", o.Compression)
+}
+
+type BadgerDB struct {
+	// lock preventing multiple entry
+	dbLock sync.RWMutex
+	bDB    *badger.DB
+}
+
+var _ interfaces.IDatabase = (*BadgerDB)(nil)
+
+func (db *BadgerDB) Delete(bucket []byte, key []byte) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	bKey := append(bucket, key...)
+	return db.bDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(bKey)
+	})
+}
+
+func (db *BadgerDB) Close() error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	return db.bDB.Close()
+}
+
+func (db *BadgerDB) Get(bucket []byte, key []byte, destination interfaces.BinaryMarshallable) (interfaces.BinaryMarshallable, error) {
+	db.dbLock.RLock()
+	defer db.dbLock.RUnlock()
+
+	bKey := append(bucket, key...)
+	var data []byte
+	err := db.bDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(bKey)
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	_, err = destination.UnmarshalBinaryData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return destination, nil
+}
+
+func (db *BadgerDB) Put(bucket []byte, key []byte, data interfaces.BinaryMarshallable) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	bKey := append(bucket, key...)
+	hex, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return db.bDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(bKey, hex)
+	})
+}
+
+func (db *BadgerDB) PutInBatch(records []interfaces.Record) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	batch := db.bDB.NewWriteBatch()
+	defer batch.Cancel()
+
+	for _, v := range records {
+		bKey := append(v.Bucket, v.Key...)
+		hex, err := v.Data.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err = batch.Set(bKey, hex); err != nil {
+			return err
+		}
+	}
+
+	return batch.Flush()
+}
+
+func (db *BadgerDB) Clear(bucket []byte) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+
+	return db.bDB.DropPrefix(bucket)
+}
+
+func (db *BadgerDB) ListAllKeys(bucket []byte) (keys [][]byte, err error) {
+	db.dbLock.RLock()
+	defer db.dbLock.RUnlock()
+
+	var answer [][]byte
+
+	err = db.bDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Seek(bucket); iter.ValidForPrefix(bucket); iter.Next() {
+			key := iter.Item().KeyCopy(nil)
+			answer = append(answer, key[len(bucket):])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return answer, nil
+}
+
+func (db *BadgerDB) GetAll(bucket []byte, sample interfaces.BinaryMarshallableAndCopyable) ([]interfaces.BinaryMarshallableAndCopyable, error) {
+	db.dbLock.RLock()
+	defer db.dbLock.RUnlock()
+
+	answer := []interfaces.BinaryMarshallableAndCopyable{}
+
+	err := db.bDB.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(bucket); iter.ValidForPrefix(bucket); iter.Next() {
+			v, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			tmp := sample.New()
+			err = tmp.UnmarshalBinary(v)
+			if err != nil {
+				return err
+			}
+			answer = append(answer, tmp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return answer, nil
+}
+
+func NewBadgerDB(filename string, create bool, o Options) (interfaces.IDatabase, error) {
+	db := new(BadgerDB)
+	var err error
+
+	if create == true {
+		err = os.MkdirAll(filename, 0750)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	compression, err := o.compression()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := badger.DefaultOptions(filename).
+		WithLogger(nil).
+		WithCompression(compression).
+		WithBlockCacheSize(o.BlockCacheMB << 20).
+		WithBloomFalsePositive(o.BloomFalsePositive).
+		WithMaxTableSize(o.MemTableMB << 20).
+		WithNumMemtables(o.NumMemtables).
+		WithValueLogFileSize(o.ValueLogFileMB << 20).
+		WithSyncWrites(o.SyncWrites)
+
+	tbDB, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	db.bDB = tbDB
+
+	return db, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package badgerdb_test
+
+import (
+	"fmt"
+	"github.com/FactomProject/factomd/common/interfaces"
+	. "github.com/FactomProject/factomd/database/badgerdb"
+	"os"
+	"testing"
+)
+
+type TestData struct {
+	Str string
+}
+
+func (t *TestData) New() interfaces.BinaryMarshallableAndCopyable {
+	return new(TestData)
+}
+
+func (t *TestData) MarshalBinary() ([]byte, error) {
+	return []byte(t.Str), nil
+}
+
+func (t *TestData) UnmarshalBinaryData(data []byte) ([]byte, error) {
+	t.Str = string(data)
+	return nil, nil
+}
+
+func (t *TestData) UnmarshalBinary(data []byte) (err error) {
+	_, err = t.UnmarshalBinaryData(data)
+	return
+}
+
+var _ interfaces.BinaryMarshallable = (*TestData)(nil)
+
+var dbFilename string = "badgerTest.db"
+
+func TestPutGetDelete(t *testing.T) {
+	m, err := NewBadgerDB(dbFilename, true, DefaultOptions())
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	defer CleanupTest(t, m)
+
+	key := []byte("key")
+	bucket := []byte("bucket")
+
+	test := new(TestData)
+	test.Str = "testtest"
+
+	err = m.Put(bucket, key, test)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	resp, err := m.Get(bucket, key, new(TestData))
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	if resp == nil {
+		t.Errorf("resp is nil")
+	}
+
+	if resp.(*TestData).Str != test.Str {
+		t.Errorf("data mismatch")
+	}
+
+	err = m.Delete(bucket, key)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	resp, err = m.Get(bucket, key, new(TestData))
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if resp != nil {
+		t.Errorf("resp is not nil while it should be")
+	}
+}
+
+func TestMultiValue(t *testing.T) {
+	m, err := NewBadgerDB(dbFilename, true, DefaultOptions())
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	defer CleanupTest(t, m)
+
+	bucket := []byte("bucket")
+	batch := []interfaces.Record{}
+	for i := 0; i < 10; i++ {
+		r := interfaces.Record{}
+		r.Key = []byte(fmt.Sprintf("%v", i))
+		r.Bucket = bucket
+		td := new(TestData)
+		td.Str = fmt.Sprintf("Data %v", i)
+		r.Data = td
+		batch = append(batch, r)
+	}
+
+	err = m.PutInBatch(batch)
+	if err != nil {
+		t.Error(err)
+	}
+
+	keys, err := m.ListAllKeys(bucket)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(keys) != 10 {
+		t.Error("Invalid length of keys")
+	}
+	for i := range keys {
+		if string(keys[i]) != fmt.Sprintf("%v", i) {
+			t.Errorf("Wrong key returned - %v", string(keys[i]))
+		}
+	}
+
+	all, err := m.GetAll(bucket, new(TestData))
+	if err != nil {
+		t.Error(err)
+	}
+	if len(all) != 10 {
+		t.Error("Invalid length of keys")
+	}
+	for i := range all {
+		v := all[i].(*TestData)
+		if v.Str != fmt.Sprintf("Data %v", i) {
+			t.Error("Wrong data returned")
+		}
+	}
+	err = m.Clear(bucket)
+	if err != nil {
+		t.Error(err)
+	}
+
+	keys, err = m.ListAllKeys(bucket)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(keys) != 0 {
+		t.Error("Keys not cleared from database properly")
+	}
+}
+
+func CleanupTest(t *testing.T, b interfaces.IDatabase) {
+	err := b.Close()
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	err = os.RemoveAll(dbFilename)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package benchmark_test
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/database/badgerdb"
+	"github.com/FactomProject/factomd/database/boltdb"
+	. "github.com/FactomProject/factomd/database/databaseOverlay"
+	"github.com/FactomProject/factomd/database/leveldb"
+	"github.com/FactomProject/factomd/database/mapdb"
+	. "github.com/FactomProject/factomd/testHelper"
+)
+
+// Compare the backends:
+//   go test -run NONE -bench . github.com/FactomProject/factomd/database/benchmark
+
+// Opens an empty database of the given type in a temporary directory.
+func newBenchmarkOverlay(b *testing.B, dbType string) (*Overlay, func()) {
+	dir, err := ioutil.TempDir("", "factomd-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var db interfaces.IDatabase
+	switch dbType {
+	case "Map":
+		m := new(mapdb.MapDB)
+		m.Init(nil)
+		db = m
+	case "Bolt":
+		bolt := new(boltdb.BoltDB)
+		bolt.Init(nil, dir+"/bench.db")
+		db = bolt
+	case "LDB":
+		db, err = leveldb.NewLevelDB(dir+"/bench.db", true)
+	case "Badger":
+		db, err = badgerdb.NewBadgerDB(dir+"/bench.db", true, badgerdb.DefaultOptions())
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	dbo := NewOverlay(db)
+	return dbo, func() {
+		dbo.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func saveBlockSet(dbo *Overlay, set *BlockSet) error {
+	dbo.StartMultiBatch()
+
+	if err := dbo.ProcessABlockMultiBatch(set.ABlock); err != nil {
+		return err
+	}
+	if err := dbo.ProcessEBlockMultiBatch(set.EBlock, false); err != nil {
+		return err
+	}
+	if err := dbo.ProcessEBlockMultiBatch(set.AnchorEBlock, false); err != nil {
+		return err
+	}
+	if err := dbo.ProcessECBlockMultiBatch(set.ECBlock, false); err != nil {
+		return err
+	}
+	if err := dbo.ProcessFBlockMultiBatch(set.FBlock); err != nil {
+		return err
+	}
+	if err := dbo.ProcessDBlockMultiBatch(set.DBlock); err != nil {
+		return err
+	}
+	for _, entry := range set.Entries {
+		if err := dbo.InsertEntry(entry); err != nil {
+			return err
+		}
+	}
+
+	return dbo.ExecuteMultiBatch()
+}
+
+// Times saving one Directory Block with all of its blocks and entries.
+func benchmarkSaveBlocks(b *testing.B, dbType string) {
+	dbo, cleanup := newBenchmarkOverlay(b, dbType)
+	defer cleanup()
+
+	var prev *BlockSet
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		prev = CreateTestBlockSet(prev)
+		b.StartTimer()
+
+		if err := saveBlockSet(dbo, prev); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Times reading random entries out of a few hundred saved blocks.
+func benchmarkReadEntries(b *testing.B, dbType string) {
+	dbo, cleanup := newBenchmarkOverlay(b, dbType)
+	defer cleanup()
+
+	var prev *BlockSet
+	hashes := []interfaces.IHash{}
+	for i := 0; i < 200; i++ {
+		prev = CreateTestBlockSet(prev)
+		if err := saveBlockSet(dbo, prev); err != nil {
+			b.Fatal(err)
+		}
+		for _, entry := range prev.Entries {
+			hashes = append(hashes, entry.GetHash())
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entry, err := dbo.FetchEntryByHash(hashes[r.Intn(len(hashes))])
+		if err != nil {
+			b.Fatal(err)
+		}
+		if entry == nil {
+			b.Fatal("Entry not found")
+		}
+	}
+}
+
+func BenchmarkSaveBlocksMap(b *testing.B)    { benchmarkSaveBlocks(b, "Map") }
+func BenchmarkSaveBlocksBolt(b *testing.B)   { benchmarkSaveBlocks(b, "Bolt") }
+func BenchmarkSaveBlocksLDB(b *testing.B)    { benchmarkSaveBlocks(b, "LDB") }
+func BenchmarkSaveBlocksBadger(b *testing.B) { benchmarkSaveBlocks(b, "Badger") }
+
+func BenchmarkReadEntriesMap(b *testing.B)    { benchmarkReadEntries(b, "Map") }
+func BenchmarkReadEntriesBolt(b *testing.B)   { benchmarkReadEntries(b, "Bolt") }
+func BenchmarkReadEntriesLDB(b *testing.B)    { benchmarkReadEntries(b, "LDB") }
+func BenchmarkReadEntriesBadger(b *testing.B) { benchmarkReadEntries(b, "Badger") }
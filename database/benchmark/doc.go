@@ -0,0 +1,7 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package benchmark holds benchmarks comparing block saves and entry reads
+// across the database backends.
+package benchmark
@@ -1,17 +1,28 @@
 package blockExtractor_test
 
 import (
+	"io/ioutil"
+	"os"
+	"testing"
+
 	. "github.com/FactomProject/factomd/database/blockExtractor"
 	"github.com/FactomProject/factomd/testHelper"
-	"testing"
 )
 
 func TestTest(t *testing.T) {
 	dbo := testHelper.CreateAndPopulateTestDatabaseOverlay()
 
+	dir, err := ioutil.TempDir("", "blockExtractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
 	be := new(BlockExtractor)
+	be.DataStorePath = dir + "/"
+
 
-	err := be.ExportDChain(dbo)
+	err = be.ExportDChain(dbo)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,59 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package databaseOverlay
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+)
+
+// A chain scoped node saves, for each chain it syncs, the Directory Block
+// height below which it has every EBlock and entry of the chain, so it goes on
+// from there when it restarts, rather than from height 0.
+
+// SaveChainScopeHeight records that the EBlocks and entries of chainID are
+// complete below the Directory Block height.
+func (db *Overlay) SaveChainScopeHeight(chainID interfaces.IHash, height uint32) error {
+	h := chainHeight(height)
+	return db.DB.Put([]byte{CHAIN_SCOPE_HEIGHT}, chainID.Bytes(), &h)
+}
+
+// FetchChainScopeHeight returns the height saved for chainID, or 0 if none is.
+func (db *Overlay) FetchChainScopeHeight(chainID interfaces.IHash) (uint32, error) {
+	h, err := db.DB.Get([]byte{CHAIN_SCOPE_HEIGHT}, chainID.Bytes(), new(chainHeight))
+	if err != nil {
+		return 0, err
+	}
+	if h == nil {
+		return 0, nil
+	}
+	return uint32(*h.(*chainHeight)), nil
+}
+
+// chainHeight is a height, as it is saved.
+type chainHeight uint32
+
+var _ interfaces.BinaryMarshallable = (*chainHeight)(nil)
+
+func (h *chainHeight) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(*h))
+	return data, nil
+}
+
+func (h *chainHeight) UnmarshalBinaryData(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("Chain scope height too short: %d bytes", len(data))
+	}
+	*h = chainHeight(binary.BigEndian.Uint32(data))
+	return data[4:], nil
+}
+
+func (h *chainHeight) UnmarshalBinary(data []byte) error {
+	_, err := h.UnmarshalBinaryData(data)
+	return err
+}
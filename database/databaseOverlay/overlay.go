@@ -12,6 +12,7 @@ import (
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/primitives"
 	"github.com/FactomProject/factomd/database/blockExtractor"
+	"github.com/FactomProject/factomd/database/readonlydb"
 )
 
 // the "table" prefix
@@ -61,6 +62,9 @@ const (
 
 	//Which EC transaction paid for this Entry
 	PAID_FOR
+
+	//How far a chain scoped node has synced each of its chains
+	CHAIN_SCOPE_HEIGHT
 )
 
 type Overlay struct {
@@ -135,6 +139,16 @@ func NewOverlay(db interfaces.IDatabase) *Overlay {
 	return answer
 }
 
+// NewReadOnlyOverlay opens a snapshot of the database of the given DBType at path.
+// The database may belong to a running factomd.  Every write to the overlay fails.
+func NewReadOnlyOverlay(dbType string, path string) (*Overlay, error) {
+	db, err := readonlydb.Open(dbType, path)
+	if err != nil {
+		return nil, err
+	}
+	return NewOverlay(db), nil
+}
+
 func (db *Overlay) FetchBlockByHeight(heightBucket []byte, blockBucket []byte, blockHeight uint32, dst interfaces.DatabaseBatchable) (interfaces.DatabaseBatchable, error) {
 	index, err := db.FetchBlockIndexByHeight(heightBucket, blockHeight)
 	if err != nil {
@@ -0,0 +1,240 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package databaseOverlay
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/FactomProject/factomd/common/constants"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/database/metricsdb"
+)
+
+var bucketNames = map[uint8]string{
+	DIRECTORYBLOCK:           "DIRECTORYBLOCK",
+	DIRECTORYBLOCK_NUMBER:    "DIRECTORYBLOCK_NUMBER",
+	DIRECTORYBLOCK_KEYMR:     "DIRECTORYBLOCK_KEYMR",
+	ADMINBLOCK:               "ADMINBLOCK",
+	ADMINBLOCK_NUMBER:        "ADMINBLOCK_NUMBER",
+	ADMINBLOCK_KEYMR:         "ADMINBLOCK_KEYMR",
+	FACTOIDBLOCK:             "FACTOIDBLOCK",
+	FACTOIDBLOCK_NUMBER:      "FACTOIDBLOCK_NUMBER",
+	FACTOIDBLOCK_KEYMR:       "FACTOIDBLOCK_KEYMR",
+	ENTRYCREDITBLOCK:         "ENTRYCREDITBLOCK",
+	ENTRYCREDITBLOCK_NUMBER:  "ENTRYCREDITBLOCK_NUMBER",
+	ENTRYCREDITBLOCK_KEYMR:   "ENTRYCREDITBLOCK_KEYMR",
+	CHAIN_HEAD:               "CHAIN_HEAD",
+	ENTRYBLOCK:               "ENTRYBLOCK",
+	ENTRYBLOCK_CHAIN_NUMBER:  "ENTRYBLOCK_CHAIN_NUMBER",
+	ENTRYBLOCK_KEYMR:         "ENTRYBLOCK_KEYMR",
+	ENTRY:                    "ENTRY",
+	DIRBLOCKINFO:             "DIRBLOCKINFO",
+	DIRBLOCKINFO_UNCONFIRMED: "DIRBLOCKINFO_UNCONFIRMED",
+	DIRBLOCKINFO_NUMBER:      "DIRBLOCKINFO_NUMBER",
+	DIRBLOCKINFO_KEYMR:       "DIRBLOCKINFO_KEYMR",
+	INCLUDED_IN:              "INCLUDED_IN",
+	PAID_FOR:                 "PAID_FOR",
+	CHAIN_SCOPE_HEIGHT:       "CHAIN_SCOPE_HEIGHT",
+}
+
+// Entries are not kept under a table prefix, but in a bucket named by their
+// chain ID.  They are reported under this name.
+const EntryDataName = "ENTRY_DATA"
+
+// BucketName returns the name of the "table" prefix of a bucket.
+func BucketName(bucket uint8) string {
+	name, ok := bucketNames[bucket]
+	if !ok {
+		return fmt.Sprintf("BUCKET_%d", bucket)
+	}
+	return name
+}
+
+// bucketOf groups every bucket under its table prefix, so the per chain
+// ENTRYBLOCK_CHAIN_NUMBER buckets are counted as one, as are the chain ID
+// buckets holding entries.
+func bucketOf(bucket []byte) string {
+	switch len(bucket) {
+	case 0:
+		return ""
+	case constants.HASH_LENGTH:
+		return EntryDataName
+	}
+	return BucketName(bucket[0])
+}
+
+// NewMeteredOverlay is NewOverlay, with the database wrapped so reads, writes,
+// misses and their latency are counted for every table.
+func NewMeteredOverlay(db interfaces.IDatabase) *Overlay {
+	return NewOverlay(metricsdb.NewMetricsDB(db, bucketOf))
+}
+
+// GetBucketMetrics returns the counters of every table used so far, or nil if
+// the overlay was not created with NewMeteredOverlay.
+func (db *Overlay) GetBucketMetrics() []metricsdb.BucketMetrics {
+	m, ok := db.DB.(*metricsdb.MetricsDB)
+	if !ok {
+		return nil
+	}
+	return m.GetMetrics()
+}
+
+// BucketUsage is the storage taken by one table.  Only values are counted;
+// keys are mostly 32 byte hashes.  The ENTRY table only indexes entries by
+// hash; the entries themselves are reported in a second row with the same
+// Bucket, named EntryDataName.
+type BucketUsage struct {
+	Bucket      uint8   `json:"bucket"`
+	Name        string  `json:"name"`
+	Keys        int     `json:"keys"`
+	ValueBytes  int64   `json:"valuebytes"`
+	AverageSize float64 `json:"averagesize"`
+}
+
+// valueSize is a sample for GetAll that only keeps the size of each value, so
+// a whole table can be walked without holding it in memory.
+type valueSize struct {
+	size int
+}
+
+var _ interfaces.BinaryMarshallableAndCopyable = (*valueSize)(nil)
+
+func (v *valueSize) New() interfaces.BinaryMarshallableAndCopyable {
+	return new(valueSize)
+}
+
+func (v *valueSize) MarshalBinary() ([]byte, error) {
+	return nil, fmt.Errorf("valueSize cannot be marshalled")
+}
+
+func (v *valueSize) UnmarshalBinaryData(data []byte) ([]byte, error) {
+	v.size = len(data)
+	return nil, nil
+}
+
+func (v *valueSize) UnmarshalBinary(data []byte) error {
+	_, err := v.UnmarshalBinaryData(data)
+	return err
+}
+
+func (u *BucketUsage) add(values []interfaces.BinaryMarshallableAndCopyable) {
+	for _, v := range values {
+		u.Keys++
+		u.ValueBytes += int64(v.(*valueSize).size)
+	}
+}
+
+func (u BucketUsage) average() BucketUsage {
+	if u.Keys > 0 {
+		u.AverageSize = float64(u.ValueBytes) / float64(u.Keys)
+	}
+	return u
+}
+
+// GetStorageReport walks every table and returns its key count, value bytes and
+// average value size.  This reads the whole database, so it is slow on a large
+// one.
+func (db *Overlay) GetStorageReport() ([]BucketUsage, error) {
+	// Entries and entry block heights are kept in a bucket per chain.
+	keys, err := db.DB.ListAllKeys([]byte{CHAIN_HEAD})
+	if err != nil {
+		return nil, err
+	}
+	var chains [][]byte
+	for _, k := range keys {
+		// A backend matching buckets by prefix also lists the entries of the
+		// chains whose ID starts with CHAIN_HEAD.
+		if len(k) == constants.HASH_LENGTH {
+			chains = append(chains, k)
+		}
+	}
+
+	data := make(map[string]BucketUsage)
+	for _, chainID := range chains {
+		var u BucketUsage
+		values, err := db.DB.GetAll(chainID, new(valueSize))
+		if err != nil {
+			return nil, err
+		}
+		u.add(values)
+		data[string(chainID)] = u
+	}
+	byPrefix, err := db.matchesByPrefix(chains)
+	if err != nil {
+		return nil, err
+	}
+
+	var answer []BucketUsage
+	for b := DIRECTORYBLOCK; b <= CHAIN_SCOPE_HEIGHT; b++ {
+		u := BucketUsage{Bucket: b, Name: BucketName(b)}
+
+		if b == ENTRYBLOCK_CHAIN_NUMBER {
+			for _, chainID := range chains {
+				values, err := db.DB.GetAll(append([]byte{b}, chainID...), new(valueSize))
+				if err != nil {
+					return nil, err
+				}
+				u.add(values)
+			}
+		} else {
+			values, err := db.DB.GetAll([]byte{b}, new(valueSize))
+			if err != nil {
+				return nil, err
+			}
+			u.add(values)
+			if byPrefix {
+				// The table took in the entries of the chains whose ID starts
+				// with its prefix; they are counted once, as entry data.
+				for _, chainID := range chains {
+					if chainID[0] == b {
+						u.Keys -= data[string(chainID)].Keys
+						u.ValueBytes -= data[string(chainID)].ValueBytes
+					}
+				}
+			}
+		}
+		answer = append(answer, u.average())
+
+		if b == ENTRY {
+			all := BucketUsage{Bucket: b, Name: EntryDataName}
+			for _, u := range data {
+				all.Keys += u.Keys
+				all.ValueBytes += u.ValueBytes
+			}
+			answer = append(answer, all.average())
+		}
+	}
+	return answer, nil
+}
+
+// matchesByPrefix tells whether the backend matches buckets by prefix, as
+// LevelDB and Badger do, rather than keeping each bucket apart, as Bolt and
+// the map database do.  By prefix, the table named by the first byte of a
+// chain ID also lists the chain's entries, keyed by the rest of the chain ID.
+func (db *Overlay) matchesByPrefix(chains [][]byte) (bool, error) {
+	for _, chainID := range chains {
+		entries, err := db.DB.ListAllKeys(chainID)
+		if err != nil {
+			return false, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		keys, err := db.DB.ListAllKeys(chainID[:1])
+		if err != nil {
+			return false, err
+		}
+		want := append(append([]byte{}, chainID[1:]...), entries[0]...)
+		for _, k := range keys {
+			if bytes.Equal(k, want) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	// No chain has entries, so none are counted twice either way.
+	return false, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package databaseOverlay_test
+
+import (
+	"testing"
+
+	. "github.com/FactomProject/factomd/common/entryBlock"
+	"github.com/FactomProject/factomd/common/primitives"
+	. "github.com/FactomProject/factomd/database/databaseOverlay"
+	"github.com/FactomProject/factomd/database/mapdb"
+	. "github.com/FactomProject/factomd/testHelper"
+)
+
+func TestStorageReport(t *testing.T) {
+	max := 10
+	var prev *EBlock = nil
+	dbo := NewMeteredOverlay(new(mapdb.MapDB))
+	defer dbo.Close()
+
+	for i := 0; i < max; i++ {
+		prev, _ = CreateTestEntryBlock(prev)
+		err := dbo.SaveEBlockHead(prev, false)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	report, err := dbo.GetStorageReport()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(report) != int(CHAIN_SCOPE_HEIGHT)+2 {
+		t.Errorf("Expected a report on %d tables, got %d", CHAIN_SCOPE_HEIGHT+2, len(report))
+	}
+	for _, u := range report {
+		switch u.Bucket {
+		case ENTRYBLOCK, ENTRYBLOCK_KEYMR, ENTRYBLOCK_CHAIN_NUMBER:
+			if u.Keys != max {
+				t.Errorf("Expected %d keys in %s, got %d", max, u.Name, u.Keys)
+			}
+		case CHAIN_HEAD:
+			if u.Keys != 1 {
+				t.Errorf("Expected 1 key in %s, got %d", u.Name, u.Keys)
+			}
+		case DIRECTORYBLOCK:
+			if u.Keys != 0 {
+				t.Errorf("Expected no keys in %s, got %d", u.Name, u.Keys)
+			}
+		}
+		if u.Keys > 0 && u.AverageSize != float64(u.ValueBytes)/float64(u.Keys) {
+			t.Errorf("Invalid average size for %s", u.Name)
+		}
+	}
+
+	entry, err := dbo.FetchEntryByHash(primitives.NewZeroHash())
+	if err != nil || entry != nil {
+		t.Errorf("Expected a miss on an unknown entry")
+	}
+
+	metrics := dbo.GetBucketMetrics()
+	found := false
+	for _, m := range metrics {
+		switch m.Bucket {
+		case BucketName(ENTRYBLOCK):
+			if m.Writes != uint64(max) {
+				t.Errorf("Expected %d writes to %s, got %d", max, m.Bucket, m.Writes)
+			}
+		case BucketName(ENTRY):
+			found = true
+			// The report walked the table once too.
+			if m.Reads != 2 || m.Misses != 1 {
+				t.Errorf("Expected 2 reads and 1 miss on %s, got %d and %d", m.Bucket, m.Reads, m.Misses)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("No metrics for %s", BucketName(ENTRY))
+	}
+
+	if NewOverlay(new(mapdb.MapDB)).GetBucketMetrics() != nil {
+		t.Errorf("An overlay without metrics should report none")
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/FactomProject/factomd/common/interfaces"
 
+	"github.com/FactomProject/factomd/database/badgerdb"
 	"github.com/FactomProject/factomd/database/boltdb"
 	"github.com/FactomProject/factomd/database/leveldb"
 	"github.com/FactomProject/factomd/database/mapdb"
@@ -55,6 +56,22 @@ func NewLevelMapHybridDB(filename string, create bool) (*HybridDB, error) {
 	return answer, nil
 }
 
+func NewBadgerMapHybridDB(filename string, create bool, opts badgerdb.Options) (*HybridDB, error) {
+	answer := new(HybridDB)
+
+	m := new(mapdb.MapDB)
+	m.Init(nil)
+	answer.temporaryStorage = m
+
+	b, err := badgerdb.NewBadgerDB(filename, create, opts)
+	if err != nil {
+		return nil, err
+	}
+	answer.persistentStorage = b
+
+	return answer, nil
+}
+
 func NewBoltMapHybridDB(bucketList [][]byte, filename string) *HybridDB {
 	answer := new(HybridDB)
 
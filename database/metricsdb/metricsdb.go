@@ -0,0 +1,199 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package metricsdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+)
+
+// BucketMetrics counts the operations done on one bucket since the database was
+// opened.  A Get that finds nothing is counted both as a read and as a miss.
+type BucketMetrics struct {
+	Bucket    string        `json:"bucket"`
+	Reads     uint64        `json:"reads"`
+	Misses    uint64        `json:"misses"`
+	Writes    uint64        `json:"writes"`
+	Deletes   uint64        `json:"deletes"`
+	ReadTime  time.Duration `json:"readtime"`  // Total time spent in reads
+	WriteTime time.Duration `json:"writetime"` // Total time spent in writes and deletes
+}
+
+// AverageRead returns the mean latency of a read on the bucket.
+func (m BucketMetrics) AverageRead() time.Duration {
+	if m.Reads == 0 {
+		return 0
+	}
+	return m.ReadTime / time.Duration(m.Reads)
+}
+
+// AverageWrite returns the mean latency of a write or delete on the bucket.
+func (m BucketMetrics) AverageWrite() time.Duration {
+	if m.Writes+m.Deletes == 0 {
+		return 0
+	}
+	return m.WriteTime / time.Duration(m.Writes+m.Deletes)
+}
+
+// MetricsDB wraps another database, and counts reads, writes, misses and the
+// time they take for every bucket.  Buckets are grouped under the name returned
+// by the naming function, so callers that derive buckets from a prefix (one
+// bucket per chain, say) can count them together.
+type MetricsDB struct {
+	db   interfaces.IDatabase
+	name func(bucket []byte) string
+
+	mutex   sync.Mutex
+	buckets map[string]*BucketMetrics
+}
+
+var _ interfaces.IDatabase = (*MetricsDB)(nil)
+
+// NewMetricsDB wraps db.  If name is nil, each bucket is counted on its own.
+func NewMetricsDB(db interfaces.IDatabase, name func(bucket []byte) string) *MetricsDB {
+	answer := new(MetricsDB)
+	answer.db = db
+	answer.name = name
+	if answer.name == nil {
+		answer.name = func(bucket []byte) string { return string(bucket) }
+	}
+	answer.buckets = map[string]*BucketMetrics{}
+	return answer
+}
+
+// bucket returns the counters of a bucket.  The caller must hold the mutex.
+func (db *MetricsDB) bucket(bucket []byte) *BucketMetrics {
+	name := db.name(bucket)
+	m, ok := db.buckets[name]
+	if !ok {
+		m = new(BucketMetrics)
+		m.Bucket = name
+		db.buckets[name] = m
+	}
+	return m
+}
+
+func (db *MetricsDB) read(bucket []byte, start time.Time, miss bool) {
+	elapsed := time.Since(start)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	m := db.bucket(bucket)
+	m.Reads++
+	m.ReadTime += elapsed
+	if miss {
+		m.Misses++
+	}
+}
+
+func (db *MetricsDB) write(bucket []byte, start time.Time, writes uint64, deletes uint64) {
+	elapsed := time.Since(start)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	m := db.bucket(bucket)
+	m.Writes += writes
+	m.Deletes += deletes
+	m.WriteTime += elapsed
+}
+
+// GetMetrics returns a copy of the counters of every bucket used so far, sorted
+// by bucket name.
+func (db *MetricsDB) GetMetrics() []BucketMetrics {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	answer := make([]BucketMetrics, 0, len(db.buckets))
+	for _, m := range db.buckets {
+		answer = append(answer, *m)
+	}
+	sort.Sort(byBucket(answer))
+	return answer
+}
+
+// ResetMetrics zeroes every counter.
+func (db *MetricsDB) ResetMetrics() {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.buckets = map[string]*BucketMetrics{}
+}
+
+func (db *MetricsDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *MetricsDB) Put(bucket, key []byte, data interfaces.BinaryMarshallable) error {
+	start := time.Now()
+	err := db.db.Put(bucket, key, data)
+	db.write(bucket, start, 1, 0)
+	return err
+}
+
+// PutInBatch splits the time of the batch between the buckets of its records,
+// by how many records each holds, as the backend writes the batch in one go.
+func (db *MetricsDB) PutInBatch(records []interfaces.Record) error {
+	start := time.Now()
+	err := db.db.PutInBatch(records)
+	if len(records) == 0 {
+		return err
+	}
+	elapsed := time.Since(start)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	counts := map[*BucketMetrics]int64{}
+	for _, r := range records {
+		counts[db.bucket(r.Bucket)]++
+	}
+	for m, n := range counts {
+		m.Writes += uint64(n)
+		m.WriteTime += elapsed * time.Duration(n) / time.Duration(len(records))
+	}
+	return err
+}
+
+func (db *MetricsDB) Get(bucket, key []byte, destination interfaces.BinaryMarshallable) (interfaces.BinaryMarshallable, error) {
+	start := time.Now()
+	answer, err := db.db.Get(bucket, key, destination)
+	db.read(bucket, start, err == nil && answer == nil)
+	return answer, err
+}
+
+func (db *MetricsDB) Delete(bucket, key []byte) error {
+	start := time.Now()
+	err := db.db.Delete(bucket, key)
+	db.write(bucket, start, 0, 1)
+	return err
+}
+
+func (db *MetricsDB) ListAllKeys(bucket []byte) ([][]byte, error) {
+	start := time.Now()
+	answer, err := db.db.ListAllKeys(bucket)
+	db.read(bucket, start, false)
+	return answer, err
+}
+
+func (db *MetricsDB) GetAll(bucket []byte, sample interfaces.BinaryMarshallableAndCopyable) ([]interfaces.BinaryMarshallableAndCopyable, error) {
+	start := time.Now()
+	answer, err := db.db.GetAll(bucket, sample)
+	db.read(bucket, start, false)
+	return answer, err
+}
+
+func (db *MetricsDB) Clear(bucket []byte) error {
+	start := time.Now()
+	err := db.db.Clear(bucket)
+	db.write(bucket, start, 0, 1)
+	return err
+}
+
+type byBucket []BucketMetrics
+
+func (b byBucket) Len() int           { return len(b) }
+func (b byBucket) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byBucket) Less(i, j int) bool { return b[i].Bucket < b[j].Bucket }
@@ -0,0 +1,157 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package metricsdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/database/mapdb"
+	. "github.com/FactomProject/factomd/database/metricsdb"
+)
+
+type TestData struct {
+	Str string
+}
+
+func (t *TestData) New() interfaces.BinaryMarshallableAndCopyable {
+	return new(TestData)
+}
+
+func (t *TestData) MarshalBinary() ([]byte, error) {
+	return []byte(t.Str), nil
+}
+
+func (t *TestData) UnmarshalBinaryData(data []byte) ([]byte, error) {
+	t.Str = string(data)
+	return nil, nil
+}
+
+func (t *TestData) UnmarshalBinary(data []byte) (err error) {
+	_, err = t.UnmarshalBinaryData(data)
+	return
+}
+
+var _ interfaces.BinaryMarshallable = (*TestData)(nil)
+
+func TestMetrics(t *testing.T) {
+	m := new(mapdb.MapDB)
+	m.Init(nil)
+	db := NewMetricsDB(m, nil)
+	defer db.Close()
+
+	bucket := []byte("bucket")
+	other := []byte("other")
+
+	test := new(TestData)
+	test.Str = "testtest"
+
+	for _, k := range []string{"a", "b", "c"} {
+		err := db.Put(bucket, []byte(k), test)
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+	batch := []interfaces.Record{
+		{Bucket: other, Key: []byte("a"), Data: test},
+		{Bucket: other, Key: []byte("b"), Data: test},
+	}
+	err := db.PutInBatch(batch)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	resp, err := db.Get(bucket, []byte("a"), new(TestData))
+	if err != nil || resp == nil {
+		t.Errorf("Expected to find key a")
+	}
+	resp, err = db.Get(bucket, []byte("z"), new(TestData))
+	if err != nil || resp != nil {
+		t.Errorf("Expected not to find key z")
+	}
+	err = db.Delete(bucket, []byte("b"))
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	metrics := db.GetMetrics()
+	if len(metrics) != 2 {
+		t.Fatalf("Expected metrics on 2 buckets, got %d", len(metrics))
+	}
+	b := metrics[0]
+	if b.Bucket != string(bucket) {
+		t.Errorf("Metrics are not sorted by bucket")
+	}
+	if b.Writes != 3 || b.Reads != 2 || b.Misses != 1 || b.Deletes != 1 {
+		t.Errorf("Invalid metrics %+v", b)
+	}
+	if b.AverageRead() > b.ReadTime || b.AverageWrite() > b.WriteTime {
+		t.Errorf("Invalid average latency")
+	}
+	if metrics[1].Writes != 2 {
+		t.Errorf("Expected 2 batched writes, got %d", metrics[1].Writes)
+	}
+
+	db.ResetMetrics()
+	if len(db.GetMetrics()) != 0 {
+		t.Errorf("Metrics were not reset")
+	}
+}
+
+func TestBucketNames(t *testing.T) {
+	m := new(mapdb.MapDB)
+	m.Init(nil)
+	db := NewMetricsDB(m, func(bucket []byte) string { return string(bucket[:1]) })
+
+	db.Get([]byte("ab"), []byte("key"), new(TestData))
+	db.Get([]byte("ac"), []byte("key"), new(TestData))
+
+	metrics := db.GetMetrics()
+	if len(metrics) != 1 || metrics[0].Bucket != "a" || metrics[0].Misses != 2 {
+		t.Errorf("Buckets were not grouped by name: %+v", metrics)
+	}
+}
+
+// slowDB takes a while to write a batch.
+type slowDB struct {
+	mapdb.MapDB
+}
+
+func (db *slowDB) PutInBatch(records []interfaces.Record) error {
+	time.Sleep(40 * time.Millisecond)
+	return db.MapDB.PutInBatch(records)
+}
+
+func TestBatchTimeSplit(t *testing.T) {
+	m := new(slowDB)
+	m.Init(nil)
+	db := NewMetricsDB(m, nil)
+
+	test := new(TestData)
+	test.Str = "testtest"
+	batch := []interfaces.Record{
+		{Bucket: []byte("a"), Key: []byte("a"), Data: test},
+		{Bucket: []byte("b"), Key: []byte("a"), Data: test},
+		{Bucket: []byte("b"), Key: []byte("b"), Data: test},
+		{Bucket: []byte("b"), Key: []byte("c"), Data: test},
+	}
+	err := db.PutInBatch(batch)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	metrics := db.GetMetrics()
+	if len(metrics) != 2 {
+		t.Fatalf("Expected metrics on 2 buckets, got %d", len(metrics))
+	}
+	a, b := metrics[0], metrics[1]
+	if a.WriteTime < 10*time.Millisecond {
+		t.Errorf("The batch's first bucket was charged %v, expected a quarter of the batch", a.WriteTime)
+	}
+	if b.WriteTime < 2*a.WriteTime || b.WriteTime > 4*a.WriteTime {
+		t.Errorf("Expected the batch's time split 1:3, got %v and %v", a.WriteTime, b.WriteTime)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package readonlydb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/database/badgerdb"
+	"github.com/FactomProject/factomd/database/boltdb"
+	"github.com/FactomProject/factomd/database/leveldb"
+)
+
+// LevelDB, Bolt and Badger all lock their files, so a tool cannot open the
+// database of a running factomd.  Instead, we copy the database files aside and
+// open the copy.  The copy is a point in time snapshot of the node's data.  Table
+// files never change once written, so they are hard linked rather than copied
+// where the filesystem allows it.  The files are only served if none of them
+// changed while we copied them, and, for LevelDB, the copy holds the manifest
+// its CURRENT file names; otherwise we wait a moment and try again, since a
+// copy torn by a write can still open cleanly.
+
+var ErrReadOnly = errors.New("Database is open read only")
+
+const copyAttempts = 5
+
+// copyRetryDelay is how long we let the node write before copying again.
+const copyRetryDelay = 200 * time.Millisecond
+
+// ReadOnlyDB wraps a snapshot of another process's database.  Every write fails
+// with ErrReadOnly, and Close removes the snapshot.
+type ReadOnlyDB struct {
+	db  interfaces.IDatabase
+	dir string // Temporary directory holding the snapshot
+}
+
+var _ interfaces.IDatabase = (*ReadOnlyDB)(nil)
+
+func (db *ReadOnlyDB) Close() error {
+	err := db.db.Close()
+	if db.dir != "" {
+		os.RemoveAll(db.dir)
+	}
+	return err
+}
+
+func (db *ReadOnlyDB) Put(bucket, key []byte, data interfaces.BinaryMarshallable) error {
+	return ErrReadOnly
+}
+
+func (db *ReadOnlyDB) PutInBatch(records []interfaces.Record) error {
+	return ErrReadOnly
+}
+
+func (db *ReadOnlyDB) Delete(bucket, key []byte) error {
+	return ErrReadOnly
+}
+
+func (db *ReadOnlyDB) Clear(bucket []byte) error {
+	return ErrReadOnly
+}
+
+func (db *ReadOnlyDB) Get(bucket, key []byte, destination interfaces.BinaryMarshallable) (interfaces.BinaryMarshallable, error) {
+	return db.db.Get(bucket, key, destination)
+}
+
+func (db *ReadOnlyDB) ListAllKeys(bucket []byte) ([][]byte, error) {
+	return db.db.ListAllKeys(bucket)
+}
+
+func (db *ReadOnlyDB) GetAll(bucket []byte, sample interfaces.BinaryMarshallableAndCopyable) ([]interfaces.BinaryMarshallableAndCopyable, error) {
+	return db.db.GetAll(bucket, sample)
+}
+
+// NewReadOnlyDB wraps a database this process already has open, so it can be
+// handed out without letting the receiver write to it.
+func NewReadOnlyDB(db interfaces.IDatabase) *ReadOnlyDB {
+	answer := new(ReadOnlyDB)
+	answer.db = db
+	return answer
+}
+
+// Open takes a snapshot of the database of the given DBType (LDB, Bolt or
+// Badger) at path, and opens it read only.  The database may be in use by a
+// running factomd.
+func Open(dbType string, path string) (*ReadOnlyDB, error) {
+	switch dbType {
+	case "LDB", "Badger", "Bolt":
+	default:
+		return nil, fmt.Errorf("Cannot open a %s database read only", dbType)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	var err error
+	for i := 0; i < copyAttempts; i++ {
+		if i > 0 {
+			time.Sleep(copyRetryDelay)
+		}
+		var db *ReadOnlyDB
+		db, err = open(dbType, path)
+		if err == nil {
+			return db, nil
+		}
+	}
+	return nil, fmt.Errorf("Could not snapshot the database at %s: %v", path, err)
+}
+
+func open(dbType string, path string) (*ReadOnlyDB, error) {
+	dir, err := ioutil.TempDir("", "factomd-readonly-")
+	if err != nil {
+		return nil, err
+	}
+	snapshot := filepath.Join(dir, filepath.Base(path))
+
+	var db interfaces.IDatabase
+	switch dbType {
+	case "LDB":
+		if err = copyStable(path, snapshot, copyDir); err == nil {
+			err = checkManifest(snapshot)
+		}
+		if err == nil {
+			db, err = leveldb.NewLevelDB(snapshot, false)
+		}
+	case "Badger":
+		if err = copyStable(path, snapshot, copyDir); err == nil {
+			db, err = badgerdb.NewBadgerDB(snapshot, false, badgerdb.DefaultOptions())
+		}
+	case "Bolt":
+		if err = copyStable(path, snapshot, copyFile); err == nil {
+			db, err = openBolt(snapshot)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	answer := NewReadOnlyDB(db)
+	answer.dir = dir
+	return answer, nil
+}
+
+// boltdb panics if it can't open the file; a torn copy is an error we retry on.
+func openBolt(filename string) (db interfaces.IDatabase, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return boltdb.NewBoltDB(nil, filename), nil
+}
+
+// fileStamp is what we compare to tell whether a file changed while we copied it.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// stamps returns the stamp of every file we copy from path, a directory or a
+// single file.
+func stamps(path string) (map[string]fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []os.FileInfo{info}
+	if info.IsDir() {
+		if files, err = ioutil.ReadDir(path); err != nil {
+			return nil, err
+		}
+	}
+	answer := make(map[string]fileStamp)
+	for _, f := range files {
+		if f.IsDir() || f.Name() == "LOCK" {
+			continue
+		}
+		answer[f.Name()] = fileStamp{f.Size(), f.ModTime()}
+	}
+	return answer, nil
+}
+
+// copyStable copies src to dst, and fails if any file of src was added,
+// removed or written to meanwhile.
+func copyStable(src, dst string, copy func(src, dst string) error) error {
+	before, err := stamps(src)
+	if err != nil {
+		return err
+	}
+	if err := copy(src, dst); err != nil {
+		return err
+	}
+	after, err := stamps(src)
+	if err != nil {
+		return err
+	}
+	if len(before) != len(after) {
+		return fmt.Errorf("%s changed while it was copied", src)
+	}
+	for name, stamp := range before {
+		if s, ok := after[name]; !ok || s.size != stamp.size || !s.modTime.Equal(stamp.modTime) {
+			return fmt.Errorf("%s changed while it was copied", filepath.Join(src, name))
+		}
+	}
+	return nil
+}
+
+// checkManifest makes sure a copied LevelDB holds the manifest its CURRENT
+// file names.  Without CURRENT, LevelDB would open the copy as a new, empty
+// database.
+func checkManifest(dir string) error {
+	current, err := ioutil.ReadFile(filepath.Join(dir, "CURRENT"))
+	if err != nil {
+		return err
+	}
+	manifest := strings.TrimSpace(string(current))
+	if !strings.HasPrefix(manifest, "MANIFEST-") {
+		return fmt.Errorf("CURRENT names no manifest: %q", manifest)
+	}
+	_, err = os.Stat(filepath.Join(dir, manifest))
+	return err
+}
+
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0750); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || f.Name() == "LOCK" {
+			continue
+		}
+		from := filepath.Join(src, f.Name())
+		to := filepath.Join(dst, f.Name())
+		switch filepath.Ext(f.Name()) {
+		case ".ldb", ".sst":
+			if os.Link(from, to) == nil {
+				continue
+			}
+		}
+		if err := copyFile(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package readonlydb_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/database/leveldb"
+	. "github.com/FactomProject/factomd/database/readonlydb"
+)
+
+type TestData struct {
+	Str string
+}
+
+func (t *TestData) New() interfaces.BinaryMarshallableAndCopyable {
+	return new(TestData)
+}
+
+func (t *TestData) MarshalBinary() ([]byte, error) {
+	return []byte(t.Str), nil
+}
+
+func (t *TestData) UnmarshalBinaryData(data []byte) ([]byte, error) {
+	t.Str = string(data)
+	return nil, nil
+}
+
+func (t *TestData) UnmarshalBinary(data []byte) (err error) {
+	_, err = t.UnmarshalBinaryData(data)
+	return
+}
+
+var _ interfaces.BinaryMarshallable = (*TestData)(nil)
+
+var dbFilename string = "readOnlyTest.db"
+
+func TestReadWhileOpen(t *testing.T) {
+	m, err := leveldb.NewLevelDB(dbFilename, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dbFilename)
+	defer m.Close()
+
+	key := []byte("key")
+	bucket := []byte("bucket")
+
+	test := new(TestData)
+	test.Str = "testtest"
+
+	err = m.Put(bucket, key, test)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	// The writer still holds its lock.
+	ro, err := Open("LDB", dbFilename)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer ro.Close()
+
+	resp, err := ro.Get(bucket, key, new(TestData))
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if resp == nil || resp.(*TestData).Str != test.Str {
+		t.Errorf("data mismatch")
+	}
+
+	if ro.Put(bucket, key, test) != ErrReadOnly {
+		t.Errorf("Put should fail on a read only database")
+	}
+	if ro.Delete(bucket, key) != ErrReadOnly {
+		t.Errorf("Delete should fail on a read only database")
+	}
+	if ro.Clear(bucket) != ErrReadOnly {
+		t.Errorf("Clear should fail on a read only database")
+	}
+
+	// Later writes don't show up in the snapshot.
+	test2 := new(TestData)
+	test2.Str = "later"
+	err = m.Put(bucket, []byte("key2"), test2)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	resp, err = ro.Get(bucket, []byte("key2"), new(TestData))
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if resp != nil {
+		t.Errorf("Snapshot should not see later writes")
+	}
+}
+
+func TestOpenUnknownType(t *testing.T) {
+	_, err := Open("Map", ".")
+	if err == nil {
+		t.Errorf("Map databases cannot be opened read only")
+	}
+}
+
+func TestOpenWithoutManifest(t *testing.T) {
+	// CURRENT names a manifest the copy doesn't hold, as when the node
+	// rolled its manifest over while we copied.
+	if err := os.MkdirAll(dbFilename, 0750); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dbFilename)
+	if err := ioutil.WriteFile(dbFilename+"/CURRENT", []byte("MANIFEST-000002\n"), 0640); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := Open("LDB", dbFilename); err == nil {
+		t.Errorf("A database without its manifest should not open")
+	}
+}
@@ -5,6 +5,7 @@
 package engine
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/log"
@@ -31,6 +32,7 @@ type MsgLog struct {
 	last    interfaces.Timestamp
 	all     bool
 	nodeCnt int
+	trace   *json.Encoder // If not nil, where TraceEvents are written; see Trace
 
 	start     interfaces.Timestamp
 	msgCnt    int
@@ -63,6 +65,14 @@ func (m *MsgLog) add2(fnode *FactomNode, out bool, peer string, where string, va
 		m.startp = m.start
 	}
 
+	stage := TraceIn
+	if out {
+		stage = TraceOut
+	} else if where == "API" {
+		stage = TraceAPI
+	}
+	m.traceEvent(fnode, stage, peer, where, valid, msg)
+
 	nm := new(msglist)
 	nm.fnode = fnode
 	nm.out = out
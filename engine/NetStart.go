@@ -5,10 +5,14 @@
 package engine
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"math"
+	"math/rand"
 	"os"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -23,9 +27,37 @@ import (
 var _ = fmt.Print
 
 type FactomNode struct {
-	State *state.State
-	Peers []interfaces.IPeer
-	MLog  *MsgLog
+	State  *state.State
+	Peers  []interfaces.IPeer
+	MLog   *MsgLog
+	Gossip *Gossip
+	// Requests for missing data waiting on an answer, see requests.go
+	Requests *Requests
+	// The node's own source of randomness in a simulation, so a run repeats
+	// whatever else draws on math/rand.  If nil, math/rand's.
+	Rand *rand.Rand
+
+	quit    chan struct{}  // Closed to stop the node's goroutines; see restart.go
+	running sync.WaitGroup // The node's goroutines
+	loaded  chan struct{}  // Closed once its database is loaded, if it loads one
+	stopped bool           // Stopped, until restarted
+}
+
+// intn is a random number in [0,n), from the node's own source if it has one.
+func (fnode *FactomNode) intn(n int) int {
+	if fnode.Rand != nil {
+		return fnode.Rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// perm is a random permutation of [0,n), from the node's own source if it
+// has one.
+func (fnode *FactomNode) perm(n int) []int {
+	if fnode.Rand != nil {
+		return fnode.Rand.Perm(n)
+	}
+	return rand.Perm(n)
 }
 
 var fnodes []*FactomNode
@@ -36,16 +68,26 @@ func NetStart(s *state.State) {
 
 	listenToPtr := flag.Int("node", 0, "Node Number the simulator will set as the focus")
 	cntPtr := flag.Int("count", 1, "The number of nodes to generate")
-	netPtr := flag.String("net", "tree", "The default algorithm to build the network connections")
+	netPtr := flag.String("net", "tree", "The algorithm to build the network connections: long, loops, alot, tree, circles, mesh, kregular, smallworld or star")
+	netFilePtr := flag.String("netfile", "", "Build the network connections from this file, each line the indexes of two nodes to connect, rather than from -net.")
+	degreePtr := flag.Int("degree", 4, "Connections of each node, for the kregular and smallworld networks")
+	rewirePtr := flag.Float64("rewire", 0.1, "Chance each connection of a smallworld network is moved to a random node")
 	dropPtr := flag.Int("drop", 0, "Number of messages to drop out of every thousand")
 	journalPtr := flag.String("journal", "", "Rerun a Journal of messages")
+	journalingPtr := flag.Bool("journaling", false, "If true, write a journal of the messages each node receives, with an index, to its log path.")
+	journalFromPtr := flag.String("journalfrom", "", "Replay the journal from this height, or height:minute.")
+	journalToPtr := flag.String("journalto", "", "Replay the journal up to this height, or height:minute.")
+	journalTypesPtr := flag.String("journaltypes", "", "Comma separated list of message types to replay from the journal, e.g. \"EOM,Ack\".  All if not set.")
+	journalStepPtr := flag.Bool("journalstep", false, "If true, replay the journal a message at a time, stepped with the \"j\" command or the control API.")
+	journalDivergePtr := flag.Bool("journaldiverge", false, "If true, stop replaying the journal where the node diverges from it.")
 	followerPtr := flag.Bool("follower", false, "If true, force node to be a follower.  Only used when replaying a journal.")
 	leaderPtr := flag.Bool("leader", true, "If true, force node to be a leader.  Only used when replaying a journal.")
 	dbPtr := flag.String("db", "", "Override the Database in the Config file and use this Database implementation")
 	cloneDBPtr := flag.String("clonedb", "", "Override the main node and use this database for the clones in a Network.")
+	configPtr := flag.String("config", "", "Read the configuration from this file rather than ~/.factom/m2/factomd.conf.")
 	folderPtr := flag.String("folder", "", "Directory in .factom to store nodes. (eg: multiple nodes on one filesystem support)")
 	portOverridePtr := flag.Int("port", 0, "Address to serve WSAPI on")
-	addressPtr := flag.String("p2pPort", "8108", "Address & port to listen for peers on.")
+	addressPtr := flag.String("p2pPort", "", "Port to listen for peers on.  Defaults to the port of the network in factomd.conf.")
 	peersPtr := flag.String("peers", "", "Array of peer addresses. ")
 	blkTimePtr := flag.Int("blktime", 0, "Seconds per block.  Production is 600.")
 	runtimeLogPtr := flag.Bool("runtimeLog", true, "If true, maintain runtime logs of messages passed.")
@@ -53,18 +95,32 @@ func NetStart(s *state.State) {
 	heartbeatPtr := flag.Bool("heartbeat", false, "If true, network just sends heartbeats.")
 	prefixNodePtr := flag.String("prefix", "", "Prefix the Factom Node Names with this value; used to create leaderless networks.")
 	profilePtr := flag.String("profile", "", "If true, turn on the go Profiler to profile execution of Factomd")
+	chainsPtr := flag.String("chains", "", "Comma separated list of chain IDs.  If set, only the EBlocks and entries of these chains are synced.")
+	snapshotPtr := flag.String("snapshot", "", "Bootstrap an empty database from this snapshot file rather than syncing every block from peers.")
+	scenarioPtr := flag.String("scenario", "", "Run a simulation with the faults of this scenario file, and check its assertions at the end of the run.")
+	controlPtr := flag.String("control", "", "Address to serve the simulator's control API on, e.g. localhost:8099.  Off if not set.")
+	tracePtr := flag.String("trace", "", "Write a trace of the messages the nodes pass, one JSON event to a line, to this file.  See Utilities/MsgTrace.")
+	loadPtr := flag.Float64("load", 0, "Submissions a second of entries, chains and factoid transactions to put on node 0, from keys funded by the LOCAL genesis address.  Off if 0.")
+	loadTimePtr := flag.Int("loadtime", 60, "Seconds to submit the -load for.")
+	seedPtr := flag.Int64("seed", 0, "If not 0, run the nodes in a deterministic simulation on a virtual clock, seeded with this value.")
 
 	flag.Parse()
 
 	listenTo := *listenToPtr
 	cnt := *cntPtr
 	net := *netPtr
+	netFile := *netFilePtr
+	degree := *degreePtr
+	rewire := *rewirePtr
 	droprate := *dropPtr
 	journal := *journalPtr
+	journaling := *journalingPtr
+	journalStep := *journalStepPtr
 	follower := *followerPtr
 	leader := *leaderPtr
 	db := *dbPtr
 	cloneDB := *cloneDBPtr
+	configFile := *configPtr
 	folder := *folderPtr
 	portOverride := *portOverridePtr
 	address := *addressPtr
@@ -75,10 +131,50 @@ func NetStart(s *state.State) {
 	heartbeat := *heartbeatPtr
 	prefix := *prefixNodePtr
 	profile := *profilePtr
+	chains := *chainsPtr
+	snapshot := *snapshotPtr
+	seed := *seedPtr
+	traceFile := *tracePtr
+	loadRate := *loadPtr
+	loadTime := *loadTimePtr
+
+	replay := DefaultReplay
+	replay.Diverge = *journalDivergePtr
+	if *journalTypesPtr != "" {
+		replay.Types = strings.Split(*journalTypesPtr, ",")
+	}
+	var err error
+	if replay.From, err = state.ParseJournalPoint(*journalFromPtr); err != nil {
+		panic("journalfrom: " + err.Error())
+	}
+	if replay.To, err = state.ParseJournalPoint(*journalToPtr); err != nil {
+		panic("journalto: " + err.Error())
+	}
+	if journalStep {
+		replay.Step = make(chan bool, 1)
+	}
+	scenarioFile := *scenarioPtr
+	controlAddress := *controlPtr
+
+	var scenario *Scenario
+	if scenarioFile != "" {
+		if scenario, err = LoadScenario(scenarioFile); err != nil {
+			panic("Could not load the scenario: " + err.Error())
+		}
+		if seed == 0 {
+			seed = scenario.Seed
+		}
+		if seed == 0 {
+			seed = 1 // A scenario always runs in a simulation
+		}
+	}
 
 	// Must add the prefix before loading the configuration.
 	s.AddPrefix(prefix)
 	FactomConfigFilename := util.GetConfigFilename("m2")
+	if configFile != "" {
+		FactomConfigFilename = configFile
+	}
 	fmt.Println(fmt.Sprintf("factom config: %s", FactomConfigFilename))
 	s.LoadConfig(FactomConfigFilename, folder)
 
@@ -86,6 +182,10 @@ func NetStart(s *state.State) {
 		s.SetPort(portOverride)
 	}
 
+	if len(chains) > 0 {
+		s.ChainScope = chains
+	}
+
 	if blkTime != 0 {
 		s.DirectoryBlockInSeconds = blkTime
 	} else {
@@ -116,6 +216,9 @@ func NetStart(s *state.State) {
 		fmt.Print("<Break>\n")
 		fmt.Print("Gracefully shutting down the server...\n")
 		for _, fnode := range fnodes {
+			if fnode.Stopped() {
+				continue
+			}
 			fmt.Print("Shutting Down: ", fnode.State.FactomNodeName, "\r\n")
 			fnode.State.ShutdownChan <- 0
 		}
@@ -162,23 +265,49 @@ func NetStart(s *state.State) {
 	os.Stderr.WriteString(fmt.Sprintf("prefix      %s\n", prefix))
 	os.Stderr.WriteString(fmt.Sprintf("count       %d\n", cnt))
 	os.Stderr.WriteString(fmt.Sprintf("net         \"%s\"\n", net))
+	os.Stderr.WriteString(fmt.Sprintf("netfile     \"%s\"\n", netFile))
+	os.Stderr.WriteString(fmt.Sprintf("degree      %d\n", degree))
+	os.Stderr.WriteString(fmt.Sprintf("rewire      %v\n", rewire))
 	os.Stderr.WriteString(fmt.Sprintf("drop        %d\n", droprate))
 	os.Stderr.WriteString(fmt.Sprintf("journal     \"%s\"\n", journal))
+	os.Stderr.WriteString(fmt.Sprintf("journaling  %v\n", journaling))
 	os.Stderr.WriteString(fmt.Sprintf("db          \"%s\"\n", db))
 	os.Stderr.WriteString(fmt.Sprintf("clonedb     \"%s\"\n", cloneDB))
 	os.Stderr.WriteString(fmt.Sprintf("folder      \"%s\"\n", folder))
 	os.Stderr.WriteString(fmt.Sprintf("port        \"%d\"\n", s.PortNumber))
+	os.Stderr.WriteString(fmt.Sprintf("network     \"%s\"\n", s.Network))
 	os.Stderr.WriteString(fmt.Sprintf("address     \"%s\"\n", address))
 	os.Stderr.WriteString(fmt.Sprintf("peers       \"%s\"\n", peers))
 	os.Stderr.WriteString(fmt.Sprintf("blkTime     %d\n", blkTime))
 	os.Stderr.WriteString(fmt.Sprintf("runtimeLog  %v\n", runtimeLog))
 	os.Stderr.WriteString(fmt.Sprintf("profile     %v\n", profile))
+	os.Stderr.WriteString(fmt.Sprintf("chains      \"%s\"\n", s.ChainScope))
+	os.Stderr.WriteString(fmt.Sprintf("snapshot    \"%s\"\n", snapshot))
+	os.Stderr.WriteString(fmt.Sprintf("seed        %d\n", seed))
+	os.Stderr.WriteString(fmt.Sprintf("scenario    \"%s\"\n", scenarioFile))
+	os.Stderr.WriteString(fmt.Sprintf("control     \"%s\"\n", controlAddress))
+	os.Stderr.WriteString(fmt.Sprintf("trace       \"%s\"\n", traceFile))
+	os.Stderr.WriteString(fmt.Sprintf("load        %v a second for %d seconds\n", loadRate, loadTime))
+	os.Stderr.WriteString(fmt.Sprintf("broadcast   \"%s\" (fanout %d)\n", s.BroadcastMode, s.GossipFanout))
 
 	s.AddPrefix(prefix)
 	s.SetOut(false)
+	s.Journaling = journaling
 	s.Init()
 	s.SetDropRate(droprate)
 
+	if snapshot != "" {
+		file, err := os.Open(snapshot)
+		if err != nil {
+			panic("Could not open the snapshot file: " + err.Error())
+		}
+		err = s.ImportSnapshot(file)
+		file.Close()
+		if err != nil {
+			panic("Failed to import the snapshot: " + err.Error())
+		}
+	}
+
 	mLog.init(runtimeLog, cnt)
 
 	//************************************************
@@ -192,14 +321,63 @@ func NetStart(s *state.State) {
 
 	// Start the P2P netowrk
 
-	// BUGBUG Get peers file from config
-	p2p := new(p2p.Controller).Init(address, "~/.factom/peers.json")
+	// The network authenticates us with our server key and identity.
+	p2p.NodeKey = s.GetServerPrivateKey().Key
+	p2p.NodeIdentityChain = s.GetIdentityChainID().String()
+	// Each network has its own magic number and peers file, and its port in
+	// factomd.conf, so nodes on different networks never talk to each other.
+	peersFile := "~/.factom/peers.json"
+	switch s.Network {
+	case "MAIN":
+		p2p.CurrentNetwork = p2p.MainNet
+	case "TEST":
+		p2p.CurrentNetwork = p2p.TestNet
+		peersFile = "~/.factom/testpeers.json"
+	case "LOCAL":
+		p2p.CurrentNetwork = p2p.LocalNet
+		peersFile = "~/.factom/localpeers.json"
+	case "CUSTOM":
+		p2p.CurrentNetwork = p2p.NetworkID(binary.BigEndian.Uint32(s.CustomNetworkID))
+		peersFile = fmt.Sprintf("~/.factom/custompeers-%x.json", s.CustomNetworkID)
+	}
+	if cfg, ok := s.GetCfg().(*util.FactomdConfig); ok {
+		if address == "" {
+			address = map[string]string{
+				"MAIN":   cfg.App.MainNetworkPort,
+				"TEST":   cfg.App.TestNetworkPort,
+				"LOCAL":  cfg.App.LocalNetworkPort,
+				"CUSTOM": cfg.App.CustomNetworkPort,
+			}[s.Network]
+		}
+		if 0 < cfg.Peer.BanDuration {
+			p2p.BanDuration = cfg.Peer.BanDuration
+		}
+		// Upload limits, so catch-up traffic can't crowd out consensus.
+		p2p.PeerUploadLimit = cfg.App.PeerUploadLimitKB * 1024
+		p2p.ClassUploadLimits[p2p.ClassConsensus] = cfg.App.ConsensusUploadLimitKB * 1024
+		p2p.ClassUploadLimits[p2p.ClassTransaction] = cfg.App.TransactionUploadLimitKB * 1024
+		p2p.ClassUploadLimits[p2p.ClassSync] = cfg.App.SyncUploadLimitKB * 1024
+		p2p.ClassUploadLimits[p2p.ClassDiscovery] = cfg.App.DiscoveryUploadLimitKB * 1024
+	}
+	// The network gossips broadcasts the same way the nodes do, or floods them.
+	p2p.GossipFanout = 0
+	if s.BroadcastMode == "gossip" {
+		p2p.GossipFanout = s.GossipFanout
+	}
+
+	if address == "" {
+		address = "8108"
+	}
+	p2p := new(p2p.Controller).Init(address, peersFile)
 	network = *p2p
 	network.StartNetwork(false) //BUGBUG This should be command line flag? Talk to Brian
 	// Setup the proxy (Which translates from network parcels to factom messages, handling addressing for directed messages)
 	p2pProxy := new(P2PProxy).Init(fnodes[0].State.FactomNodeName, "P2P Network").(*P2PProxy)
 	p2pProxy.FromNetwork = network.FromNetwork
 	p2pProxy.ToNetwork = network.ToNetwork
+	p2pProxy.Network = &network
+	p2pProxy.Node = fnodes[0]
+	wsapi.SetNetwork(&network)
 	fnodes[0].Peers = append(fnodes[0].Peers, p2pProxy)
 	p2pProxy.SetDebugMode(netdebug)
 	p2pProxy.SetTestMode(heartbeat)
@@ -222,86 +400,91 @@ func NetStart(s *state.State) {
 		network.DialPeer(peer)
 	}
 
-	switch net {
-	case "long":
-		fmt.Println("Using long Network")
-		for i := 1; i < cnt; i++ {
-			AddSimPeer(fnodes, i-1, i)
-		}
-	case "loops":
-		fmt.Println("Using loops Network")
-		for i := 1; i < cnt; i++ {
-			AddSimPeer(fnodes, i-1, i)
-		}
-		for i := 0; (i+17)*2 < cnt; i += 17 {
-			AddSimPeer(fnodes, i%cnt, (i+5)%cnt)
-		}
-		for i := 0; (i+13)*2 < cnt; i += 13 {
-			AddSimPeer(fnodes, i%cnt, (i+7)%cnt)
-		}
-	case "alot":
-		n := len(fnodes)
-		for i := 0; i < n; i++ {
-			AddSimPeer(fnodes, i, (i+1)%n)
-			AddSimPeer(fnodes, i, (i+3)%n)
-			AddSimPeer(fnodes, i, (i+5)%n)
-			AddSimPeer(fnodes, i, (i+7)%n)
-		}
+	// The random topologies are seeded as the simulation is, so a run with a
+	// seed is the same network each time.
+	netSeed := seed
+	if netSeed == 0 {
+		netSeed = time.Now().UnixNano()
+	}
+	var topology *Topology
+	if netFile != "" {
+		topology, err = LoadTopology(netFile, cnt)
+	} else {
+		topology, err = MakeTopology(net, cnt, degree, rewire, rand.New(rand.NewSource(netSeed)))
+	}
+	if err != nil {
+		fmt.Println(err, " Using a Long Network")
+		topology, _ = MakeTopology("long", cnt, degree, rewire, nil)
+	}
+	fmt.Printf("Using %s Network: %s\n", topology.Name, topology.Stats())
+	topology.Connect(fnodes)
 
-	case "tree":
-		index := 0
-		row := 1
-	treeloop:
-		for i := 0; true; i++ {
-			for j := 0; j <= i; j++ {
-				AddSimPeer(fnodes, index, row)
-				AddSimPeer(fnodes, index, row+1)
-				row++
-				index++
-				if index >= len(fnodes) {
-					break treeloop
-				}
-			}
-			row += 1
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			panic("Could not create the trace: " + err.Error())
 		}
-	case "circles":
-		circleSize := 7
-		index := 0
-		for {
-			AddSimPeer(fnodes, index, index+circleSize-1)
-			for i := index; i < index+circleSize-1; i++ {
-				AddSimPeer(fnodes, i, i+1)
-			}
-			index += circleSize
-
-			AddSimPeer(fnodes, index, index-circleSize/3)
-			AddSimPeer(fnodes, index+2, index-circleSize-circleSize*2/3-1)
-			AddSimPeer(fnodes, index+3, index-(2*circleSize)-circleSize*2/3)
-			AddSimPeer(fnodes, index+5, index-(3*circleSize)-circleSize*2/3+1)
+		mLog.Trace(f, fnodes)
+	}
 
-			if index >= len(fnodes) {
-				break
+	if journal != "" {
+		go func() {
+			result, err := ReplayJournal(s, journal, replay)
+			if err != nil {
+				fmt.Println(err)
+				return
 			}
-		}
-	default:
-		fmt.Println("Didn't understand network type. Known types: mesh, long, circles, tree, loops.  Using a Long Network")
-		for i := 1; i < cnt; i++ {
-			AddSimPeer(fnodes, i-1, i)
-		}
-
+			fmt.Println(result)
+		}()
 	}
-	if journal != "" {
-		go LoadJournal(s, journal)
-		startServers(false)
+	// Compare the blocks the nodes save, to catch the first that diverge.
+	divergence := NewDivergenceDetector(fnodes)
+	var load *LoadGenerator
+	if 0 < loadRate {
+		load = NewLoadGenerator(&APIQueueSubmitter{Node: fnodes[0]}, loadRate, time.Duration(loadTime)*time.Second)
+	}
+	var sim *Simulation
+	if seed != 0 {
+		sim = NewSimulation(seed, fnodes)
+		sim.Scenario = scenario
+		sim.Divergence = divergence
+		sim.Load = load
+		sim.Start(journal == "")
+		if scenario != nil && 0 < scenario.RunFor() {
+			runScenario(sim)
+		}
+		go sim.Run(time.Duration(math.MaxInt64))
 	} else {
-		startServers(true)
+		startServers(journal == "")
+		if 1 < len(fnodes) {
+			go divergence.Watch(time.Second)
+		}
+		if load != nil {
+			go func() {
+				report, err := load.Run(time.Minute)
+				if err != nil {
+					fmt.Println("Load stopped:", err)
+				}
+				fmt.Print(report)
+			}()
+		}
 	}
 
 	// Start the webserver
 	go wsapi.Start(fnodes[0].State)
 
-	// Listen for commands:
-	SimControl(listenTo)
+	// Listen for commands, from the control API if asked for, and the keyboard:
+	control := NewSimController(fnodes, listenTo)
+	control.Sim = sim
+	control.ReplayStep = replay.Step
+	control.Divergence = divergence
+	control.Load = load
+	if controlAddress != "" {
+		go func() {
+			fmt.Println("Control API:", control.Serve(controlAddress))
+		}()
+	}
+	SimControl(control)
 
 }
 
@@ -324,21 +507,33 @@ func makeServer(s *state.State) *FactomNode {
 	fnode.State = newState
 	fnodes = append(fnodes, fnode)
 	fnode.MLog = mLog
+	fnode.Gossip = NewGossip()
+	fnode.Requests = NewRequests()
 
 	return fnode
 }
 
+// runScenario runs the simulation for the time its scenario asks for, then
+// checks the scenario's assertions and exits.
+func runScenario(sim *Simulation) {
+	sim.Run(sim.Scenario.RunFor())
+	errs := sim.Scenario.Check(sim)
+	for _, err := range errs {
+		fmt.Println("Scenario failed:", err)
+	}
+	if 0 < len(errs) {
+		os.Exit(1)
+	}
+	fmt.Println("Scenario passed")
+	os.Exit(0)
+}
+
 func startServers(load bool) {
 
 	for i, fnode := range fnodes {
 		if i > 0 {
 			fnode.State.Init()
 		}
-		go NetworkProcessorNet(fnode)
-		if load {
-			go state.LoadDatabase(fnode.State)
-		}
-		go Timer(fnode.State)
-		go fnode.State.ValidatorLoop()
+		fnode.start(load)
 	}
 }
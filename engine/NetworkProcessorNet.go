@@ -6,7 +6,6 @@ package engine
 
 import (
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/FactomProject/factomd/common/interfaces"
@@ -17,89 +16,98 @@ var _ = log.Printf
 var _ = fmt.Print
 
 func NetworkProcessorNet(fnode *FactomNode) {
-	go Peers(fnode)
-	go NetworkOutputs(fnode)
-	go InvalidOutputs(fnode)
+	fnode.run(func() { Peers(fnode) })
+	fnode.run(func() { NetworkOutputs(fnode) })
+	fnode.run(func() { InvalidOutputs(fnode) })
+	fnode.run(func() { ManageRequests(fnode) })
 }
 
 func Peers(fnode *FactomNode) {
-	cnt := 0
-	for {
-		for i := 0; i < 100 && len(fnode.State.APIQueue()) > 0; i++ {
-			select {
-			case msg := <-fnode.State.APIQueue():
-				if msg == nil {
-					break
-				}
-				cnt++
-				msg.SetOrigin(0)
-				if fnode.State.Replay.IsTSValid_(msg.GetMsgHash().Fixed(),
-					int64(msg.GetTimestamp())/1000,
-					int64(fnode.State.GetTimestamp())/1000) {
-
-					fnode.MLog.add2(fnode, false, fnode.State.FactomNodeName, "API", true, msg)
-					if len(fnode.State.InMsgQueue()) < 9000 {
-						fnode.State.InMsgQueue() <- msg
-					}
-				}
-			default:
+	for !fnode.quitting() {
+		if receive(fnode) == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
 
+// receive moves what has come in from the API and from our peers onto the
+// node's InMsgQueue, and returns the number of messages it looked at.
+func receive(fnode *FactomNode) (cnt int) {
+	for i := 0; i < 100 && len(fnode.State.APIQueue()) > 0; i++ {
+		select {
+		case msg := <-fnode.State.APIQueue():
+			if msg == nil {
+				break
+			}
+			cnt++
+			msg.SetOrigin(0)
+			if fnode.State.Replay.IsTSValid_(msg.GetMsgHash().Fixed(),
+				int64(msg.GetTimestamp())/1000,
+				int64(fnode.State.GetTimestamp())/1000) {
+
+				fnode.MLog.add2(fnode, false, fnode.State.FactomNodeName, "API", true, msg)
+				if len(fnode.State.InMsgQueue()) < 9000 {
+					fnode.State.InMsgQueue() <- msg
+				}
 			}
+		default:
+
 		}
+	}
 
-		// Put any broadcasts from our peers into our BroadcastIn queue
-		for i, peer := range fnode.Peers {
-			for j := 0; j < 100; j++ {
+	// Put any broadcasts from our peers into our BroadcastIn queue
+	for i, peer := range fnode.Peers {
+		for j := 0; j < 100; j++ {
 
-				var msg interfaces.IMsg
-				var err error
+			var msg interfaces.IMsg
+			var err error
 
-				if !fnode.State.GetNetStateOff() {
-					msg, err = peer.Recieve()
-				}
+			if !fnode.State.GetNetStateOff() {
+				msg, err = peer.Recieve()
+			}
 
-				if msg == nil {
-					// Recieve is not blocking; nothing to do, we get a nil.
-					break
-				}
+			if msg == nil {
+				// Recieve is not blocking; nothing to do, we get a nil.
+				break
+			}
 
-				cnt++
+			cnt++
 
-				if err != nil {
-					fmt.Println("ERROR recieving message on", fnode.State.FactomNodeName+":", err)
-					break
+			if err != nil {
+				fmt.Println("ERROR recieving message on", fnode.State.FactomNodeName+":", err)
+				break
+			}
+
+			msg.SetOrigin(i + 1)
+			if gossipReceive(fnode, i, msg) {
+				continue
+			}
+			fnode.Requests.Heard(i, msg)
+			if fnode.State.Replay.IsTSValid_(msg.GetMsgHash().Fixed(),
+				int64(msg.GetTimestamp())/1000,
+				int64(fnode.State.GetTimestamp())/1000) {
+				//if state.GetOut() {
+				//	fnode.State.Println("In Comming!! ",msg)
+				//}
+				in := "PeerIn"
+				if msg.IsPeer2Peer() {
+					in = "P2P In"
 				}
+				nme := fmt.Sprintf("%s %d", in, i+1)
 
-				msg.SetOrigin(i + 1)
-				if fnode.State.Replay.IsTSValid_(msg.GetMsgHash().Fixed(),
-					int64(msg.GetTimestamp())/1000,
-					int64(fnode.State.GetTimestamp())/1000) {
-					//if state.GetOut() {
-					//	fnode.State.Println("In Comming!! ",msg)
-					//}
-					in := "PeerIn"
-					if msg.IsPeer2Peer() {
-						in = "P2P In"
-					}
-					nme := fmt.Sprintf("%s %d", in, i+1)
-
-					fnode.MLog.add2(fnode, false, peer.GetNameTo(), nme, true, msg)
-
-					// Ignore messages if there are too many.
-					if len(fnode.State.InMsgQueue()) < 9000 {
-						fnode.State.InMsgQueue() <- msg
-					}
-
-				} else {
-					fnode.MLog.add2(fnode, false, peer.GetNameTo(), "PeerIn", false, msg)
+				fnode.MLog.add2(fnode, false, peer.GetNameTo(), nme, true, msg)
+
+				// Ignore messages if there are too many.
+				if len(fnode.State.InMsgQueue()) < 9000 {
+					fnode.State.InMsgQueue() <- msg
 				}
+
+			} else {
+				fnode.MLog.add2(fnode, false, peer.GetNameTo(), "PeerIn", false, msg)
 			}
 		}
-		if cnt == 0 {
-			time.Sleep(50 * time.Millisecond)
-		}
-		cnt = 0
 	}
+	return
 }
 
 func NetworkOutputs(fnode *FactomNode) {
@@ -108,57 +116,88 @@ func NetworkOutputs(fnode *FactomNode) {
 		// 	fmt.Print(fnode.State.GetFactomNodeName(), "-", len(fnode.State.NetworkOutMsgQueue()), " ")
 		// }
 		time.Sleep(1 * time.Millisecond)
-		msg := <-fnode.State.NetworkOutMsgQueue()
-
-		// Local Messages are Not broadcast out.  This is mostly the block signature
-		// generated by the timer for the leaders which needs to be processed, but replaced
-		// by an updated version when the block is ready.
-		if !msg.IsLocal() {
-			if rand.Int()%1000 < fnode.State.GetDropRate() {
-				//drop the message, rather than processing it normally
-			} else {
-				// We don't care about the result, but we do want to log that we have
-				// seen this message before, because we might have generated the message
-				// ourselves.
-				fnode.State.Replay.IsTSValid_(
-					msg.GetMsgHash().Fixed(),
-					int64(msg.GetTimestamp())/1000,
-					int64(fnode.State.GetTimestamp())/1000)
-
-				p := msg.GetOrigin() - 1
+		select {
+		case msg := <-fnode.State.NetworkOutMsgQueue():
+			send(fnode, msg)
+		case <-fnode.quit:
+			return
+		}
+	}
+}
 
-				if msg.IsPeer2Peer() {
-					// Must have a Peer to send a message to a peer
-					if len(fnode.Peers) > 0 {
-						if p < 0 {
-							p = rand.Int() % len(fnode.Peers)
-						}
-						fnode.MLog.add2(fnode, true, fnode.Peers[p].GetNameTo(), "P2P out", true, msg)
-						if !fnode.State.GetNetStateOff() {
-							fnode.Peers[p].Send(msg)
-						}
-					}
-				} else {
-					for i, peer := range fnode.Peers {
-						// Don't resend to the node that sent it to you.
-						if i != p {
-							bco := fmt.Sprintf("%s/%d/%d", "BCast", p, i)
-							fnode.MLog.add2(fnode, true, peer.GetNameTo(), bco, true, msg)
-							if !fnode.State.GetNetStateOff() {
-								peer.Send(msg)
-							}
-						}
-					}
+// send sends a message from the node's NetworkOutMsgQueue to its peers.
+func send(fnode *FactomNode, msg interfaces.IMsg) {
+	// Local Messages are Not broadcast out.  This is mostly the block signature
+	// generated by the timer for the leaders which needs to be processed, but replaced
+	// by an updated version when the block is ready.
+	if msg.IsLocal() {
+		return
+	}
+	if fnode.intn(1000) < fnode.State.GetDropRate() {
+		//drop the message, rather than processing it normally
+		return
+	}
+	// We don't care about the result, but we do want to log that we have
+	// seen this message before, because we might have generated the message
+	// ourselves.
+	fnode.State.Replay.IsTSValid_(
+		msg.GetMsgHash().Fixed(),
+		int64(msg.GetTimestamp())/1000,
+		int64(fnode.State.GetTimestamp())/1000)
+
+	p := msg.GetOrigin() - 1
+
+	if msg.IsPeer2Peer() {
+		// Our own requests for missing data go to a peer that should
+		// have it, and are retried elsewhere if not answered.
+		if p < 0 && fnode.Requests.Send(fnode, msg) {
+			return
+		}
+		// Must have a Peer to send a message to a peer
+		if len(fnode.Peers) > 0 {
+			if p < 0 {
+				p = fnode.intn(len(fnode.Peers))
+			}
+			fnode.MLog.add2(fnode, true, fnode.Peers[p].GetNameTo(), "P2P out", true, msg)
+			if !fnode.State.GetNetStateOff() {
+				fnode.Peers[p].Send(msg)
+			}
+		}
+	} else if fnode.State.BroadcastMode == "gossip" {
+		gossipBroadcast(fnode, msg, p)
+	} else {
+		for i, peer := range fnode.Peers {
+			// Don't resend to the node that sent it to you.
+			if i != p {
+				bco := fmt.Sprintf("%s/%d/%d", "BCast", p, i)
+				fnode.MLog.add2(fnode, true, peer.GetNameTo(), bco, true, msg)
+				if !fnode.State.GetNetStateOff() {
+					peer.Send(msg)
 				}
 			}
 		}
 	}
 }
 
-// Just throw away the trash
+// Throw away the trash, but demerit the network peers that sent it.
 func InvalidOutputs(fnode *FactomNode) {
 	for {
 		time.Sleep(1 * time.Millisecond)
-		<-fnode.State.NetworkInvalidMsgQueue()
+		select {
+		case msg := <-fnode.State.NetworkInvalidMsgQueue():
+			invalid(fnode, msg)
+		case <-fnode.quit:
+			return
+		}
+	}
+}
+
+func invalid(fnode *FactomNode, msg interfaces.IMsg) {
+	p := msg.GetOrigin() - 1
+	if p < 0 || p >= len(fnode.Peers) {
+		return
+	}
+	if proxy, ok := fnode.Peers[p].(*P2PProxy); ok {
+		proxy.Demerit(msg.GetNetworkOrigin(), InvalidMsgDemerit)
 	}
 }
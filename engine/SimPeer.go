@@ -21,6 +21,8 @@ type SimPeer struct {
 	// Channels that define the connection:
 	BroadcastOut chan []byte
 	BroadcastIn  chan []byte
+	// Set when a Simulation delivers what is sent on its virtual clock
+	sim *Simulation
 }
 
 var _ interfaces.IPeer = (*SimPeer)(nil)
@@ -67,6 +69,10 @@ func (f *SimPeer) Send(msg interfaces.IMsg) error {
 		fmt.Println("ERROR on Send: ", err)
 		return err
 	}
+	if f.sim != nil {
+		f.sim.deliver(f, data)
+		return nil
+	}
 	if len(f.BroadcastOut) < 9000 {
 		f.BroadcastOut <- data
 	}
@@ -0,0 +1,202 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/state"
+)
+
+// A DivergenceDetector compares the digests of the blocks each of the
+// simulator's nodes saved, height by height, and stops at the first height
+// where two of them disagree.  Nodes also compare digests with their peers,
+// over BlockDigestMsg; see State.Divergence.
+
+type DivergenceDetector struct {
+	mutex   sync.Mutex
+	Nodes   []*FactomNode
+	First   *state.Divergence // The first divergence found, if any
+	Report  string            // The differences between the two nodes' blocks and process lists there
+	checked uint32            // Every node agreed on the heights below this
+}
+
+func NewDivergenceDetector(nodes []*FactomNode) *DivergenceDetector {
+	d := new(DivergenceDetector)
+	d.Nodes = nodes
+	return d
+}
+
+// Check compares the nodes' digests of each height they have all saved since
+// the last check, and returns the first divergence found, if any.
+func (d *DivergenceDetector) Check() *state.Divergence {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.First != nil || len(d.Nodes) < 2 {
+		return d.First
+	}
+	for _, fnode := range d.Nodes {
+		if fnode.Stopped() {
+			return nil // Its blocks can't be read until it is restarted
+		}
+	}
+
+	top := d.Nodes[0].State.GetHighestRecordedBlock()
+	for _, fnode := range d.Nodes[1:] {
+		if h := fnode.State.GetHighestRecordedBlock(); h < top {
+			top = h
+		}
+	}
+	for ; d.checked <= top; d.checked++ {
+		first := d.Nodes[0].State.GetDigest(d.checked)
+		if first == nil {
+			return nil // Not saved after all; try again later
+		}
+		for i, fnode := range d.Nodes[1:] {
+			digest := fnode.State.GetDigest(d.checked)
+			if digest == nil {
+				return nil
+			}
+			if diffs := first.Diff(digest); 0 < len(diffs) {
+				d.First = &state.Divergence{Height: d.checked, A: first, B: digest, Differences: diffs}
+				d.Report = DivergenceReport(d.Nodes[0], d.Nodes[i+1], d.checked)
+				fmt.Println(d.First.String())
+				fmt.Print(d.Report)
+				return d.First
+			}
+		}
+	}
+	return nil
+}
+
+// Divergence returns the first divergence found, and its report.
+func (d *DivergenceDetector) Divergence() (*state.Divergence, string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.First, d.Report
+}
+
+// Watch checks the nodes every period, until it finds a divergence.  The
+// Simulation checks its nodes itself.
+func (d *DivergenceDetector) Watch(period time.Duration) {
+	for d.Check() == nil {
+		time.Sleep(period)
+	}
+}
+
+// DivergenceReport is a diff of the blocks nodes a and b saved at height, and
+// of their process lists, if they still have them: lines only a has start
+// with "-", and lines only b has with "+".
+func DivergenceReport(a, b *FactomNode, height uint32) string {
+	return diffBlocks(a.State.FactomNodeName, b.State.FactomNodeName, height, divergenceBlocks(a.State, height), divergenceBlocks(b.State, height))
+}
+
+// diffBlocks is the report of two nodes' blocks at height, as divergenceBlocks
+// returns them.
+func diffBlocks(nameA, nameB string, height uint32, blocksA, blocksB []string) string {
+	report := fmt.Sprintf("--- %s\n+++ %s\n", nameA, nameB)
+	kinds := []string{"Directory Block", "Admin Block", "Factoid Block", "Entry Credit Block", "Process List"}
+	for i, kind := range kinds {
+		if i >= len(blocksA) || i >= len(blocksB) {
+			break
+		}
+		diff := lineDiff(strings.Split(blocksA[i], "\n"), strings.Split(blocksB[i], "\n"))
+		if len(diff) == 0 {
+			continue
+		}
+		report += fmt.Sprintf("@@ %s %d @@\n", kind, height)
+		report += strings.Join(diff, "\n") + "\n"
+	}
+	return report
+}
+
+// divergenceBlocks returns the blocks s saved at height, and its process
+// list, as text.
+func divergenceBlocks(s *state.State, height uint32) []string {
+	var blocks []interfaces.Printable
+	if dbstate := s.GetDBState(height); dbstate != nil {
+		blocks = append(blocks, dbstate.DirectoryBlock, dbstate.AdminBlock, dbstate.FactoidBlock, dbstate.EntryCreditBlock)
+	} else if msg, err := s.LoadDBState(height); err == nil && msg != nil {
+		dbstate := msg.(*messages.DBStateMsg)
+		blocks = append(blocks, dbstate.DirectoryBlock, dbstate.AdminBlock, dbstate.FactoidBlock, dbstate.EntryCreditBlock)
+	} else {
+		blocks = make([]interfaces.Printable, 4)
+	}
+	var text []string
+	for _, block := range blocks {
+		if block == nil {
+			text = append(text, "<nil>")
+		} else {
+			text = append(text, block.String())
+		}
+	}
+	if pl := s.ProcessLists.Find(height); pl != nil {
+		// Without the node's name, which would always differ.
+		text = append(text, strings.Replace(pl.String(), s.FactomNodeName, "", -1))
+	} else {
+		text = append(text, "<no process list>")
+	}
+	return text
+}
+
+// lineDiff returns the lines where a and b differ, marked "-" if only in a
+// and "+" if only in b, with a line of context either side, and "..." for
+// lines left out.  It is empty if they are the same.
+func lineDiff(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	var changed []bool
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines, changed = append(lines, "  "+a[i]), append(changed, false)
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines, changed = append(lines, "- "+a[i]), append(changed, true)
+			i++
+		default:
+			lines, changed = append(lines, "+ "+b[j]), append(changed, true)
+			j++
+		}
+	}
+
+	var diff []string
+	last := -1
+	for k := range lines {
+		near := changed[k] || (0 < k && changed[k-1]) || (k+1 < len(lines) && changed[k+1])
+		if !near {
+			continue
+		}
+		if 0 <= last && last+1 < k {
+			diff = append(diff, "...")
+		}
+		diff = append(diff, lines[k])
+		last = k
+	}
+	return diff
+}
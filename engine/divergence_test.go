@@ -0,0 +1,68 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/FactomProject/factomd/engine"
+)
+
+func TestDivergenceDetector(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	sim := newSimulation(42, 3)
+	detector := NewDivergenceDetector(sim.Nodes)
+	sim.Divergence = detector
+	sim.Run(time.Minute)
+
+	// Whether or not the nodes diverged, every height below the one found
+	// must agree, and the one found must not.
+	top := sim.Nodes[0].State.GetHighestRecordedBlock()
+	for _, fnode := range sim.Nodes {
+		if h := fnode.State.GetHighestRecordedBlock(); h < top {
+			top = h
+		}
+	}
+	first, report := detector.Divergence()
+	if first != nil {
+		top = first.Height
+		if len(first.A.Diff(first.B)) == 0 || len(first.Differences) == 0 {
+			t.Errorf("Divergence at %d with no differences", first.Height)
+		}
+		if !strings.HasPrefix(report, "--- "+first.A.Node+"\n+++ "+first.B.Node+"\n") || !strings.Contains(report, "\n@@ ") {
+			t.Errorf("The report doesn't diff %s and %s:\n%s", first.A.Node, first.B.Node, report)
+		}
+	}
+	for h := uint32(0); h < top; h++ {
+		digest := sim.Nodes[0].State.GetDigest(h)
+		for _, fnode := range sim.Nodes[1:] {
+			if diffs := digest.Diff(fnode.State.GetDigest(h)); 0 < len(diffs) {
+				t.Errorf("%s and %s diverge at %d, before the divergence found: %v", digest.Node, fnode.State.FactomNodeName, h, diffs)
+			}
+		}
+	}
+}
+
+func TestDivergenceReport(t *testing.T) {
+	sim := newSimulation(7, 2)
+	sim.Run(5 * time.Second)
+	a, b := sim.Nodes[0], sim.Nodes[1]
+	if a.State.GetDigest(0) == nil || b.State.GetDigest(0) == nil {
+		t.Fatalf("No digest of the genesis block")
+	}
+	if report := DivergenceReport(a, b, 0); strings.Contains(report, "\n- ") || strings.Contains(report, "\n+ ") {
+		t.Errorf("The genesis blocks differ:\n%s", report)
+	}
+	if d := a.State.GetDigest(0).Diff(b.State.GetDigest(0)); 0 < len(d) {
+		t.Errorf("The genesis digests differ: %v", d)
+	}
+	if a.State.GetDigest(a.State.GetHighestRecordedBlock()+1) != nil {
+		t.Errorf("A digest of a block not saved")
+	}
+}
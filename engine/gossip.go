@@ -0,0 +1,120 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/p2p"
+)
+
+// With BroadcastMode "gossip", a broadcast goes in full to GossipFanout of our
+// peers picked at random, and the others are only sent an InventoryMsg with its
+// MsgHash.  A peer that has not seen the message asks us for it with a
+// GetDataMsg, so it still gets it if none of the peers that sent it in full
+// reach it.  Any other BroadcastMode floods every broadcast to every peer.
+//
+// The P2PProxy stands for the whole p2p network, so it always gets the message
+// in full; the p2p Controller gossips it on to its own connections.
+
+// Gossip is the recently seen messages of a node, by MsgHash, and the
+// messages it has asked its peers for, kept as the p2p Controller keeps the
+// payloads it gossips.
+type Gossip struct {
+	cache *p2p.GossipCache // Values are interfaces.IMsg
+}
+
+func NewGossip() *Gossip {
+	g := new(Gossip)
+	g.cache = p2p.NewGossipCache()
+	return g
+}
+
+// Add remembers msg as seen, forgetting the least recently seen message if the
+// cache is full.
+func (g *Gossip) Add(msg interfaces.IMsg) {
+	hash := msg.GetMsgHash()
+	if hash == nil {
+		return
+	}
+	g.cache.Add(hash.Fixed(), msg)
+}
+
+// Get returns the message with the given MsgHash, or nil if we haven't seen it
+// recently.
+func (g *Gossip) Get(hash interfaces.IHash) interfaces.IMsg {
+	msg, _ := g.cache.Get(hash.Fixed()).(interfaces.IMsg)
+	return msg
+}
+
+// Request returns true if we should ask for the message with the given
+// MsgHash: we haven't seen it, and haven't asked for it in the last
+// p2p.GossipRequestTimeout.
+func (g *Gossip) Request(hash interfaces.IHash) bool {
+	now := interfaces.Now()
+	g.cache.Expire(now)
+	return g.cache.Request(hash.Fixed(), now)
+}
+
+// gossipBroadcast sends msg to every peer but the one at index p, in full to
+// GossipFanout of them, and as an inventory announcement to the rest.
+func gossipBroadcast(fnode *FactomNode, msg interfaces.IMsg, p int) {
+	fnode.Gossip.Add(msg)
+
+	var others []int
+	for i, peer := range fnode.Peers {
+		if i == p {
+			continue
+		}
+		if _, ok := peer.(*P2PProxy); ok {
+			gossipSend(fnode, i, fmt.Sprintf("%s/%d/%d", "BCast", p, i), msg)
+			continue
+		}
+		others = append(others, i)
+	}
+
+	var inventory interfaces.IMsg
+	for n, j := range fnode.perm(len(others)) {
+		i := others[j]
+		if n < fnode.State.GossipFanout {
+			gossipSend(fnode, i, fmt.Sprintf("%s/%d/%d", "Gossip", p, i), msg)
+			continue
+		}
+		if inventory == nil {
+			inventory = messages.NewInventoryMsg(fnode.State, msg.GetMsgHash())
+		}
+		gossipSend(fnode, i, fmt.Sprintf("%s/%d/%d", "Inv", p, i), inventory)
+	}
+}
+
+// gossipReceive notes msg, just received from the peer at index i, as seen.
+// It answers inventory and get data messages, and returns true for them, as
+// they go no further than the network.
+func gossipReceive(fnode *FactomNode, i int, msg interfaces.IMsg) bool {
+	switch m := msg.(type) {
+	case *messages.InventoryMsg:
+		if fnode.Gossip.Request(m.Announced) {
+			gossipSend(fnode, i, "GetData", messages.NewGetDataMsg(fnode.State, m.Announced))
+		}
+		return true
+	case *messages.GetDataMsg:
+		if data := fnode.Gossip.Get(m.RequestHash); data != nil {
+			gossipSend(fnode, i, "Data", data)
+		}
+		return true
+	}
+	fnode.Gossip.Add(msg)
+	return false
+}
+
+func gossipSend(fnode *FactomNode, i int, where string, msg interfaces.IMsg) {
+	peer := fnode.Peers[i]
+	fnode.MLog.add2(fnode, true, peer.GetNameTo(), where, true, msg)
+	if !fnode.State.GetNetStateOff() {
+		peer.Send(msg)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"testing"
+
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/common/primitives"
+	. "github.com/FactomProject/factomd/engine"
+	"github.com/FactomProject/factomd/p2p"
+)
+
+func newGossipMsg(i int) *messages.MissingData {
+	msg := new(messages.MissingData)
+	msg.Timestamp.SetTimeNow()
+	msg.RequestHash = primitives.Sha([]byte{byte(i >> 8), byte(i)})
+	return msg
+}
+
+func TestGossipCache(t *testing.T) {
+	g := NewGossip()
+	first := newGossipMsg(0)
+	if !g.Request(first.GetMsgHash()) {
+		t.Errorf("A message we haven't seen should be requested")
+	}
+	if g.Request(first.GetMsgHash()) {
+		t.Errorf("A message should not be requested again while the request is in flight")
+	}
+
+	g.Add(first)
+	if g.Get(first.GetMsgHash()) != first {
+		t.Errorf("Did not get back the message added")
+	}
+	if g.Request(first.GetMsgHash()) {
+		t.Errorf("A message we have seen should not be requested")
+	}
+
+	for i := 1; i <= p2p.GossipCacheSize; i++ {
+		g.Add(newGossipMsg(i))
+	}
+	if g.Get(first.GetMsgHash()) != nil {
+		t.Errorf("The least recently seen message should have been dropped")
+	}
+}
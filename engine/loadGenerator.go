@@ -0,0 +1,571 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FactomProject/factomd/common/constants"
+	"github.com/FactomProject/factomd/common/entryBlock"
+	"github.com/FactomProject/factomd/common/factoid"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/wallet"
+)
+
+// A LoadGenerator puts load on a node: commit/reveal pairs that start new
+// chains or add entries to them, and factoid transactions, at a steady rate.
+// It first funds the wallet's keys 1 to Keys from the address the genesis
+// block of a LOCAL network funds, then spreads the load over them.  Each
+// reveal is sent once its commit is acknowledged.  Step moves it on, from the
+// clock of a Simulation or, in Run, from the real one; Report says how much
+// was submitted, and how long it took to be acknowledged and to be saved in a
+// directory block.
+
+// The kinds of load.
+const (
+	LoadEntry       = "entry"
+	LoadChain       = "chain"
+	LoadTransaction = "transaction"
+	loadFunding     = "funding"
+)
+
+const LoadFundingTimeout = time.Minute // To wait for the funding to be acknowledged
+
+// A LoadSubmitter takes the load to a node.
+type LoadSubmitter interface {
+	Submit(msg interfaces.IMsg) error
+	AckStatus(hash interfaces.IHash) (int, error) // As State.GetACKStatus
+	FactoshisPerEC() (uint64, error)
+}
+
+type LoadGenerator struct {
+	Submitter    LoadSubmitter
+	Rate         float64       // Submissions a second; a commit and its reveal are one
+	Duration     time.Duration // To submit for, once funded
+	Entries      int           // The mix of the load, as weights
+	Chains       int
+	Transactions int
+	Keys         uint64        // The load is spread over
+	Credits      uint64        // Entry credits each key is funded with
+	Factoshis    uint64        // Factoshis each key is funded with, to make transactions from
+	Poll         time.Duration // How often the status of what was submitted is checked
+
+	mutex   sync.Mutex
+	nonce   []byte // Makes the chains of this run new
+	funding *loadItem
+	rate    uint64 // Factoshis per entry credit
+	start   time.Time
+	next    time.Time
+	polled  time.Time
+	items   []*loadItem
+	chains  []interfaces.IHash // Made, to add entries to
+	count   uint64
+	failed  int
+	err     error
+}
+
+type loadItem struct {
+	kind     string
+	commit   interfaces.IMsg  // Of an entry or chain
+	msg      interfaces.IMsg  // The reveal or the transaction
+	block    interfaces.IHash // The msg is recorded as included in a block under
+	sent     time.Time
+	revealed bool
+	acked    time.Duration // Since sent, 0 until acknowledged
+	saved    time.Duration // Since sent, 0 until saved in a directory block
+}
+
+// NewLoadGenerator makes a generator that submits rate a second for d through
+// submitter, with a mix of mostly entries, some transactions and a few new
+// chains.
+func NewLoadGenerator(submitter LoadSubmitter, rate float64, d time.Duration) *LoadGenerator {
+	g := new(LoadGenerator)
+	g.Submitter = submitter
+	g.Rate = rate
+	g.Duration = d
+	g.Entries = 6
+	g.Chains = 1
+	g.Transactions = 3
+	g.Keys = 4
+	g.Credits = 10000
+	g.Factoshis = 10e8
+	g.Poll = 100 * time.Millisecond
+	return g
+}
+
+// Step funds the keys, submits what is due, and checks on what has been
+// submitted.  It returns the error that stopped the load, if any.
+func (g *LoadGenerator) Step() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.err != nil {
+		return g.err
+	}
+	now := interfaces.Now()
+
+	if g.funding == nil {
+		g.nonce = make([]byte, 8)
+		binary.BigEndian.PutUint64(g.nonce, uint64(now.UnixNano()))
+		if g.rate, g.err = g.Submitter.FactoshisPerEC(); g.err != nil {
+			return g.err
+		}
+		g.funding = g.fund()
+		g.funding.sent = now
+		if g.err = g.Submitter.Submit(g.funding.msg); g.err != nil {
+			return g.err
+		}
+	}
+	if g.start.IsZero() {
+		if g.err = g.check(g.funding, now); g.err != nil {
+			return g.err
+		}
+		if g.funding.acked == 0 {
+			if LoadFundingTimeout < now.Sub(g.funding.sent) {
+				g.err = fmt.Errorf("The funding transaction was not acknowledged in %s; is the node on a LOCAL network?", LoadFundingTimeout)
+			}
+			return g.err
+		}
+		// Give the node a moment to process the funding before it is spent
+		g.start = now.Add(g.Poll)
+		g.next = g.start
+	}
+
+	interval := time.Duration(float64(time.Second) / g.Rate)
+	for !now.Before(g.next) && g.next.Before(g.start.Add(g.Duration)) {
+		item := g.make()
+		item.sent = now
+		first := item.commit
+		if first == nil {
+			first = item.msg
+		}
+		if err := g.Submitter.Submit(first); err != nil {
+			g.failed++
+		} else {
+			g.items = append(g.items, item)
+		}
+		g.next = g.next.Add(interval)
+	}
+
+	if now.Sub(g.polled) < g.Poll {
+		return nil
+	}
+	g.polled = now
+	for _, item := range g.items {
+		if item.saved == 0 {
+			if g.err = g.check(item, now); g.err != nil {
+				return g.err
+			}
+		}
+	}
+	return nil
+}
+
+// Done is true once the load has all been submitted, and either saved in a
+// directory block or given wait since the last submission to be.
+func (g *LoadGenerator) Done(wait time.Duration) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.err != nil {
+		return true
+	}
+	if g.start.IsZero() || interfaces.Now().Before(g.start.Add(g.Duration)) {
+		return false
+	}
+	if interfaces.Now().After(g.start.Add(g.Duration + wait)) {
+		return true
+	}
+	for _, item := range g.items {
+		if item.saved == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Run steps the generator on the real clock until it is Done, and reports.
+func (g *LoadGenerator) Run(wait time.Duration) (*LoadReport, error) {
+	tick := g.Poll
+	if interval := time.Duration(float64(time.Second) / g.Rate); interval < tick {
+		tick = interval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := g.Step(); err != nil {
+			return g.Report(), err
+		}
+		if g.Done(wait) {
+			break
+		}
+	}
+	return g.Report(), nil
+}
+
+// fund makes the transaction that funds the keys from the LOCAL genesis
+// address.
+func (g *LoadGenerator) fund() *loadItem {
+	tx := new(factoid.Transaction)
+	for k := uint64(1); k <= g.Keys; k++ {
+		tx.AddECOutput(wallet.NewECPublicKey(k), g.Credits*g.rate)
+		tx.AddOutput(wallet.NewFactoidAddress(k), g.Factoshis)
+	}
+	tx.AddInput(wallet.NewLocalGenesisAddress(), 0)
+	return g.transaction(loadFunding, tx, wallet.NewLocalGenesisPrivKey())
+}
+
+// make makes the next submission of the mix.
+func (g *LoadGenerator) make() *loadItem {
+	g.count++
+	key := 1 + g.count%g.Keys
+	n := g.count % uint64(g.Entries+g.Chains+g.Transactions)
+
+	switch {
+	case n < uint64(g.Transactions):
+		tx := new(factoid.Transaction)
+		tx.AddInput(wallet.NewFactoidAddress(key), 0)
+		tx.AddOutput(wallet.NewFactoidAddress(1+key%g.Keys), 1000)
+		return g.transaction(LoadTransaction, tx, wallet.NewPrivKey(key))
+
+	case n < uint64(g.Transactions+g.Entries) && 0 < len(g.chains):
+		entry := entryBlock.NewEntry()
+		entry.ChainID = g.chains[g.count%uint64(len(g.chains))]
+		entry.Content = []byte(fmt.Sprintf("Load entry %d", g.count))
+		commit := messages.NewCommitEntryMsg()
+		var err error
+		if commit.CommitEntry, err = wallet.NewSignedCommitEntry(key, entry); err != nil {
+			panic(err)
+		}
+		commit.Timestamp.SetTimeNow()
+		return g.reveal(LoadEntry, commit, entry)
+
+	default: // A chain; entries too, until there is a chain to add them to
+		entry := entryBlock.NewEntry()
+		count := make([]byte, 8)
+		binary.BigEndian.PutUint64(count, g.count)
+		entry.ExtIDs = [][]byte{[]byte("load"), g.nonce, count}
+		entry.ChainID = entryBlock.NewChainID(entry)
+		entry.Content = []byte(fmt.Sprintf("Load chain %d", g.count))
+		commit := new(messages.CommitChainMsg)
+		var err error
+		if commit.CommitChain, err = wallet.NewSignedCommitChain(key, entry); err != nil {
+			panic(err)
+		}
+		commit.Timestamp.SetTimeNow()
+		return g.reveal(LoadChain, commit, entry)
+	}
+}
+
+// transaction finishes tx, paying its fee from its input, and signs it with
+// priv.
+func (g *LoadGenerator) transaction(kind string, tx *factoid.Transaction, priv []byte) *loadItem {
+	tx.SetMilliTimestamp(interfaces.GetTimeMilli())
+	fee, err := tx.CalculateFee(g.rate)
+	if err != nil {
+		panic(err)
+	}
+	fee += g.rate // For the signature of the RCD signing adds
+	in, err := tx.GetInput(0)
+	if err != nil {
+		panic(err)
+	}
+	var out uint64
+	for _, o := range tx.GetOutputs() {
+		out += o.GetAmount()
+	}
+	for _, o := range tx.GetECOutputs() {
+		out += o.GetAmount()
+	}
+	in.SetAmount(out + fee)
+	if err := wallet.SignTransaction(priv, tx); err != nil {
+		panic(err)
+	}
+
+	msg := new(messages.FactoidTransaction)
+	msg.Transaction = tx
+	msg.Timestamp.SetTimeNow()
+	return &loadItem{kind: kind, msg: msg, block: tx.GetHash()}
+}
+
+func (g *LoadGenerator) reveal(kind string, commit interfaces.IMsg, entry *entryBlock.Entry) *loadItem {
+	msg := messages.NewRevealEntryMsg()
+	msg.Entry = entry
+	return &loadItem{kind: kind, commit: commit, msg: msg, block: entry.GetHash()}
+}
+
+// check moves item on: reveals it once its commit is acknowledged, and notes
+// when it is acknowledged and when saved.
+func (g *LoadGenerator) check(item *loadItem, now time.Time) error {
+	if item.commit != nil && !item.revealed {
+		status, err := g.Submitter.AckStatus(item.commit.GetHash())
+		if err != nil || status < constants.AckStatusACK {
+			return err
+		}
+		item.revealed = true
+		item.msg.(*messages.RevealEntryMsg).Timestamp.SetTimeNow()
+		if err := g.Submitter.Submit(item.msg); err != nil {
+			g.failed++
+		}
+		return nil
+	}
+	if item.acked == 0 {
+		status, err := g.Submitter.AckStatus(item.msg.GetHash())
+		if err != nil {
+			return err
+		}
+		if constants.AckStatusACK <= status {
+			item.acked = now.Sub(item.sent)
+			if item.kind == LoadChain {
+				g.chains = append(g.chains, item.msg.(*messages.RevealEntryMsg).Entry.GetChainID())
+			}
+		}
+	}
+	status, err := g.Submitter.AckStatus(item.block)
+	if err != nil {
+		return err
+	}
+	if status == constants.AckStatusDBlockConfirmed {
+		item.saved = now.Sub(item.sent)
+		if item.acked == 0 {
+			item.acked = item.saved
+		}
+	}
+	return nil
+}
+
+// A LoadReport is how the load went.  Latencies are from when a submission
+// was sent, so those of entries and chains include their commit.
+type LoadReport struct {
+	Submitted  int                    `json:"submitted"`
+	Failed     int                    `json:"failed"` // The submitter refused
+	Acked      int                    `json:"acked"`
+	Saved      int                    `json:"saved"`
+	Kinds      map[string]int         `json:"kinds"` // Submitted, by kind
+	Elapsed    time.Duration          `json:"elapsed"`
+	Throughput float64                `json:"throughput"` // Acknowledged a second
+	Latency    map[string]LoadLatency `json:"latency"`    // Of acknowledgement, "ack", and of saving, "saved"
+	Error      string                 `json:"error,omitempty"`
+}
+
+type LoadLatency struct {
+	Count  int           `json:"count"`
+	Min    time.Duration `json:"min"`
+	Median time.Duration `json:"median"`
+	P90    time.Duration `json:"p90"`
+	Max    time.Duration `json:"max"`
+}
+
+// Report says how the load has gone so far.
+func (g *LoadGenerator) Report() *LoadReport {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	r := new(LoadReport)
+	r.Submitted = len(g.items)
+	r.Failed = g.failed
+	r.Kinds = make(map[string]int)
+	if g.err != nil {
+		r.Error = g.err.Error()
+	}
+	var acked, saved []time.Duration
+	for _, item := range g.items {
+		r.Kinds[item.kind]++
+		if item.acked != 0 {
+			acked = append(acked, item.acked)
+		}
+		if item.saved != 0 {
+			saved = append(saved, item.saved)
+		}
+	}
+	r.Acked, r.Saved = len(acked), len(saved)
+	r.Latency = map[string]LoadLatency{"ack": loadLatency(acked), "saved": loadLatency(saved)}
+	if !g.start.IsZero() {
+		r.Elapsed = interfaces.Now().Sub(g.start)
+		if g.Duration < r.Elapsed {
+			r.Elapsed = g.Duration
+		}
+	}
+	if 0 < r.Elapsed {
+		r.Throughput = float64(r.Acked) / r.Elapsed.Seconds()
+	}
+	return r
+}
+
+func loadLatency(latencies []time.Duration) LoadLatency {
+	l := LoadLatency{Count: len(latencies)}
+	if len(latencies) == 0 {
+		return l
+	}
+	sort.Sort(durations(latencies))
+	l.Min = latencies[0]
+	l.Median = latencies[len(latencies)/2]
+	l.P90 = latencies[len(latencies)*9/10]
+	l.Max = latencies[len(latencies)-1]
+	return l
+}
+
+func (r *LoadReport) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("Submitted %d in %s (%d entries, %d chains, %d transactions), %d refused\n",
+		r.Submitted, r.Elapsed, r.Kinds[LoadEntry], r.Kinds[LoadChain], r.Kinds[LoadTransaction], r.Failed))
+	buf.WriteString(fmt.Sprintf("Acknowledged %d, %.2f a second; saved in a directory block %d\n", r.Acked, r.Throughput, r.Saved))
+	buf.WriteString(fmt.Sprintf("%-6s %6s %12s %12s %12s %12s\n", "", "Count", "Min", "Median", "90%", "Max"))
+	for _, name := range []string{"ack", "saved"} {
+		l := r.Latency[name]
+		buf.WriteString(fmt.Sprintf("%-6s %6d %12s %12s %12s %12s\n", name, l.Count, l.Min, l.Median, l.P90, l.Max))
+	}
+	if r.Error != "" {
+		buf.WriteString("Stopped: " + r.Error + "\n")
+	}
+	return buf.String()
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+//**********************************************************************
+// Submitters
+//**********************************************************************
+
+// An APIQueueSubmitter puts the load straight onto the APIQueue of a node.
+// It takes the node's State as it submits, so it follows the node through a
+// restart, which gives the node a fresh State.
+type APIQueueSubmitter struct {
+	Node *FactomNode
+}
+
+func (a *APIQueueSubmitter) Submit(msg interfaces.IMsg) error {
+	a.Node.State.APIQueue() <- msg
+	return nil
+}
+
+func (a *APIQueueSubmitter) AckStatus(hash interfaces.IHash) (int, error) {
+	return a.Node.State.GetACKStatus(hash)
+}
+
+func (a *APIQueueSubmitter) FactoshisPerEC() (uint64, error) {
+	return a.Node.State.GetFactoshisPerEC(), nil
+}
+
+// A WSAPISubmitter submits the load through the v2 API of a factomd at
+// Server, e.g. "localhost:8088".
+type WSAPISubmitter struct {
+	Server string
+}
+
+func (w *WSAPISubmitter) Submit(msg interfaces.IMsg) error {
+	var method string
+	var params map[string]string
+	switch m := msg.(type) {
+	case *messages.CommitChainMsg:
+		data, err := m.CommitChain.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		method, params = "commit-chain", map[string]string{"message": hex.EncodeToString(data)}
+	case *messages.CommitEntryMsg:
+		data, err := m.CommitEntry.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		method, params = "commit-entry", map[string]string{"entry": hex.EncodeToString(data)}
+	case *messages.RevealEntryMsg:
+		data, err := m.Entry.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		method, params = "reveal-entry", map[string]string{"entry": hex.EncodeToString(data)}
+	case *messages.FactoidTransaction:
+		data, err := m.Transaction.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		method, params = "factoid-submit", map[string]string{"transaction": hex.EncodeToString(data)}
+	default:
+		return fmt.Errorf("Can't submit a %s through the API", messages.MessageName(msg.Type()))
+	}
+	_, err := w.call(method, params)
+	return err
+}
+
+// The wsapi answers for the status of any hash through factoid-ack;
+// entry-ack only knows an entry once it is in the database.
+var loadAckStatus = map[string]int{
+	"Invalid":         constants.AckStatusInvalid,
+	"Unknown":         constants.AckStatusUnknown,
+	"NotConfirmed":    constants.AckStatusNotConfirmed,
+	"TransactionACK":  constants.AckStatusACK,
+	"1Minute":         constants.AckStatus1Minute,
+	"DBlockConfirmed": constants.AckStatusDBlockConfirmed,
+}
+
+func (w *WSAPISubmitter) AckStatus(hash interfaces.IHash) (int, error) {
+	result, err := w.call("factoid-ack", map[string]string{"txid": hash.String()})
+	if err != nil {
+		return 0, err
+	}
+	response := new(struct {
+		Status string `json:"status"`
+	})
+	if err := json.Unmarshal(result, response); err != nil {
+		return 0, err
+	}
+	status, ok := loadAckStatus[response.Status]
+	if !ok {
+		return 0, fmt.Errorf("Unknown status %q", response.Status)
+	}
+	return status, nil
+}
+
+func (w *WSAPISubmitter) FactoshisPerEC() (uint64, error) {
+	result, err := w.call("factoid-fee", nil)
+	if err != nil {
+		return 0, err
+	}
+	response := new(struct {
+		Fee uint64 `json:"fee"`
+	})
+	if err := json.Unmarshal(result, response); err != nil {
+		return 0, err
+	}
+	return response.Fee, nil
+}
+
+// call makes a JSON-RPC call to the v2 API, and returns its result.
+func (w *WSAPISubmitter) call(method string, params interface{}) (json.RawMessage, error) {
+	request := primitives.NewJSON2Request(method, 0, params)
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(fmt.Sprintf("http://%s/v2", w.Server), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := new(struct {
+		Error  *primitives.JSONError `json:"error"`
+		Result json.RawMessage       `json:"result"`
+	})
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("%s: %s %v", method, response.Error.Message, response.Error.Data)
+	}
+	return response.Result, nil
+}
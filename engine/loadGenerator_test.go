@@ -0,0 +1,90 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/common/primitives"
+	. "github.com/FactomProject/factomd/engine"
+	"github.com/FactomProject/factomd/wsapi"
+)
+
+func TestLoadGenerator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs simulations")
+	}
+	for _, through := range []string{"APIQueue", "wsapi"} {
+		sim := newSimulation(42, 1)
+		var submitter LoadSubmitter = &APIQueueSubmitter{Node: sim.Nodes[0]}
+		if through == "wsapi" {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				j, err := primitives.ParseJSON2Request(string(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, jsonError := wsapi.HandleV2Request(sim.Nodes[0].State, j)
+				if jsonError != nil {
+					resp = primitives.NewJSON2Response()
+					resp.AddError(jsonError.Code, jsonError.Message, jsonError.Data)
+				}
+				w.Write([]byte(resp.String()))
+			}))
+			defer server.Close()
+			submitter = &WSAPISubmitter{Server: strings.TrimPrefix(server.URL, "http://")}
+		}
+
+		load := NewLoadGenerator(submitter, 5, 20*time.Second)
+		sim.Load = load
+		sim.RunUntil(time.Minute, func() bool { return load.Done(20 * time.Second) })
+
+		report := load.Report()
+		if report.Error != "" || report.Submitted != 100 || report.Failed != 0 {
+			t.Fatalf("Through %s, expected 100 submitted without error:\n%s", through, report)
+		}
+		for _, kind := range []string{LoadEntry, LoadChain, LoadTransaction} {
+			if report.Kinds[kind] == 0 {
+				t.Errorf("Through %s, no %s was submitted", through, kind)
+			}
+		}
+		ack, saved := report.Latency["ack"], report.Latency["saved"]
+		if report.Acked == 0 || report.Saved == 0 || report.Acked < report.Saved {
+			t.Errorf("Through %s, expected the load to be acknowledged, then saved:\n%s", through, report)
+		}
+		if ack.Min <= 0 || ack.Max < ack.Median || saved.Min < ack.Min {
+			t.Errorf("Through %s, unlikely latencies:\n%s", through, report)
+		}
+	}
+}
+
+func TestAPIQueueSubmitterRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	sim := newSimulation(42, 2)
+	submitter := &APIQueueSubmitter{Node: sim.Nodes[1]}
+	sim.Run(10 * time.Second)
+	if err := sim.StopNode(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.RestartNode(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The load goes to the State the node restarted with.
+	if err := submitter.Submit(new(messages.EOM)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sim.Nodes[1].State.APIQueue()); n != 1 {
+		t.Errorf("Expected the restarted node to have the load queued, it has %d", n)
+	}
+}
@@ -6,26 +6,72 @@ package engine
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/state"
 )
 
+// A journal is replayed by putting its messages on a node's input queue, in
+// the order they were journaled.  Journals are either the JournalEntry lines
+// State.JournalMessage writes, or the older "MsgHex:" lines of
+// State.MessageToLogString.  Only the first have the heights and minutes that
+// ranges and divergence need.
+
+// ReplayOptions choose which messages of a journal are replayed, and how.
+type ReplayOptions struct {
+	Delay   time.Duration      // How long to wait before the first message, for the node to start
+	From    state.JournalPoint // First minute replayed
+	To      state.JournalPoint // Last minute replayed; to the end of the journal if zero
+	Types   []string           // Only replay messages of these types, as messages.MessageName, or all if empty
+	Step    chan bool          // If not nil, each message waits for a value on Step; false ends the replay
+	Diverge bool               // Stop where the node diverges from the journal
+
+	keyMRs map[uint32]string // KeyMRs of the directory blocks saved when the journal was written
+}
+
+// DefaultReplay replays every message, after giving the node time to start.
+var DefaultReplay = ReplayOptions{Delay: 5 * time.Second}
+
+// ReplayResult is what came of a replay.
+type ReplayResult struct {
+	Read     int                 // Messages read from the journal
+	Replayed int                 // Messages put on the node's input queue
+	Invalid  int                 // Replayed messages that didn't validate when the node took them, if checked for divergence
+	Diverged *state.JournalEntry // The entry where the node diverged, if it did
+	Reason   string              // How it diverged, or why the replay stopped early
+}
+
+func (r *ReplayResult) String() string {
+	str := fmt.Sprintf("Replayed %d of %d messages", r.Replayed, r.Read)
+	if r.Invalid != 0 {
+		str += fmt.Sprintf(", %d invalid", r.Invalid)
+	}
+	if r.Diverged != nil {
+		str += fmt.Sprintf("; diverged at %s %s of height %d minute %d",
+			r.Diverged.Type, r.Diverged.Hash, r.Diverged.Height, r.Diverged.Minute)
+	}
+	if r.Reason != "" {
+		str += ": " + r.Reason
+	}
+	return str
+}
+
 func LoadJournal(s interfaces.IState, journal string) {
-	f, err := os.Open(journal)
+	result, err := ReplayJournal(s, journal, DefaultReplay)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	defer f.Close()
-	r := bufio.NewReaderSize(f, 4*1024)
-
-	LoadJournalFromReader(s, r)
+	fmt.Println(result)
 }
 
 func LoadJournalFromString(s interfaces.IState, journalStr string) {
@@ -35,55 +81,111 @@ func LoadJournalFromString(s interfaces.IState, journalStr string) {
 }
 
 func LoadJournalFromReader(s interfaces.IState, r *bufio.Reader) {
+	ReplayJournalFromReader(s, r, DefaultReplay)
+}
+
+// ReplayJournal replays the journal file to s, as opts ask.  If the journal
+// has an index, a replay from a later minute seeks to it rather than reading
+// from the start.
+func ReplayJournal(s interfaces.IState, journal string, opts ReplayOptions) (*ReplayResult, error) {
+	f, err := os.Open(journal)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Journals of MsgHex lines have no index, and are read from the start.
+	if index, err := state.ReadJournalIndex(journal); err == nil && 0 < len(index) {
+		opts.keyMRs = make(map[uint32]string)
+		var offset int64 = -1
+		for _, i := range index {
+			if i.KeyMR != "" {
+				opts.keyMRs[i.Saved] = i.KeyMR
+			}
+			if offset < 0 && !i.JournalPoint.Before(opts.From) {
+				offset = i.Offset
+			}
+		}
+		if offset < 0 {
+			info, err := f.Stat()
+			if err != nil {
+				return nil, err
+			}
+			offset = info.Size() // Nothing in the journal is at or after From
+		}
+		if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+			return nil, err
+		}
+	}
+
+	return ReplayJournalFromReader(s, bufio.NewReaderSize(f, 4*1024), opts), nil
+}
+
+// ReplayJournalFromReader replays the journal read from r to s, as opts ask.
+func ReplayJournalFromReader(s interfaces.IState, r *bufio.Reader, opts ReplayOptions) *ReplayResult {
 	s.SetIsReplaying()
 	defer s.SetIsDoneReplaying()
 
 	fmt.Println("Replaying Journal")
-	time.Sleep(time.Second * 5)
+	time.Sleep(opts.Delay)
 	fmt.Println("GO!")
+	result := new(ReplayResult)
+	var check *replayCheck
+	if fs, ok := s.(*state.State); ok && opts.Diverge {
+		check = newReplayCheck(fs, &opts, result)
+		defer fs.SetReplayCheck(nil)
+	}
 	t := 0
-	p := 0
 	for {
 		t++
-		fmt.Println("total: ", t, " processed: ", p, "            \r")
+		fmt.Println("total: ", t, " processed: ", result.Replayed, "            \r")
 
 		// line is empty if no more data
 		line, err := r.ReadBytes('\n')
-		if len(line) == 0 || err != nil {
+		if len(line) == 0 || (err != nil && len(bytes.TrimSpace(line)) == 0) {
 			break
 		}
 
-		// Get the next word.  If not MsgHex:, then go to next line.
-		adv, word, err := bufio.ScanWords(line, true)
-		if string(word) != "MsgHex:" {
-			continue // Go to next line.
-		}
-		line = line[adv:] // Remove "MsgHex:" from the line.
-
-		// Remove spaces.
-		adv, data, err := bufio.ScanWords(line, true)
+		msg, entry, err := journalLine(line)
 		if err != nil {
 			fmt.Println(err)
-			return
+			result.Reason = err.Error()
+			break
 		}
-
-		// Decode the hex
-		binary, err := hex.DecodeString(string(data))
-		if err != nil {
-			fmt.Println(err)
-			return
+		if msg == nil {
+			continue // Not a message
 		}
+		result.Read++
 
-		// Unmarshal the message.
-		msg, err := messages.UnmarshalMessage(binary)
-		if err != nil {
-			fmt.Println(err)
-			return
+		if entry != nil {
+			if entry.JournalPoint.Before(opts.From) {
+				continue
+			}
+			if opts.To != (state.JournalPoint{}) && opts.To.Before(entry.JournalPoint) {
+				break
+			}
+		}
+		if !opts.wants(msg) {
+			continue
+		}
+		if opts.Step != nil && !<-opts.Step {
+			result.Reason = "Stopped"
+			break
 		}
 
 		// Process the message.
+		if check != nil && entry != nil {
+			check.expect(msg)
+		}
 		s.InMsgQueue() <- msg
-		p++
+		result.Replayed++
+		if check != nil && entry != nil {
+			if reason := <-check.result; reason != "" {
+				result.Diverged = entry
+				result.Reason = reason
+				break
+			}
+		}
 		if len(s.InMsgQueue()) > 200 {
 			for len(s.InMsgQueue()) > 50 {
 				time.Sleep(time.Millisecond * 10)
@@ -97,4 +199,110 @@ func LoadJournalFromReader(s interfaces.IState, r *bufio.Reader) {
 	for len(s.InMsgQueue()) > 0 {
 		time.Sleep(time.Millisecond * 100)
 	}
+	return result
+}
+
+// journalLine returns the message on a line of a journal, and its entry if
+// the journal has them.  msg is nil if the line has no message.
+func journalLine(line []byte) (msg interfaces.IMsg, entry *state.JournalEntry, err error) {
+	line = bytes.TrimSpace(line)
+	if 0 < len(line) && line[0] == '{' {
+		entry = new(state.JournalEntry)
+		if err = json.Unmarshal(line, entry); err != nil {
+			return nil, nil, err
+		}
+		msg, err = entry.Message()
+		return msg, entry, err
+	}
+
+	// Get the next word.  If not MsgHex:, then go to next line.
+	adv, word, err := bufio.ScanWords(line, true)
+	if string(word) != "MsgHex:" {
+		return nil, nil, nil
+	}
+	line = line[adv:] // Remove "MsgHex:" from the line.
+
+	// Remove spaces.
+	_, data, err := bufio.ScanWords(line, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Decode the hex
+	binary, err := hex.DecodeString(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unmarshal the message.
+	msg, err = messages.UnmarshalMessage(binary)
+	return msg, nil, err
+}
+
+func (opts *ReplayOptions) wants(msg interfaces.IMsg) bool {
+	if len(opts.Types) == 0 {
+		return true
+	}
+	name := messages.MessageName(msg.Type())
+	for _, t := range opts.Types {
+		if strings.EqualFold(strings.TrimSpace(t), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// A replayCheck compares the node with the journal as the node takes each
+// replayed message, on the node's goroutine, so the check sees the node as it
+// was when the message was journaled rather than racing its processing.
+type replayCheck struct {
+	mutex    sync.Mutex
+	expected interfaces.IMsg
+	result   chan string
+}
+
+func newReplayCheck(s *state.State, opts *ReplayOptions, result *ReplayResult) *replayCheck {
+	c := &replayCheck{result: make(chan string, 1)}
+	s.SetReplayCheck(func(msg interfaces.IMsg) {
+		c.mutex.Lock()
+		expected := c.expected
+		if msg == expected {
+			c.expected = nil
+		}
+		c.mutex.Unlock()
+		if msg != expected {
+			return // Not from the replay
+		}
+		if msg.Validate(s) < 0 {
+			result.Invalid++
+		}
+		c.result <- opts.diverged(s)
+	})
+	return c
+}
+
+// expect has the check look for msg, the next message replayed.
+func (c *replayCheck) expect(msg interfaces.IMsg) {
+	c.mutex.Lock()
+	c.expected = msg
+	c.mutex.Unlock()
+}
+
+// diverged returns how s has diverged from the journal, or "" if it hasn't:
+// if the last directory block s saved isn't the one the journal's node saved.
+func (opts *ReplayOptions) diverged(s *state.State) string {
+	height := s.GetHighestRecordedBlock()
+	keyMR, ok := opts.keyMRs[height]
+	if !ok {
+		return ""
+	}
+	msg, err := s.LoadDBState(height)
+	if err != nil || msg == nil {
+		return fmt.Sprintf("The directory block at height %d can't be read", height)
+	}
+	if saved := msg.(*messages.DBStateMsg).DirectoryBlock.GetKeyMR().String(); saved != keyMR {
+		return fmt.Sprintf("The directory block at height %d has KeyMR %s, but was %s when journaled",
+			height, saved, keyMR)
+	}
+	return ""
 }
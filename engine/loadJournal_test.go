@@ -1,10 +1,16 @@
 package engine_test
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	. "github.com/FactomProject/factomd/engine"
+	"github.com/FactomProject/factomd/state"
 	. "github.com/FactomProject/factomd/testHelper"
 )
 
@@ -22,3 +28,90 @@ func TestLoadJournalFromReader(t *testing.T) {
 		t.Errorf("Head is %v, expected 9", head)
 	}
 }
+
+// writeJournal journals the test DBStates, two to a height, and returns the
+// journal file.
+func writeJournal(t *testing.T, dir string) string {
+	s := CreateEmptyTestState()
+	s.Journaling = true
+	s.JournalFile = filepath.Join(dir, "journal.log")
+	for i, msg := range CreateTestDBStateList() {
+		s.LLeaderHeight = uint32(i / 2)
+		s.JournalMessage(msg)
+	}
+	return s.JournalFile
+}
+
+func replayTo(t *testing.T, journal string, opts ReplayOptions) *ReplayResult {
+	s := CreateEmptyTestState()
+	go s.ValidatorLoop()
+	result, err := ReplayJournal(s, journal, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestReplayJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := writeJournal(t, dir)
+
+	if result := replayTo(t, journal, ReplayOptions{}); result.Read != 10 || result.Replayed != 10 {
+		t.Errorf("Expected the whole journal replayed, got %s", result)
+	}
+
+	// The replay seeks to height 2, so never reads the first four messages.
+	result := replayTo(t, journal, ReplayOptions{From: state.JournalPoint{Height: 2}, To: state.JournalPoint{Height: 3}})
+	if result.Read != 5 || result.Replayed != 4 {
+		t.Errorf("Expected heights 2 and 3 replayed, got %s", result)
+	}
+
+	if result := replayTo(t, journal, ReplayOptions{Types: []string{"EOM", "Ack"}}); result.Replayed != 0 {
+		t.Errorf("Only EOMs and Acks should be replayed, got %s", result)
+	}
+
+	step := make(chan bool, 1)
+	go func() {
+		step <- true
+		step <- true
+		step <- false
+	}()
+	if result := replayTo(t, journal, ReplayOptions{Step: step}); result.Replayed != 2 || result.Reason != "Stopped" {
+		t.Errorf("Expected two steps, got %s", result)
+	}
+}
+
+func TestReplayUntilDivergence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := writeJournal(t, dir)
+
+	// Have the journal say its node saved a different block 1.
+	index, err := state.ReadJournalIndex(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, i := range index {
+		if i.Height == 2 {
+			i.Saved, i.KeyMR = 1, strings.Repeat("ab", 32)
+		}
+		line, _ := json.Marshal(i)
+		lines = append(lines, string(line)+"\n")
+	}
+	if err := ioutil.WriteFile(journal+state.JournalIndexSuffix, []byte(strings.Join(lines, "")), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	result := replayTo(t, journal, ReplayOptions{Diverge: true})
+	if result.Diverged == nil || !strings.Contains(result.Reason, "height 1 ") || result.Read == 10 {
+		t.Errorf("Expected the replay to stop once block 1 was saved, got %s", result)
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/state"
+)
+
+// A trace follows messages through the nodes: a TraceEvent, one JSON object
+// to a line, each time a node takes a message from the API or a peer, sends
+// it to a peer, or passes it through a stage of consensus (see state.TraceAck
+// and the rest).  FollowMessage picks the events of one message out of a
+// trace, with the latency of each hop, WriteChromeTrace converts a trace for
+// chrome://tracing, and SequenceDiagrams draws a message's hops minute by
+// minute.
+
+// The network stages of a TraceEvent.
+const (
+	TraceAPI = "api" // The node took the message from the API
+	TraceIn  = "in"  // The node took the message from a peer
+	TraceOut = "out" // The node sent the message to a peer
+)
+
+type TraceEvent struct {
+	Time   int64  `json:"time"`           // Microseconds
+	Node   string `json:"node"`           // Name of the node
+	Peer   string `json:"peer,omitempty"` // The message came from or went to, for the network stages
+	Stage  string `json:"stage"`
+	Where  string `json:"where,omitempty"` // As MsgLog has it, e.g. "P2P In 2"
+	Valid  bool   `json:"valid"`
+	Type   string `json:"type"` // As messages.MessageName
+	Hash   string `json:"hash"` // GetHash of the message; an ack has the hash of the message it acknowledges
+	Height uint32 `json:"height"`
+	Minute int    `json:"minute"`
+}
+
+// Trace writes a TraceEvent for every message the nodes log, and every stage
+// of consensus they pass a message through, to w.
+func (m *MsgLog) Trace(w io.Writer, fnodes []*FactomNode) {
+	m.sem.Lock()
+	m.trace = json.NewEncoder(w)
+	m.sem.Unlock()
+	for _, fnode := range fnodes {
+		fnode := fnode
+		fnode.State.MsgTrace = func(stage string, msg interfaces.IMsg) {
+			m.sem.Lock()
+			defer m.sem.Unlock()
+			m.traceEvent(fnode, stage, "", "", true, msg)
+		}
+	}
+}
+
+// traceEvent writes the event, if tracing.  The caller holds m.sem.
+func (m *MsgLog) traceEvent(fnode *FactomNode, stage string, peer string, where string, valid bool, msg interfaces.IMsg) {
+	if m.trace == nil {
+		return
+	}
+	e := TraceEvent{
+		Time:   interfaces.Now().UnixNano() / 1000,
+		Node:   fnode.State.FactomNodeName,
+		Peer:   peer,
+		Stage:  stage,
+		Where:  where,
+		Valid:  valid,
+		Type:   messages.MessageName(msg.Type()),
+		Height: fnode.State.LLeaderHeight,
+		Minute: fnode.State.LeaderMinute,
+	}
+	hash := msg.GetHash()
+	if ack, ok := msg.(*messages.Ack); ok && ack.MessageHash != nil {
+		hash = ack.MessageHash
+	}
+	if hash != nil {
+		e.Hash = hash.String()
+	}
+	m.trace.Encode(e)
+}
+
+// ReadTrace reads the events of a trace.
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	var events []TraceEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// A TraceHop is an event in the life of a message, and how long it took to
+// get there.
+type TraceHop struct {
+	TraceEvent
+	Since   time.Duration // Since the first event of the message
+	Latency time.Duration // Since the send, for TraceIn; since the node's last event of the message otherwise
+	From    int           // The hop it followed: the send for TraceIn, or the node's last event; -1 if none
+}
+
+// FollowMessage returns the events of the message with hash, which may be a
+// prefix of the hash, and of its acks, in order of time.
+func FollowMessage(events []TraceEvent, hash string) []TraceHop {
+	var hops []TraceHop
+	for _, e := range events {
+		if hash != "" && strings.HasPrefix(e.Hash, hash) {
+			hops = append(hops, TraceHop{TraceEvent: e, From: -1})
+		}
+	}
+	sort.Stable(hopsByTime(hops))
+
+	last := make(map[string]int)      // The last hop at each node
+	sent := make(map[[3]string][]int) // Sends not yet received, by node, peer and type
+	for i := range hops {
+		h := &hops[i]
+		h.Since = time.Duration(h.Time-hops[0].Time) * time.Microsecond
+		if h.Stage == TraceIn {
+			key := [3]string{h.Peer, h.Node, h.Type}
+			if s := sent[key]; 0 < len(s) {
+				h.From = s[0]
+				sent[key] = s[1:]
+			}
+		}
+		if j, ok := last[h.Node]; ok && h.From < 0 {
+			h.From = j
+		}
+		if 0 <= h.From {
+			h.Latency = time.Duration(h.Time-hops[h.From].Time) * time.Microsecond
+		}
+		if h.Stage == TraceOut {
+			key := [3]string{h.Node, h.Peer, h.Type}
+			sent[key] = append(sent[key], i)
+		}
+		last[h.Node] = i
+	}
+	return hops
+}
+
+// A TraceSummary is how long a message took to reach each stage of consensus
+// on the first node to reach it, from its first event; -1 if it never did.
+type TraceSummary struct {
+	Hash      string
+	Type      string
+	Nodes     int // That saw the message
+	Ack       time.Duration
+	Processed time.Duration
+	Block     time.Duration
+}
+
+// SummarizeMessage summarizes the hops of a message, as FollowMessage returns
+// them.
+func SummarizeMessage(hops []TraceHop) TraceSummary {
+	summary := TraceSummary{Ack: -1, Processed: -1, Block: -1}
+	nodes := make(map[string]bool)
+	for _, h := range hops {
+		nodes[h.Node] = true
+		if summary.Hash == "" {
+			summary.Hash = h.Hash
+		}
+		if summary.Type == "" || summary.Type == "Ack" {
+			summary.Type = h.Type
+		}
+		var stage *time.Duration
+		switch h.Stage {
+		case state.TraceAck:
+			stage = &summary.Ack
+		case state.TraceProcessed:
+			stage = &summary.Processed
+		case state.TraceBlock:
+			stage = &summary.Block
+		default:
+			continue
+		}
+		if *stage < 0 {
+			*stage = h.Since
+		}
+	}
+	summary.Nodes = len(nodes)
+	return summary
+}
+
+// WriteChromeTrace writes events in the Trace Event Format of
+// chrome://tracing: an instant event on the node's track for each event, and
+// a flow arrow from each send to its receipt.
+func WriteChromeTrace(w io.Writer, events []TraceEvent) error {
+	type chromeEvent struct {
+		Name  string            `json:"name"`
+		Cat   string            `json:"cat,omitempty"`
+		Ph    string            `json:"ph"`
+		Ts    int64             `json:"ts"`
+		Pid   int               `json:"pid"`
+		Tid   int               `json:"tid"`
+		ID    int               `json:"id,omitempty"`
+		Bp    string            `json:"bp,omitempty"`
+		Scope string            `json:"s,omitempty"`
+		Args  map[string]string `json:"args,omitempty"`
+	}
+	var out []chromeEvent
+
+	tids := make(map[string]int)
+	tid := func(node string) int {
+		if _, ok := tids[node]; !ok {
+			tids[node] = len(tids) + 1
+			out = append(out, chromeEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: tids[node],
+				Args: map[string]string{"name": node}})
+		}
+		return tids[node]
+	}
+
+	byHash := make(map[string][]TraceEvent)
+	var hashes []string
+	for _, e := range events {
+		if _, ok := byHash[e.Hash]; !ok {
+			hashes = append(hashes, e.Hash)
+		}
+		byHash[e.Hash] = append(byHash[e.Hash], e)
+	}
+	flow := 0
+	for _, hash := range hashes {
+		hops := FollowMessage(byHash[hash], hash)
+		for _, h := range hops {
+			args := map[string]string{"hash": h.Hash, "height": fmt.Sprint(h.Height), "minute": fmt.Sprint(h.Minute)}
+			if h.Peer != "" {
+				args["peer"] = h.Peer
+			}
+			if h.Where != "" {
+				args["where"] = h.Where
+			}
+			out = append(out, chromeEvent{Name: h.Type + " " + h.Stage, Cat: h.Stage, Ph: "i", Ts: h.Time,
+				Pid: 1, Tid: tid(h.Node), Scope: "t", Args: args})
+			if h.Stage == TraceIn && 0 <= h.From && hops[h.From].Stage == TraceOut {
+				flow++
+				from := hops[h.From]
+				out = append(out,
+					chromeEvent{Name: h.Type, Cat: "hop", Ph: "s", Ts: from.Time, Pid: 1, Tid: tid(from.Node), ID: flow},
+					chromeEvent{Name: h.Type, Cat: "hop", Ph: "f", Ts: h.Time, Pid: 1, Tid: tid(h.Node), ID: flow, Bp: "e"})
+			}
+		}
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{"traceEvents": out, "displayTimeUnit": "ms"})
+}
+
+// SequenceDiagrams draws the hops of a message as Mermaid sequence diagrams,
+// one for each minute it was seen in: an arrow for each time a node took it
+// from a peer, and a note for the other stages.  The minute of a hop is the
+// minute of the node it happened on.
+func SequenceDiagrams(hops []TraceHop) string {
+	var minutes []state.JournalPoint
+	diagrams := make(map[state.JournalPoint][]TraceHop)
+	for _, h := range hops {
+		p := state.JournalPoint{Height: h.Height, Minute: h.Minute}
+		if _, ok := diagrams[p]; !ok {
+			minutes = append(minutes, p)
+		}
+		diagrams[p] = append(diagrams[p], h)
+	}
+	sort.Sort(pointsByTime(minutes))
+
+	var buf bytes.Buffer
+	for _, p := range minutes {
+		participants := make(map[string]string)
+		var lines []string
+		participant := func(node string) string {
+			if id, ok := participants[node]; ok {
+				return id
+			}
+			id := fmt.Sprintf("n%d", len(participants))
+			participants[node] = id
+			lines = append(lines, fmt.Sprintf("    participant %s as %s", id, node))
+			return id
+		}
+		var body []string
+		for _, h := range diagrams[p] {
+			node := participant(h.Node)
+			switch h.Stage {
+			case TraceOut:
+				continue // Drawn when received
+			case TraceIn:
+				peer := participant(h.Peer)
+				body = append(body, fmt.Sprintf("    %s->>%s: %s %s", peer, node, h.Type, latency(h)))
+			default:
+				body = append(body, fmt.Sprintf("    Note over %s: %s %s %s", node, h.Type, h.Stage, latency(h)))
+			}
+		}
+		if len(body) == 0 {
+			continue // Only sends, drawn in the minute they were received
+		}
+		buf.WriteString(fmt.Sprintf("%%%% Height %d minute %d\n", p.Height, p.Minute))
+		buf.WriteString("sequenceDiagram\n")
+		buf.WriteString(strings.Join(append(lines, body...), "\n"))
+		buf.WriteString("\n\n")
+	}
+	return buf.String()
+}
+
+type hopsByTime []TraceHop
+
+func (h hopsByTime) Len() int           { return len(h) }
+func (h hopsByTime) Less(i, j int) bool { return h[i].Time < h[j].Time }
+func (h hopsByTime) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+type pointsByTime []state.JournalPoint
+
+func (p pointsByTime) Len() int           { return len(p) }
+func (p pointsByTime) Less(i, j int) bool { return p[i].Before(p[j]) }
+func (p pointsByTime) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func latency(h TraceHop) string {
+	if h.From < 0 {
+		return fmt.Sprintf("at %s", h.Since)
+	}
+	return fmt.Sprintf("+%s", h.Latency)
+}
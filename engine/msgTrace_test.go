@@ -0,0 +1,83 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/FactomProject/factomd/engine"
+	"github.com/FactomProject/factomd/state"
+)
+
+func TestFollowMessage(t *testing.T) {
+	events := []TraceEvent{
+		{Time: 1000, Node: "FNode0", Stage: TraceAPI, Type: "Commit Entry", Hash: "aa11"},
+		{Time: 3000, Node: "FNode0", Stage: state.TraceAck, Type: "Commit Entry", Hash: "aa11"},
+		{Time: 4000, Node: "FNode0", Peer: "FNode1", Stage: TraceOut, Type: "Commit Entry", Hash: "aa11"},
+		{Time: 2000, Node: "FNode1", Stage: TraceAPI, Type: "EOM", Hash: "bb22"},
+		{Time: 9000, Node: "FNode1", Peer: "FNode0", Stage: TraceIn, Type: "Commit Entry", Hash: "aa11"},
+		{Time: 9500, Node: "FNode1", Stage: state.TraceBlock, Type: "Commit Entry", Hash: "aa11"},
+	}
+	hops := FollowMessage(events, "aa")
+	if len(hops) != 5 {
+		t.Fatalf("Expected the 5 events of the message, got %d", len(hops))
+	}
+	in := hops[3]
+	if in.Stage != TraceIn || in.From != 2 || in.Latency != 5*time.Millisecond || in.Since != 8*time.Millisecond {
+		t.Errorf("The receipt should follow the send 5ms later, got %+v", in)
+	}
+	if hops[1].From != 0 || hops[1].Latency != 2*time.Millisecond || hops[0].From != -1 {
+		t.Errorf("Hops at a node should follow its last hop, got %+v", hops[:2])
+	}
+
+	summary := SummarizeMessage(hops)
+	if summary.Nodes != 2 || summary.Ack != 2*time.Millisecond || summary.Processed != -1 || summary.Block != 8500*time.Microsecond {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+
+	diagrams := SequenceDiagrams(hops)
+	if !strings.Contains(diagrams, "sequenceDiagram") || !strings.Contains(diagrams, "n0->>n1: Commit Entry +5ms") {
+		t.Errorf("Unexpected diagram:\n%s", diagrams)
+	}
+}
+
+func TestTraceSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	sim := newSimulation(42, 2)
+	var trace bytes.Buffer
+	sim.Nodes[0].MLog.Trace(&trace, sim.Nodes)
+	sim.Run(time.Minute)
+
+	events, err := ReadTrace(&trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := make(map[string]bool)
+	for _, e := range events {
+		stages[e.Stage] = true
+	}
+	for _, stage := range []string{TraceIn, TraceOut, state.TraceAck, state.TraceAcked, state.TraceProcessed, state.TraceBlock} {
+		if !stages[stage] {
+			t.Errorf("No %s event traced", stage)
+		}
+	}
+
+	var chrome bytes.Buffer
+	if err := WriteChromeTrace(&chrome, events); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		TraceEvents []map[string]interface{} `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(chrome.Bytes(), &decoded); err != nil || len(decoded.TraceEvents) < len(events) {
+		t.Errorf("Expected a chrome trace of at least %d events, got %d %v", len(events), len(decoded.TraceEvents), err)
+	}
+}
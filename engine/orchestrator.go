@@ -0,0 +1,482 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/state"
+)
+
+// An Orchestrator runs a network of factomd processes on this machine, a node
+// to each, where -count clones the nodes inside one process and they share
+// its globals.  The processes only talk over real p2p sockets, as they would
+// on separate machines.  Each node gets a folder of Dir with a generated
+// factomd.conf, its own ports and server key, and dials the nodes its
+// Topology links it to.  Each also serves the control API, which the
+// Orchestrator polls for the summary, and to compare the nodes' blocks as the
+// DivergenceDetector does.
+type Orchestrator struct {
+	Binary string         // The factomd to run
+	Dir    string         // The home directory of the nodes' configs; their folders are in Dir/.factom/m2
+	Args   []string       // More flags for every node, e.g. -blktime=10
+	Log    io.Writer      // Every node's output, each line with its name in front; nil for none
+	Nodes  []*ProcessNode // Node 0 is the leader
+
+	mutex   sync.Mutex // Guards the divergence found
+	first   *state.Divergence
+	report  string
+	checked uint32 // Every node agreed on the heights below this
+
+	logMutex sync.Mutex
+}
+
+// A ProcessNode is a node the Orchestrator runs in a factomd process.
+type ProcessNode struct {
+	Name    string   // FactomNodeName, set by its -prefix
+	Prefix  string   // -prefix
+	Folder  string   // Its config, database and logs; -folder is this, relative to the home directory
+	Leader  bool     // Else it is a follower
+	Port    int      // Of the WSAPI
+	P2PPort int      // It listens for peers on
+	Control string   // Address of its control API
+	Peers   []string // The p2p addresses of the nodes it dials
+	PrivKey string   // Its server private key, in hex
+
+	mutex sync.Mutex // Guards the process, which the wait goroutine and Status share
+	cmd   *exec.Cmd
+	done  chan struct{} // Closed when the process exits
+	err   error         // Why it exited
+}
+
+// ProcessStatus is the state of a ProcessNode: whether its process is running,
+// and the status its control API gives, if it answered.
+type ProcessStatus struct {
+	NodeStatus
+	Pid     int    `json:"pid"`
+	Running bool   `json:"running"`
+	Error   string `json:"error,omitempty"` // Why the control API didn't answer
+}
+
+// NewOrchestrator sets up the nodes of topology to run binary in folders of
+// dir.  Node i serves the WSAPI on port+10*i, listens for peers on the port
+// after, and serves the control API on the one after that.  Node 0 is the
+// leader, with the default server key as the simulator's first node has; the
+// others are followers with keys of their own, as its clones have.
+func NewOrchestrator(binary string, dir string, topology *Topology, port int) (*Orchestrator, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	o := new(Orchestrator)
+	o.Binary = binary
+	o.Dir = dir
+	for i := 0; i < topology.Nodes; i++ {
+		n := new(ProcessNode)
+		if 0 < i {
+			n.Prefix = fmt.Sprintf("P%d_", i)
+		}
+		n.Name = n.Prefix + "FNode0"
+		n.Folder = filepath.Join(dir, ".factom", "m2", fmt.Sprintf("node%d", i))
+		n.Leader = i == 0
+		n.Port = port + 10*i
+		n.P2PPort = n.Port + 1
+		n.Control = fmt.Sprintf("localhost:%d", n.Port+2)
+		n.PrivKey = "4c38c72fc5cdad68f13b74674d3ffb1f3d63a112710868c9b08946553448d26d"
+		if !n.Leader {
+			n.PrivKey = hex.EncodeToString(primitives.Sha([]byte(n.Name)).Bytes())
+		}
+		o.Nodes = append(o.Nodes, n)
+	}
+	// The node later in the list dials, so each link is only dialed once.
+	for _, link := range topology.Links {
+		a, b := o.Nodes[link[0]], o.Nodes[link[1]]
+		b.Peers = append(b.Peers, fmt.Sprintf("127.0.0.1:%d", a.P2PPort))
+	}
+	return o, nil
+}
+
+// Config is the factomd.conf of node n.  Only what differs from the defaults
+// is set.
+func (o *Orchestrator) Config(n *ProcessNode) (string, error) {
+	key, err := primitives.NewPrivateKeyFromHex(n.PrivKey)
+	if err != nil {
+		return "", err
+	}
+	mode := "FULL"
+	if n.Leader {
+		mode = "SERVER"
+	}
+	var out bytes.Buffer
+	out.WriteString(fmt.Sprintf("; Written by the Orchestrator for %s\n", n.Name))
+	out.WriteString("[app]\n")
+	out.WriteString(fmt.Sprintf("HomeDir                               = %q\n", o.Dir))
+	out.WriteString("Network                               = LOCAL\n")
+	out.WriteString(fmt.Sprintf("LocalNetworkPort                      = %d\n", n.P2PPort))
+	out.WriteString(fmt.Sprintf("NodeMode                              = %s\n", mode))
+	out.WriteString(fmt.Sprintf("LocalServerPrivKey                    = %s\n", n.PrivKey))
+	out.WriteString(fmt.Sprintf("LocalServerPublicKey                  = %s\n", key.Pub.String()))
+	out.WriteString("[wsapi]\n")
+	out.WriteString(fmt.Sprintf("PortNumber                            = %d\n", n.Port))
+	return out.String(), nil
+}
+
+// Command is the factomd command line of node n.
+func (o *Orchestrator) Command(n *ProcessNode) []string {
+	folder, _ := filepath.Rel(filepath.Join(o.Dir, ".factom", "m2"), n.Folder)
+	args := []string{
+		"-config=" + filepath.Join(n.Folder, "factomd.conf"),
+		"-folder=" + folder + "/",
+		fmt.Sprintf("-port=%d", n.Port),
+		fmt.Sprintf("-p2pPort=%d", n.P2PPort),
+		"-peers=" + strings.Join(n.Peers, " "),
+		"-control=" + n.Control,
+		"-count=1",
+		"-prefix=" + n.Prefix,
+	}
+	if !n.Leader {
+		args = append(args, "-follower")
+	}
+	return append(args, o.Args...)
+}
+
+// Start writes each node's config and starts its process, in its folder.
+// What a node writes goes to factomd.log in its folder, and to o.Log.
+func (o *Orchestrator) Start() error {
+	for _, n := range o.Nodes {
+		if err := o.StartNode(n); err != nil {
+			o.Stop(0)
+			return err
+		}
+	}
+	return nil
+}
+
+// StartNode starts node n, if it isn't running.
+func (o *Orchestrator) StartNode(n *ProcessNode) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.running() {
+		return nil
+	}
+	if err := os.MkdirAll(n.Folder, 0755); err != nil {
+		return err
+	}
+	config, err := o.Config(n)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(n.Folder, "factomd.conf"), []byte(config), 0644); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(filepath.Join(n.Folder, "factomd.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	n.cmd = exec.Command(o.Binary, o.Command(n)...)
+	n.cmd.Dir = n.Folder // The peers file is relative to it
+	n.cmd.Stdout = log
+	if o.Log != nil {
+		n.cmd.Stdout = io.MultiWriter(log, &nodeLogWriter{o: o, prefix: n.Name + ": "})
+	}
+	n.cmd.Stderr = n.cmd.Stdout
+	if err := n.cmd.Start(); err != nil {
+		log.Close()
+		return fmt.Errorf("%s: %s", n.Name, err.Error())
+	}
+	n.done = make(chan struct{})
+	n.err = nil
+	go func(cmd *exec.Cmd, done chan struct{}) {
+		err := cmd.Wait()
+		log.Close()
+		n.mutex.Lock()
+		n.err = err
+		n.mutex.Unlock()
+		close(done)
+	}(n.cmd, n.done)
+	return nil
+}
+
+// Stop interrupts every node, and kills those that haven't exited after wait.
+func (o *Orchestrator) Stop(wait time.Duration) {
+	var wg sync.WaitGroup
+	for _, n := range o.Nodes {
+		wg.Add(1)
+		go func(n *ProcessNode) {
+			defer wg.Done()
+			o.StopNode(n, wait)
+		}(n)
+	}
+	wg.Wait()
+}
+
+// StopNode interrupts node n, and kills it if it hasn't exited after wait.
+func (o *Orchestrator) StopNode(n *ProcessNode, wait time.Duration) error {
+	n.mutex.Lock()
+	if !n.running() {
+		n.mutex.Unlock()
+		return nil
+	}
+	cmd, done := n.cmd, n.done
+	n.mutex.Unlock()
+
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(wait):
+		cmd.Process.Kill()
+		<-done
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.err
+}
+
+// Running is true if the process of n has started and not exited.
+func (n *ProcessNode) Running() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.running()
+}
+
+// Pid is the process id of n, or 0 if it never started.
+func (n *ProcessNode) Pid() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.cmd == nil || n.cmd.Process == nil {
+		return 0
+	}
+	return n.cmd.Process.Pid
+}
+
+// running is Running, with the mutex held.
+func (n *ProcessNode) running() bool {
+	if n.done == nil {
+		return false
+	}
+	select {
+	case <-n.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Status returns the state of every node.
+func (o *Orchestrator) Status() []ProcessStatus {
+	var status []ProcessStatus
+	for i, n := range o.Nodes {
+		s := ProcessStatus{Running: n.Running(), Pid: n.Pid()}
+		s.Node, s.Name = i, n.Name
+		var nodes []NodeStatus
+		if err := n.get("/v1/nodes", &nodes); err != nil {
+			s.Error = err.Error()
+		} else if len(nodes) == 0 {
+			s.Error = "No nodes"
+		} else {
+			s.NodeStatus = nodes[0]
+			s.Node = i
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// Summary is the summary ("s") of the simulator, over the processes.
+func (o *Orchestrator) Summary() string {
+	status := o.Status()
+	prt := "===SummaryStart===\n"
+	for _, s := range status {
+		switch {
+		case s.Error != "" && !s.Running:
+			prt = prt + fmt.Sprintf("%8s Not running\n", s.Name)
+		case s.Error != "":
+			prt = prt + fmt.Sprintf("%8s %s\n", s.Name, s.Error)
+		default:
+			prt = prt + fmt.Sprintf("%8s %s \n", s.Name, s.Summary)
+		}
+	}
+	list := ""
+	for i := range status {
+		list = list + fmt.Sprintf(" %2d ", i)
+	}
+	prt = prt + fmt.Sprintf("      %6s            %6s%s\n", "Queues", "Nodes:", list)
+	for _, q := range []struct{ key, name string }{
+		{"follower", "FollowerMsgQueue"},
+		{"in", "InMsgQueue"},
+		{"api", "APIQueue"},
+		{"leader", "LeaderMsgQueue"},
+		{"stall", "stall Queue"},
+		{"timer", "TimerMsgQueue"},
+		{"networkout", "NetworkOutMsgQueue"},
+		{"networkinvalid", "NetworkInvalidMsgQueue"},
+	} {
+		list = ""
+		for _, s := range status {
+			list = list + fmt.Sprintf(" %3d", s.Queues[q.key])
+		}
+		prt = prt + fmt.Sprintf("      %-22s %s\n", q.name, list)
+	}
+	prt = prt + "===SummaryEnd===\n"
+	return prt
+}
+
+// Check compares the nodes' digests of each height they have all saved since
+// the last check, and returns the first divergence found, if any.
+func (o *Orchestrator) Check() *state.Divergence {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.first != nil || len(o.Nodes) < 2 {
+		return o.first
+	}
+
+	var top uint32
+	for i, s := range o.Status() {
+		if s.Error != "" {
+			return nil // Can't tell what it saved; try again later
+		}
+		if i == 0 || s.RecordedHeight < top {
+			top = s.RecordedHeight
+		}
+	}
+	for ; o.checked <= top; o.checked++ {
+		first := new(state.BlockDigest)
+		if err := o.Nodes[0].get(fmt.Sprintf("/v1/digest/%d", o.checked), first); err != nil {
+			return nil
+		}
+		for i, n := range o.Nodes[1:] {
+			digest := new(state.BlockDigest)
+			if err := n.get(fmt.Sprintf("/v1/digest/%d", o.checked), digest); err != nil {
+				return nil
+			}
+			if diffs := first.Diff(digest); 0 < len(diffs) {
+				o.first = &state.Divergence{Height: o.checked, A: first, B: digest, Differences: diffs}
+				o.report = o.divergenceReport(o.Nodes[0], o.Nodes[i+1], o.first)
+				fmt.Println(o.first.String())
+				fmt.Print(o.report)
+				return o.first
+			}
+		}
+	}
+	return nil
+}
+
+// Divergence returns the first divergence found, and its report.
+func (o *Orchestrator) Divergence() (*state.Divergence, string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.first, o.report
+}
+
+// Watch checks the nodes every period, until it finds a divergence.
+func (o *Orchestrator) Watch(period time.Duration) {
+	for o.Check() == nil {
+		time.Sleep(period)
+	}
+}
+
+// CheckDivergence checks the nodes for a divergence, and returns what was
+// found, with the divergences each node found from its peers.
+func (o *Orchestrator) CheckDivergence() *DivergenceStatus {
+	o.Check()
+	status := new(DivergenceStatus)
+	status.Divergence, status.Report = o.Divergence()
+	for _, n := range o.Nodes {
+		node := new(DivergenceStatus)
+		if err := n.get("/v1/divergence", node); err != nil {
+			continue
+		}
+		for name, d := range node.Peers {
+			if status.Peers == nil {
+				status.Peers = make(map[string]*state.Divergence)
+			}
+			status.Peers[name] = d
+		}
+	}
+	return status
+}
+
+// divergenceReport is DivergenceReport of nodes a and b where they diverge,
+// from the blocks their control APIs serve.
+func (o *Orchestrator) divergenceReport(a, b *ProcessNode, d *state.Divergence) string {
+	var blocksA, blocksB []string
+	path := fmt.Sprintf("/v1/blocks/%d", d.Height)
+	if err := a.get(path, &blocksA); err != nil {
+		return fmt.Sprintf("%s: %s\n", a.Name, err.Error())
+	}
+	if err := b.get(path, &blocksB); err != nil {
+		return fmt.Sprintf("%s: %s\n", b.Name, err.Error())
+	}
+	return diffBlocks(d.A.Node, d.B.Node, d.Height, blocksA, blocksB)
+}
+
+// Handler returns an http.Handler for the orchestrator's part of the control
+// API: GET /v1/nodes and GET /v1/divergence, over the processes.
+func (o *Orchestrator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/nodes", handleControl("GET", func(r *http.Request) (interface{}, error) {
+		return o.Status(), nil
+	}))
+	mux.HandleFunc("/v1/divergence", handleControl("GET", func(r *http.Request) (interface{}, error) {
+		return o.CheckDivergence(), nil
+	}))
+	return mux
+}
+
+// get calls the control API of n at path, and decodes what it answers into
+// answer.
+func (n *ProcessNode) get(path string, answer interface{}) error {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + n.Control + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		failed := make(map[string]string)
+		json.NewDecoder(resp.Body).Decode(&failed)
+		return fmt.Errorf("%s: %s", n.Name, failed["error"])
+	}
+	return json.NewDecoder(resp.Body).Decode(answer)
+}
+
+// nodeLogWriter writes what a node outputs to the Orchestrator's Log, a line
+// at a time with prefix in front, so the nodes' lines don't mix.
+type nodeLogWriter struct {
+	o      *Orchestrator
+	prefix string
+	line   []byte
+}
+
+func (w *nodeLogWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexAny(w.line, "\r\n")
+		if i < 0 {
+			break
+		}
+		if 0 < i {
+			w.o.logMutex.Lock()
+			io.WriteString(w.o.Log, w.prefix+string(w.line[:i])+"\n")
+			w.o.logMutex.Unlock()
+		}
+		w.line = w.line[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/FactomProject/factomd/engine"
+)
+
+func TestOrchestratorCommand(t *testing.T) {
+	topology, _ := MakeTopology("long", 3, 0, 0, nil)
+	o, err := NewOrchestrator("factomd", "orchestrator", topology, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Args = []string{"-blktime=10"}
+	if len(o.Nodes) != 3 || !o.Nodes[0].Leader || o.Nodes[1].Leader {
+		t.Fatalf("Expected a leader and two followers, got %+v", o.Nodes)
+	}
+	if o.Nodes[0].PrivKey == o.Nodes[1].PrivKey || o.Nodes[1].PrivKey == o.Nodes[2].PrivKey {
+		t.Errorf("The nodes share a server key")
+	}
+
+	// The long network is a line, so each node dials the one before it.
+	args := strings.Join(o.Command(o.Nodes[2]), " ")
+	for _, want := range []string{"-folder=node2/", "-port=9020", "-p2pPort=9021", "-peers=127.0.0.1:9011", "-control=localhost:9022", "-prefix=P2_", "-follower", "-blktime=10"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("No %s in %s", want, args)
+		}
+	}
+	if args := strings.Join(o.Command(o.Nodes[0]), " "); strings.Contains(args, "-follower") || !strings.Contains(args, "-peers= ") {
+		t.Errorf("Node 0 should lead and dial no one: %s", args)
+	}
+
+	config, err := o.Config(o.Nodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Network                               = LOCAL", "LocalNetworkPort                      = 9011", "PortNumber                            = 9010", o.Nodes[1].PrivKey, o.Dir} {
+		if !strings.Contains(config, want) {
+			t.Errorf("No %q in the config:\n%s", want, config)
+		}
+	}
+}
+
+func TestOrchestratorStartStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orchestrator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// sleep rejects the factomd flags, so each node exits at once; poll the
+	// status while the nodes restart, as a client of the control API would.
+	topology, _ := MakeTopology("long", 2, 0, 0, nil)
+	o, err := NewOrchestrator("sleep", dir, topology, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				o.Status()
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if err := o.Start(); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range o.Nodes {
+			if n.Pid() == 0 {
+				t.Errorf("No pid for %s", n.Name)
+			}
+		}
+		o.Stop(time.Second)
+		for _, n := range o.Nodes {
+			if n.Running() {
+				t.Errorf("%s is still running", n.Name)
+			}
+		}
+	}
+	close(done)
+	<-polled
+}
+
+func TestOrchestratorStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	sim := newSimulation(42, 2)
+	sim.Run(30 * time.Second)
+
+	// Serve each node's control API as its own process would.
+	topology, _ := MakeTopology("long", 2, 0, 0, nil)
+	o, err := NewOrchestrator("factomd", "orchestrator", topology, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range o.Nodes {
+		server := httptest.NewServer(NewSimController(sim.Nodes[i:i+1], 0).Handler())
+		defer server.Close()
+		n.Control = strings.TrimPrefix(server.URL, "http://")
+	}
+
+	status := o.Status()
+	if len(status) != 2 {
+		t.Fatalf("Expected the status of 2 nodes, got %+v", status)
+	}
+	for i, s := range status {
+		if s.Error != "" || s.Running || s.Node != i || s.Name != sim.Nodes[i].State.FactomNodeName || s.Summary == "" {
+			t.Errorf("Unexpected status of node %d: %+v", i, s)
+		}
+	}
+	if !status[0].Leader || status[1].Leader {
+		t.Errorf("Node 0 should be the only leader")
+	}
+	if summary := o.Summary(); !strings.Contains(summary, sim.Nodes[1].State.ShortString()) {
+		t.Errorf("The summary has no line for %s:\n%s", sim.Nodes[1].State.FactomNodeName, summary)
+	}
+
+	// The digests the control API serves compare as the nodes' own do: every
+	// height below a divergence agrees, and the one found doesn't.
+	first := o.Check()
+	if first == nil {
+		for h := uint32(0); h <= sim.Nodes[1].State.GetHighestRecordedBlock(); h++ {
+			if diffs := sim.Nodes[0].State.GetDigest(h).Diff(sim.Nodes[1].State.GetDigest(h)); 0 < len(diffs) {
+				t.Errorf("The nodes diverge at %d, but none was found: %v", h, diffs)
+			}
+		}
+		return
+	}
+	if len(first.Differences) == 0 || first.A.Node != sim.Nodes[0].State.FactomNodeName || first.B.Node != sim.Nodes[1].State.FactomNodeName {
+		t.Errorf("Unexpected divergence %v", first)
+	}
+	for h := uint32(0); h < first.Height; h++ {
+		if diffs := sim.Nodes[0].State.GetDigest(h).Diff(sim.Nodes[1].State.GetDigest(h)); 0 < len(diffs) {
+			t.Errorf("The nodes diverge at %d, before the divergence found: %v", h, diffs)
+		}
+	}
+	if _, report := o.Divergence(); !strings.HasPrefix(report, "--- "+first.A.Node+"\n+++ "+first.B.Node+"\n") {
+		t.Errorf("The report doesn't diff %s and %s:\n%s", first.A.Node, first.B.Node, report)
+	}
+}
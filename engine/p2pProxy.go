@@ -5,10 +5,13 @@
 package engine
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/FactomProject/factomd/common/constants"
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/messages"
 	"github.com/FactomProject/factomd/p2p"
@@ -23,23 +26,55 @@ type P2PProxy struct {
 	ToName   string
 	FromName string
 	// Channels that define the connection:
-	BroadcastOut chan []byte // ToNetwork from factomd
-	BroadcastIn  chan []byte // FromNetwork for Factomd
+	BroadcastOut chan factomMessage // ToNetwork from factomd
+	BroadcastIn  chan factomMessage // FromNetwork for Factomd
 
 	ToNetwork   chan p2p.Parcel // Parcels from the application for us to route
 	FromNetwork chan p2p.Parcel // Parcels from the network for the application
 
+	// The network, which we tell how its peers behave (see ManagePeerScores)
+	Network *p2p.Controller
+	// The node whose federated servers the keys peers authenticate with are
+	// looked up in, through its current State, so it follows restarts.
+	Node *FactomNode
+
 	testMode  bool
 	debugMode int
+
+	scoreMutex sync.Mutex
+	received   map[string]int   // Messages from each peer since the last report
+	demerits   map[string]int32 // Demerits of each peer since the last report
+}
+
+// factomMessage is a message from the network, along with the hash of the peer
+// it came from and the key that peer authenticated with, or a message for the network along with the class it is queued
+// in, the hash of the peer it is directed to ("" to broadcast it), and the
+// earliest protocol version of peers that know it.
+type factomMessage struct {
+	message    []byte
+	peerHash   string
+	peerKey    string
+	class      p2p.MessageClass
+	minVersion uint16
 }
 
+const (
+	PeerReportInterval = time.Second // How often peer scores are reported to the network
+	PeerFloodLimit     = 1000        // Messages from one peer in a PeerReportInterval before it is flooding us
+
+	InvalidMsgDemerit int32 = 10  // For each message from a peer that fails Validate()
+	FloodDemerit      int32 = 100 // For each PeerReportInterval a peer floods us
+)
+
 var _ interfaces.IPeer = (*P2PProxy)(nil)
 
 func (f *P2PProxy) Init(fromName, toName string) interfaces.IPeer {
 	f.ToName = toName
 	f.FromName = fromName
-	f.BroadcastOut = make(chan []byte, 10000)
-	f.BroadcastIn = make(chan []byte, 10000)
+	f.BroadcastOut = make(chan factomMessage, 10000)
+	f.BroadcastIn = make(chan factomMessage, 10000)
+	f.received = make(map[string]int)
+	f.demerits = make(map[string]int32)
 	f.testMode = false // When this is false, factomd is connected to the network.  When true, network is isolated, and a heartbeat test message sent over the network.
 	return f
 }
@@ -67,23 +102,49 @@ func (f *P2PProxy) Send(msg interfaces.IMsg) error {
 			fmt.Println("ERROR on Send: ", err)
 			return err
 		}
+		peerHash := "" // Broadcast, unless a peer to peer message names a peer
+		if msg.IsPeer2Peer() {
+			peerHash = msg.GetNetworkOrigin()
+		}
 		if len(f.BroadcastOut) < 10000 {
-			f.BroadcastOut <- data
+			f.BroadcastOut <- factomMessage{message: data, peerHash: peerHash, class: messageClass(msg), minVersion: minVersion(msg)}
 		}
 	}
 	return nil
 }
 
+// Returns the identity of the federated server whose signing key a peer
+// authenticated with, or nil if the peer is not a federated server.
+func (f *P2PProxy) federatedIdentity(peerKey string) interfaces.IHash {
+	if f.Node == nil || peerKey == "" {
+		return nil
+	}
+	key, err := hex.DecodeString(peerKey)
+	if err != nil {
+		return nil
+	}
+	return f.Node.State.GetFederatedServerByKey(key)
+}
+
 // Non-blocking return value from channel.
 func (f *P2PProxy) Recieve() (interfaces.IMsg, error) {
 	if !f.testMode {
 		select {
 		case data, ok := <-f.BroadcastIn:
 			if ok {
-				msg, err := messages.UnmarshalMessage(data)
+				msg, err := messages.UnmarshalMessage(data.message)
 				if 0 < f.debugMode {
 					fmt.Printf(".")
 				}
+				if nil == err {
+					msg.SetNetworkOrigin(data.peerHash)
+					msg.SetNetworkIdentity(f.federatedIdentity(data.peerKey))
+				} else {
+					f.Demerit(data.peerHash, InvalidMsgDemerit) // Sent us garbage
+				}
+				f.scoreMutex.Lock()
+				f.received[data.peerHash]++
+				f.scoreMutex.Unlock()
 				return msg, err
 			}
 		default:
@@ -120,30 +181,88 @@ func (f *P2PProxy) Len() int {
 func (p *P2PProxy) startProxy() {
 	go p.ManageOutChannel() // Bridges between network format Parcels and factomd messages (incl. addressing to peers)
 	go p.ManageInChannel()
+	go p.ManagePeerScores()
+}
+
+// Demerit counts demerits against the network peer with the given hash, to be
+// reported with the next peer scores.  Peers are merited by the network itself
+// for every well formed parcel, so only demerits come from the application.
+func (f *P2PProxy) Demerit(peerHash string, demerit int32) {
+	if peerHash == "" {
+		return
+	}
+	f.scoreMutex.Lock()
+	defer f.scoreMutex.Unlock()
+	f.demerits[peerHash] += demerit
 }
 
-// Note: BUGBUG - the NetworkProcessorNet / state has a channel of bad messages "Bad message queue?"
-// We need to rpocess these messages and get / give demerits.
-// Paul says its ok to punt on this for the alpha
-//
+// ManagePeerScores reports the demerits of the network peers every
+// PeerReportInterval, adding FloodDemerit for the peers that sent us more than
+// PeerFloodLimit messages in the interval.  Reporting in batches keeps the
+// network's command channel from filling up when a peer misbehaves a lot.
+func (f *P2PProxy) ManagePeerScores() {
+	for {
+		time.Sleep(PeerReportInterval)
+
+		f.scoreMutex.Lock()
+		for peerHash, count := range f.received {
+			if PeerFloodLimit < count {
+				f.demerits[peerHash] += FloodDemerit
+			}
+		}
+		demerits := f.demerits
+		f.received = make(map[string]int)
+		f.demerits = make(map[string]int32)
+		f.scoreMutex.Unlock()
+
+		if nil == f.Network {
+			continue
+		}
+		for peerHash, demerit := range demerits {
+			f.Network.AdjustPeerQuality(peerHash, -demerit)
+		}
+	}
+}
 
 // manageOutChannel takes messages from the f.broadcastOut channel and sends them to the network.
 func (f *P2PProxy) ManageOutChannel() {
 	for data := range f.BroadcastOut {
 		// Wrap it in a parcel and send it out channel ToNetwork.
-		parcel := p2p.NewParcel(p2p.CurrentNetwork, data)
+		parcel := p2p.NewParcel(p2p.CurrentNetwork, data.message)
 		parcel.Header.Type = p2p.TypeMessage
-		// BUGBUG JAYJAY TODO -- Load the target peer from the message, if there is one, int the parcel
-		// so it can be sent as a directed message
+		parcel.Header.Class = data.class
+		parcel.Header.MinVersion = data.minVersion
+		parcel.Header.TargetPeer = data.peerHash // Directed if there is a target, see Send()
 		f.ToNetwork <- *parcel
 	}
 }
 
+// messageClass returns the class a message is queued in on its way to the
+// network, so that consensus messages aren't held up behind catch-up traffic.
+func messageClass(msg interfaces.IMsg) p2p.MessageClass {
+	switch msg.Type() {
+	case constants.COMMIT_CHAIN_MSG, constants.COMMIT_ENTRY_MSG, constants.REVEAL_ENTRY_MSG, constants.FACTOID_TRANSACTION_MSG:
+		return p2p.ClassTransaction
+	case constants.DBSTATE_MSG, constants.DBSTATE_MISSING_MSG, constants.MISSING_DATA, constants.DATA_RESPONSE, constants.REQUEST_BLOCK_MSG, constants.BLOCK_DIGEST_MSG:
+		return p2p.ClassSync
+	}
+	return p2p.ClassConsensus
+}
+
+// minVersion returns the earliest protocol version of peers that know a
+// message.  Peers that haven't upgraded would reject newer messages.
+func minVersion(msg interfaces.IMsg) uint16 {
+	if msg.Type() == constants.BLOCK_DIGEST_MSG {
+		return p2p.DigestVersion
+	}
+	return 0
+}
+
 // manageInChannel takes messages from the network and stuffs it in the f.BroadcastIn channel
 func (f *P2PProxy) ManageInChannel() {
 	for data := range f.FromNetwork {
-		// BUGBUG JAYJAY TODO Here is where you copy the connecton ID into the Factom message?
-		message := data.Payload
+		// The connection ID goes along, so we can tell the network how the peer behaves.
+		message := factomMessage{message: data.Payload, peerHash: data.Header.TargetPeer, peerKey: data.Header.PeerKey}
 		f.BroadcastIn <- message
 	}
 }
@@ -161,6 +280,9 @@ func (f *P2PProxy) ProxyStatusReport(fnodes []*FactomNode) {
 		note("   FromNetwork Queue:   %d", len(f.FromNetwork))
 		note("  BroadcastOut Queue:   %d", len(f.BroadcastOut))
 		note("   BroadcastIn Queue:   %d", len(f.BroadcastIn))
+		for _, class := range p2p.GetQueueMetrics() {
+			note("%12s Send Queue:   %d (sent %d, %d bytes, dropped %d)", class.Class, class.Queued, class.Sent, class.SentBytes, class.Dropped)
+		}
 	}
 }
 
@@ -0,0 +1,75 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/FactomProject/factomd/common/adminBlock"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
+	. "github.com/FactomProject/factomd/engine"
+	"github.com/FactomProject/factomd/p2p"
+	. "github.com/FactomProject/factomd/testHelper"
+)
+
+// Sends a message into the proxy as a peer with key, and returns it as the
+// application receives it.
+func receiveFrom(t *testing.T, proxy *P2PProxy, key []byte) interfaces.IMsg {
+	data, err := newGossipMsg(1).MarshalBinary()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	parcel := p2p.NewParcel(p2p.CurrentNetwork, data)
+	parcel.Header.Type = p2p.TypeMessage
+	parcel.Header.TargetPeer = "peer"
+	parcel.Header.PeerKey = hex.EncodeToString(key)
+	proxy.FromNetwork <- *parcel
+	for i := 0; i < 100; i++ {
+		msg, err := proxy.Recieve()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if msg != nil {
+			return msg
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Message never came through the proxy")
+	return nil
+}
+
+func TestP2PProxyNetworkIdentity(t *testing.T) {
+	fnode := new(FactomNode)
+	fnode.State = CreateEmptyTestState()
+
+	identity := primitives.Sha([]byte("FNode1"))
+	key := NewPrimitivesPrivateKey(1).Pub
+	ablock := fnode.State.NewAdminBlock(0)
+	ablock.AddFedServer(identity)
+	ablock.AddABEntry(adminBlock.NewAddFederatedServerSigningKey(identity, 0, *key))
+	ablock.UpdateState(fnode.State)
+
+	proxy := new(P2PProxy).Init("node", "P2P Network").(*P2PProxy)
+	proxy.FromNetwork = make(chan p2p.Parcel, 1)
+	proxy.Node = fnode
+	go proxy.ManageInChannel()
+	defer close(proxy.FromNetwork)
+
+	msg := receiveFrom(t, proxy, key[:])
+	if msg.GetNetworkOrigin() != "peer" {
+		t.Errorf("Message should come from peer, not %q", msg.GetNetworkOrigin())
+	}
+	if msg.GetNetworkIdentity() == nil || !msg.GetNetworkIdentity().IsSameAs(identity) {
+		t.Errorf("A peer with the signing key of a federated server should be that server")
+	}
+
+	other := NewPrimitivesPrivateKey(2).Pub
+	if receiveFrom(t, proxy, other[:]).GetNetworkIdentity() != nil {
+		t.Errorf("A peer that is not a federated server should have no identity")
+	}
+}
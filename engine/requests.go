@@ -0,0 +1,248 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+)
+
+// Requests for missing data (DBStateMissing, MissingMsg and MissingData) go
+// to one peer at a time, rather than to the whole network.  The peer is one
+// that has sent us a message at or above the height asked for, so it should
+// have what we are missing.  Over the P2PProxy the request is directed to one
+// peer on the p2p network, by setting the message's NetworkOrigin.  If no
+// answer comes in RequestTimeout, the peer is demerited and the request goes
+// to another peer, up to RequestRetries times.  A request already waiting on
+// an answer is not sent again.
+
+const (
+	RequestTimeout       = 4 * time.Second        // How long a peer has to answer a request
+	RequestRetries       = 3                      // Peers asked after the first before we give up
+	RequestCheckInterval = 250 * time.Millisecond // How often we look for requests that timed out
+
+	UnansweredDemerit int32 = 5 // For each request a network peer doesn't answer in time
+)
+
+// requestPeer is a peer a request can go to: an index into the node's Peers,
+// and for the P2PProxy, the hash of a peer on the p2p network.
+type requestPeer struct {
+	index    int
+	peerHash string
+}
+
+type request struct {
+	msg    interfaces.IMsg
+	height uint32 // The height a peer must have reached to answer
+	peer   requestPeer
+	asked  map[requestPeer]bool
+	sent   time.Time
+	tries  int
+}
+
+// Requests is the requests for missing data a node is waiting on, and the
+// highest height each of its peers has shown.
+type Requests struct {
+	mutex   sync.Mutex
+	pending map[string]*request
+	heights map[requestPeer]uint32
+
+	Unanswered uint64 // Requests that timed out, over all peers
+}
+
+func NewRequests() *Requests {
+	r := new(Requests)
+	r.pending = make(map[string]*request)
+	r.heights = make(map[requestPeer]uint32)
+	return r
+}
+
+// requestKey returns the key a request is tracked by, and the height a peer
+// must have reached to answer it.  ok is false for messages that are not
+// tracked requests.
+func requestKey(msg interfaces.IMsg) (key string, height uint32, ok bool) {
+	switch m := msg.(type) {
+	case *messages.DBStateMissing:
+		return fmt.Sprintf("dbstate %d-%d", m.DBHeightStart, m.DBHeightEnd), m.DBHeightStart, true
+	case *messages.MissingMsg:
+		return fmt.Sprintf("msg %d/%d/%d", m.DBHeight, m.VM, m.ProcessListHeight), m.DBHeight, true
+	case *messages.MissingData:
+		return fmt.Sprintf("data %x", m.RequestHash.Bytes()), 0, true
+	}
+	return "", 0, false
+}
+
+// answersKeys returns the keys of the requests msg may answer.
+func (r *Requests) answersKeys(msg interfaces.IMsg) []string {
+	switch m := msg.(type) {
+	case *messages.DBStateMsg:
+		height := m.DirectoryBlock.GetHeader().GetDBHeight()
+		var keys []string
+		for key, req := range r.pending {
+			if missing, ok := req.msg.(*messages.DBStateMissing); ok && missing.DBHeightStart <= height && height <= missing.DBHeightEnd {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	case *messages.Ack:
+		return []string{fmt.Sprintf("msg %d/%d/%d", m.DBHeight, m.GetVMIndex(), m.Height)}
+	case *messages.DataResponse:
+		return []string{fmt.Sprintf("data %x", m.DataHash.Bytes())}
+	}
+	return nil
+}
+
+// messageHeight returns the height a message shows its sender has reached.
+func messageHeight(msg interfaces.IMsg) (uint32, bool) {
+	switch m := msg.(type) {
+	case *messages.DBStateMsg:
+		return m.DirectoryBlock.GetHeader().GetDBHeight(), true
+	case *messages.EOM:
+		return m.DBHeight, true
+	case *messages.DirectoryBlockSignature:
+		return m.DBHeight, true
+	case *messages.Ack:
+		return m.DBHeight, true
+	}
+	return 0, false
+}
+
+// Heard notes a message from the peer at index i of the node's Peers: the
+// height it shows the peer has reached, and the requests it answers.
+func (r *Requests) Heard(i int, msg interfaces.IMsg) {
+	peer := requestPeer{index: i, peerHash: msg.GetNetworkOrigin()}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if height, ok := messageHeight(msg); ok && r.heights[peer] < height {
+		r.heights[peer] = height
+	}
+	for _, key := range r.answersKeys(msg) {
+		delete(r.pending, key)
+	}
+}
+
+// choose picks a peer for a request: one that has reached the height and has
+// not been asked yet, or failing that, any peer not asked yet.
+func (r *Requests) choose(fnode *FactomNode, req *request) (requestPeer, bool) {
+	var reached, others []requestPeer
+	for peer, height := range r.heights {
+		if req.asked[peer] || peer.index >= len(fnode.Peers) {
+			continue
+		}
+		if req.height <= height {
+			reached = append(reached, peer)
+		} else {
+			others = append(others, peer)
+		}
+	}
+	// Map order is random, so sort the peers for rand to pick from, and a
+	// simulation with a seed picks the same peers each run.
+	sortPeers(reached)
+	sortPeers(others)
+	if 0 < len(reached) {
+		return reached[fnode.intn(len(reached))], true
+	}
+	// Peers we haven't heard from yet.  The P2PProxy with no peer hash
+	// broadcasts, as we know of no one peer on the network to ask.
+	for i := range fnode.Peers {
+		peer := requestPeer{index: i}
+		if _, heard := r.heights[peer]; !heard && !req.asked[peer] {
+			others = append(others, peer)
+		}
+	}
+	if 0 < len(others) {
+		return others[fnode.intn(len(others))], true
+	}
+	return requestPeer{}, false
+}
+
+func sortPeers(peers []requestPeer) {
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].index != peers[j].index {
+			return peers[i].index < peers[j].index
+		}
+		return peers[i].peerHash < peers[j].peerHash
+	})
+}
+
+// Send sends a request for missing data that this node made to one of its
+// peers, and tracks it.  It returns false if msg is not a tracked request, so
+// the caller should send it as before.
+func (r *Requests) Send(fnode *FactomNode, msg interfaces.IMsg) bool {
+	key, height, ok := requestKey(msg)
+	if !ok {
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, waiting := r.pending[key]; waiting {
+		return true
+	}
+	req := &request{msg: msg, height: height, asked: make(map[requestPeer]bool)}
+	if r.sendTo(fnode, req) {
+		r.pending[key] = req
+	}
+	return true
+}
+
+// sendTo sends req to a peer it hasn't been sent to, and returns false if
+// there is none.
+func (r *Requests) sendTo(fnode *FactomNode, req *request) bool {
+	peer, ok := r.choose(fnode, req)
+	if !ok {
+		return false
+	}
+	req.peer = peer
+	req.asked[peer] = true
+	req.sent = interfaces.Now()
+	req.msg.SetOrigin(peer.index + 1)
+	req.msg.SetNetworkOrigin(peer.peerHash)
+	gossipSend(fnode, peer.index, fmt.Sprintf("Request %d", req.tries), req.msg)
+	return true
+}
+
+// Expire demerits the peers that didn't answer in RequestTimeout, and sends
+// their requests to other peers.
+func (r *Requests) Expire(fnode *FactomNode, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]string, 0, len(r.pending))
+	for key := range r.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // So retries pick their peers in the same order each run
+	for _, key := range keys {
+		req := r.pending[key]
+		if now.Sub(req.sent) < RequestTimeout {
+			continue
+		}
+		r.Unanswered++
+		if proxy, ok := fnode.Peers[req.peer.index].(*P2PProxy); ok && req.peer.peerHash != "" {
+			proxy.Demerit(req.peer.peerHash, UnansweredDemerit)
+		}
+		req.tries++
+		if RequestRetries < req.tries || !r.sendTo(fnode, req) {
+			delete(r.pending, key)
+		}
+	}
+}
+
+// ManageRequests retries the requests of a node that timed out.
+func ManageRequests(fnode *FactomNode) {
+	for !fnode.quitting() {
+		time.Sleep(RequestCheckInterval)
+		fnode.Requests.Expire(fnode, interfaces.Now())
+	}
+}
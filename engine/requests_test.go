@@ -0,0 +1,93 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"testing"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	. "github.com/FactomProject/factomd/engine"
+	. "github.com/FactomProject/factomd/testHelper"
+)
+
+func newRequestNode(peers int) *FactomNode {
+	fnode := new(FactomNode)
+	fnode.State = CreateEmptyTestState()
+	fnode.MLog = new(MsgLog)
+	fnode.Requests = NewRequests()
+	for i := 0; i < peers; i++ {
+		fnode.Peers = append(fnode.Peers, new(SimPeer).Init("node", "peer"))
+	}
+	return fnode
+}
+
+func sentTo(fnode *FactomNode) []int {
+	answer := make([]int, len(fnode.Peers))
+	for i, peer := range fnode.Peers {
+		answer[i] = len(peer.(*SimPeer).BroadcastOut)
+	}
+	return answer
+}
+
+func TestRequestGoesToPeerWithHeight(t *testing.T) {
+	fnode := newRequestNode(3)
+	dbstates := CreateTestDBStateList()
+	fnode.Requests.Heard(1, dbstates[len(dbstates)-1])
+	fnode.Requests.Heard(2, dbstates[0])
+
+	missing := messages.NewDBStateMissing(fnode.State, 3, 5)
+	if !fnode.Requests.Send(fnode, missing) {
+		t.Fatalf("DBStateMissing should be tracked")
+	}
+	if sent := sentTo(fnode); sent[0] != 0 || sent[1] != 1 || sent[2] != 0 {
+		t.Errorf("The request should go to the one peer that has the height, went to %v", sent)
+	}
+
+	fnode.Requests.Send(fnode, messages.NewDBStateMissing(fnode.State, 3, 5))
+	if sent := sentTo(fnode); sent[1] != 1 {
+		t.Errorf("A request waiting on an answer should not be sent again")
+	}
+
+	fnode.Requests.Heard(1, dbstates[4])
+	fnode.Requests.Send(fnode, messages.NewDBStateMissing(fnode.State, 3, 5))
+	if sent := sentTo(fnode); sent[1] != 2 {
+		t.Errorf("Once answered, the request should be sent again when asked")
+	}
+}
+
+func TestRequestRetries(t *testing.T) {
+	fnode := newRequestNode(2)
+	fnode.Requests.Send(fnode, messages.NewDBStateMissing(fnode.State, 3, 5))
+
+	now := interfaces.Now() // The clock Requests uses, which a Simulation may have replaced
+	fnode.Requests.Expire(fnode, now)
+	if fnode.Requests.Unanswered != 0 {
+		t.Errorf("A request should not expire before RequestTimeout")
+	}
+
+	fnode.Requests.Expire(fnode, now.Add(RequestTimeout))
+	if sent := sentTo(fnode); sent[0] != 1 || sent[1] != 1 {
+		t.Errorf("An unanswered request should go to the other peer, went to %v", sent)
+	}
+
+	// Every peer has been asked, so the request is dropped.
+	fnode.Requests.Expire(fnode, now.Add(3*RequestTimeout))
+	if fnode.Requests.Unanswered != 2 {
+		t.Errorf("Expected 2 unanswered, got %d", fnode.Requests.Unanswered)
+	}
+	fnode.Requests.Send(fnode, messages.NewDBStateMissing(fnode.State, 3, 5))
+	if sent := sentTo(fnode); sent[0]+sent[1] != 3 {
+		t.Errorf("A dropped request should be sent again when asked")
+	}
+}
+
+func TestUntrackedMessages(t *testing.T) {
+	fnode := newRequestNode(1)
+	var msg interfaces.IMsg = new(messages.EOM)
+	if fnode.Requests.Send(fnode, msg) {
+		t.Errorf("An EOM is not a request")
+	}
+}
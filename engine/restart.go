@@ -0,0 +1,110 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+
+	"github.com/FactomProject/factomd/state"
+)
+
+// A node of the simulator can be stopped on its own, and restarted later on
+// its database, as if its process had been shut down and started again.  What
+// it saved before it stopped, it loads from its database; what it missed while
+// it was down, it asks its peers for with DBStateMissing, as any node behind
+// the network does.  The Simulation stops and restarts its nodes itself; see
+// Simulation.StopNode.
+
+// start runs the goroutines of the node, one of which loads its database into
+// it if load is true.
+func (fnode *FactomNode) start(load bool) {
+	fnode.quit = make(chan struct{})
+	fnode.loaded = nil
+	NetworkProcessorNet(fnode)
+	if load {
+		loaded := make(chan struct{})
+		fnode.loaded = loaded
+		fnode.run(func() {
+			defer close(loaded)
+			state.LoadDatabaseUntil(fnode.State, fnode.quit)
+		})
+	}
+	fnode.run(func() { timer(fnode.State, fnode.quit) })
+	fnode.run(fnode.State.ValidatorLoop)
+}
+
+// run runs f in a goroutine of the node, which Stop waits on.
+func (fnode *FactomNode) run(f func()) {
+	fnode.running.Add(1)
+	go func() {
+		defer fnode.running.Done()
+		f()
+	}()
+}
+
+// quitting is true once the node is told to stop.
+func (fnode *FactomNode) quitting() bool {
+	select {
+	case <-fnode.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stopped is true if the node is stopped, until it is restarted.
+func (fnode *FactomNode) Stopped() bool {
+	return fnode.stopped
+}
+
+// Stop stops the goroutines of the node and closes its database, and returns
+// once they are done.  Messages its peers send it are lost until it is
+// restarted.
+func (fnode *FactomNode) Stop() error {
+	if fnode.stopped {
+		return fmt.Errorf("%s is already stopped", fnode.State.FactomNodeName)
+	}
+	if fnode.quit == nil {
+		return fmt.Errorf("%s was never started", fnode.State.FactomNodeName)
+	}
+	close(fnode.quit)
+	if fnode.loaded != nil {
+		<-fnode.loaded // The validator closes the database as it stops
+	}
+	select {
+	case fnode.State.ShutdownChan <- 0:
+	default: // Already shutting down
+	}
+	fnode.running.Wait()
+	fnode.stopped = true
+	return nil
+}
+
+// Restart starts a stopped node again on its database.
+func (fnode *FactomNode) Restart() error {
+	if !fnode.stopped {
+		return fmt.Errorf("%s is not stopped", fnode.State.FactomNodeName)
+	}
+	fnode.reset()
+	fnode.start(true)
+	return nil
+}
+
+// reset gives a stopped node a fresh State on its database, and drops what
+// its peers sent it while it was down, as a new process would start.
+func (fnode *FactomNode) reset() {
+	fnode.State = fnode.State.Fresh()
+	fnode.State.Init()
+	fnode.Gossip = NewGossip()
+	fnode.Requests = NewRequests()
+	for _, peer := range fnode.Peers {
+		if simPeer, ok := peer.(*SimPeer); ok {
+			for 0 < len(simPeer.BroadcastIn) {
+				<-simPeer.BroadcastIn
+			}
+		}
+	}
+	fnode.stopped = false
+}
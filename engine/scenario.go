@@ -0,0 +1,238 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/FactomProject/factomd/common/messages"
+)
+
+// A Scenario is a set of faults for a Simulation to put on the links between
+// its nodes, and the assertions the nodes should pass at the end of the run.
+// Scenarios are written in JSON, for example:
+//
+//	{
+//	    "seed": 7,
+//	    "run": "10m",
+//	    "links": [
+//	        {"latency": "50ms", "jitter": "100ms", "distribution": "exponential"},
+//	        {"from": [2], "drop": 0.1, "duplicate": 0.05, "reorder": 0.2}
+//	    ],
+//	    "partitions": [
+//	        {"from": [0], "to": [3, 4], "start": {"height": 2}, "heal": {"height": 4, "minute": 5}}
+//	    ],
+//	    "assert": [{"height": 5, "agree": true}]
+//	}
+//
+// Nodes are given by their index.  An empty list of nodes means every node.
+
+const (
+	SimUniform     = "uniform"     // Jitter anywhere from 0 to Jitter
+	SimNormal      = "normal"      // Jitter with a half normal distribution, Jitter the standard deviation
+	SimExponential = "exponential" // Jitter with an exponential distribution, Jitter the mean
+)
+
+type Scenario struct {
+	Seed       int64          `json:"seed"`       // Seed for the Simulation, if not set on the command line
+	Run        string         `json:"run"`        // How long to run on the virtual clock before checking the assertions
+	Links      []SimLink      `json:"links"`      // Faults on links, the last that matches a link applies
+	Partitions []SimPartition `json:"partitions"` // Links that are cut for a time
+	Assert     []SimAssertion `json:"assert"`     // What must be true at the end of the run
+
+	runFor time.Duration
+}
+
+// A SimLink is how messages sent from one node to another are delivered.
+type SimLink struct {
+	From         []int   `json:"from"`         // Nodes the messages are sent from
+	To           []int   `json:"to"`           // Nodes the messages are sent to
+	Latency      string  `json:"latency"`      // Least time a message takes, as for time.ParseDuration
+	Jitter       string  `json:"jitter"`       // Added to the latency, at random
+	Distribution string  `json:"distribution"` // Of the jitter; uniform by default
+	Drop         float64 `json:"drop"`         // Fraction of messages lost
+	Duplicate    float64 `json:"duplicate"`    // Fraction of messages delivered twice
+	Reorder      float64 `json:"reorder"`      // Fraction of messages that may arrive before ones sent earlier
+	Corrupt      float64 `json:"corrupt"`      // Fraction of messages with a byte changed
+
+	latency time.Duration
+	jitter  time.Duration
+}
+
+// A SimPoint is a minute in a block, as the node sending a message has it.
+type SimPoint struct {
+	Height uint32 `json:"height"`
+	Minute int    `json:"minute"`
+}
+
+// A SimPartition cuts the links from one set of nodes to another, in that
+// direction only, from the point Start until the point Heal.  A Heal of
+// height 0 and minute 0 means the partition never heals.  List both
+// directions for a partition that cuts both ways.
+type SimPartition struct {
+	From  []int    `json:"from"`
+	To    []int    `json:"to"`
+	Start SimPoint `json:"start"`
+	Heal  SimPoint `json:"heal"`
+}
+
+// A SimAssertion is something the nodes must agree on at the end of a run.
+type SimAssertion struct {
+	Height uint32 `json:"height"` // Every node must have saved the directory block at this height
+	Agree  bool   `json:"agree"`  // And every node must have the same KeyMR for it
+}
+
+// LoadScenario reads a Scenario from a JSON file.
+func LoadScenario(filename string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScenario(data)
+}
+
+// ParseScenario reads a Scenario from JSON, and checks it.
+func ParseScenario(data []byte) (*Scenario, error) {
+	scenario := new(Scenario)
+	if err := json.Unmarshal(data, scenario); err != nil {
+		return nil, err
+	}
+	var err error
+	if scenario.runFor, err = parseDuration(scenario.Run); err != nil {
+		return nil, fmt.Errorf("run: %s", err.Error())
+	}
+	for i := range scenario.Links {
+		link := &scenario.Links[i]
+		if link.latency, err = parseDuration(link.Latency); err != nil {
+			return nil, fmt.Errorf("links[%d] latency: %s", i, err.Error())
+		}
+		if link.jitter, err = parseDuration(link.Jitter); err != nil {
+			return nil, fmt.Errorf("links[%d] jitter: %s", i, err.Error())
+		}
+		switch link.Distribution {
+		case "", SimUniform, SimNormal, SimExponential:
+		default:
+			return nil, fmt.Errorf("links[%d] distribution: unknown distribution %q", i, link.Distribution)
+		}
+		for _, fraction := range []float64{link.Drop, link.Duplicate, link.Reorder, link.Corrupt} {
+			if fraction < 0 || 1 < fraction {
+				return nil, fmt.Errorf("links[%d]: fractions must be from 0 to 1", i)
+			}
+		}
+	}
+	return scenario, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// RunFor is how long the scenario runs for on the virtual clock, or 0 if it
+// runs until stopped.
+func (scenario *Scenario) RunFor() time.Duration {
+	return scenario.runFor
+}
+
+func hasNode(nodes []int, i int) bool {
+	if len(nodes) == 0 {
+		return true
+	}
+	for _, node := range nodes {
+		if node == i {
+			return true
+		}
+	}
+	return false
+}
+
+// before is true if a comes before b.
+func (a SimPoint) before(b SimPoint) bool {
+	return a.Height < b.Height || (a.Height == b.Height && a.Minute < b.Minute)
+}
+
+// link returns the faults on the link from node from to node to, or nil if
+// there are none.
+func (scenario *Scenario) link(from, to int) *SimLink {
+	for i := len(scenario.Links) - 1; i >= 0; i-- {
+		if hasNode(scenario.Links[i].From, from) && hasNode(scenario.Links[i].To, to) {
+			return &scenario.Links[i]
+		}
+	}
+	return nil
+}
+
+// cut is true if the link from node from to node to is partitioned when the
+// sender is at now.
+func (scenario *Scenario) cut(from, to int, now SimPoint) bool {
+	for _, p := range scenario.Partitions {
+		if !hasNode(p.From, from) || !hasNode(p.To, to) || now.before(p.Start) {
+			continue
+		}
+		if p.Heal == (SimPoint{}) || now.before(p.Heal) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long a message takes on the link.
+func (link *SimLink) delay(sim *Simulation) time.Duration {
+	if link.jitter <= 0 {
+		return link.latency
+	}
+	var jitter float64
+	switch link.Distribution {
+	case SimNormal:
+		jitter = math.Abs(sim.rng.NormFloat64()) * float64(link.jitter)
+	case SimExponential:
+		jitter = sim.rng.ExpFloat64() * float64(link.jitter)
+	default:
+		jitter = sim.rng.Float64() * float64(link.jitter)
+	}
+	return link.latency + time.Duration(jitter)
+}
+
+// Check returns an error for each assertion of the scenario the nodes of sim
+// fail.
+func (scenario *Scenario) Check(sim *Simulation) []error {
+	var errs []error
+	for _, assert := range scenario.Assert {
+		keyMR, first := "", ""
+		for _, fnode := range sim.Nodes {
+			s := fnode.State
+			if s.GetHighestRecordedBlock() < assert.Height {
+				errs = append(errs, fmt.Errorf("%s has not saved the directory block at height %d, only to %d",
+					s.FactomNodeName, assert.Height, s.GetHighestRecordedBlock()))
+				continue
+			}
+			if !assert.Agree {
+				continue
+			}
+			// As saved, rather than the node's copy in memory, whose KeyMR
+			// may not have been updated.
+			msg, err := s.LoadDBState(assert.Height)
+			if err != nil || msg == nil {
+				errs = append(errs, fmt.Errorf("%s can't read the directory block it saved at height %d: %v",
+					s.FactomNodeName, assert.Height, err))
+				continue
+			}
+			mr := msg.(*messages.DBStateMsg).DirectoryBlock.GetKeyMR().String()
+			if keyMR == "" {
+				keyMR, first = mr, s.FactomNodeName
+			} else if mr != keyMR {
+				errs = append(errs, fmt.Errorf("%s has KeyMR %s at height %d, but %s has %s",
+					s.FactomNodeName, mr, assert.Height, first, keyMR))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomProject/factomd/engine"
+)
+
+func TestParseScenario(t *testing.T) {
+	scenario, err := ParseScenario([]byte(`{
+		"seed": 7,
+		"run": "90s",
+		"links": [{"from": [1], "latency": "50ms", "jitter": "10ms", "distribution": "normal", "drop": 0.5}],
+		"partitions": [{"from": [0], "to": [1], "start": {"height": 1, "minute": 3}}],
+		"assert": [{"height": 2, "agree": true}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.Seed != 7 || scenario.RunFor() != 90*time.Second {
+		t.Errorf("Expected seed 7 and run of 90s, got %d and %s", scenario.Seed, scenario.RunFor())
+	}
+	if len(scenario.Links) != 1 || scenario.Links[0].Drop != 0.5 || len(scenario.Partitions) != 1 || scenario.Partitions[0].Start.Minute != 3 {
+		t.Errorf("Scenario not parsed: %+v", scenario)
+	}
+
+	for _, bad := range []string{
+		`{"run": "forever"}`,
+		`{"links": [{"jitter": "10"}]}`,
+		`{"links": [{"distribution": "poisson"}]}`,
+		`{"links": [{"drop": 2}]}`,
+		`{"links": {}}`,
+	} {
+		if _, err := ParseScenario([]byte(bad)); err == nil {
+			t.Errorf("Expected an error for %s", bad)
+		}
+	}
+}
+
+func TestScenarioPartition(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	scenario, err := ParseScenario([]byte(`{
+		"partitions": [{"from": [0], "to": [1]}, {"from": [1], "to": [0]}],
+		"assert": [{"height": 1}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := newSimulation(42, 2)
+	sim.Scenario = scenario
+	sim.Run(time.Minute)
+
+	if sim.Nodes[0].State.GetHighestRecordedBlock() < 1 {
+		t.Errorf("The leader should make blocks alone")
+	}
+	if sim.Nodes[1].State.GetHighestRecordedBlock() != 0 {
+		t.Errorf("A node cut off from the leader should get no blocks")
+	}
+	if errs := scenario.Check(sim); len(errs) != 1 {
+		t.Errorf("Expected the node cut off to fail the assertion, got %v", errs)
+	}
+}
+
+func TestScenarioAgree(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	// Node 2 is cut off until height 3, and catches up from the blocks the
+	// others send it.  The others have the blocks they built in memory, it
+	// the ones it was sent, and they must still agree on what they saved.
+	scenario, err := ParseScenario([]byte(`{
+		"partitions": [{"from": [0, 1], "to": [2], "heal": {"height": 3}}],
+		"assert": [{"height": 1, "agree": true}, {"height": 4, "agree": true}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := newSimulation(42, 3)
+	sim.Scenario = scenario
+	if !sim.RunToHeight(4, 3*time.Minute) {
+		t.Fatalf("Stopped at height %d", sim.Nodes[2].State.GetHighestRecordedBlock())
+	}
+	if errs := scenario.Check(sim); len(errs) != 0 {
+		t.Errorf("Nodes that saved the same blocks should agree, got %v", errs)
+	}
+}
@@ -13,19 +13,20 @@ import (
 	"unicode"
 
 	"github.com/FactomProject/factomd/common/interfaces"
-	"github.com/FactomProject/factomd/common/messages"
 	"github.com/FactomProject/factomd/common/primitives"
-	"github.com/FactomProject/factomd/wsapi"
 )
 
 var _ = fmt.Print
 
-func SimControl(listenTo int) {
+// SimControl is the keyboard UI of the simulator.  Its commands go through
+// control, as do those of the control API.
+func SimControl(control *SimController) {
 
 	var _ = time.Sleep
 	var summary bool
 	var watchPL bool
 	var watchMessages bool
+	listenTo := control.Focus()
 
 	for {
 		l := make([]byte, 100)
@@ -47,17 +48,18 @@ func SimControl(listenTo int) {
 			cmd = []string{"h"}
 		}
 		b := string(cmd[0])
+		listenTo = control.Focus()
 		v, err := strconv.Atoi(string(b))
-		if err == nil && v >= 0 && v < len(fnodes) && fnodes[listenTo].State != nil {
-			listenTo = v
-			os.Stderr.WriteString(fmt.Sprintf("Switching to Node %d\n", listenTo))
+		if err == nil {
+			if err := control.SetFocus(v); err == nil {
+				listenTo = v
+				os.Stderr.WriteString(fmt.Sprintf("Switching to Node %d\n", listenTo))
+			}
 		} else {
 			// fmt.Printf("Parsing command, found %d elements.  The first element is: %+v / %s \n Full command: %+v\n", len(cmd), b[0], string(b), cmd)
 			switch {
 			case 'w' == b[0]:
-				if listenTo >= 0 && listenTo < len(fnodes) {
-					wsapi.SetState(fnodes[listenTo].State)
-				}
+				control.PointWSAPI(listenTo)
 			case 's' == b[0]:
 				summary = !summary
 				if summary {
@@ -75,97 +77,32 @@ func SimControl(listenTo int) {
 					os.Stderr.WriteString("--Print Process Lists Off--\n")
 				}
 			case 'a' == b[0]:
-				mLog.all = false
-				for _, fnode := range fnodes {
-					fnode.State.SetOut(false)
-				}
-				if listenTo < 0 || listenTo > len(fnodes) {
-					fmt.Println("Select a node first")
-					break
-				}
-				f := fnodes[listenTo]
-				fmt.Println("-----------------------------", f.State.FactomNodeName, "--------------------------------------", string(b[:len(b)]))
-				if len(b) < 2 {
-					break
-				}
-				ht, err := strconv.Atoi(string(b[1:]))
-				if err != nil {
-					fmt.Println(err, "Dump Adminblock block with an  where n = blockheight, i.e. 'a10'")
-				} else {
-					msg, err := f.State.LoadDBState(uint32(ht))
-					if err == nil && msg != nil {
-						dsmsg := msg.(*messages.DBStateMsg)
-						ABlock := dsmsg.AdminBlock
-						fmt.Println(ABlock.String())
-					} else {
-						fmt.Println("Error: ", err, msg)
-					}
-				}
+				printBlock(control, listenTo, "admin", b, "Dump Adminblock block with an  where n = blockheight, i.e. 'a10'")
 			case 'f' == b[0]:
-				mLog.all = false
-				for _, fnode := range fnodes {
-					fnode.State.SetOut(false)
-				}
-				if listenTo < 0 || listenTo > len(fnodes) {
-					fmt.Println("Select a node first")
-					break
-				}
-				f := fnodes[listenTo]
-				fmt.Println("-----------------------------", f.State.FactomNodeName, "--------------------------------------", string(b[:len(b)]))
-				if len(b) < 2 {
-					break
-				}
-				ht, err := strconv.Atoi(string(b[1:]))
-				if err != nil {
-					fmt.Println(err, "Dump Factoid block with fn  where n = blockheight, i.e. 'f10'")
-				} else {
-					msg, err := f.State.LoadDBState(uint32(ht))
-					if err == nil && msg != nil {
-						dsmsg := msg.(*messages.DBStateMsg)
-						FBlock := dsmsg.FactoidBlock
-						fmt.Printf(FBlock.String())
-					} else {
-						fmt.Println("Error: ", err, msg)
-					}
-				}
+				printBlock(control, listenTo, "factoid", b, "Dump Factoid block with fn  where n = blockheight, i.e. 'f10'")
 			case 'd' == b[0]:
-				mLog.all = false
-				for _, fnode := range fnodes {
-					fnode.State.SetOut(false)
-				}
-				if listenTo < 0 || listenTo > len(fnodes) {
-					fmt.Println("Select a node first")
-					break
-				}
-				f := fnodes[listenTo]
-				fmt.Println("-----------------------------", f.State.FactomNodeName, "--------------------------------------", string(b[:len(b)]))
-				if len(b) < 2 {
-					break
+				printBlock(control, listenTo, "directory", b, "Dump Directory block with dn  where n = blockheight, i.e. 'd10'")
+			case 'x' == b[0]:
+				f := control.Nodes[listenTo]
+				v, _ := control.Offline(listenTo)
+				if v {
+					os.Stderr.WriteString("Bring " + f.State.FactomNodeName + " Back onto the network\n")
+				} else {
+					os.Stderr.WriteString("Take  " + f.State.FactomNodeName + " off the network\n")
 				}
-				ht, err := strconv.Atoi(string(b[1:]))
-				if err != nil {
-					fmt.Println(err, "Dump Directory block with dn  where n = blockheight, i.e. 'd10'")
+				control.SetOffline(listenTo, !v)
+			case 'k' == b[0]:
+				f := control.Nodes[listenTo]
+				var err error
+				if f.Stopped() {
+					os.Stderr.WriteString("Restart " + f.State.FactomNodeName + " on its database\n")
+					err = control.RestartNode(listenTo)
 				} else {
-					msg, err := f.State.LoadDBState(uint32(ht))
-					if err == nil && msg != nil {
-						dsmsg := msg.(*messages.DBStateMsg)
-						DBlock := dsmsg.DirectoryBlock
-						fmt.Printf(DBlock.String())
-					} else {
-						fmt.Println("Error: ", err, msg)
-					}
+					os.Stderr.WriteString("Stop    " + f.State.FactomNodeName + "\n")
+					err = control.StopNode(listenTo)
 				}
-			case 'x' == b[0]:
-
-				if listenTo >= 0 && listenTo < len(fnodes) {
-					f := fnodes[listenTo]
-					v := f.State.GetNetStateOff()
-					if v {
-						os.Stderr.WriteString("Bring " + f.State.FactomNodeName + " Back onto the network\n")
-					} else {
-						os.Stderr.WriteString("Take  " + f.State.FactomNodeName + " off the network\n")
-					}
-					f.State.SetNetStateOff(!v)
+				if err != nil {
+					fmt.Println(err)
 				}
 
 			case 'm' == b[0]:
@@ -177,29 +114,40 @@ func SimControl(listenTo int) {
 					os.Stderr.WriteString("--Print Messages Off--\n")
 				}
 			case 'l' == b[0]:
-				msg := messages.NewAddServerMsg(fnodes[listenTo].State, 0)
-				fnodes[listenTo].State.InMsgQueue() <- msg
-				os.Stderr.WriteString(fmt.Sprintln("Attempting to make", fnodes[listenTo].State.GetFactomNodeName(), "a Leader"))
+				control.MakeLeader(listenTo)
+				os.Stderr.WriteString(fmt.Sprintln("Attempting to make", control.Nodes[listenTo].State.GetFactomNodeName(), "a Leader"))
 				fallthrough
 			case 'n' == b[0]:
-				fnodes[listenTo].State.SetOut(false)
-				listenTo++
-				if listenTo >= len(fnodes) {
-					listenTo = 0
-				}
-				fnodes[listenTo].State.SetOut(true)
+				listenTo = control.Next()
 				os.Stderr.WriteString(fmt.Sprint("\r\nSwitching to Node ", listenTo, "\r\n"))
+			case 'j' == b[0]:
+				if err := control.Step(false); err != nil {
+					fmt.Println(err)
+				}
+			case 'v' == b[0]:
+				status := control.CheckDivergence()
+				if status.Divergence == nil {
+					os.Stderr.WriteString("No divergence found\n")
+				} else {
+					os.Stderr.WriteString(status.Divergence.String() + "\n" + status.Report)
+				}
+				for name, d := range status.Peers {
+					os.Stderr.WriteString(fmt.Sprintf("%s found %s\n", name, d.String()))
+				}
+			case 'g' == b[0]:
+				if report, err := control.LoadReport(); err != nil {
+					fmt.Println(err)
+				} else {
+					os.Stderr.WriteString(report.String())
+				}
 			case 'c' == b[0]:
-				c := !fnodes[0].State.DebugConsensus
+				c := !control.Trace()
 				if c {
 					os.Stderr.WriteString(fmt.Sprint("\r\nTrace Consensus\n"))
 				} else {
 					os.Stderr.WriteString(fmt.Sprint("\r\nTurn off Consensus Trace \n"))
 				}
-
-				for _, f := range fnodes {
-					f.State.DebugConsensus = c
-				}
+				control.SetTrace(c)
 
 			case 'h' == b[0]:
 				os.Stderr.WriteString("-------------------------------------------------------------------------------\n")
@@ -214,10 +162,15 @@ func SimControl(listenTo int) {
 				os.Stderr.WriteString("n             Change the focus to the next node.\n")
 				os.Stderr.WriteString("l             Make focused node the Leader.\n")
 				os.Stderr.WriteString("x             Take the given node out of the netork or bring an offline node back in.\n")
+				os.Stderr.WriteString("k             Stop the focused node, closing its database, or restart a stopped one on it.\n")
+				os.Stderr.WriteString("j             Replay the next message of a journal replayed with -journalstep.\n")
+				os.Stderr.WriteString("v             Show the first height where the nodes' blocks diverge.\n")
+				os.Stderr.WriteString("g             Show how the load put on with -load has gone.\n")
 				os.Stderr.WriteString("w             Point the WSAPI to send API calls to the current node.")
 				os.Stderr.WriteString("h or <enter>  Show help\n")
 				os.Stderr.WriteString("\n")
 				os.Stderr.WriteString("Most commands are case insensitive.\n")
+				os.Stderr.WriteString("Run with -control to have these commands served as JSON over HTTP.\n")
 				os.Stderr.WriteString("-------------------------------------------------------------------------------\n\n")
 
 			default:
@@ -226,6 +179,31 @@ func SimControl(listenTo int) {
 	}
 }
 
+// printBlock prints the block of the given kind at the height following the
+// command letter in b, e.g. "d10".
+func printBlock(control *SimController, listenTo int, kind string, b string, usage string) {
+	mLog.all = false
+	for _, fnode := range control.Nodes {
+		fnode.State.SetOut(false)
+	}
+	f := control.Nodes[listenTo]
+	fmt.Println("-----------------------------", f.State.FactomNodeName, "--------------------------------------", b)
+	if len(b) < 2 {
+		return
+	}
+	ht, err := strconv.Atoi(b[1:])
+	if err != nil {
+		fmt.Println(err, usage)
+		return
+	}
+	block, err := control.Block(listenTo, kind, uint32(ht))
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return
+	}
+	fmt.Println(block.String())
+}
+
 func printSummary(summary *bool, listenTo *int) {
 	out := ""
 	for {
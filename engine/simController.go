@@ -0,0 +1,667 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/state"
+	"github.com/FactomProject/factomd/wsapi"
+)
+
+// A SimController runs the simulator's commands on its nodes, and returns what
+// they find as data rather than printing it.  SimControl, the keyboard UI,
+// uses one, and Serve makes the same commands available over HTTP, so a
+// script or a test can drive the simulator:
+//
+//	GET  /v1/nodes                         Status of every node
+//	GET  /v1/focus                         The node commands go to by default
+//	POST /v1/focus?node=N                  Change the focus
+//	POST /v1/next                          Change the focus to the next node
+//	POST /v1/offline?node=N[&off=BOOL]     Take a node off the network or back on; toggles if off is not given
+//	POST /v1/leader?node=N                 Make a node a leader
+//	POST /v1/stop?node=N                   Stop a node, closing its database
+//	POST /v1/restart?node=N                Start a stopped node again on its database
+//	GET  /v1/block/KIND/HEIGHT?node=N      The admin, factoid or directory block at HEIGHT
+//	GET  /v1/processlist?node=N            The process list and directory block states
+//	POST /v1/trace[?on=BOOL]               Trace consensus on every node; toggles if on is not given
+//	POST /v1/wsapi?node=N                  Point the WSAPI at a node
+//	POST /v1/step[?stop=BOOL]              Replay the next message of a journal replayed step by step, or stop
+//	GET  /v1/divergence                    The first height where the nodes' blocks differ, if any
+//	GET  /v1/digest/HEIGHT?node=N          The digest of the blocks saved at HEIGHT
+//	GET  /v1/blocks/HEIGHT?node=N          The blocks saved at HEIGHT and the process list, as text
+//	GET  /v1/load                          How the load put on with -load has gone
+//
+// node is the focus if not given.  Answers are JSON; errors are an object
+// with an "error" field.
+
+type SimController struct {
+	mutex sync.Mutex
+	Nodes []*FactomNode
+	focus int
+	wsapi int // The node the WSAPI answers for
+
+	Sim        *Simulation         // Runs the nodes, if they are simulated on a virtual clock; commands run on its goroutine
+	ReplayStep chan bool           // Steps a journal replayed a message at a time, if not nil
+	Divergence *DivergenceDetector // Compares the nodes' blocks, if not nil
+	Load       *LoadGenerator      // Puts load on the nodes, if not nil
+}
+
+// NodeStatus is the state of a node, much as the summary ("s") shows it.
+type NodeStatus struct {
+	Node           int            `json:"node"`
+	Name           string         `json:"name"`
+	Leader         bool           `json:"leader"`
+	Offline        bool           `json:"offline"`
+	Stopped        bool           `json:"stopped"`
+	RecordedHeight uint32         `json:"recordedheight"`
+	LeaderHeight   uint32         `json:"leaderheight"`
+	LeaderMinute   int            `json:"leaderminute"`
+	Queues         map[string]int `json:"queues"`
+	Summary        string         `json:"summary"` // The node's line of the summary
+}
+
+// ProcessListStatus is the process list a node is building, and its directory
+// block states, as the "p" command shows them.
+type ProcessListStatus struct {
+	Node           int             `json:"node"`
+	Name           string          `json:"name"`
+	DBHeight       uint32          `json:"dbheight"`
+	MinuteComplete int             `json:"minutecomplete"`
+	MinuteFinished int             `json:"minutefinished"`
+	FedServers     []string        `json:"fedservers"`
+	AuditServers   []string        `json:"auditservers"`
+	VMs            []VMStatus      `json:"vms"`
+	DBStates       []DBStateStatus `json:"dbstates"`
+}
+
+type VMStatus struct {
+	Height         int `json:"height"`
+	ListLength     int `json:"listlength"`
+	LeaderMinute   int `json:"leaderminute"`
+	Seal           int `json:"seal"`
+	MinuteComplete int `json:"minutecomplete"`
+	MinuteFinished int `json:"minutefinished"`
+	MinuteHeight   int `json:"minuteheight"`
+}
+
+type DBStateStatus struct {
+	Height uint32 `json:"height"`
+	KeyMR  string `json:"keymr"`
+	Saved  bool   `json:"saved"`
+}
+
+func NewSimController(nodes []*FactomNode, focus int) *SimController {
+	c := new(SimController)
+	c.Nodes = nodes
+	c.focus = focus
+	return c
+}
+
+// do runs f on the goroutine running the simulation, if the nodes are
+// simulated, so it doesn't race with them.  Otherwise it runs f here, as the
+// keyboard commands always have.
+func (c *SimController) do(f func()) {
+	if c.Sim == nil {
+		f()
+		return
+	}
+	c.Sim.Do(f)
+}
+
+func (c *SimController) node(i int) (*FactomNode, error) {
+	if i < 0 || i >= len(c.Nodes) {
+		return nil, fmt.Errorf("No node %d; there are %d nodes", i, len(c.Nodes))
+	}
+	return c.Nodes[i], nil
+}
+
+// Focus is the node commands go to by default.
+func (c *SimController) Focus() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.focus
+}
+
+// SetFocus changes the focus to node i.
+func (c *SimController) SetFocus(i int) error {
+	if _, err := c.node(i); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.focus = i
+	return nil
+}
+
+// Next changes the focus to the next node, and returns it.
+func (c *SimController) Next() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	last := c.Nodes[c.focus]
+	c.focus = (c.focus + 1) % len(c.Nodes)
+	next := c.Nodes[c.focus]
+	c.do(func() {
+		last.State.SetOut(false)
+		next.State.SetOut(true)
+	})
+	return c.focus
+}
+
+// Status returns the state of every node.
+func (c *SimController) Status() (status []NodeStatus) {
+	c.do(func() { status = c.status() })
+	return
+}
+
+func (c *SimController) status() []NodeStatus {
+	var status []NodeStatus
+	for i, f := range c.Nodes {
+		s := f.State
+		status = append(status, NodeStatus{
+			Node:           i,
+			Name:           s.FactomNodeName,
+			Leader:         s.Leader,
+			Offline:        s.GetNetStateOff(),
+			Stopped:        f.Stopped(),
+			RecordedHeight: s.GetHighestRecordedBlock(),
+			LeaderHeight:   s.LLeaderHeight,
+			LeaderMinute:   s.LeaderMinute,
+			Queues: map[string]int{
+				"follower":       len(s.FollowerMsgQueue()),
+				"in":             len(s.InMsgQueue()),
+				"api":            len(s.APIQueue()),
+				"leader":         len(s.LeaderMsgQueue()),
+				"stall":          len(s.Stall()),
+				"timer":          len(s.TimerMsgQueue()),
+				"networkout":     len(s.NetworkOutMsgQueue()),
+				"networkinvalid": len(s.NetworkInvalidMsgQueue()),
+			},
+			Summary: s.ShortString(),
+		})
+	}
+	return status
+}
+
+// Offline is true if node i is off the network.
+func (c *SimController) Offline(i int) (off bool, err error) {
+	f, err := c.node(i)
+	if err != nil {
+		return false, err
+	}
+	c.do(func() { off = f.State.GetNetStateOff() })
+	return off, nil
+}
+
+// SetOffline takes node i off the network, or brings it back on.
+func (c *SimController) SetOffline(i int, off bool) error {
+	f, err := c.node(i)
+	if err != nil {
+		return err
+	}
+	c.do(func() { f.State.SetNetStateOff(off) })
+	return nil
+}
+
+// StopNode stops node i: its goroutines end, its database is closed, and it
+// hears nothing from its peers until it is restarted.
+func (c *SimController) StopNode(i int) error {
+	f, err := c.node(i)
+	if err != nil {
+		return err
+	}
+	if c.Sim == nil {
+		return f.Stop()
+	}
+	c.Sim.Do(func() { err = c.Sim.StopNode(i) })
+	return err
+}
+
+// RestartNode starts stopped node i again on its database.  It catches up on
+// the blocks it missed from its peers.
+func (c *SimController) RestartNode(i int) error {
+	f, err := c.node(i)
+	if err != nil {
+		return err
+	}
+	if c.Sim == nil {
+		err = f.Restart()
+	} else {
+		c.Sim.Do(func() { err = c.Sim.RestartNode(i) })
+	}
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if i == c.wsapi && wsapi.Servers != nil {
+		wsapi.SetState(f.State)
+	}
+	return nil
+}
+
+// MakeLeader asks the network to make node i a leader.
+func (c *SimController) MakeLeader(i int) error {
+	f, err := c.node(i)
+	if err != nil {
+		return err
+	}
+	c.do(func() { f.State.InMsgQueue() <- messages.NewAddServerMsg(f.State, 0) })
+	return nil
+}
+
+// Block returns the admin, factoid or directory block at height, as node i
+// has it.
+func (c *SimController) Block(i int, kind string, height uint32) (interfaces.Printable, error) {
+	f, err := c.node(i)
+	if err != nil {
+		return nil, err
+	}
+	var msg interfaces.IMsg
+	c.do(func() { msg, err = f.State.LoadDBState(height) })
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("%s has no block at height %d", f.State.FactomNodeName, height)
+	}
+	dbstate := msg.(*messages.DBStateMsg)
+	switch kind {
+	case "admin":
+		return dbstate.AdminBlock, nil
+	case "factoid":
+		return dbstate.FactoidBlock, nil
+	case "directory":
+		return dbstate.DirectoryBlock, nil
+	}
+	return nil, fmt.Errorf("Unknown kind of block %q; expected admin, factoid or directory", kind)
+}
+
+// ProcessList returns the process list node i is building, and its
+// directory block states.
+func (c *SimController) ProcessList(i int) (status *ProcessListStatus, err error) {
+	f, err := c.node(i)
+	if err != nil {
+		return nil, err
+	}
+	c.do(func() { status, err = processList(i, f.State) })
+	return
+}
+
+func processList(i int, s *state.State) (*ProcessListStatus, error) {
+	pl := s.ProcessLists.Get(s.GetHighestRecordedBlock())
+	if pl == nil {
+		return nil, fmt.Errorf("%s has no process list at height %d", s.FactomNodeName, s.GetHighestRecordedBlock())
+	}
+
+	status := &ProcessListStatus{
+		Node:           i,
+		Name:           s.FactomNodeName,
+		DBHeight:       pl.DBHeight,
+		MinuteComplete: pl.MinuteComplete(),
+		MinuteFinished: pl.MinuteFinished(),
+		FedServers:     serverIDs(pl.FedServers),
+		AuditServers:   serverIDs(pl.AuditServers),
+	}
+	for j, vm := range pl.VMs {
+		if j >= len(pl.FedServers) {
+			break
+		}
+		status.VMs = append(status.VMs, VMStatus{
+			Height:         vm.Height,
+			ListLength:     len(vm.List),
+			LeaderMinute:   vm.LeaderMinute,
+			Seal:           vm.Seal,
+			MinuteComplete: vm.MinuteComplete,
+			MinuteFinished: vm.MinuteFinished,
+			MinuteHeight:   vm.MinuteHeight,
+		})
+	}
+	for j, dbstate := range s.DBStates.DBStates {
+		if dbstate == nil || dbstate.DirectoryBlock == nil {
+			continue
+		}
+		status.DBStates = append(status.DBStates, DBStateStatus{
+			Height: s.DBStates.Base + uint32(j),
+			KeyMR:  dbstate.DirectoryBlock.GetKeyMR().String(),
+			Saved:  dbstate.Saved,
+		})
+	}
+	return status, nil
+}
+
+func serverIDs(servers []interfaces.IFctServer) []string {
+	ids := []string{}
+	for _, server := range servers {
+		ids = append(ids, server.GetChainID().String())
+	}
+	return ids
+}
+
+// Trace is true if consensus is traced.
+func (c *SimController) Trace() (on bool) {
+	c.do(func() { on = c.Nodes[0].State.DebugConsensus })
+	return
+}
+
+// SetTrace turns the trace of consensus on every node on or off.
+func (c *SimController) SetTrace(on bool) {
+	c.do(func() {
+		for _, f := range c.Nodes {
+			f.State.DebugConsensus = on
+		}
+	})
+}
+
+// PointWSAPI has the WSAPI answer calls from node i.
+func (c *SimController) PointWSAPI(i int) error {
+	f, err := c.node(i)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.wsapi = i
+	wsapi.SetState(f.State)
+	return nil
+}
+
+// Step has a journal replayed a message at a time replay the next message, or
+// stop if stop is true.
+func (c *SimController) Step(stop bool) error {
+	if c.ReplayStep == nil {
+		return fmt.Errorf("No journal is being replayed step by step")
+	}
+	select {
+	case c.ReplayStep <- !stop:
+		return nil
+	default:
+		return fmt.Errorf("The last step has not been taken yet")
+	}
+}
+
+// DivergenceStatus is where the nodes diverged: the first height the
+// simulator found nodes' blocks differ, with a diff of them, and the first
+// height each node found a peer's digest differ from its own.
+type DivergenceStatus struct {
+	Divergence *state.Divergence            `json:"divergence"`
+	Report     string                       `json:"report,omitempty"`
+	Peers      map[string]*state.Divergence `json:"peers,omitempty"`
+}
+
+// CheckDivergence checks the nodes for a divergence, and returns what was
+// found.
+func (c *SimController) CheckDivergence() *DivergenceStatus {
+	status := new(DivergenceStatus)
+	c.do(func() { c.checkDivergence(status) })
+	return status
+}
+
+func (c *SimController) checkDivergence(status *DivergenceStatus) {
+	if c.Divergence != nil {
+		c.Divergence.Check()
+		status.Divergence, status.Report = c.Divergence.Divergence()
+	}
+	for _, fnode := range c.Nodes {
+		if d := fnode.State.Divergence; d != nil {
+			if status.Peers == nil {
+				status.Peers = make(map[string]*state.Divergence)
+			}
+			status.Peers[fnode.State.FactomNodeName] = d
+		}
+	}
+}
+
+// Digest returns the digest of the blocks node i saved at height.
+func (c *SimController) Digest(i int, height uint32) (*state.BlockDigest, error) {
+	f, err := c.node(i)
+	if err != nil {
+		return nil, err
+	}
+	var d *state.BlockDigest
+	c.do(func() { d = f.State.GetDigest(height) })
+	if d == nil {
+		return nil, fmt.Errorf("%s has not saved height %d", f.State.FactomNodeName, height)
+	}
+	return d, nil
+}
+
+// DivergenceBlocks returns the directory, admin, factoid and entry credit
+// blocks node i saved at height, and its process list, as the text a
+// DivergenceReport diffs.
+func (c *SimController) DivergenceBlocks(i int, height uint32) ([]string, error) {
+	f, err := c.node(i)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []string
+	c.do(func() { blocks = divergenceBlocks(f.State, height) })
+	return blocks, nil
+}
+
+// LoadReport says how the load put on the nodes has gone so far.
+func (c *SimController) LoadReport() (*LoadReport, error) {
+	if c.Load == nil {
+		return nil, fmt.Errorf("No load is being put on the nodes; run with -load")
+	}
+	return c.Load.Report(), nil
+}
+
+//**********************************************************************
+// The HTTP API
+//**********************************************************************
+
+// Serve answers the control API on address, e.g. "localhost:8099".  It only
+// returns if the listener fails.
+func (c *SimController) Serve(address string) error {
+	return http.ListenAndServe(address, c.Handler())
+}
+
+// Handler returns the http.Handler for the control API.
+func (c *SimController) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/nodes", c.get(func(r *http.Request) (interface{}, error) {
+		return c.Status(), nil
+	}))
+	mux.HandleFunc("/v1/focus", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			i, err := c.nodeParam(r)
+			if err == nil {
+				err = c.SetFocus(i)
+			}
+			if err != nil {
+				writeControlError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		writeControlJSON(w, map[string]int{"node": c.Focus()})
+	})
+	mux.HandleFunc("/v1/next", c.post(func(r *http.Request) (interface{}, error) {
+		return map[string]int{"node": c.Next()}, nil
+	}))
+	mux.HandleFunc("/v1/offline", c.post(func(r *http.Request) (interface{}, error) {
+		i, err := c.nodeParam(r)
+		if err != nil {
+			return nil, err
+		}
+		off, err := c.Offline(i)
+		if err != nil {
+			return nil, err
+		}
+		off = !off
+		if v := r.FormValue("off"); v != "" {
+			if off, err = strconv.ParseBool(v); err != nil {
+				return nil, err
+			}
+		}
+		c.SetOffline(i, off)
+		return map[string]interface{}{"node": i, "offline": off}, nil
+	}))
+	mux.HandleFunc("/v1/leader", c.post(func(r *http.Request) (interface{}, error) {
+		i, err := c.nodeParam(r)
+		if err == nil {
+			err = c.MakeLeader(i)
+		}
+		return map[string]int{"node": i}, err
+	}))
+	mux.HandleFunc("/v1/stop", c.post(func(r *http.Request) (interface{}, error) {
+		i, err := c.nodeParam(r)
+		if err == nil {
+			err = c.StopNode(i)
+		}
+		return map[string]int{"node": i}, err
+	}))
+	mux.HandleFunc("/v1/restart", c.post(func(r *http.Request) (interface{}, error) {
+		i, err := c.nodeParam(r)
+		if err == nil {
+			err = c.RestartNode(i)
+		}
+		return map[string]int{"node": i}, err
+	}))
+	mux.HandleFunc("/v1/block/", c.get(func(r *http.Request) (interface{}, error) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/block/"), "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Expected /v1/block/KIND/HEIGHT")
+		}
+		height, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		i, err := c.nodeParam(r)
+		if err != nil {
+			return nil, err
+		}
+		block, err := c.Block(i, parts[0], uint32(height))
+		if err != nil {
+			return nil, err
+		}
+		data, err := block.JSONByte()
+		return json.RawMessage(data), err
+	}))
+	mux.HandleFunc("/v1/processlist", c.get(func(r *http.Request) (interface{}, error) {
+		i, err := c.nodeParam(r)
+		if err != nil {
+			return nil, err
+		}
+		return c.ProcessList(i)
+	}))
+	mux.HandleFunc("/v1/trace", c.post(func(r *http.Request) (interface{}, error) {
+		on := !c.Trace()
+		if v := r.FormValue("on"); v != "" {
+			var err error
+			if on, err = strconv.ParseBool(v); err != nil {
+				return nil, err
+			}
+		}
+		c.SetTrace(on)
+		return map[string]bool{"on": on}, nil
+	}))
+	mux.HandleFunc("/v1/wsapi", c.post(func(r *http.Request) (interface{}, error) {
+		i, err := c.nodeParam(r)
+		if err == nil {
+			err = c.PointWSAPI(i)
+		}
+		return map[string]int{"node": i}, err
+	}))
+	mux.HandleFunc("/v1/step", c.post(func(r *http.Request) (interface{}, error) {
+		stop := false
+		if v := r.FormValue("stop"); v != "" {
+			var err error
+			if stop, err = strconv.ParseBool(v); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]bool{"stop": stop}, c.Step(stop)
+	}))
+	mux.HandleFunc("/v1/divergence", c.get(func(r *http.Request) (interface{}, error) {
+		return c.CheckDivergence(), nil
+	}))
+	mux.HandleFunc("/v1/digest/", c.get(func(r *http.Request) (interface{}, error) {
+		i, height, err := c.heightParams(r, "/v1/digest/")
+		if err != nil {
+			return nil, err
+		}
+		return c.Digest(i, height)
+	}))
+	mux.HandleFunc("/v1/blocks/", c.get(func(r *http.Request) (interface{}, error) {
+		i, height, err := c.heightParams(r, "/v1/blocks/")
+		if err != nil {
+			return nil, err
+		}
+		return c.DivergenceBlocks(i, height)
+	}))
+	mux.HandleFunc("/v1/load", c.get(func(r *http.Request) (interface{}, error) {
+		return c.LoadReport()
+	}))
+	return mux
+}
+
+// nodeParam returns the node a request is for: its node parameter, or the
+// focus.
+func (c *SimController) nodeParam(r *http.Request) (int, error) {
+	v := r.FormValue("node")
+	if v == "" {
+		return c.Focus(), nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("node: %s", err.Error())
+	}
+	_, err = c.node(i)
+	return i, err
+}
+
+// heightParams returns the node a request is for, and the height at the end
+// of its path, after prefix.
+func (c *SimController) heightParams(r *http.Request, prefix string) (int, uint32, error) {
+	height, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, prefix), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Expected %sHEIGHT", prefix)
+	}
+	i, err := c.nodeParam(r)
+	return i, uint32(height), err
+}
+
+func (c *SimController) get(answer func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return handleControl("GET", answer)
+}
+
+func (c *SimController) post(answer func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return handleControl("POST", answer)
+}
+
+// handleControl answers requests of method with what answer returns, as JSON.
+func handleControl(method string, answer func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeControlError(w, http.StatusMethodNotAllowed, fmt.Errorf("Use %s", method))
+			return
+		}
+		v, err := answer(r)
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeControlJSON(w, v)
+	}
+}
+
+func writeControlJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeControlError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,161 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/FactomProject/factomd/engine"
+)
+
+func call(t *testing.T, handler http.Handler, method, url string, answer interface{}) int {
+	r := httptest.NewRequest(method, url, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if answer != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), answer); err != nil {
+			t.Errorf("%s %s: %s in %s", method, url, err.Error(), w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func TestControlAPI(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	sim := newSimulation(42, 2)
+	sim.Run(30 * time.Second)
+	handler := NewSimController(sim.Nodes, 0).Handler()
+
+	var nodes []NodeStatus
+	call(t, handler, "GET", "/v1/nodes", &nodes)
+	if len(nodes) != 2 || nodes[1].Name != sim.Nodes[1].State.FactomNodeName || !nodes[0].Leader {
+		t.Errorf("Unexpected status %+v", nodes)
+	}
+
+	var focus map[string]int
+	call(t, handler, "POST", "/v1/focus?node=1", &focus)
+	if focus["node"] != 1 {
+		t.Errorf("Expected focus on node 1, got %v", focus)
+	}
+
+	var offline map[string]interface{}
+	call(t, handler, "POST", "/v1/offline", &offline)
+	if offline["offline"] != true || !sim.Nodes[1].State.GetNetStateOff() {
+		t.Errorf("The focused node should be offline, got %v", offline)
+	}
+	call(t, handler, "POST", "/v1/offline?off=false", &offline)
+	if offline["offline"] != false || sim.Nodes[1].State.GetNetStateOff() {
+		t.Errorf("The focused node should be back online, got %v", offline)
+	}
+
+	var block map[string]interface{}
+	if code := call(t, handler, "GET", "/v1/block/directory/0?node=0", &block); code != http.StatusOK {
+		t.Errorf("Expected the directory block, got %d %v", code, block)
+	}
+
+	var pl ProcessListStatus
+	call(t, handler, "GET", "/v1/processlist?node=0", &pl)
+	if len(pl.VMs) == 0 || len(pl.FedServers) == 0 || len(pl.DBStates) == 0 || pl.DBStates[0].KeyMR == "" {
+		t.Errorf("Unexpected process list %+v", pl)
+	}
+
+	var trace map[string]bool
+	call(t, handler, "POST", "/v1/trace?on=true", &trace)
+	if !trace["on"] || !sim.Nodes[1].State.DebugConsensus {
+		t.Errorf("Consensus should be traced on every node")
+	}
+	call(t, handler, "POST", "/v1/trace", &trace)
+	if trace["on"] || sim.Nodes[0].State.DebugConsensus {
+		t.Errorf("Trace should toggle off")
+	}
+
+	var failed map[string]string
+	for _, bad := range []struct{ method, url string }{
+		{"POST", "/v1/focus?node=9"},
+		{"GET", "/v1/block/receipt/0"},
+		{"GET", "/v1/block/directory/x"},
+		{"GET", "/v1/processlist?node=-1"},
+	} {
+		if code := call(t, handler, bad.method, bad.url, &failed); code != http.StatusBadRequest || failed["error"] == "" {
+			t.Errorf("%s %s: expected an error, got %d %v", bad.method, bad.url, code, failed)
+		}
+	}
+	if code := call(t, handler, "GET", "/v1/leader", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("Commands that change the simulation must be posted, got %d", code)
+	}
+}
+
+// TestControlRunningSimulation drives a simulation while it runs, as -seed
+// does, so go test -race finds any command that touches a node off the
+// simulation's goroutine.
+func TestControlRunningSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	sim := newSimulation(42, 2)
+	control := NewSimController(sim.Nodes, 0)
+	control.Sim = sim
+	handler := control.Handler()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sim.RunUntil(time.Hour, func() bool {
+			select {
+			case <-stop:
+				return true
+			default:
+				return false
+			}
+		})
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// Polled while the nodes make their first blocks.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		var nodes []NodeStatus
+		call(t, handler, "GET", "/v1/nodes", &nodes)
+		if len(nodes) == 2 && 2 <= nodes[1].RecordedHeight {
+			break
+		}
+		if time.Since(start) > time.Minute {
+			t.Fatalf("No blocks made, at %+v", nodes)
+		}
+	}
+	var offline map[string]interface{}
+	call(t, handler, "POST", "/v1/offline?node=1", &offline)
+	if offline["offline"] != true {
+		t.Errorf("Node 1 should be offline, got %v", offline)
+	}
+	call(t, handler, "POST", "/v1/offline?node=1", &offline)
+	if offline["offline"] != false {
+		t.Errorf("Node 1 should be back online, got %v", offline)
+	}
+	var nodes []NodeStatus
+	call(t, handler, "GET", "/v1/nodes", &nodes)
+	if len(nodes) != 2 || nodes[1].Offline {
+		t.Errorf("Unexpected status %+v", nodes)
+	}
+	var trace map[string]bool
+	call(t, handler, "POST", "/v1/trace?on=true", &trace)
+	call(t, handler, "POST", "/v1/trace?on=false", &trace)
+	var pl ProcessListStatus
+	call(t, handler, "GET", "/v1/processlist?node=1", &pl)
+	var block map[string]interface{}
+	call(t, handler, "GET", "/v1/block/directory/0?node=1", &block)
+	var leader map[string]int
+	call(t, handler, "POST", "/v1/leader?node=1", &leader)
+	call(t, handler, "POST", "/v1/next", &leader)
+}
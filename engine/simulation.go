@@ -0,0 +1,415 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/state"
+)
+
+// A Simulation runs a set of FactomNodes so a run can be repeated exactly.
+// Every node is driven from one goroutine, in order, rather than from the
+// goroutines of NetworkProcessorNet, Timer and ValidatorLoop.  All the nodes
+// share a virtual clock (interfaces.Now) that only moves when they have nothing
+// left to do, minutes are ticked on that clock, and messages between SimPeers
+// are delivered by a scheduler seeded from Seed.  So the same seed and the same
+// nodes give the same blocks, however fast or loaded the machine is.
+//
+// Only one Simulation may run in a process at a time, as the clock is global.
+
+const (
+	SimStartTime  int64         = 1483228800            // Unix time the virtual clock starts at
+	SimStep       time.Duration = 10 * time.Millisecond // Longest the clock moves in one go
+	SimMaxRounds                = 100                   // Rounds over the nodes before the clock must move on
+	SimTimerDelay time.Duration = 2 * time.Second       // As Timer, before the first minute
+)
+
+type simEvent struct {
+	at    time.Time
+	seq   uint64 // Events at the same time happen in the order they were scheduled
+	event func()
+}
+
+type simEvents []*simEvent
+
+func (e simEvents) Len() int { return len(e) }
+func (e simEvents) Less(i, j int) bool {
+	if e[i].at.Equal(e[j].at) {
+		return e[i].seq < e[j].seq
+	}
+	return e[i].at.Before(e[j].at)
+}
+func (e simEvents) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *simEvents) Push(x interface{}) { *e = append(*e, x.(*simEvent)) }
+func (e *simEvents) Pop() interface{} {
+	old := *e
+	event := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return event
+}
+
+// simTimer ticks the minutes of one node, as Timer does on the wall clock.
+type simTimer struct {
+	minute int
+	next   time.Time
+	timer  *state.Timer
+}
+
+type Simulation struct {
+	Seed    int64
+	Nodes   []*FactomNode
+	Latency time.Duration // Every message between SimPeers takes at least this long
+	Jitter  time.Duration // Plus a random part up to this long
+
+	Scenario   *Scenario           // Faults on the links between the nodes, if not nil
+	Divergence *DivergenceDetector // Checks the nodes agree each time the clock moves, if not nil
+	Load       *LoadGenerator      // Stepped each time the clock moves, if not nil
+
+	now    time.Time
+	rng    *rand.Rand
+	events simEvents
+	seq    uint64
+	timers []*simTimer
+	links  map[*SimPeer]time.Time // When the last message sent on each link arrives
+	ends   map[*SimPeer][2]int    // The nodes at each end of a link
+	do     chan func()            // Run between moves of the clock; see Do
+}
+
+// MakeSimNodes makes count nodes for a Simulation: one on s, which must have
+// its configuration loaded, and clones of s for the rest, as NetStart does.
+// They are not connected; see AddSimPeer.
+func MakeSimNodes(s *state.State, count int) []*FactomNode {
+	if s.CloneDBType == "" {
+		s.CloneDBType = s.DBType
+	}
+	mlog := new(MsgLog)
+	mlog.init(false, count)
+	var nodes []*FactomNode
+	for i := 0; i < count; i++ {
+		fnode := new(FactomNode)
+		fnode.State = s
+		if i > 0 {
+			fnode.State = s.Clone(fmt.Sprintf("%d", i)).(*state.State)
+		}
+		fnode.State.Init()
+		fnode.MLog = mlog
+		fnode.Gossip = NewGossip()
+		fnode.Requests = NewRequests()
+		nodes = append(nodes, fnode)
+	}
+	return nodes
+}
+
+// NewSimulation makes a simulation of nodes, and sets the clock to the start
+// of the simulation.  Each node gets its own source of randomness, seeded
+// from seed, so goroutines outside the simulation drawing on math/rand don't
+// change the run.
+func NewSimulation(seed int64, nodes []*FactomNode) *Simulation {
+	sim := new(Simulation)
+	sim.Seed = seed
+	sim.Nodes = nodes
+	sim.Latency = 20 * time.Millisecond
+	sim.Jitter = 30 * time.Millisecond
+	sim.now = time.Unix(SimStartTime, 0)
+	sim.rng = rand.New(rand.NewSource(seed))
+	sim.links = make(map[*SimPeer]time.Time)
+	sim.ends = make(map[*SimPeer][2]int)
+	sim.do = make(chan func())
+	for i, fnode := range nodes {
+		fnode.Rand = rand.New(rand.NewSource(seed + int64(i)))
+	}
+	interfaces.Now = sim.Now
+	return sim
+}
+
+// Now is the time on the virtual clock.
+func (sim *Simulation) Now() time.Time {
+	return sim.now
+}
+
+// Rand is the simulation's own source of randomness, seeded from Seed.
+func (sim *Simulation) Rand() *rand.Rand {
+	return sim.rng
+}
+
+// Start loads the nodes' databases if load is true, and takes over the
+// delivery of messages between their SimPeers.  Call it once the nodes are
+// connected.
+func (sim *Simulation) Start(load bool) {
+	index := make(map[string]int)
+	for i, fnode := range sim.Nodes {
+		index[fnode.State.FactomNodeName] = i
+	}
+	for i, fnode := range sim.Nodes {
+		if load {
+			state.LoadDatabase(fnode.State)
+		}
+		for _, peer := range fnode.Peers {
+			if simPeer, ok := peer.(*SimPeer); ok {
+				simPeer.sim = sim
+				sim.ends[simPeer] = [2]int{i, index[simPeer.ToName]}
+			}
+		}
+		sim.timers = append(sim.timers, sim.newTimer())
+	}
+}
+
+// newTimer is the timer of a node that starts now, whose first minute is
+// ticked after SimTimerDelay.
+func (sim *Simulation) newTimer() *simTimer {
+	tenth := sim.tenth()
+	return &simTimer{next: sim.now.Add(SimTimerDelay).Truncate(tenth).Add(tenth), timer: new(state.Timer)}
+}
+
+// tenth is a tenth of a minute of a block, as the first node has it.
+func (sim *Simulation) tenth() time.Duration {
+	return time.Duration(sim.Nodes[0].State.GetDirectoryBlockInSeconds()) * time.Second / 10
+}
+
+// Schedule has event happen when the virtual clock reaches at.
+func (sim *Simulation) Schedule(at time.Time, event func()) {
+	sim.seq++
+	heap.Push(&sim.events, &simEvent{at: at, seq: sim.seq, event: event})
+}
+
+// deliver schedules data sent on peer to arrive after the link's latency.
+// Messages on a link arrive in the order they were sent, unless the Scenario
+// has them reordered.  The Scenario may also drop, duplicate or corrupt them.
+func (sim *Simulation) deliver(peer *SimPeer, data []byte) {
+	to := sim.Nodes[sim.ends[peer][1]]
+	if to.Stopped() {
+		return
+	}
+	latency, jitter := sim.Latency, sim.Jitter
+	var link *SimLink
+	if sim.Scenario != nil {
+		ends := sim.ends[peer]
+		s := sim.Nodes[ends[0]].State
+		if sim.Scenario.cut(ends[0], ends[1], SimPoint{s.LLeaderHeight, s.LeaderMinute}) {
+			return
+		}
+		if link = sim.Scenario.link(ends[0], ends[1]); link != nil {
+			if sim.rng.Float64() < link.Drop {
+				return
+			}
+			if 0 < len(data) && sim.rng.Float64() < link.Corrupt {
+				data = append([]byte{}, data...)
+				data[sim.rng.Intn(len(data))] ^= byte(1 + sim.rng.Intn(255))
+			}
+		}
+	}
+
+	copies := 1
+	if link != nil && sim.rng.Float64() < link.Duplicate {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		var at time.Time
+		if link != nil {
+			at = sim.now.Add(link.delay(sim))
+		} else {
+			at = sim.now.Add(latency)
+			if 0 < jitter {
+				at = at.Add(time.Duration(sim.rng.Int63n(int64(jitter))))
+			}
+		}
+		if link == nil || link.Reorder <= sim.rng.Float64() {
+			if last := sim.links[peer]; at.Before(last) {
+				at = last
+			}
+			sim.links[peer] = at
+		}
+		sim.Schedule(at, func() {
+			if !to.Stopped() && len(peer.BroadcastOut) < 9000 {
+				peer.BroadcastOut <- data
+			}
+		})
+	}
+}
+
+// step does what the goroutines of one node would do, without waiting, and
+// returns true if anything was done.
+func (sim *Simulation) step(i int) bool {
+	fnode := sim.Nodes[i]
+	progress := 0 < receive(fnode)
+	if fnode.State.ValidatorStep(sim.timers[i].timer) {
+		progress = true
+	}
+	for done := false; !done; {
+		select {
+		case msg := <-fnode.State.NetworkOutMsgQueue():
+			send(fnode, msg)
+			progress = true
+		case msg := <-fnode.State.NetworkInvalidMsgQueue():
+			invalid(fnode, msg)
+			progress = true
+		default:
+			done = true
+		}
+	}
+	fnode.Requests.Expire(fnode, sim.now)
+	return progress
+}
+
+// settle steps every node in turn until none has anything left to do, or
+// SimMaxRounds have gone by.
+func (sim *Simulation) settle() {
+	for round := 0; round < SimMaxRounds; round++ {
+		progress := false
+		for i, fnode := range sim.Nodes {
+			if !fnode.Stopped() && sim.step(i) {
+				progress = true
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+// tick sends each node its next minute if it is due, and the node is ready
+// for it.  Like Timer, it holds the minute back while the node is busy
+// with the last one.
+func (sim *Simulation) tick() {
+	tenth := sim.tenth()
+	for i, t := range sim.timers {
+		s := sim.Nodes[i].State
+		if sim.Nodes[i].Stopped() || sim.now.Before(t.next) || 0 < len(s.LeaderMsgQueue()) || 5000 < len(s.InMsgQueue()) || 0 < s.GetEOM() {
+			continue
+		}
+		s.TickerQueue() <- t.minute
+		t.minute = (t.minute + 1) % 10
+		for !sim.now.Before(t.next) {
+			t.next = t.next.Add(tenth)
+		}
+	}
+}
+
+// advance moves the clock to the next event or minute, but no more than
+// SimStep, and no further than limit.
+func (sim *Simulation) advance(limit time.Time) {
+	next := sim.now.Add(SimStep)
+	if 0 < len(sim.events) && sim.events[0].at.Before(next) {
+		next = sim.events[0].at
+	}
+	for i, t := range sim.timers {
+		if !sim.Nodes[i].Stopped() && sim.now.Before(t.next) && t.next.Before(next) {
+			next = t.next
+		}
+	}
+	if limit.Before(next) {
+		next = limit
+	}
+	sim.now = next
+	for 0 < len(sim.events) && !sim.now.Before(sim.events[0].at) {
+		heap.Pop(&sim.events).(*simEvent).event()
+	}
+}
+
+// Run runs the simulation for d on the virtual clock.
+func (sim *Simulation) Run(d time.Duration) {
+	sim.RunUntil(d, nil)
+}
+
+// RunUntil runs the simulation until done returns true, checking after each
+// move of the clock, or until d has gone by on the virtual clock.  It returns
+// true if done.
+func (sim *Simulation) RunUntil(d time.Duration, done func() bool) bool {
+	limit := sim.now.Add(d)
+	for {
+		for done := false; !done; {
+			select {
+			case f := <-sim.do:
+				f()
+			default:
+				done = true
+			}
+		}
+		sim.tick()
+		sim.settle()
+		if sim.Divergence != nil {
+			sim.Divergence.Check()
+		}
+		if sim.Load != nil {
+			sim.Load.Step()
+		}
+		if done != nil && done() {
+			return true
+		}
+		if !sim.now.Before(limit) {
+			return false
+		}
+		sim.advance(limit)
+	}
+}
+
+// RunToHeight runs the simulation until every node has saved the directory
+// block at height, or d has gone by on the virtual clock.  It returns true
+// if every node got there.
+func (sim *Simulation) RunToHeight(height uint32, d time.Duration) bool {
+	return sim.RunUntil(d, func() bool {
+		for _, fnode := range sim.Nodes {
+			if fnode.State.GetHighestRecordedBlock() < height {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// MakeLeader asks the network to make node i a leader, as the simulator's
+// "l" command does.
+func (sim *Simulation) MakeLeader(i int) {
+	s := sim.Nodes[i].State
+	s.InMsgQueue() <- messages.NewAddServerMsg(s, 0)
+}
+
+// StopNode stops node i, as FactomNode.Stop does: it is no longer stepped, its
+// minutes are not ticked, its database is closed, and what is sent to it is
+// lost until it is restarted.
+func (sim *Simulation) StopNode(i int) error {
+	fnode := sim.Nodes[i]
+	if fnode.Stopped() {
+		return fmt.Errorf("%s is already stopped", fnode.State.FactomNodeName)
+	}
+	s := fnode.State
+	s.DBMutex.Lock()
+	s.DB.Close()
+	s.DBMutex.Unlock()
+	fnode.stopped = true
+	return nil
+}
+
+// RestartNode starts stopped node i again on its database, as
+// FactomNode.Restart does.  Its minutes are ticked as they were when the
+// simulation started, after SimTimerDelay.
+func (sim *Simulation) RestartNode(i int) error {
+	fnode := sim.Nodes[i]
+	if !fnode.Stopped() {
+		return fmt.Errorf("%s is not stopped", fnode.State.FactomNodeName)
+	}
+	fnode.reset()
+	state.LoadDatabase(fnode.State)
+	sim.timers[i] = sim.newTimer()
+	return nil
+}
+
+// Do runs f on the goroutine running the simulation, between moves of the
+// clock, and returns once it has.  It is how another goroutine, such as a
+// SimController, changes the nodes of a running simulation.
+func (sim *Simulation) Do(f func()) {
+	done := make(chan struct{})
+	sim.do <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
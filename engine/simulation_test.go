@@ -0,0 +1,153 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/FactomProject/factomd/common/messages"
+	. "github.com/FactomProject/factomd/engine"
+	"github.com/FactomProject/factomd/state"
+)
+
+// newSimulation makes a simulation of count nodes, every one connected to
+// every other, with node 0 the only leader.
+func newSimulation(seed int64, count int) *Simulation {
+	s := new(state.State)
+	s.LoadConfig("", "")
+	nodes := MakeSimNodes(s, count)
+	for i := range nodes {
+		for j := i + 1; j < count; j++ {
+			AddSimPeer(nodes, i, j)
+		}
+	}
+	sim := NewSimulation(seed, nodes)
+	sim.Start(true)
+	return sim
+}
+
+// makeLeaders promotes every node of sim to a leader, one at a time, and
+// reports if all of them became federated servers within d.
+func makeLeaders(sim *Simulation, d time.Duration) bool {
+	for i := 1; i < len(sim.Nodes); i++ {
+		sim.MakeLeader(i)
+		feds := i + 1
+		if !sim.RunUntil(d, func() bool {
+			for _, fnode := range sim.Nodes {
+				if len(fnode.State.LeaderPL.FedServers) < feds {
+					return false
+				}
+			}
+			return true
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// savedKeyMR is the KeyMR of the directory block fnode saved at height h, as
+// read back from its database rather than as the node has it in memory.
+func savedKeyMR(fnode *FactomNode, h uint32) string {
+	saved, err := fnode.State.LoadDBState(h)
+	if err != nil || saved == nil {
+		return fmt.Sprintf("none (%v)", err)
+	}
+	return saved.(*messages.DBStateMsg).DirectoryBlock.GetKeyMR().String()
+}
+
+// result is what a run of a simulation came to: the KeyMR of every
+// directory block each node saved, and the number of messages logged.
+func result(sim *Simulation) []string {
+	var answer []string
+	for _, fnode := range sim.Nodes {
+		for h := uint32(0); h <= fnode.State.GetHighestRecordedBlock(); h++ {
+			answer = append(answer, fmt.Sprintf("%s %d %s", fnode.State.FactomNodeName, h, savedKeyMR(fnode, h)))
+		}
+	}
+	return append(answer, fmt.Sprintf("%d messages", len(sim.Nodes[0].MLog.MsgList)))
+}
+
+func TestSimulationRepeats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs two simulations")
+	}
+	const leaders, height = 5, 20
+	var runs [][]string
+	for run := 0; run < 2; run++ {
+		sim := newSimulation(42, leaders)
+		if !makeLeaders(sim, time.Minute) {
+			t.Fatalf("Not all %d nodes became leaders", leaders)
+		}
+		if !sim.RunToHeight(height, 5*time.Minute) {
+			t.Fatalf("Stopped at height %d, short of %d", sim.Nodes[0].State.GetHighestRecordedBlock(), height)
+		}
+		for h := uint32(0); h <= height; h++ {
+			want := savedKeyMR(sim.Nodes[0], h)
+			for _, fnode := range sim.Nodes[1:] {
+				if got := savedKeyMR(fnode, h); got != want {
+					t.Errorf("%s saved block %d as %s, %s as %s", fnode.State.FactomNodeName, h, got, sim.Nodes[0].State.FactomNodeName, want)
+				}
+			}
+		}
+		runs = append(runs, result(sim))
+	}
+	if len(runs[0]) != len(runs[1]) {
+		t.Fatalf("Runs with the same seed differ: %v and %v", runs[0], runs[1])
+	}
+	for i := range runs[0] {
+		if runs[0][i] != runs[1][i] {
+			t.Errorf("Runs with the same seed differ: %s and %s", runs[0][i], runs[1][i])
+		}
+	}
+}
+
+func TestSimulationRestartNode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Runs a simulation")
+	}
+	sim := newSimulation(42, 2)
+	sim.Run(time.Minute)
+	height := sim.Nodes[1].State.GetHighestRecordedBlock()
+	// As saved, rather than as the node has it in memory.
+	saved, err := sim.Nodes[1].State.LoadDBState(0)
+	if err != nil || saved == nil {
+		t.Fatalf("No genesis block saved: %v", err)
+	}
+	genesis := saved.(*messages.DBStateMsg).DirectoryBlock.GetKeyMR().String()
+
+	if err := sim.StopNode(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.StopNode(1); err == nil {
+		t.Errorf("Stopped a stopped node")
+	}
+	sim.Run(time.Minute)
+	if !sim.Nodes[1].Stopped() || sim.Nodes[1].State.GetHighestRecordedBlock() != height {
+		t.Errorf("The stopped node moved on from height %d to %d", height, sim.Nodes[1].State.GetHighestRecordedBlock())
+	}
+
+	// Restarted, the node loads the blocks it saved, and goes on from there.
+	old := sim.Nodes[1].State
+	if err := sim.RestartNode(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.RestartNode(1); err == nil {
+		t.Errorf("Restarted a running node")
+	}
+	s := sim.Nodes[1].State
+	if s == old || s.FactomNodeName != old.FactomNodeName || s.IdentityChainID.String() != old.IdentityChainID.String() {
+		t.Errorf("The restarted node is not a fresh state of %s", old.FactomNodeName)
+	}
+	sim.Run(time.Minute)
+	if sim.Nodes[1].Stopped() || s.GetHighestRecordedBlock() < height {
+		t.Errorf("The restarted node is at height %d, below the %d it saved", s.GetHighestRecordedBlock(), height)
+	}
+	if keyMR := s.GetDirectoryBlockByHeight(0).GetKeyMR().String(); keyMR != genesis {
+		t.Errorf("The restarted node has genesis block %s, not %s", keyMR, genesis)
+	}
+}
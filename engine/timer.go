@@ -15,8 +15,15 @@ import (
 var _ = (*s.State)(nil)
 
 func Timer(state interfaces.IState) {
+	timer(state, nil)
+}
+
+// timer is Timer, until quit is closed.
+func timer(state interfaces.IState, quit chan struct{}) {
 
-	time.Sleep(2 * time.Second)
+	if !pause(2*time.Second, quit) {
+		return
+	}
 
 	billion := int64(1000000000)
 	period := int64(state.GetDirectoryBlockInSeconds()) * billion
@@ -32,7 +39,9 @@ func Timer(state interfaces.IState) {
 		state.Print(fmt.Sprintf("Time: %v\r\n", time.Now()))
 	}
 
-	time.Sleep(time.Duration(wait))
+	if !pause(time.Duration(wait), quit) {
+		return
+	}
 
 	for {
 
@@ -40,7 +49,9 @@ func Timer(state interfaces.IState) {
 			// Don't stuff messages into the system if the
 			// Leader is behind.
 			for len(state.LeaderMsgQueue()) > 0 {
-				time.Sleep(time.Millisecond * 10)
+				if !pause(time.Millisecond*10, quit) {
+					return
+				}
 			}
 
 			now = time.Now().UnixNano()
@@ -54,9 +65,13 @@ func Timer(state interfaces.IState) {
 				wait = next - now
 				next += tenthPeriod
 			}
-			time.Sleep(time.Duration(wait))
+			if !pause(time.Duration(wait), quit) {
+				return
+			}
 			for len(state.InMsgQueue()) > 5000 || state.GetEOM() > 0 {
-				time.Sleep(100 * time.Millisecond)
+				if !pause(100*time.Millisecond, quit) {
+					return
+				}
 			}
 
 			state.TickerQueue() <- i
@@ -65,6 +80,16 @@ func Timer(state interfaces.IState) {
 	}
 }
 
+// pause sleeps for d, and returns false if quit is closed first.
+func pause(d time.Duration, quit chan struct{}) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-quit:
+		return false
+	}
+}
+
 func PrintBusy(state interfaces.IState, i int) {
 
 	s := state.(*s.State)
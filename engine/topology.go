@@ -0,0 +1,335 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Topology is how the simulator's nodes are connected: the links between
+// them, given by the nodes' indexes.  A link is made once, from the lower
+// index to the higher, and never from a node to itself.
+
+// Topologies are the names MakeTopology knows.
+var Topologies = []string{"long", "loops", "alot", "tree", "circles", "mesh", "kregular", "smallworld", "star"}
+
+type Topology struct {
+	Name  string
+	Nodes int
+	Links [][2]int
+
+	linked map[[2]int]bool
+}
+
+// TopologyStats describe the shape of a topology, as consensus latency
+// depends on it.
+type TopologyStats struct {
+	Links      int
+	MinDegree  int
+	MaxDegree  int
+	MeanDegree float64
+	Diameter   int // Longest of the shortest paths between nodes; -1 if some can't reach others
+	Components int // Sets of nodes connected to each other, but not to the rest
+}
+
+func NewTopology(name string, nodes int) *Topology {
+	t := new(Topology)
+	t.Name = name
+	t.Nodes = nodes
+	t.linked = make(map[[2]int]bool)
+	return t
+}
+
+// Add links nodes a and b.  As for AddSimPeer, links out of range, to a node
+// itself, or made before are ignored.  Add returns true if the link is new.
+func (t *Topology) Add(a, b int) bool {
+	if a > b {
+		a, b = b, a
+	}
+	if a < 0 || b >= t.Nodes || a == b || t.linked[[2]int{a, b}] {
+		return false
+	}
+	t.linked[[2]int{a, b}] = true
+	t.Links = append(t.Links, [2]int{a, b})
+	return true
+}
+
+// Linked is true if nodes a and b are linked.
+func (t *Topology) Linked(a, b int) bool {
+	if a > b {
+		a, b = b, a
+	}
+	return t.linked[[2]int{a, b}]
+}
+
+// Connect adds a SimPeer to fnodes for each link of the topology.
+func (t *Topology) Connect(fnodes []*FactomNode) {
+	for _, link := range t.Links {
+		AddSimPeer(fnodes, link[0], link[1])
+	}
+}
+
+// MakeTopology builds the topology called name over count nodes.  degree is
+// the number of links of each node for kregular and smallworld, and rewire
+// the chance a link of smallworld is moved to a random node.  rng is used for
+// the random topologies.
+func MakeTopology(name string, count int, degree int, rewire float64, rng *rand.Rand) (*Topology, error) {
+	t := NewTopology(name, count)
+	switch name {
+	case "long":
+		for i := 1; i < count; i++ {
+			t.Add(i-1, i)
+		}
+	case "loops":
+		for i := 1; i < count; i++ {
+			t.Add(i-1, i)
+		}
+		for i := 0; (i+17)*2 < count; i += 17 {
+			t.Add(i%count, (i+5)%count)
+		}
+		for i := 0; (i+13)*2 < count; i += 13 {
+			t.Add(i%count, (i+7)%count)
+		}
+	case "alot":
+		for i := 0; i < count; i++ {
+			t.Add(i, (i+1)%count)
+			t.Add(i, (i+3)%count)
+			t.Add(i, (i+5)%count)
+			t.Add(i, (i+7)%count)
+		}
+	case "tree":
+		index := 0
+		row := 1
+	treeloop:
+		for i := 0; true; i++ {
+			for j := 0; j <= i; j++ {
+				t.Add(index, row)
+				t.Add(index, row+1)
+				row++
+				index++
+				if index >= count {
+					break treeloop
+				}
+			}
+			row += 1
+		}
+	case "circles":
+		circleSize := 7
+		index := 0
+		for {
+			t.Add(index, index+circleSize-1)
+			for i := index; i < index+circleSize-1; i++ {
+				t.Add(i, i+1)
+			}
+			index += circleSize
+
+			t.Add(index, index-circleSize/3)
+			t.Add(index+2, index-circleSize-circleSize*2/3-1)
+			t.Add(index+3, index-(2*circleSize)-circleSize*2/3)
+			t.Add(index+5, index-(3*circleSize)-circleSize*2/3+1)
+
+			if index >= count {
+				break
+			}
+		}
+	case "mesh":
+		for i := 0; i < count; i++ {
+			for j := i + 1; j < count; j++ {
+				t.Add(i, j)
+			}
+		}
+	case "star":
+		for i := 1; i < count; i++ {
+			t.Add(0, i)
+		}
+	case "kregular":
+		if degree < 1 || count <= degree || count*degree%2 != 0 {
+			return nil, fmt.Errorf("A %d-regular network of %d nodes can't be made; the degree must be below the count, and their product even", degree, count)
+		}
+		return kRegular(count, degree, rng), nil
+	case "smallworld":
+		if degree < 2 || count <= degree || degree%2 != 0 {
+			return nil, fmt.Errorf("A small world network of %d nodes with degree %d can't be made; the degree must be even, and below the count", count, degree)
+		}
+		if rewire < 0 || 1 < rewire {
+			return nil, fmt.Errorf("The chance of rewiring a link must be from 0 to 1, not %v", rewire)
+		}
+		// Watts-Strogatz: a ring, each node linked to the degree/2 nodes after
+		// it, then each link rewired to a random node with chance rewire.
+		for i := 0; i < count; i++ {
+			for j := 1; j <= degree/2; j++ {
+				t.Add(i, (i+j)%count)
+			}
+		}
+		for l := 0; l < len(t.Links) && 0 < rewire; l++ {
+			if rng.Float64() >= rewire {
+				continue
+			}
+			a, b := t.Links[l][0], t.Links[l][1]
+			c := rng.Intn(count)
+			if c == a || t.Linked(a, c) {
+				continue
+			}
+			delete(t.linked, [2]int{a, b})
+			if c < a {
+				t.Links[l] = [2]int{c, a}
+			} else {
+				t.Links[l] = [2]int{a, c}
+			}
+			t.linked[t.Links[l]] = true
+		}
+	default:
+		return nil, fmt.Errorf("Didn't understand network type %q. Known types: %s", name, strings.Join(Topologies, ", "))
+	}
+	return t, nil
+}
+
+// kRegular makes a random network where every node has degree links, by
+// pairing up the free ends of links at random, and starting over if it
+// paints itself into a corner.
+func kRegular(count int, degree int, rng *rand.Rand) *Topology {
+	for {
+		t := NewTopology("kregular", count)
+		var ends []int
+		for i := 0; i < count; i++ {
+			for j := 0; j < degree; j++ {
+				ends = append(ends, i)
+			}
+		}
+		stuck := false
+		for 0 < len(ends) && !stuck {
+			stuck = true
+			for try := 0; try < 100; try++ {
+				i, j := rng.Intn(len(ends)), rng.Intn(len(ends))
+				if i == j || !t.Add(ends[i], ends[j]) {
+					continue
+				}
+				if i < j {
+					i, j = j, i
+				}
+				ends = append(ends[:i], ends[i+1:]...)
+				ends = append(ends[:j], ends[j+1:]...)
+				stuck = false
+				break
+			}
+		}
+		if !stuck {
+			return t
+		}
+	}
+}
+
+// LoadTopology reads a topology over count nodes from an edge list: a link on
+// each line, given as the indexes of the two nodes.  Blank lines, and
+// anything after a #, are ignored.
+func LoadTopology(filename string, count int) (*Topology, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	t := NewTopology(filename, count)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected two nodes, found %q", filename, line, text)
+		}
+		var link [2]int
+		for i, field := range fields {
+			if link[i], err = strconv.Atoi(field); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", filename, line, err.Error())
+			}
+			if link[i] < 0 || link[i] >= count {
+				return nil, fmt.Errorf("%s:%d: no node %d; there are %d nodes", filename, line, link[i], count)
+			}
+		}
+		t.Add(link[0], link[1])
+	}
+	return t, scanner.Err()
+}
+
+// Stats returns the degrees, diameter and components of the topology.
+func (t *Topology) Stats() TopologyStats {
+	var stats TopologyStats
+	stats.Links = len(t.Links)
+	if t.Nodes == 0 {
+		return stats
+	}
+
+	neighbors := make([][]int, t.Nodes)
+	for _, link := range t.Links {
+		neighbors[link[0]] = append(neighbors[link[0]], link[1])
+		neighbors[link[1]] = append(neighbors[link[1]], link[0])
+	}
+	stats.MinDegree = len(neighbors[0])
+	for _, n := range neighbors {
+		if len(n) < stats.MinDegree {
+			stats.MinDegree = len(n)
+		}
+		if len(n) > stats.MaxDegree {
+			stats.MaxDegree = len(n)
+		}
+	}
+	stats.MeanDegree = float64(2*len(t.Links)) / float64(t.Nodes)
+
+	// A breadth first search from every node gives the shortest paths.
+	component := make([]int, t.Nodes)
+	for i := range component {
+		component[i] = -1
+	}
+	distance := make([]int, t.Nodes)
+	for start := 0; start < t.Nodes; start++ {
+		if component[start] < 0 {
+			component[start] = stats.Components
+			stats.Components++
+		}
+		for i := range distance {
+			distance[i] = -1
+		}
+		distance[start] = 0
+		queue := []int{start}
+		for 0 < len(queue) {
+			node := queue[0]
+			queue = queue[1:]
+			for _, next := range neighbors[node] {
+				if distance[next] < 0 {
+					distance[next] = distance[node] + 1
+					component[next] = component[start]
+					if distance[next] > stats.Diameter {
+						stats.Diameter = distance[next]
+					}
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+	if 1 < stats.Components {
+		stats.Diameter = -1
+	}
+	return stats
+}
+
+func (s TopologyStats) String() string {
+	diameter := fmt.Sprintf("diameter %d", s.Diameter)
+	if s.Diameter < 0 {
+		diameter = fmt.Sprintf("not connected, %d components", s.Components)
+	}
+	return fmt.Sprintf("%d links, degree min %d mean %.2f max %d, %s",
+		s.Links, s.MinDegree, s.MeanDegree, s.MaxDegree, diameter)
+}
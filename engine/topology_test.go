@@ -0,0 +1,110 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package engine_test
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	. "github.com/FactomProject/factomd/engine"
+)
+
+func TestTopologyStats(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		count         int
+		links         int
+		min, max      int
+		diameter      int
+		components    int
+		degree        int
+		rewire        float64
+		checkDiameter bool
+	}{
+		{"long", 10, 9, 1, 2, 9, 1, 0, 0, true},
+		{"mesh", 10, 45, 9, 9, 1, 1, 0, 0, true},
+		{"star", 10, 9, 1, 9, 2, 1, 0, 0, true},
+		{"smallworld", 20, 40, 0, 0, 0, 0, 4, 0, false},
+		{"smallworld", 20, 40, 4, 4, 5, 1, 4, 0, true}, // No rewiring leaves a ring
+		{"kregular", 20, 30, 3, 3, 0, 0, 3, 0, false},
+	} {
+		topology, err := MakeTopology(test.name, test.count, test.degree, test.rewire, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		stats := topology.Stats()
+		if stats.Links != test.links {
+			t.Errorf("%s: expected %d links, got %d", test.name, test.links, stats.Links)
+		}
+		if test.min != 0 && (stats.MinDegree != test.min || stats.MaxDegree != test.max) {
+			t.Errorf("%s: expected degree %d to %d, got %s", test.name, test.min, test.max, stats)
+		}
+		if test.checkDiameter && (stats.Diameter != test.diameter || stats.Components != test.components) {
+			t.Errorf("%s: expected diameter %d, got %s", test.name, test.diameter, stats)
+		}
+	}
+}
+
+func TestRandomTopologies(t *testing.T) {
+	rewired, _ := MakeTopology("smallworld", 30, 4, 0.5, rand.New(rand.NewSource(3)))
+	ring, _ := MakeTopology("smallworld", 30, 4, 0, nil)
+	if len(rewired.Links) != len(ring.Links) {
+		t.Errorf("Rewiring should keep the number of links")
+	}
+	moved := 0
+	for _, link := range rewired.Links {
+		if !ring.Linked(link[0], link[1]) {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Errorf("Expected some links to be rewired")
+	}
+
+	a, _ := MakeTopology("kregular", 16, 4, 0, rand.New(rand.NewSource(5)))
+	b, _ := MakeTopology("kregular", 16, 4, 0, rand.New(rand.NewSource(5)))
+	for i := range a.Links {
+		if a.Links[i] != b.Links[i] {
+			t.Fatalf("The same seed should give the same network")
+		}
+	}
+
+	for _, bad := range []struct {
+		name   string
+		count  int
+		degree int
+	}{
+		{"kregular", 5, 3}, {"kregular", 4, 4}, {"smallworld", 10, 3}, {"hypercube", 8, 3},
+	} {
+		if _, err := MakeTopology(bad.name, bad.count, bad.degree, 0, rand.New(rand.NewSource(1))); err == nil {
+			t.Errorf("Expected an error for %s of %d nodes with degree %d", bad.name, bad.count, bad.degree)
+		}
+	}
+}
+
+func TestLoadTopology(t *testing.T) {
+	file, err := ioutil.TempFile("", "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# Two triangles\n0 1\n1 2\n2 0\n\n3 4 # and a pair\n4 3\n")
+	file.Close()
+
+	topology, err := LoadTopology(file.Name(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := topology.Stats()
+	if stats.Links != 4 || stats.Components != 2 || stats.Diameter != -1 {
+		t.Errorf("Unexpected topology %s", stats)
+	}
+
+	if _, err := LoadTopology(file.Name(), 4); err == nil {
+		t.Errorf("Expected an error for a node out of range")
+	}
+}
@@ -5,10 +5,11 @@
 package p2p
 
 import (
-	"encoding/gob"
+	"encoding/hex"
 	"hash/crc32"
 	"io"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -20,20 +21,24 @@ type Connection struct {
 	SendChannel    chan interface{} // Send means "towards the network" Channel takes Parcels and ConnectionCommands
 	ReceiveChannel chan interface{} // Recieve means "from the network" Channel sends Parcels and ConnectionCommands
 	// and as "address" for sending messages to specific nodes.
-	encoder         *gob.Encoder // Wire format is gobs in this version, may switch to binary
-	decoder         *gob.Decoder // Wire format is gobs in this version, may switch to binary
-	timeLastContact time.Time    // We track how recently we have heard from a peer to determin if it is still active.
-	peer            Peer         // the datastructure representing the peer we are talking to. defined in peer.go
-	attempts        int          // reconnection attempts
-	timeLastAttempt time.Time    // time of last attempt to connect via dial
-	timeLastPing    time.Time    // time of last ping sent
-	timeLastUpdate  time.Time    // time of last peer update sent
-	state           uint8        // Current state of the connection. Private. Only communication
+	secure          *secureConn // Encrypted link to the peer, set up by the handshake in goOnline()
+	dialer          bool        // We dialed the peer (as opposed to accepting its connection)
+	version         uint16      // Protocol version negotiated in the handshake. Wire format is in wire.go
+	timeLastContact time.Time   // We track how recently we have heard from a peer to determin if it is still active.
+	peer            Peer        // the datastructure representing the peer we are talking to. defined in peer.go
+	attempts        int         // reconnection attempts
+	timeLastAttempt time.Time   // time of last attempt to connect via dial
+	timeLastPing    time.Time   // time of last ping sent
+	timeLastUpdate  time.Time   // time of last peer update sent
+	state           uint8       // Current state of the connection. Private. Only communication
+	queues          *sendQueues // Parcels waiting to be sent, by class.  Only used by the runloop.
+	metrics         *connectionMetrics
 }
 
 // Each connection is a simple state machine.  The state is managed by a single goroutine which also does netowrking.
 // The flow is this:  Connection gets initialized, and either has a peer or a net connection (From an accept())
-// If no network connection, the Connection dials.  If the dial is successful, it moves to the Online state
+// If no network connection, the Connection dials.  Either way it then runs the handshake (handshake.go), which
+// authenticates the peer and encrypts the connection.  If that succeeds, it moves to the Online state.
 // If not, it moves to the Shutdown state-- we only dial out once when initialized with a peer.
 // If we are online and get a network error, we shift to offline mode.  In offline state we attempt to reconnect for
 // a period defined in protocol.go.  IF successful, we go back Online.  If too many attempts are made, we go to
@@ -74,6 +79,36 @@ const (
 	ConnectionAdjustPeerQuality
 )
 
+// ConnectionMetrics is a snapshot of a connection, for managing peers.  Bytes
+// are counted as parcels, header and payload, before encryption.
+type ConnectionMetrics struct {
+	PeerHash         string    `json:"peerhash"`
+	Address          string    `json:"address"`
+	State            string    `json:"state"`
+	Dialer           bool      `json:"dialer"` // We dialed the peer, rather than the peer dialing us
+	QualityScore     int32     `json:"qualityscore"`
+	IdentityChain    string    `json:"identitychain"` // Claimed in the handshake, not verified
+	Version          uint16    `json:"version"`       // Protocol version negotiated in the handshake, 0 until then
+	BytesSent        uint64    `json:"bytessent"`
+	BytesReceived    uint64    `json:"bytesreceived"`
+	MessagesSent     uint64    `json:"messagessent"`
+	MessagesReceived uint64    `json:"messagesreceived"`
+	LastMessage      time.Time `json:"lastmessage"` // When we last got a valid parcel from the peer
+}
+
+// connectionMetrics is shared by the connection's goroutines, which update it,
+// and the controller, which reads it.
+type connectionMetrics struct {
+	mutex sync.Mutex
+	ConnectionMetrics
+}
+
+func (m *connectionMetrics) snapshot() ConnectionMetrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.ConnectionMetrics
+}
+
 //////////////////////////////
 //
 // Public API
@@ -85,9 +120,10 @@ func (c *Connection) InitWithConn(conn net.Conn, peer Peer) *Connection {
 	c.peer = peer
 	note(c.peer.Hash, "Connection.InitWithConn() called.")
 	c.conn = conn
+	c.dialer = false
+	c.state = ConnectionInitialized
 	c.commonInit()
-	c.goOnline()
-	go c.runLoop()
+	go c.runLoop() // The handshake happens there, so it doesn't hold up the accept loop.
 	return c
 }
 
@@ -111,6 +147,9 @@ func (c *Connection) Init(peer Peer) *Connection {
 func (c *Connection) commonInit() {
 	c.SendChannel = make(chan interface{}, 1000)
 	c.ReceiveChannel = make(chan interface{}, 1000)
+	c.queues = new(sendQueues)
+	c.metrics = new(connectionMetrics)
+	c.publishMetrics()
 	c.timeLastUpdate = time.Now()
 }
 
@@ -119,11 +158,11 @@ func (c *Connection) runLoop() {
 	for ConnectionShutdown != c.state { // loop exits when we hit shutdown state
 		// time.Sleep(time.Second * 1) // This can be a tight loop, don't want to starve the application
 		time.Sleep(time.Millisecond * 1) // This can be a tight loop, don't want to starve the application
+		c.publishMetrics()
 		switch c.state {
 		case ConnectionInitialized:
-			if c.dial() {
-				c.goOnline()
-			} else { //  we did not connect successfully
+			// Accepted connections already have a net connection, the others dial out.
+			if (nil == c.conn && !c.dial()) || !c.goOnline() { //  we did not connect successfully
 				c.goShutdown()
 			}
 		case ConnectionOnline:
@@ -131,14 +170,13 @@ func (c *Connection) runLoop() {
 			c.pingPeer() // sends a ping periodically if things have been quiet
 			if PeerSaveInterval < time.Since(c.timeLastUpdate) {
 				debug(c.peer.Hash, "runLoop() PeerSaveInterval interval %s is less than duration since last update: %s ", PeerSaveInterval.String(), time.Since(c.timeLastUpdate).String())
+				c.peer.decayQuality(time.Now())
 				c.updatePeer() // every PeerSaveInterval * 0.90 we send an update peer to the controller.
 			}
 		case ConnectionOffline:
 			duration := time.Since(c.timeLastAttempt)
 			if TimeBetweenRedials < duration && MaxNumberOfRedialAttempts > c.attempts {
-				if c.dial() {
-					c.goOnline()
-				} else { //  we did not connect successfully
+				if !c.dial() || !c.goOnline() { //  we did not connect successfully
 					c.attempts++
 					c.timeLastAttempt = time.Now()
 				}
@@ -156,11 +194,22 @@ func (c *Connection) runLoop() {
 	}
 }
 
-// Called when we are online and connected to the peer.
-func (c *Connection) goOnline() {
+// Called when we are connected to the peer.  Runs the handshake, and if the peer authenticates, goes online.
+func (c *Connection) goOnline() bool {
 	note(c.peer.Hash, "Connection.goOnline() called. %s", c.peer.Hash)
-	c.encoder = gob.NewEncoder(c.conn)
-	c.decoder = gob.NewDecoder(c.conn)
+	secure, theirs, version, err := handshake(c.conn, c.dialer, CurrentNetwork, NodeKey, NodeIdentityChain, NodeID)
+	if nil != err {
+		logerror(c.peer.Hash, "Connection.goOnline() handshake with %s failed: %+v", c.peer.Address, err)
+		c.conn.Close()
+		c.conn = nil
+		c.peer.demerit()
+		return false
+	}
+	c.secure = secure
+	c.peer.NodeKey = hex.EncodeToString(theirs.NodeKey[:])
+	c.peer.IdentityChain = theirs.identityChain()
+	debug(c.peer.Hash, "Connection.goOnline() peer authenticated with key %s identity %s", c.peer.NodeKey, c.peer.IdentityChain)
+	c.version = version
 	c.timeLastPing = time.Now()
 	c.timeLastContact = time.Now()
 	c.timeLastAttempt = time.Now()
@@ -172,6 +221,9 @@ func (c *Connection) goOnline() {
 	parcel := NewParcel(CurrentNetwork, []byte("Peer Request"))
 	parcel.Header.Type = TypePeerRequest
 	c.SendChannel <- ConnectionParcel{parcel: *parcel}
+	c.updatePeer() // Let the controller know the peer's key and identity.
+	c.publishMetrics()
+	return true
 }
 
 func (c *Connection) goOffline() {
@@ -181,14 +233,18 @@ func (c *Connection) goOffline() {
 	if nil != c.conn {
 		defer c.conn.Close()
 	}
-	c.decoder = nil
-	c.encoder = nil
+	c.secure = nil
 	c.peer.demerit()
 }
 
 func (c *Connection) goShutdown() {
 	debug(c.peer.Hash, "Connection.goShutdown() - Sending ConnectionIsShutdown to RecieveChannel")
 	c.state = ConnectionShutdown
+	c.queues.clear()
+	c.publishMetrics()
+	if nil != c.conn {
+		c.conn.Close()
+	}
 	c.ReceiveChannel <- ConnectionCommand{command: ConnectionIsShutdown}
 }
 
@@ -201,11 +257,13 @@ func (c *Connection) dial() bool {
 		return false
 	}
 	c.conn = conn
+	c.dialer = true
 	debug(c.peer.Hash, "Connection.dial(%s) was successful.", c.peer.Address)
 	return true
 }
 
-// processSends gets all the messages from the application and sends them out over the network
+// processSends gets all the messages from the application, queues them by class, and sends
+// out over the network what the upload limits allow, highest priority first (see queues.go)
 func (c *Connection) processSends() {
 	// note(c.peer.Hash, "Connection.processSends() called. Items in send channel: %d State: %s", len(c.SendChannel), c.ConnectionState())
 	for 0 < len(c.SendChannel) { // effectively "While there are messages"
@@ -214,7 +272,9 @@ func (c *Connection) processSends() {
 		case ConnectionParcel:
 			debug(c.peer.Hash, "processSends() ConnectionParcel")
 			parameters := message.(ConnectionParcel)
-			c.sendParcel(parameters.parcel)
+			if !c.queues.push(parameters.parcel) {
+				debug(c.peer.Hash, "processSends() %s queue full, dropped %s", MessageClassStrings[parcelClass(parameters.parcel)], parameters.parcel.MessageType())
+			}
 		case ConnectionCommand:
 			debug(c.peer.Hash, "processSends() ConnectionCommand")
 			parameters := message.(ConnectionCommand)
@@ -223,6 +283,13 @@ func (c *Connection) processSends() {
 			logfatal(c.peer.Hash, "processSends() unknown message?: %+v ", message)
 		}
 	}
+	for ConnectionOnline == c.state {
+		parcel, ok := c.queues.next(time.Now())
+		if !ok {
+			break
+		}
+		c.sendParcel(parcel)
+	}
 }
 
 func (c *Connection) handleCommand(command ConnectionCommand) {
@@ -241,6 +308,7 @@ func (c *Connection) handleCommand(command ConnectionCommand) {
 		c.peer.QualityScore = c.peer.QualityScore + delta
 		if MinumumQualityScore > c.peer.QualityScore {
 			debug(c.peer.Hash, "handleCommand() disconnecting peer: %s for quality score: %d", c.peer.Hash, c.peer.QualityScore)
+			c.peer.ban(time.Now())
 			c.updatePeer()
 			c.goShutdown()
 		}
@@ -251,9 +319,14 @@ func (c *Connection) handleCommand(command ConnectionCommand) {
 
 func (c *Connection) sendParcel(parcel Parcel) {
 	debug(c.peer.Hash, "sendParcel() sending message to network of type: %s", parcel.MessageType())
-	parcel.Header.NodeID = NodeID // Send it out with our ID for loopback.
-	debug(c.peer.Hash, "sendParcel() Sanity check. Encoder: %+v, Parcel: %s", c.encoder, parcel.MessageType())
-	err := c.encoder.Encode(parcel)
+	parcel.Header.Version = c.version
+	err := writeParcel(c.secure, &parcel)
+	if nil == err {
+		c.metrics.mutex.Lock()
+		c.metrics.BytesSent += uint64(ParcelHeaderSize + len(parcel.Payload))
+		c.metrics.MessagesSent++
+		c.metrics.mutex.Unlock()
+	}
 	if nil != err {
 		logerror(c.peer.Hash, "Connection.sendParcel() got encoding error: %+v", err)
 		c.peer.demerit()
@@ -267,27 +340,23 @@ func (c *Connection) sendParcel(parcel Parcel) {
 func (c *Connection) processReceives() {
 	note(c.peer.Hash, "Connection.processReceives() called. State: %s", c.ConnectionState())
 	for c.state == ConnectionOnline {
-		var message Parcel
 		// c.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		err := c.decoder.Decode(&message)
+		message, err := readParcel(c.secure)
 		if nil != err {
 			c.goOffline()
 			logerror(c.peer.Hash, "Connection.processReceives() got decoding error: %+v", err)
 		} else {
 			note(c.peer.Hash, "Connection.processReceives() RECIEVED FROM NETWORK!  State: %s MessageType: %s", c.ConnectionState(), message.MessageType())
-			c.handleParcel(message)
+			c.metrics.mutex.Lock()
+			c.metrics.BytesReceived += uint64(ParcelHeaderSize + len(message.Payload))
+			c.metrics.MessagesReceived++
+			c.metrics.mutex.Unlock()
+			c.handleParcel(*message)
 		}
 	}
 	note(c.peer.Hash, "Connection.processReceives() exited. %s", c.peer.Address)
 }
 
-// TODO - make it easy to switch between encoding/binary and encoding/gob here.
-// func (c *Connection) encodeAndSend(parcel Parcel)l error {
-// }
-
-// func (c *Connection) receiveAndDecode(parcel Parcel) bool {
-// }
-
 // handleParcel checks the parcel command type, and either generates a response, or passes it along.
 func (c *Connection) handleParcel(parcel Parcel) {
 	parcel.Header.Timestamp = time.Now() // set the timestamp to the recieved time.
@@ -305,6 +374,9 @@ func (c *Connection) handleParcel(parcel Parcel) {
 		c.timeLastContact = time.Now() // We only update for valid messages (incluidng pings and heartbeats)
 		c.attempts = 0                 // reset since we are clearly in touch now.
 		c.peer.merit()                 // Increase peer quality score.
+		c.metrics.mutex.Lock()
+		c.metrics.LastMessage = c.timeLastContact
+		c.metrics.mutex.Unlock()
 		debug(c.peer.Hash, "Connection.handleParcel() got ParcelValid %s", parcel.MessageType())
 		if Notes <= CurrentLoggingLevel {
 			parcel.PrintMessageType()
@@ -327,13 +399,10 @@ func (c *Connection) parcelValidity(parcel Parcel) uint8 {
 	debug(c.peer.Hash, "Connection.isValidParcel(%s)", parcel.MessageType())
 	crc := crc32.Checksum(parcel.Payload, CRCKoopmanTable)
 	switch {
-	case parcel.Header.NodeID == NodeID: // We are talking to ourselves!
-		logerror(c.peer.Hash, "Connection.isValidParcel(), failed due to loopback!: %+v", parcel.Header)
-		return InvalidDisconnectPeer
 	case parcel.Header.Network != CurrentNetwork:
 		logerror(c.peer.Hash, "Connection.isValidParcel(), failed due to wrong network: %+v", parcel.Header)
 		return InvalidDisconnectPeer
-	case parcel.Header.Version < ProtocolVersionMinimum:
+	case parcel.Header.Version != c.version: // We agreed on a version in the handshake.
 		logerror(c.peer.Hash, "Connection.isValidParcel(), failed due to wrong version: %+v", parcel.Header)
 		return InvalidDisconnectPeer
 	case parcel.Header.Length != uint32(len(parcel.Payload)):
@@ -366,10 +435,15 @@ func (c *Connection) handleParcelTypes(parcel Parcel) {
 	case TypePeerResponse:
 		debug(c.peer.Hash, "handleParcelTypes() TypePeerResponse")
 		c.ReceiveChannel <- ConnectionParcel{parcel: parcel} // Controller handles these.
+	case TypeInventory, TypeGetData:
+		debug(c.peer.Hash, "handleParcelTypes() %s", parcel.MessageType())
+		c.ReceiveChannel <- ConnectionParcel{parcel: parcel} // Controller handles these.
 	case TypeMessage:
 		debug(c.peer.Hash, "handleParcelTypes() TypeMessage. Message is a: %s", parcel.MessageType())
 		// Store our connection ID so the controller can direct response to us.
 		parcel.Header.TargetPeer = c.peer.Hash
+		// And who the peer is, so the application can trust or prioritise its messages.
+		parcel.Header.PeerKey = c.peer.NodeKey
 		parcel.Header.NodeID = NodeID
 		c.ReceiveChannel <- ConnectionParcel{parcel: parcel}
 	default:
@@ -399,9 +473,37 @@ func (c *Connection) pingPeer() {
 func (c *Connection) updatePeer() {
 	verbose(c.peer.Hash, "updatePeer() SENDING ConnectionUpdatingPeer - Connection State: %s", c.ConnectionState())
 	c.timeLastUpdate = time.Now()
+	c.peer.LastContact = c.timeLastContact
 	c.ReceiveChannel <- ConnectionCommand{command: ConnectionUpdatingPeer, peer: c.peer}
 }
 
+// publishMetrics copies what the connection knows of itself and its peer to its
+// metrics, for the controller to read.
+func (c *Connection) publishMetrics() {
+	c.metrics.mutex.Lock()
+	defer c.metrics.mutex.Unlock()
+	c.metrics.PeerHash = c.peer.Hash
+	c.metrics.Address = c.peer.Address
+	c.metrics.State = c.ConnectionState()
+	c.metrics.Dialer = c.dialer
+	c.metrics.QualityScore = c.peer.QualityScore
+	c.metrics.IdentityChain = c.peer.IdentityChain
+	c.metrics.Version = c.version
+}
+
+// speaksGossip is true once the connection negotiated a version with the
+// inventory and get data parcels.  The controller holds a copy of the
+// connection, so it reads the version from the shared metrics.
+func (c Connection) speaksGossip() bool {
+	return c.speaks(GossipVersion)
+}
+
+// speaks is true if the connection negotiated version or later.  Every
+// connection speaks version 0.
+func (c Connection) speaks(version uint16) bool {
+	return version == 0 || (c.metrics != nil && version <= c.metrics.snapshot().Version)
+}
+
 func (c *Connection) ConnectionState() string {
 	return connectionStateStrings[c.state]
 }
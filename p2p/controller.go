@@ -11,10 +11,14 @@ package p2p
 // Other than Init and NetworkStart, all administration is done via the channel.
 
 import (
+	crand "crypto/rand"
 	"fmt"
 	"math/rand"
 	"net"
+	"sort"
 	"time"
+
+	"github.com/FactomProject/ed25519"
 )
 
 // Controller manages the peer to peer network.
@@ -33,7 +37,8 @@ type Controller struct {
 	lastPeerManagement time.Time             // Last time we ran peer management.
 	NodeID             uint64
 	lastStatusReport   time.Time
-	lastPeerRequest    time.Time // Last time we asked peers about the peers they know about.
+	lastPeerRequest    time.Time    // Last time we asked peers about the peers they know about.
+	gossipCache        *GossipCache // Payloads seen recently, see gossip.go
 }
 
 // CommandDialPeer is used to instruct the Controller to dial a peer address
@@ -67,6 +72,27 @@ type CommandBan struct {
 	peerHash string
 }
 
+// CommandUnban is used to instruct the Controller to lift the ban on a peer
+type CommandUnban struct {
+	peerHash string
+}
+
+// CommandDisconnect is used to instruct the Controller to shut down the connection to a peer
+type CommandDisconnect struct {
+	peerHash string
+}
+
+// CommandGetConnections is used to ask the Controller for the metrics of its connections
+type CommandGetConnections struct {
+	reply chan []ConnectionMetrics
+}
+
+// CommandAdjustPeerQuality is used to instruct the Controller to change a connections quality score
+type CommandAdjustPeerQuality struct {
+	peerHash string
+	delta    int32
+}
+
 // CommandChangeLogging is used to instruct the Controller to takve various actions.
 type CommandChangeLogging struct {
 	level uint8
@@ -89,10 +115,19 @@ func (c *Controller) Init(port string, peersFile string) *Controller {
 	c.ToNetwork = make(chan Parcel, 10000)          // Parcels from the app for the network
 	c.listenPort = port
 	c.connections = make(map[string]Connection)
+	c.gossipCache = NewGossipCache()
 	discovery := new(Discovery).Init(peersFile)
 	c.discovery = *discovery
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	NodeID = uint64(r.Int63()) // This is a global used by all connections
+	// No node key configured, so we authenticate with a throwaway one.
+	if nil == NodeKey {
+		_, key, err := ed25519.GenerateKey(crand.Reader)
+		if nil != err {
+			logfatal("ctrlr", "Controller.Init() could not generate a node key: %+v", err)
+		}
+		NodeKey = key
+	}
 	c.lastPeerManagement = time.Now()
 	c.lastPeerRequest = time.Now()
 	return c
@@ -160,6 +195,55 @@ func (c *Controller) Ban(peerHash string) {
 	c.commandChannel <- CommandBan{peerHash: peerHash}
 }
 
+// Unban lifts the ban on a peer, and gives it a fresh quality score.
+func (c *Controller) Unban(peerHash string) {
+	debug("ctrlr", "Unban %s", peerHash)
+	c.commandChannel <- CommandUnban{peerHash: peerHash}
+}
+
+// Disconnect shuts down the connection to a peer.  It may be dialed again
+// later, like any other peer; ban it to keep it away.
+func (c *Controller) Disconnect(peerHash string) {
+	debug("ctrlr", "Disconnect %s", peerHash)
+	c.commandChannel <- CommandDisconnect{peerHash: peerHash}
+}
+
+// GetConnections returns the metrics of our connections, sorted by address.  It
+// waits on the runloop, and returns nil if the runloop doesn't take the command
+// and answer within ManagementTimeout.
+func (c *Controller) GetConnections() []ConnectionMetrics {
+	reply := make(chan []ConnectionMetrics, 1)
+	timeout := time.After(ManagementTimeout)
+	select {
+	case c.commandChannel <- CommandGetConnections{reply: reply}:
+	case <-timeout:
+		return nil
+	}
+	select {
+	case connections := <-reply:
+		return connections
+	case <-timeout:
+		return nil
+	}
+}
+
+// PeerHashFor returns the hash of a peer given either its hash or its
+// address, for management commands that take either.
+func PeerHashFor(peer string) string {
+	if address, err := NormalizeAddress(peer); nil == err {
+		return PeerHashFromAddress(address)
+	}
+	return peer
+}
+
+// AdjustPeerQuality adds delta to the quality score of a connected peer.  The
+// application uses this to report how the messages of a peer validate.  A peer
+// whose score falls below MinumumQualityScore is disconnected and banned.
+func (c *Controller) AdjustPeerQuality(peerHash string, delta int32) {
+	debug("ctrlr", "AdjustPeerQuality %s by %d", peerHash, delta)
+	c.commandChannel <- CommandAdjustPeerQuality{peerHash: peerHash, delta: delta}
+}
+
 //////////////////////////////////////////////////////////////////////
 //
 // Private API (unexported)
@@ -234,7 +318,7 @@ func (c *Controller) runloop() {
 }
 
 // Route pulls all of the messages from the application and sends them to the appropriate
-// peer. Broadcast messages go to everyone, directed messages go to the named peer.
+// peer. Broadcast messages are gossiped (see gossip.go), directed messages go to the named peer.
 // route also passes incomming messages on to the application.
 func (c *Controller) route() {
 	// verbose("ctrlr", "Controller.route() called. Number peers: %d", len(c.connections))
@@ -259,6 +343,7 @@ func (c *Controller) route() {
 	// For each message, see if it is directed, if so, send to the
 	// specific peer, otherwise, broadcast.
 	// silence("ctrlr", "Controller.route() size of ToNetwork channel: %d", len(c.ToNetwork))
+	inventory := make(map[string][][gossipHashSize]byte)
 	for 0 < len(c.ToNetwork) { // effectively "While there are messages"
 		parcel := <-c.ToNetwork
 		TotalMessagesSent++
@@ -266,17 +351,17 @@ func (c *Controller) route() {
 		if "" != parcel.Header.TargetPeer { // directed send
 			verbose("ctrlr", "Controller.route() Directed send to %+v", parcel.Header.TargetPeer)
 			connection, present := c.connections[parcel.Header.TargetPeer]
-			if present { // We're still connected to the target
+			if present && connection.speaks(parcel.Header.MinVersion) { // We're still connected to the target, and it knows the parcel
 				connection.SendChannel <- ConnectionParcel{parcel: parcel}
 			}
 		} else { // broadcast
 			verbose("ctrlr", "Controller.route() Broadcast send to %d peers", len(c.connections))
-			for _, connection := range c.connections {
-				verbose("ctrlr", "Controller.route() Send to peer %s ", connection.peer.Hash)
-				connection.SendChannel <- ConnectionParcel{parcel: parcel}
-			}
+			c.gossip(parcel, inventory)
 		}
 	}
+	for peerHash, hashes := range inventory {
+		c.sendHashes(c.connections[peerHash], TypeInventory, hashes)
+	}
 
 }
 
@@ -289,7 +374,12 @@ func (c *Controller) handleParcelReceive(message interface{}, peerHash string, c
 	parcel.Header.TargetPeer = peerHash // Set the connection ID so the application knows which peer the message is from.
 	switch parcel.Header.Type {
 	case TypeMessage: // Application message, send it on.
+		c.gossipAdd(parcel.Payload, peerHash)
 		c.FromNetwork <- parcel
+	case TypeInventory: // Ask the peer for what it has that we haven't seen
+		c.handleInventory(parcel, connection)
+	case TypeGetData: // Send the peer what it asked for
+		c.handleGetData(parcel, connection)
 	case TypePeerRequest: // send a response to the connection over its connection.SendChannel
 		// Get selection of peers from discovery
 		response := NewParcel(CurrentNetwork, c.discovery.SharePeers())
@@ -299,7 +389,10 @@ func (c *Controller) handleParcelReceive(message interface{}, peerHash string, c
 		verbose("ctrlr", "Controller.route() sent the SharePeers response: %+v", response.MessageType())
 	case TypePeerResponse:
 		// Add these peers to our known peers
-		c.discovery.LearnPeers(parcel.Payload)
+		if err := c.discovery.LearnPeers(parcel.Payload); nil != err {
+			note("ctrlr", "Controller.handleParcelReceive() poisoned peer response from %s: %+v", peerHash, err)
+			c.applicationPeerUpdate(-PoisonedResponseDemerit, peerHash)
+		}
 	default:
 		logfatal("ctrlr", "handleParcelReceive() unknown parcel.Header.Type?: %+v ", parcel)
 	}
@@ -325,7 +418,9 @@ func (c *Controller) handleCommand(command interface{}) {
 		parameters := command.(CommandDialPeer)
 		peer := c.discovery.GetPeerByAddress(parameters.address)
 		_, present := c.connections[peer.Hash]
-		if !present { // we are not connected to the peer
+		if peer.IsBanned(time.Now()) {
+			debug("ctrlr", "Controller.handleCommand(CommandDialPeer) not dialing banned peer %s", peer.Address)
+		} else if !present { // we are not connected to the peer
 			conn := new(Connection).Init(peer)
 			connection := *conn
 			c.connections[connection.peer.Hash] = connection
@@ -337,7 +432,10 @@ func (c *Controller) handleCommand(command interface{}) {
 		parameters := command.(CommandAddPeer)
 		connection := parameters.connection
 		_, present := c.connections[connection.peer.Hash] // check if we are already connected to the peer
-		if !present {                                     // we are not connected to the peer
+		if c.discovery.isBannedAddress(connection.peer.Address, time.Now()) {
+			debug("ctrlr", "Controller.handleCommand(CommandAddPeer) dropping banned peer %s", connection.peer.Address)
+			connection.SendChannel <- ConnectionCommand{command: ConnectionShutdownNow}
+		} else if !present { // we are not connected to the peer
 			c.connections[connection.peer.Hash] = connection
 		}
 		debug("ctrlr", "Controller.handleCommand(CommandAddPeer) got peer %+v", parameters.connection)
@@ -362,7 +460,36 @@ func (c *Controller) handleCommand(command interface{}) {
 		verbose("ctrlr", "handleCommand() Processing command: CommandBan")
 		parameters := command.(CommandBan)
 		peerHash := parameters.peerHash
-		c.applicationPeerUpdate(BannedQualityScore, peerHash)
+		if _, present := c.connections[peerHash]; present {
+			c.applicationPeerUpdate(BannedQualityScore, peerHash)
+		} else {
+			c.discovery.banPeer(peerHash, time.Now())
+		}
+	case CommandUnban:
+		verbose("ctrlr", "handleCommand() Processing command: CommandUnban")
+		parameters := command.(CommandUnban)
+		c.discovery.unbanPeer(parameters.peerHash)
+	case CommandDisconnect:
+		verbose("ctrlr", "handleCommand() Processing command: CommandDisconnect")
+		parameters := command.(CommandDisconnect)
+		if connection, present := c.connections[parameters.peerHash]; present {
+			connection.SendChannel <- ConnectionCommand{command: ConnectionShutdownNow}
+		}
+	case CommandGetConnections:
+		verbose("ctrlr", "handleCommand() Processing command: CommandGetConnections")
+		parameters := command.(CommandGetConnections)
+		connections := []ConnectionMetrics{}
+		for _, connection := range c.connections {
+			if nil != connection.metrics {
+				connections = append(connections, connection.metrics.snapshot())
+			}
+		}
+		sort.Sort(connectionsByAddress(connections))
+		parameters.reply <- connections
+	case CommandAdjustPeerQuality:
+		verbose("ctrlr", "handleCommand() Processing command: CommandAdjustPeerQuality")
+		parameters := command.(CommandAdjustPeerQuality)
+		c.applicationPeerUpdate(parameters.delta, parameters.peerHash)
 	default:
 		logfatal("ctrlr", "Unkown p2p.Controller command recieved: %+v", commandType)
 	}
@@ -394,6 +521,7 @@ func (c *Controller) managePeers() {
 		duration := time.Since(c.discovery.lastPeerSave)
 		// Every so often, tell the discovery service to save peers.
 		if PeerSaveInterval < duration {
+			c.discovery.decayPeers(time.Now())
 			c.discovery.SavePeers()
 			c.discovery.PrintPeers() // No-op if debugging off.
 		}
@@ -440,3 +568,16 @@ func (c *Controller) networkStatusReport() {
 		silence("ctrlr", "###########################")
 	}
 }
+
+// sort.Sort interface implementation
+type connectionsByAddress []ConnectionMetrics
+
+func (c connectionsByAddress) Len() int {
+	return len(c)
+}
+func (c connectionsByAddress) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+func (c connectionsByAddress) Less(i, j int) bool {
+	return c[i].Address < c[j].Address
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newManagedController returns a controller with connections to the given
+// addresses that are never dialed, so their metrics stay as published.
+func newManagedController(addresses ...string) *Controller {
+	c := newGossipController(0)
+	c.commandChannel = make(chan interface{}, 10)
+	c.discovery = *new(Discovery).Init(filepath.Join(os.TempDir(), "no-such-peers.json"))
+	for _, address := range addresses {
+		connection := Connection{peer: c.discovery.GetPeerByAddress(address)}
+		connection.commonInit()
+		c.connections[connection.peer.Hash] = connection
+	}
+	return c
+}
+
+func TestGetConnections(t *testing.T) {
+	c := newManagedController("10.0.0.2:8108", "10.0.0.1:8108")
+	go func() { c.handleCommand(<-c.commandChannel) }()
+	connections := c.GetConnections()
+	if len(connections) != 2 || connections[0].Address != "10.0.0.1:8108" || connections[1].State != "Initialized" {
+		t.Errorf("Got connections %+v", connections)
+	}
+}
+
+func TestGetConnectionsTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { ManagementTimeout = timeout }(ManagementTimeout)
+	ManagementTimeout = 10 * time.Millisecond
+
+	// The runloop is stuck, so nothing takes the command.
+	c := newManagedController()
+	c.commandChannel = make(chan interface{})
+	if connections := c.GetConnections(); connections != nil {
+		t.Errorf("Got connections %+v from a stuck runloop", connections)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	c := newManagedController("10.0.0.1:8108")
+	c.handleCommand(CommandDisconnect{peerHash: PeerHashFor("10.0.0.1:8108")})
+	command, ok := (<-c.connections[PeerHashFor("10.0.0.1:8108")].SendChannel).(ConnectionCommand)
+	if !ok || command.command != ConnectionShutdownNow {
+		t.Errorf("Connection was not told to shut down")
+	}
+}
+
+func TestUnban(t *testing.T) {
+	c := newManagedController()
+	hash := c.discovery.GetPeerByAddress("10.0.0.1:8108").Hash
+	c.handleCommand(CommandBan{peerHash: PeerHashFor("10.0.0.1:8108")})
+	if !c.discovery.knownPeers[hash].IsBanned(time.Now()) {
+		t.Fatalf("Peer was not banned")
+	}
+	c.handleCommand(CommandUnban{peerHash: hash})
+	if peer := c.discovery.knownPeers[hash]; peer.IsBanned(time.Now()) || peer.QualityScore != 0 {
+		t.Errorf("Peer is still banned, with score %d", peer.QualityScore)
+	}
+}
+
+func TestLoggingLevelFromString(t *testing.T) {
+	if level, ok := LoggingLevelFromString("debugging"); !ok || level != Debugging {
+		t.Errorf("Got level %d", level)
+	}
+	if _, ok := LoggingLevelFromString("loud"); ok {
+		t.Errorf("Unknown level accepted")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"math/rand"
 	"os"
 	"sort"
@@ -57,19 +58,26 @@ func (d *Discovery) SavePeers() {
 
 // LoadPeers loads the known peers from disk OVERWRITING PREVIOUS VALUES
 func (d *Discovery) LoadPeers() {
+	d.knownPeers = map[string]Peer{}
 	file, err := os.Open(d.peersFilePath)
 	if nil != err {
 		logerror("discovery", "Discover.LoadPeers() File read error on file: %s, Error: %+v", d.peersFilePath, err)
 		return
 	}
+	loaded := map[string]Peer{}
 	dec := json.NewDecoder(bufio.NewReader(file))
-	dec.Decode(&d.knownPeers)
+	dec.Decode(&loaded)
+	// Older peers files have addresses that may not be normalized, and hashes
+	// and groups worked out the old way, so work them out again.
+	for _, peer := range loaded {
+		d.addNormalized(peer)
+	}
 	note("discovery", "LoadPeers() found %d peers in peers.josn", len(d.knownPeers))
 	file.Close()
 }
 
 // SharePeers gets a set of peers to send to other hosts
-// For now, this gives a random set of 24 of the total known peers.
+// This gives up to MaxPeersShared of the known peers, see getPeerSelection.
 // The peers are in a json encoded string as byte slice
 func (d *Discovery) SharePeers() []byte {
 	return d.getPeerSelection()
@@ -83,72 +91,28 @@ func (d *Discovery) ServePeers() string {
 	return string(d.getPeerSelection())
 }
 
-// BUGBUG - we need to filter on special peers, and rewrite share peers and serve peers for this
-// Also need to update the controller stuff for them.
-// Exclusive flag is:  OnlySpecialPeers
-
 // Think the way to do this is to have known peers be peers in the general peer to peer network.
 // Exclusive peers are not shared, and stored in a seperate file.
 
-// Returns a set of peers from the ones we know about.
-// Right now returns the set of all peers we know about.
-// Should maybe return the top 24 peers, based on distance from a randomly chosen peer (maximizing distance)
-// Returns the peers as a JSON string (maybe this should be []Peers, but right now only SharePeers
-// and ServePeers call this.)
+// getPeerSelection returns up to MaxPeersShared of the peers we know about, as
+// JSON (maybe this should be []Peers, but right now only SharePeers and
+// ServePeers call this.)  Special peers are never shared, and neither are
+// peers we haven't heard from in PeerShareMaxAge, as we can't vouch for them.
+// The rest are spread over network groups, best and most recently heard from
+// first, see diversePeers.
 func (d *Discovery) getPeerSelection() []byte {
-	// BUGBUG TODO
-	// BUGBUG doesn't take into account peer type or exclusive flag
-	// BUGBUG couldn't we implemetn a distance sort, the n take the first and last of the sorted peers as furthest away?
-
-	// var peer, currentBest Peer
-	// var currentBestDistance float64
-	selectedPeers := []Peer{}
 	peerPool := []Peer{}
+	now := time.Now()
 	for _, peer := range d.knownPeers {
-		// if SpecialPeer != peer.Type {
+		if peer.IsBanned(now) { // Our bans are no business of other peers, but no need to promote banned peers.
+			continue
+		}
+		if SpecialPeer == peer.Type || PeerShareMaxAge < now.Sub(peer.LastContact) {
+			continue
+		}
 		peerPool = append(peerPool, peer)
-		// }
 	}
-	// Temporarily return all peers:
-	selectedPeers = peerPool
-
-	// This needs to be refactored:
-	// numPeers := len(peerPool)
-	// numToSelect := 24
-	// if numToSelect > numPeers {
-	// 	// then we return all of the peers
-	// 	selectedPeers = peerPool
-	// } else {
-	// 	selectedPeers = peerPool
-	// BUGBUG Rewrite this to sort by location, then take first, middle, last repeatedly. More deterministic
-	// 	// Pick a random peer
-	// 	if numToSelect >= 1 && numPeers > 0 {
-	// 		peer = peers[d.rng.Intn(numPeers-1)]
-	// 	} else {
-	// 		return []byte{}
-	// 	}
-	// 	currentBest = peer
-	// 	currentBestDistance = 0.0
-	// 	selectedPeers := []Peer{peer}
-	// 	verbose("discovery", "getPeerSelection() numToSelect %d, numPeers: %d", numToSelect, numPeers)
-
-	// 	for numToSelect > len(selectedPeers) {
-	// 		verbose("discovery", "getPeerSelection() numToSelect %d, selected: %d", numToSelect, len(selectedPeers))
-	// 		// Iterate thru the peers and find the peer that is furthest away by location
-	// 		for _, target := range peers {
-	// 			distance := math.Abs(float64(target.Location) - float64(peer.Location))
-	// 			if distance > currentBestDistance { // better peer found
-	// 				currentBest = target
-	// 				currentBestDistance = distance
-	// 			}
-	// 		}
-	// 		if currentBest != peer {
-	// 			selectedPeers = append(selectedPeers, currentBest)
-	// 			currentBestDistance = 0.0
-	// 			currentBest = peer
-	// 		}
-	// 	}
-	// }
+	selectedPeers := d.diversePeers(peerPool, MaxPeersShared)
 
 	json, err := json.Marshal(selectedPeers)
 	if nil != err {
@@ -161,56 +125,97 @@ func (d *Discovery) getPeerSelection() []byte {
 // LearnPeers recieves a set of peers from other hosts
 // The unique peers are added to our peer list.
 // The peers are in a json encoded string as a byte slice
-func (d *Discovery) LearnPeers(payload []byte) {
+// A response with more than MaxPeersLearned peers, or with any bad address, is
+// poisoned: none of it is learned, and an error is returned so the sender can
+// be demerited.
+func (d *Discovery) LearnPeers(payload []byte) error {
 	dec := json.NewDecoder(bytes.NewReader(payload))
 	var peerArray []Peer
 	err := dec.Decode(&peerArray)
 	if nil != err {
 		logerror("discovery", "Discovery.LearnPeers got an error unmarshalling json. error: %+v json: %+v", err, strconv.Quote(string(payload)))
-		return
+		return err
+	}
+	if MaxPeersLearned < len(peerArray) {
+		return fmt.Errorf("Peer response has %d peers, more than the %d allowed", len(peerArray), MaxPeersLearned)
 	}
 	for _, value := range peerArray {
-		_, present := d.knownPeers[value.Hash]
-		if !present {
-			value.QualityScore = 0
-			d.knownPeers[value.Hash] = value
+		if _, err := NormalizeAddress(value.Address); nil != err {
+			return fmt.Errorf("Peer response has bad address %s: %+v", strconv.Quote(value.Address), err)
+		}
+	}
+	for _, value := range peerArray {
+		value.QualityScore = 0
+		value.Type = RegularPeer
+		value.NodeKey = "" // Only the peer itself can tell us its key, in the handshake.
+		value.IdentityChain = ""
+		value.QualityDecayed = time.Time{} // Nor do we take their word on scores, bans or when it was last heard from.
+		value.BannedUntil = time.Time{}
+		value.LastContact = time.Time{}
+		if d.addNormalized(value) {
 			note("discovery", "Discovery.LearnPeers !!!!!!!!!!!!! Discoverd new PEER!   %+v ", value)
 		}
 	}
+	return nil
 }
 
-// BUGBUG Have PeerQualitySort and PeerDistanceSort implemented.
-// TODO:
-// 	-- Get peers by quality on startup (pass in number you want)
-// 	-- Get peers by distance (When adding additional?)
-
-// GetStartupPeers gets a set of peers to connect to on startup
-// For now, this gives a set of 12 of the total known peers.
+// GetStartupPeers gets a set of NumberPeersToConnect peers to connect to on
+// startup, spread over as many network groups as we can.
 func (d *Discovery) GetStartupPeers() []Peer {
 	peers := []Peer{}
-	// reverse := []Peer{}
-	selectedPeers := []Peer{}
+	now := time.Now()
 	for _, peer := range d.knownPeers {
+		if peer.IsBanned(now) {
+			continue
+		}
+		if OnlySpecialPeers && SpecialPeer != peer.Type {
+			continue
+		}
 		peers = append(peers, peer)
-		// reverse = append(peers, peer)
 	}
-	// Generate a sort of the known peers by quality score
-	sort.Sort(PeerDistanceSort(peers))
-	// sort.Sort(sort.Reverse(PeerDistanceSort(peers)))
-	// Do we only connect to special peers?
-	// need a flag for special peers and logic
-	// Otherwise take the N best peers from known peers.
-	// BUGBUG this doesn't find diverse distances.
+	return d.diversePeers(peers, NumberPeersToConnect)
+}
+
+// diversePeers returns up to n of the given peers, taking one peer from each
+// network group in turn, so that a few networks (which may well be one party)
+// can't fill all our connections.  Within a group the peers with the best
+// quality go first, and of those the ones we heard from most recently.
+func (d *Discovery) diversePeers(peers []Peer, n int) []Peer {
+	groups := map[string][]Peer{}
+	names := []string{}
 	for _, peer := range peers {
-		if len(selectedPeers) < NumberPeersToConnect {
-			if OnlySpecialPeers {
-				if SpecialPeer == peer.Type {
-					selectedPeers = append(selectedPeers, peer)
-				}
-			} else {
-				selectedPeers = append(selectedPeers, peer)
+		if _, present := groups[peer.Group]; !present {
+			names = append(names, peer.Group)
+		}
+		groups[peer.Group] = append(groups[peer.Group], peer)
+	}
+	// Shuffle the groups so we don't always favour the same ones.
+	sort.Strings(names)
+	for i, j := range d.rng.Perm(len(names)) {
+		names[i], names[j] = names[j], names[i]
+	}
+	for _, name := range names {
+		group := groups[name]
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].QualityScore != group[j].QualityScore {
+				return group[i].QualityScore > group[j].QualityScore
+			}
+			return group[i].LastContact.After(group[j].LastContact)
+		})
+	}
+
+	selectedPeers := []Peer{}
+	for round := 0; len(selectedPeers) < n; round++ {
+		added := false
+		for _, name := range names {
+			if round < len(groups[name]) && len(selectedPeers) < n {
+				selectedPeers = append(selectedPeers, groups[name][round])
+				added = true
 			}
 		}
+		if !added {
+			break
+		}
 	}
 	return selectedPeers
 }
@@ -223,6 +228,9 @@ func (d *Discovery) GetStartupPeers() []Peer {
 // }
 
 func (d *Discovery) GetPeerByAddress(address string) Peer {
+	if normalized, err := NormalizeAddress(address); nil == err {
+		address = normalized
+	}
 	hash := PeerHashFromAddress(address)
 	peer, present := d.knownPeers[hash]
 	// If it exists, return it, otherwise create and add to knownPeers
@@ -239,11 +247,74 @@ func (d *Discovery) UpdatePeer(peer Peer) {
 	d.knownPeers[peer.Hash] = peer
 }
 
+// addNormalized adds a peer read from disk or learned from another peer to the
+// known peers, with its address normalized and its hash and group worked out
+// from that.  It returns false if the address is bad or the peer is known.
+func (d *Discovery) addNormalized(peer Peer) bool {
+	address, err := NormalizeAddress(peer.Address)
+	if nil != err {
+		logerror("discovery", "Discovery.addNormalized() dropping peer with bad address %s: %+v", peer.Address, err)
+		return false
+	}
+	peer.Address = address
+	peer.Hash = PeerHashFromAddress(address)
+	peer.Group = networkGroup(address)
+	if _, present := d.knownPeers[peer.Hash]; present {
+		return false
+	}
+	d.knownPeers[peer.Hash] = peer
+	return true
+}
+
+// banPeer bans a known peer we are not connected to.
+func (d *Discovery) banPeer(hash string, now time.Time) {
+	peer, present := d.knownPeers[hash]
+	if present {
+		peer.ban(now)
+		d.knownPeers[hash] = peer
+	}
+}
+
+// unbanPeer lifts the ban on a known peer, and gives it a fresh quality score.
+func (d *Discovery) unbanPeer(hash string) {
+	peer, present := d.knownPeers[hash]
+	if present {
+		peer.BannedUntil = time.Time{}
+		peer.QualityScore = 0
+		d.knownPeers[hash] = peer
+	}
+}
+
+// isBannedAddress returns true if a peer with the same IP address as the given
+// address is banned.  Peers that dial us come from a different port than the
+// one they listen on, so the port is not compared.
+func (d *Discovery) isBannedAddress(address string, now time.Time) bool {
+	host := hostOf(address)
+	if normalized, err := NormalizeAddress(address); nil == err {
+		host = hostOf(normalized)
+	}
+	for _, peer := range d.knownPeers {
+		if peer.IsBanned(now) && hostOf(peer.Address) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// decayPeers decays the quality scores of the known peers, and lifts the bans
+// that are over.
+func (d *Discovery) decayPeers(now time.Time) {
+	for hash, peer := range d.knownPeers {
+		peer.decayQuality(now)
+		d.knownPeers[hash] = peer
+	}
+}
+
 // PrintPeers Print details about the known peers
 func (d *Discovery) PrintPeers() {
 	note("discovery", "\n\n\n\nPeer Report:")
 	for key, value := range d.knownPeers {
-		note("discovery", "%s \t Address: %s \t Quality: %d", key, value.Address, value.QualityScore)
+		note("discovery", "%s \t Address: %s \t Quality: %d \t Banned until: %s", key, value.Address, value.QualityScore, value.BannedUntil)
 	}
 	note("discovery", "\n\n\n\n")
 }
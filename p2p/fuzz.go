@@ -0,0 +1,34 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build gofuzz
+// +build gofuzz
+
+package p2p
+
+import (
+	"bytes"
+)
+
+// Fuzz is the entry point for go-fuzz (github.com/dvyukov/go-fuzz):
+//
+//	go-fuzz-build github.com/FactomProject/factomd/p2p
+//	go-fuzz -bin=p2p-fuzz.zip -workdir=p2p/fuzz
+//
+// It feeds arbitrary bytes to the parcel decoder, and checks that whatever it
+// accepts encodes back to the same bytes.
+func Fuzz(data []byte) int {
+	parcel, err := readParcel(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	again, err := parcel.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(again, data[:len(again)]) {
+		panic("parcel does not encode back to the bytes it was decoded from")
+	}
+	return 1
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"time"
+)
+
+// Gossip
+//
+// With GossipFanout above zero, the Controller does not send a broadcast to every
+// connection.  It goes in full to GossipFanout connections picked at random, and
+// the others get its hash in a TypeInventory parcel.  A peer that hasn't seen a
+// hash asks for the payload with a TypeGetData parcel, and we send it from the
+// payloads we have seen recently.  A broadcast is never sent back to the peer we
+// got it from.  With GossipFanout at zero every broadcast is flooded to every
+// connection, as before.  Peers that negotiated a version before GossipVersion
+// don't know these parcels, so they always get broadcasts in full.  Peers that
+// negotiated a version before a parcel's MinVersion get neither it nor its hash.
+//
+// Payloads are known by their SHA256.  Inventory and get data parcels carry any
+// number of these hashes back to back.
+
+const gossipHashSize = sha256.Size
+
+// A gossipEntry is a payload in the Controller's GossipCache.
+type gossipEntry struct {
+	hash    [gossipHashSize]byte
+	payload []byte
+	from    string       // Hash of the peer we first got it from, "" if from the application
+	class   MessageClass // Class the application sent it in, so get data replies are queued the same
+	version uint16       // Earliest version of peers it may be sent to
+}
+
+// gossipAdd remembers payload, from the peer with the hash from, as seen and
+// returns its entry.  If it was seen already, the entry is the one from the
+// first time.
+func (c *Controller) gossipAdd(payload []byte, from string) *gossipEntry {
+	hash := sha256.Sum256(payload)
+	return c.gossipCache.Add(hash, &gossipEntry{hash: hash, payload: payload, from: from}).(*gossipEntry)
+}
+
+// splitHashes splits the payload of an inventory or get data parcel into
+// hashes.  A trailing partial hash is ignored.
+func splitHashes(payload []byte) [][gossipHashSize]byte {
+	hashes := make([][gossipHashSize]byte, len(payload)/gossipHashSize)
+	for i := range hashes {
+		copy(hashes[i][:], payload[i*gossipHashSize:])
+	}
+	return hashes
+}
+
+// joinHashes makes the payloads of inventory or get data parcels for hashes,
+// splitting them so no payload is larger than MaxPayloadSize.
+func joinHashes(hashes [][gossipHashSize]byte) [][]byte {
+	var payloads [][]byte
+	perPayload := MaxPayloadSize / gossipHashSize
+	for 0 < len(hashes) {
+		n := len(hashes)
+		if perPayload < n {
+			n = perPayload
+		}
+		payload := make([]byte, 0, n*gossipHashSize)
+		for _, hash := range hashes[:n] {
+			payload = append(payload, hash[:]...)
+		}
+		payloads = append(payloads, payload)
+		hashes = hashes[n:]
+	}
+	return payloads
+}
+
+// gossip sends a broadcast parcel from the application on, in full to
+// GossipFanout connections and as inventory to the rest.  inventory collects
+// the hashes for each connection, so they can go out in one parcel per
+// connection once all of the application's parcels are routed.
+func (c *Controller) gossip(parcel Parcel, inventory map[string][][gossipHashSize]byte) {
+	entry := c.gossipAdd(parcel.Payload, "")
+	entry.class = parcel.Header.Class
+	entry.version = parcel.Header.MinVersion
+	var peers []string
+	for peerHash, connection := range c.connections {
+		if peerHash != entry.from && connection.speaks(entry.version) {
+			peers = append(peers, peerHash)
+		}
+	}
+	for n, i := range rand.Perm(len(peers)) {
+		peerHash := peers[i]
+		if GossipFanout <= 0 || n < GossipFanout || parcel.Header.Type != TypeMessage || !c.connections[peerHash].speaksGossip() {
+			verbose("ctrlr", "Controller.gossip() Send to peer %s ", peerHash)
+			c.connections[peerHash].SendChannel <- ConnectionParcel{parcel: parcel}
+			continue
+		}
+		inventory[peerHash] = append(inventory[peerHash], entry.hash)
+	}
+}
+
+// sendHashes sends hashes to a connection in parcels of the given type.
+func (c *Controller) sendHashes(connection Connection, parcelType ParcelCommandType, hashes [][gossipHashSize]byte) {
+	for _, payload := range joinHashes(hashes) {
+		parcel := NewParcel(CurrentNetwork, payload)
+		parcel.Header.Type = parcelType
+		connection.SendChannel <- ConnectionParcel{parcel: *parcel}
+	}
+}
+
+// handleInventory asks the connection for the payloads it announced that we
+// haven't seen or asked another peer for.
+func (c *Controller) handleInventory(parcel Parcel, connection Connection) {
+	now := time.Now()
+	c.gossipCache.Expire(now)
+	var wanted [][gossipHashSize]byte
+	for _, hash := range splitHashes(parcel.Payload) {
+		if c.gossipCache.Request(hash, now) {
+			wanted = append(wanted, hash)
+		}
+	}
+	verbose("ctrlr", "Controller.handleInventory() asking for %d payloads", len(wanted))
+	c.sendHashes(connection, TypeGetData, wanted)
+}
+
+// handleGetData sends the connection the payloads it asked for that we still
+// have.
+func (c *Controller) handleGetData(parcel Parcel, connection Connection) {
+	for _, hash := range splitHashes(parcel.Payload) {
+		entry, _ := c.gossipCache.Get(hash).(*gossipEntry)
+		if nil == entry || !connection.speaks(entry.version) {
+			continue
+		}
+		response := NewParcel(CurrentNetwork, entry.payload)
+		response.Header.Type = TypeMessage
+		response.Header.Class = entry.class
+		connection.SendChannel <- ConnectionParcel{parcel: *response}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	GossipCacheSize      = 10000           // Payloads kept to answer get data requests
+	GossipRequestTimeout = 2 * time.Second // How long to wait on a get data before asking another peer
+)
+
+// A GossipCache holds what a gossiping node has seen recently, by hash, to
+// answer get data requests with, and the hashes it has asked its peers for and
+// not seen yet.  It keeps the GossipCacheSize most recently seen.  The
+// Controller keeps payloads in one, and the simulator's nodes their messages.
+type GossipCache struct {
+	mutex     sync.Mutex
+	seen      map[[32]byte]*list.Element // Values are *gossipCacheItem
+	recent    *list.List                 // Most recently seen at the front
+	requested map[[32]byte]time.Time     // When we asked for something we haven't seen yet
+}
+
+type gossipCacheItem struct {
+	hash  [32]byte
+	value interface{}
+}
+
+func NewGossipCache() *GossipCache {
+	g := new(GossipCache)
+	g.seen = make(map[[32]byte]*list.Element)
+	g.recent = list.New()
+	g.requested = make(map[[32]byte]time.Time)
+	return g
+}
+
+// Add remembers value as seen, by hash, forgetting the least recently seen if
+// the cache is full, and returns the value.  If hash was seen already, the
+// value is the one seen the first time.
+func (g *GossipCache) Add(hash [32]byte, value interface{}) interface{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	delete(g.requested, hash)
+	if e, ok := g.seen[hash]; ok {
+		g.recent.MoveToFront(e)
+		return e.Value.(*gossipCacheItem).value
+	}
+	g.seen[hash] = g.recent.PushFront(&gossipCacheItem{hash: hash, value: value})
+	for g.recent.Len() > GossipCacheSize {
+		oldest := g.recent.Remove(g.recent.Back()).(*gossipCacheItem)
+		delete(g.seen, oldest.hash)
+	}
+	return value
+}
+
+// Get returns what was seen by hash, or nil if it wasn't seen recently.
+func (g *GossipCache) Get(hash [32]byte) interface{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	e, ok := g.seen[hash]
+	if !ok {
+		return nil
+	}
+	return e.Value.(*gossipCacheItem).value
+}
+
+// Request returns true if we should ask for hash: we haven't seen it, and
+// haven't asked for it in the GossipRequestTimeout before now.
+func (g *GossipCache) Request(hash [32]byte, now time.Time) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, ok := g.seen[hash]; ok {
+		return false
+	}
+	if asked, ok := g.requested[hash]; ok && now.Sub(asked) < GossipRequestTimeout {
+		return false
+	}
+	g.requested[hash] = now
+	return true
+}
+
+// Expire forgets the requests that were not answered in time.
+func (g *GossipCache) Expire(now time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for hash, asked := range g.requested {
+		if GossipRequestTimeout <= now.Sub(asked) {
+			delete(g.requested, hash)
+		}
+	}
+}
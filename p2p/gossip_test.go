@@ -0,0 +1,225 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newGossipController returns a controller with n connections that are never
+// dialed, so whatever is sent to them stays in their SendChannel.
+func newGossipController(n int) *Controller {
+	c := new(Controller)
+	c.ToNetwork = make(chan Parcel, 100)
+	c.FromNetwork = make(chan Parcel, 100)
+	c.connections = make(map[string]Connection)
+	c.gossipCache = NewGossipCache()
+	for i := 0; i < n; i++ {
+		connection := Connection{SendChannel: make(chan interface{}, 100)}
+		connection.peer.Hash = fmt.Sprintf("peer%d", i)
+		connection.metrics = new(connectionMetrics)
+		connection.metrics.Version = ProtocolVersion
+		c.connections[connection.peer.Hash] = connection
+	}
+	return c
+}
+
+// sent returns the parcels sent to each connection, by type.
+func sent(c *Controller) map[ParcelCommandType][]Parcel {
+	answer := make(map[ParcelCommandType][]Parcel)
+	for _, connection := range c.connections {
+		for 0 < len(connection.SendChannel) {
+			parcel := (<-connection.SendChannel).(ConnectionParcel).parcel
+			answer[parcel.Header.Type] = append(answer[parcel.Header.Type], parcel)
+		}
+	}
+	return answer
+}
+
+func TestGossipFanout(t *testing.T) {
+	defer func(fanout int) { GossipFanout = fanout }(GossipFanout)
+	GossipFanout = 2
+
+	c := newGossipController(6)
+	payload := []byte("a broadcast")
+	c.ToNetwork <- *NewParcel(CurrentNetwork, payload)
+	c.route()
+
+	parcels := sent(c)
+	if len(parcels[TypeMessage]) != 2 {
+		t.Errorf("Broadcast went in full to %d peers, expected 2", len(parcels[TypeMessage]))
+	}
+	if len(parcels[TypeInventory]) != 4 {
+		t.Fatalf("Broadcast was announced to %d peers, expected 4", len(parcels[TypeInventory]))
+	}
+	hash := sha256.Sum256(payload)
+	for _, parcel := range parcels[TypeInventory] {
+		if !bytes.Equal(parcel.Payload, hash[:]) {
+			t.Errorf("Inventory %x, expected %x", parcel.Payload, hash)
+		}
+	}
+
+	// A peer that gets the inventory asks for the payload, and we send it.
+	connection := c.connections["peer0"]
+	c.handleGetData(parcels[TypeInventory][0], connection)
+	parcels = sent(c)
+	if len(parcels[TypeMessage]) != 1 || !bytes.Equal(parcels[TypeMessage][0].Payload, payload) {
+		t.Errorf("Get data was answered with %+v", parcels[TypeMessage])
+	}
+}
+
+func TestGossipFlood(t *testing.T) {
+	defer func(fanout int) { GossipFanout = fanout }(GossipFanout)
+	GossipFanout = 0
+
+	c := newGossipController(6)
+	c.ToNetwork <- *NewParcel(CurrentNetwork, []byte("a broadcast"))
+	c.route()
+
+	parcels := sent(c)
+	if len(parcels[TypeMessage]) != 6 || len(parcels[TypeInventory]) != 0 {
+		t.Errorf("Flooding sent %d messages and %d inventories, expected 6 and 0", len(parcels[TypeMessage]), len(parcels[TypeInventory]))
+	}
+}
+
+func TestGossipOldPeers(t *testing.T) {
+	defer func(fanout int) { GossipFanout = fanout }(GossipFanout)
+	GossipFanout = 1
+
+	c := newGossipController(4)
+	for _, peerHash := range []string{"peer1", "peer2", "peer3"} {
+		c.connections[peerHash].metrics.Version = GossipVersion - 1
+	}
+	c.ToNetwork <- *NewParcel(CurrentNetwork, []byte("a broadcast"))
+	c.route()
+
+	for _, peerHash := range []string{"peer1", "peer2", "peer3"} {
+		connection := c.connections[peerHash]
+		if len(connection.SendChannel) != 1 {
+			t.Fatalf("%s was sent %d parcels, expected 1", peerHash, len(connection.SendChannel))
+		}
+		parcel := (<-connection.SendChannel).(ConnectionParcel).parcel
+		if parcel.Header.Type != TypeMessage {
+			t.Errorf("%s is on an old version, but was sent a %s", peerHash, parcel.MessageType())
+		}
+	}
+}
+
+func TestGossipMinVersion(t *testing.T) {
+	defer func(fanout int) { GossipFanout = fanout }(GossipFanout)
+	GossipFanout = 1
+
+	c := newGossipController(4)
+	c.connections["peer0"].metrics.Version = DigestVersion - 1
+	parcel := NewParcel(CurrentNetwork, []byte("a digest"))
+	parcel.Header.MinVersion = DigestVersion
+	c.ToNetwork <- *parcel
+	parcel.Header.TargetPeer = "peer0"
+	c.ToNetwork <- *parcel
+	c.route()
+
+	if n := len(c.connections["peer0"].SendChannel); n != 0 {
+		t.Errorf("A peer on an older version was sent %d parcels", n)
+	}
+	parcels := sent(c)
+	if len(parcels[TypeMessage]) != 1 || len(parcels[TypeInventory]) != 2 {
+		t.Errorf("Sent %d messages and %d inventories, expected 1 and 2", len(parcels[TypeMessage]), len(parcels[TypeInventory]))
+	}
+
+	// Nor is it sent the payload if it asks for it.
+	c.handleGetData(parcels[TypeInventory][0], c.connections["peer0"])
+	if n := len(c.connections["peer0"].SendChannel); n != 0 {
+		t.Errorf("A peer on an older version was sent the payload")
+	}
+}
+
+func TestGossipNotBackToSender(t *testing.T) {
+	defer func(fanout int) { GossipFanout = fanout }(GossipFanout)
+	GossipFanout = 0
+
+	c := newGossipController(3)
+	payload := []byte("from a peer")
+	c.handleParcelReceive(ConnectionParcel{parcel: *NewParcel(CurrentNetwork, payload)}, "peer1", c.connections["peer1"])
+	<-c.FromNetwork
+
+	// The application broadcasts what it got on.
+	c.ToNetwork <- *NewParcel(CurrentNetwork, payload)
+	c.route()
+	if 0 < len(c.connections["peer1"].SendChannel) {
+		t.Errorf("Broadcast was sent back to the peer it came from")
+	}
+	if len(sent(c)[TypeMessage]) != 2 {
+		t.Errorf("Broadcast should go to the two other peers")
+	}
+}
+
+func TestGossipInventory(t *testing.T) {
+	c := newGossipController(2)
+	seen := []byte("seen")
+	c.gossipAdd(seen, "")
+	seenHash, unseenHash := sha256.Sum256(seen), sha256.Sum256([]byte("unseen"))
+
+	inventory := NewParcel(CurrentNetwork, append(seenHash[:], unseenHash[:]...))
+	inventory.Header.Type = TypeInventory
+	c.handleInventory(*inventory, c.connections["peer0"])
+	// Another peer announcing it too isn't asked, as the request is in flight.
+	c.handleInventory(*inventory, c.connections["peer1"])
+
+	parcels := sent(c)
+	if len(parcels[TypeGetData]) != 1 {
+		t.Fatalf("Sent %d get data requests, expected 1", len(parcels[TypeGetData]))
+	}
+	if !bytes.Equal(parcels[TypeGetData][0].Payload, unseenHash[:]) {
+		t.Errorf("Asked for %x, expected only %x", parcels[TypeGetData][0].Payload, unseenHash)
+	}
+}
+
+func TestGossipCache(t *testing.T) {
+	g := NewGossipCache()
+	now := time.Now()
+	first := [32]byte{1}
+	if !g.Request(first, now) || g.Request(first, now.Add(time.Second)) {
+		t.Errorf("What wasn't seen should be requested once while the request is in flight")
+	}
+	g.Expire(now.Add(GossipRequestTimeout))
+	if !g.Request(first, now.Add(GossipRequestTimeout)) {
+		t.Errorf("An expired request should be made again")
+	}
+
+	if g.Add(first, "first") != "first" || g.Add(first, "again") != "first" || g.Get(first) != "first" {
+		t.Errorf("What was seen first should be kept")
+	}
+	if g.Request(first, now) {
+		t.Errorf("What was seen should not be requested")
+	}
+	for i := 1; i <= GossipCacheSize; i++ {
+		g.Add([32]byte{byte(i), byte(i >> 8), 1}, i)
+	}
+	if g.Get(first) != nil {
+		t.Errorf("The least recently seen should have been dropped")
+	}
+}
+
+func TestJoinHashes(t *testing.T) {
+	hashes := make([][gossipHashSize]byte, MaxPayloadSize/gossipHashSize+1)
+	for i := range hashes {
+		hashes[i][0] = byte(i)
+	}
+	payloads := joinHashes(hashes)
+	if len(payloads) != 2 {
+		t.Fatalf("Got %d payloads, expected 2", len(payloads))
+	}
+	var split [][gossipHashSize]byte
+	for _, payload := range payloads {
+		split = append(split, splitHashes(payload)...)
+	}
+	if len(split) != len(hashes) || split[len(split)-1] != hashes[len(hashes)-1] {
+		t.Errorf("Hashes did not survive the round trip")
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/FactomProject/ed25519"
+)
+
+// The handshake runs as soon as a TCP connection is up, before any parcel is sent:
+//
+//   1. Both sides send a hello in the clear: the range of protocol versions they
+//      speak, their NodeID (for loopback protection), their ed25519 node key, the
+//      identity chain they claim (zero if none), and a fresh ephemeral P-256 key.
+//      The connection uses the highest version both sides speak.
+//   2. Both derive a shared secret from the ephemeral keys (ECDH), and from it and
+//      the hash of both hellos (the dialer's first) a key for each direction.
+//      Everything after this point is encrypted and authenticated (AES-GCM).
+//   3. Over the encrypted link, each side signs the hello hash with its node key.
+//   4. From NetworkVersion on, both sides then send the network they are on, the
+//      extension of the hello, and peers on another network are turned away.
+//      The hello itself is the same on every version, so a peer on an older
+//      version can still read it; its parcels carry its network, and are turned
+//      away if it is on another network.
+//
+// A peer that can't produce the signature does not hold the node key it claims,
+// and a man in the middle can't complete both key exchanges and both signatures,
+// nor talk the two sides down to an older version.
+// The identity chain is only a claim bound to the node key; it is up to the
+// application to decide if the key belongs to that identity.
+
+const (
+	ephemeralKeySize = 65 // Uncompressed P-256 point
+	helloSize        = 2 + 2 + 8 + ed25519.PublicKeySize + 32 + ephemeralKeySize
+
+	// Frames are at most this big before encryption.  Larger writes are split.
+	maxFrameSize = 64 * 1024
+)
+
+type hello struct {
+	Network    NetworkID // Sent in the extension from NetworkVersion on, not in the hello
+	Version    uint16    // Highest protocol version spoken
+	MinVersion uint16    // Lowest protocol version spoken
+	NodeID     uint64
+	NodeKey    [ed25519.PublicKeySize]byte
+	Identity   [32]byte
+	Ephemeral  [ephemeralKeySize]byte
+}
+
+func (h *hello) MarshalBinary() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, h.Version)
+	binary.Write(&buf, binary.BigEndian, h.MinVersion)
+	binary.Write(&buf, binary.BigEndian, h.NodeID)
+	buf.Write(h.NodeKey[:])
+	buf.Write(h.Identity[:])
+	buf.Write(h.Ephemeral[:])
+	return buf.Bytes()
+}
+
+func (h *hello) UnmarshalBinary(data []byte) error {
+	if len(data) != helloSize {
+		return fmt.Errorf("Handshake hello is %d bytes, expected %d", len(data), helloSize)
+	}
+	h.Version = binary.BigEndian.Uint16(data)
+	h.MinVersion = binary.BigEndian.Uint16(data[2:])
+	h.NodeID = binary.BigEndian.Uint64(data[4:])
+	data = data[12:]
+	copy(h.NodeKey[:], data)
+	data = data[ed25519.PublicKeySize:]
+	copy(h.Identity[:], data)
+	data = data[32:]
+	copy(h.Ephemeral[:], data)
+	return nil
+}
+
+// identityChain returns the claimed identity chain as hex, or "" if none.
+func (h *hello) identityChain() string {
+	if h.Identity == [32]byte{} {
+		return ""
+	}
+	return hex.EncodeToString(h.Identity[:])
+}
+
+// negotiateVersion returns the highest protocol version both hellos speak.
+func negotiateVersion(ours *hello, theirs *hello) (uint16, error) {
+	version := ours.Version
+	if theirs.Version < version {
+		version = theirs.Version
+	}
+	if version < ours.MinVersion || version < theirs.MinVersion || theirs.MinVersion > theirs.Version {
+		return 0, fmt.Errorf("Peer speaks protocol versions %d to %d, we speak %d to %d", theirs.MinVersion, theirs.Version, ours.MinVersion, ours.Version)
+	}
+	return version, nil
+}
+
+// handshake authenticates us with key and identity (hex, may be "") to the peer
+// on conn and the peer to us, provided the peer is on network.  It returns the
+// encrypted link to the peer, the hello the peer sent, and the protocol version
+// to use.  dialer is true on the side that opened the connection.
+func handshake(conn net.Conn, dialer bool, network NetworkID, key *[ed25519.PrivateKeySize]byte, identity string, nodeID uint64) (*secureConn, *hello, uint16, error) {
+	return handshakeVersion(conn, dialer, network, key, identity, nodeID, ProtocolVersion)
+}
+
+// handshakeVersion is handshake, speaking at most maxVersion, as an older peer
+// would.
+func handshakeVersion(conn net.Conn, dialer bool, network NetworkID, key *[ed25519.PrivateKeySize]byte, identity string, nodeID uint64, maxVersion uint16) (*secureConn, *hello, uint16, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	curve := elliptic.P256()
+	ephemeral, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	ours := new(hello)
+	ours.Network = network
+	ours.Version = maxVersion
+	ours.MinVersion = ProtocolVersionMinimum
+	ours.NodeID = nodeID
+	ours.NodeKey = *ed25519.GetPublicKey(key)
+	if id, err := hex.DecodeString(identity); err == nil && len(id) == len(ours.Identity) {
+		copy(ours.Identity[:], id)
+	}
+	copy(ours.Ephemeral[:], elliptic.Marshal(curve, x, y))
+
+	data := make([]byte, helloSize)
+	err = exchange(conn, ours.MarshalBinary(), data)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	theirs := new(hello)
+	err = theirs.UnmarshalBinary(data)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	version, err := negotiateVersion(ours, theirs)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if theirs.NodeID == nodeID {
+		return nil, nil, 0, fmt.Errorf("Connected to ourselves")
+	}
+
+	px, py := elliptic.Unmarshal(curve, theirs.Ephemeral[:])
+	if px == nil {
+		return nil, nil, 0, fmt.Errorf("Peer sent an invalid ephemeral key")
+	}
+	sx, _ := curve.ScalarMult(px, py, ephemeral)
+	secret := make([]byte, 32)
+	sxBytes := sx.Bytes()
+	copy(secret[len(secret)-len(sxBytes):], sxBytes)
+
+	first, second := ours, theirs
+	if !dialer {
+		first, second = theirs, ours
+	}
+	transcript := sha256.Sum256(append(first.MarshalBinary(), second.MarshalBinary()...))
+
+	sendKey := deriveKey(secret, "dialer", transcript[:])
+	recvKey := deriveKey(secret, "listener", transcript[:])
+	if !dialer {
+		sendKey, recvKey = recvKey, sendKey
+	}
+	secure, err := newSecureConn(conn, sendKey, recvKey)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	// Prove we hold our node key, and check they hold theirs.
+	sig := ed25519.Sign(key, authMessage(dialer, transcript[:]))
+	theirSig := new([ed25519.SignatureSize]byte)
+	err = exchange(secure, sig[:], theirSig[:])
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if !ed25519.Verify(&theirs.NodeKey, authMessage(!dialer, transcript[:]), theirSig) {
+		return nil, nil, 0, fmt.Errorf("Peer failed to prove it holds node key %x", theirs.NodeKey)
+	}
+
+	if NetworkVersion <= version {
+		var out, in [4]byte
+		binary.BigEndian.PutUint32(out[:], uint32(network))
+		err = exchange(secure, out[:], in[:])
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		theirs.Network = NetworkID(binary.BigEndian.Uint32(in[:]))
+		if theirs.Network != network {
+			return nil, nil, 0, fmt.Errorf("Peer is on network %s, we are on %s", theirs.Network.String(), network.String())
+		}
+	}
+	return secure, theirs, version, nil
+}
+
+// exchange sends out and reads len(in) bytes at the same time, as both sides of
+// the handshake write before they read.
+func exchange(rw io.ReadWriter, out []byte, in []byte) error {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(out)
+		errs <- err
+	}()
+	_, err := io.ReadFull(rw, in)
+	if werr := <-errs; err == nil {
+		err = werr
+	}
+	return err
+}
+
+func deriveKey(secret []byte, role string, transcript []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("factom p2p key " + role))
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+func authMessage(dialer bool, transcript []byte) []byte {
+	role := "listener"
+	if dialer {
+		role = "dialer"
+	}
+	return append([]byte("factom p2p auth "+role), transcript...)
+}
+
+// secureConn encrypts everything written to it into length prefixed frames, and
+// decrypts the frames read from it.  One goroutine may write while another
+// reads.
+type secureConn struct {
+	conn      net.Conn
+	send      cipher.AEAD
+	recv      cipher.AEAD
+	sendCount uint64 // Frames sent, used as the nonce
+	recvCount uint64 // Frames received, used as the nonce
+	buffer    []byte // Decrypted bytes not read yet
+}
+
+func newSecureConn(conn net.Conn, sendKey []byte, recvKey []byte) (*secureConn, error) {
+	s := new(secureConn)
+	s.conn = conn
+	var err error
+	s.send, err = newAEAD(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	s.recv, err = newAEAD(recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(aead cipher.AEAD, count uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], count)
+	return n
+}
+
+func (s *secureConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		sealed := s.send.Seal(nil, nonce(s.send, s.sendCount), chunk, nil)
+		s.sendCount++
+
+		frame := make([]byte, 4, 4+len(sealed))
+		binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+		frame = append(frame, sealed...)
+		if _, err := s.conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (s *secureConn) Read(p []byte) (int, error) {
+	for len(s.buffer) == 0 {
+		err := s.readFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buffer)
+	s.buffer = s.buffer[n:]
+	return n, nil
+}
+
+func (s *secureConn) readFrame() error {
+	var size [4]byte
+	if _, err := io.ReadFull(s.conn, size[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length > uint32(maxFrameSize+s.recv.Overhead()) {
+		return fmt.Errorf("Frame of %d bytes is too large", length)
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(s.conn, sealed); err != nil {
+		return err
+	}
+	plain, err := s.recv.Open(nil, nonce(s.recv, s.recvCount), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("Frame failed authentication: %v", err)
+	}
+	s.recvCount++
+	s.buffer = plain
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/FactomProject/ed25519"
+)
+
+type handshakeResult struct {
+	secure  *secureConn
+	theirs  *hello
+	version uint16
+	err     error
+}
+
+func newKey(t *testing.T) *[ed25519.PrivateKeySize]byte {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return key
+}
+
+func TestHandshake(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	dialerKey, listenerKey := newKey(t), newKey(t)
+	identity := strings.Repeat("88", 32)
+
+	results := make(chan handshakeResult, 1)
+	go func() {
+		secure, theirs, version, err := handshake(b, false, TestNet, listenerKey, "", 2)
+		results <- handshakeResult{secure, theirs, version, err}
+	}()
+	dialer, theirs, version, err := handshake(a, true, TestNet, dialerKey, identity, 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	listener := <-results
+	if listener.err != nil {
+		t.Fatalf("%v", listener.err)
+	}
+
+	if version != ProtocolVersion || listener.version != ProtocolVersion {
+		t.Errorf("Negotiated versions %d and %d, expected %d", version, listener.version, ProtocolVersion)
+	}
+	if theirs.NodeKey != *ed25519.GetPublicKey(listenerKey) || theirs.identityChain() != "" {
+		t.Errorf("Dialer got the wrong peer: %x %s", theirs.NodeKey, theirs.identityChain())
+	}
+	if listener.theirs.NodeKey != *ed25519.GetPublicKey(dialerKey) || listener.theirs.identityChain() != identity {
+		t.Errorf("Listener got the wrong peer: %x %s", listener.theirs.NodeKey, listener.theirs.identityChain())
+	}
+
+	// Data goes both ways, including writes bigger than a frame.
+	big := make([]byte, maxFrameSize*2+100)
+	rand.Read(big)
+	for _, data := range [][]byte{[]byte("hello"), big} {
+		go io.CopyN(listener.secure, listener.secure, int64(len(data)))
+		got := make([]byte, len(data))
+		err = exchange(dialer, data, got)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Echo mismatch on %d bytes", len(data))
+		}
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	ours := &hello{Version: 4, MinVersion: 2}
+	tests := []struct {
+		min, max uint16
+		version  uint16 // 0 if they can't agree
+	}{
+		{2, 4, 4},
+		{1, 3, 3},
+		{4, 6, 4},
+		{1, 1, 0},
+		{5, 6, 0},
+		{3, 2, 0},
+	}
+	for _, test := range tests {
+		version, err := negotiateVersion(ours, &hello{Version: test.max, MinVersion: test.min})
+		if test.version == 0 {
+			if err == nil {
+				t.Errorf("Versions %d to %d should not agree with ours", test.min, test.max)
+			}
+		} else if err != nil || version != test.version {
+			t.Errorf("Versions %d to %d negotiated %d, expected %d", test.min, test.max, version, test.version)
+		}
+	}
+}
+
+func TestHandshakeRejectsVersion(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	go func() {
+		h := new(hello)
+		h.Network = TestNet
+		h.Version = ProtocolVersionMinimum - 1
+		h.MinVersion = ProtocolVersionMinimum - 1
+		h.NodeID = 2
+		in := make([]byte, helloSize)
+		exchange(b, h.MarshalBinary(), in)
+	}()
+	_, _, _, err := handshake(a, true, TestNet, newKey(t), "", 1)
+	if err == nil {
+		t.Errorf("Handshake with a peer on an old version should fail")
+	}
+}
+
+func TestHandshakeRejectsNetwork(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	go handshake(b, false, MainNet, newKey(t), "", 2)
+	_, _, _, err := handshake(a, true, TestNet, newKey(t), "", 1)
+	if err == nil || !strings.Contains(err.Error(), "MainNet") {
+		t.Errorf("Handshake with a peer on another network should fail, got %v", err)
+	}
+}
+
+func TestHandshakeOlderPeer(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	// A peer from before the network was in the handshake still connects.
+	dialerKey, listenerKey := newKey(t), newKey(t)
+	results := make(chan handshakeResult, 1)
+	go func() {
+		secure, theirs, version, err := handshakeVersion(b, false, TestNet, listenerKey, "", 2, NetworkVersion-1)
+		results <- handshakeResult{secure, theirs, version, err}
+	}()
+	_, _, version, err := handshake(a, true, TestNet, dialerKey, "", 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	listener := <-results
+	if listener.err != nil {
+		t.Fatalf("%v", listener.err)
+	}
+	if version != NetworkVersion-1 || listener.version != NetworkVersion-1 {
+		t.Errorf("Negotiated versions %d and %d, expected %d", version, listener.version, NetworkVersion-1)
+	}
+}
+
+func TestHandshakeRejectsLoopback(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	key := newKey(t)
+	go handshake(b, false, TestNet, key, "", 1)
+	_, _, _, err := handshake(a, true, TestNet, key, "", 1)
+	if err == nil {
+		t.Errorf("Handshake with ourselves should fail")
+	}
+}
+
+func TestSecureConnRejectsTampering(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	key := bytes.Repeat([]byte{1}, 32)
+	reader, err := newSecureConn(b, key, key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Encrypt a frame into a buffer, flip a bit, and send it on.
+	var buf bytes.Buffer
+	writer, _ := newSecureConn(&bufConn{buf: &buf}, key, key)
+	writer.Write([]byte("some parcel"))
+	frame := buf.Bytes()
+	frame[len(frame)-1] ^= 1
+	go a.Write(frame)
+
+	_, err = reader.Read(make([]byte, 100))
+	if err == nil {
+		t.Errorf("A tampered frame should fail authentication")
+	}
+}
+
+// bufConn is a net.Conn that writes into a buffer.
+type bufConn struct {
+	net.Conn
+	buf *bytes.Buffer
+}
+
+func (b *bufConn) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
@@ -18,18 +18,20 @@ type Parcel struct {
 	Payload []byte
 }
 
-// ParcelHeaderSize is the number of bytes in a parcel header
-const ParcelHeaderSize = 28
-
+// ParcelHeader holds the wire header (see wire.go) and, after it, fields local to this node.
 type ParcelHeader struct {
 	Network    NetworkID         // 4 bytes - the network we are on (eg testnet, main net, etc.)
 	Version    uint16            // 2 bytes - the version of the protocol we are running.
 	Type       ParcelCommandType // 2 bytes - network level commands (eg: ping/pong)
 	Length     uint32            // 4 bytes - length of the payload (that follows this header) in bytes
-	TargetPeer string            // ? bytes - "" or nil for broadcast, otherwise the destination peer's hash.
 	Crc32      uint32            // 4 bytes - data integrity hash (of the payload itself.)
-	Timestamp  time.Time
-	NodeID     uint64
+	TargetPeer string            // Not sent - "" or nil for broadcast, otherwise the destination (or source) peer's hash.
+	Timestamp  time.Time         // Not sent - time the parcel was received
+	NodeID     uint64            // Not sent
+	Class      MessageClass      // Not sent - priority class the parcel is queued in, see queues.go
+	MinVersion uint16            // Not sent - peers that negotiated an earlier version aren't sent the parcel
+	// Set when the parcel is received, from the handshake with the peer that sent it:
+	PeerKey string // Hex ed25519 key of the peer
 }
 
 type ParcelCommandType uint16
@@ -43,6 +45,8 @@ const ( // iota is reset to 0
 	TypePeerResponse                          // "Here's some peers I know about."
 	TypeAlert                                 // network wide alerts (used in bitcoin to indicate criticalities)
 	TypeMessage                               // Application level message
+	TypeInventory                             // "I have these messages" (gossip.go)
+	TypeGetData                               // "Please send me these messages" (gossip.go)
 )
 
 // CommandStrings is a Map of command ids to strings for easy printing of network comands
@@ -54,6 +58,8 @@ var CommandStrings = map[ParcelCommandType]string{
 	TypePeerResponse: "Peer Response", // "Here's some peers I know about."
 	TypeAlert:        "Alert",         // network wide alerts (used in bitcoin to indicate criticalities)
 	TypeMessage:      "Message",       // Application level message
+	TypeInventory:    "Inventory",     // "I have these messages"
+	TypeGetData:      "Get Data",      // "Please send me these messages"
 }
 
 // MaxPayloadSize is the maximum bytes a message can be at the networking level.
@@ -95,6 +101,7 @@ func (p *ParcelHeader) Print() {
 	debug("parcel", "\t CRC32:\t%d", p.Crc32)
 	debug("parcel", "\t Timestamp:\t%d", p.Timestamp)
 	debug("parcel", "\t NodeID:\t%d", p.NodeID)
+	debug("parcel", "\t PeerKey:\t%s", p.PeerKey)
 }
 
 func (p *Parcel) Print() {
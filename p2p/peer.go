@@ -7,18 +7,27 @@ package p2p
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"fmt"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Data structures and functions related to peers (eg other nodes in the network)
 
 type Peer struct {
-	QualityScore int32  // 0 is neutral quality, negative is a bad peer.
-	Address      string // Must be in form of x.x.x.x:p
-	Hash         string
-	Location     uint32 // IP address as an int.
-	Type         uint8
+	QualityScore  int32  // 0 is neutral quality, negative is a bad peer.
+	Address       string // host:port as returned by NormalizeAddress.  The host may be IPv4, IPv6 or a name.
+	Hash          string
+	Group         string // Network group of the address, see networkGroup()
+	Type          uint8
+	NodeKey       string // Hex ed25519 key the peer proved it holds in the handshake, "" until connected
+	IdentityChain string // Hex identity chain the peer claimed in the handshake, "" if none
+
+	QualityDecayed time.Time // When QualityScore was last decayed towards 0
+	BannedUntil    time.Time // The peer is banned until then; zero if it isn't
+	LastContact    time.Time // When we last got a valid parcel from the peer; zero if never
 }
 
 const ( // iota is reset to 0
@@ -27,43 +36,70 @@ const ( // iota is reset to 0
 )
 
 func (p *Peer) Init(address string, quality int, peerType uint8) *Peer {
-	p.Address = address
+	normalized, err := NormalizeAddress(address)
+	if nil != err {
+		logerror("peer", "Peer.Init() bad address %s: %+v", address, err)
+		normalized = address
+	}
+	p.Address = normalized
 	p.QualityScore = 0 // start at zero, zero is neutral, negative is a bad peer, positive is a good peer.
-	p.Hash = PeerHashFromAddress(address)
+	p.Hash = PeerHashFromAddress(normalized)
 	p.Type = peerType
-	p.Location = p.locationFromAddress()
+	p.Group = networkGroup(normalized)
 	return p
 }
 
-// BUGBUG Hadn't considered IPV6 addresses.
-// BUGBUG Need to audit all the net code to check IPv6 addresses
-// Here's an IPv6 conversion:
-// Ref: http://stackoverflow.com/questions/23297141/golang-net-ip-to-ipv6-from-mysql-as-decimal39-0-conversion
-// func ipv6ToInt(IPv6Addr net.IP) *big.Int {
-//     IPv6Int := big.NewInt(0)
-//     IPv6Int.SetBytes(IPv6Addr)
-//     return IPv6Int
-// }
-// Problem is we're working wiht string addresses, may never have made a connection.
-// BUGBUG - we might have a DNS address, not iP address and need to resolve it!
-// locationFromAddress converts the peers address into a uint32 "location" numeric
-func (p *Peer) locationFromAddress() uint32 {
-	// Split out the port
-	ip_port := strings.Split(p.Address, ":")
-	// Split the IPv4 octets
-	octets := strings.Split(ip_port[0], ".")
-	// Turn into uint32
-	var location uint32
-	b0, _ := strconv.Atoi(octets[0])
-	b1, _ := strconv.Atoi(octets[1])
-	b2, _ := strconv.Atoi(octets[2])
-	b3, _ := strconv.Atoi(octets[3])
-	location += uint32(b0) << 24
-	location += uint32(b1) << 16
-	location += uint32(b2) << 8
-	location += uint32(b3)
-	debug("peer", "Peer: %s with ip_port: %+v and octets: %+v has Location: %d", p.Hash, ip_port, octets, location)
-	return location
+// NormalizeAddress returns an address in the one form we hash and save it in:
+// host:port, with IPv6 hosts in brackets and in their shortest form, IPv4 mapped
+// IPv6 hosts as IPv4, and host names in lower case.  Host names are not resolved
+// here, as that would block; they are resolved when the peer is dialed.
+func NormalizeAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(address))
+	if nil != err {
+		return "", err
+	}
+	if "" == host || "" == port {
+		return "", fmt.Errorf("Peer address %s needs both a host and a port", address)
+	}
+	if number, err := strconv.Atoi(port); nil != err || number < 1 || 65535 < number {
+		return "", fmt.Errorf("Peer address %s has a bad port", address)
+	}
+	if ip := net.ParseIP(host); nil != ip {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// hostOf returns the host of an address, without the port.
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if nil != err {
+		return address
+	}
+	return host
+}
+
+// networkGroup returns the group an address belongs to, so we can spread our
+// connections over many networks rather than many hosts on one network, which
+// may all be run by the same party.  The group is the /16 of an IPv4 address,
+// the /48 of an IPv6 address, or the domain (the last two labels) of a host
+// name.
+func networkGroup(address string) string {
+	host := hostOf(address)
+	ip := net.ParseIP(host)
+	switch {
+	case nil != ip && nil != ip.To4():
+		return (&net.IPNet{IP: ip.To4().Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	case nil != ip:
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+	}
+	labels := strings.Split(host, ".")
+	if 2 < len(labels) {
+		labels = labels[len(labels)-2:]
+	}
+	return strings.Join(labels, ".")
 }
 
 func PeerHashFromAddress(address string) string {
@@ -87,6 +123,41 @@ func (p *Peer) demerit() {
 	}
 }
 
+// IsBanned returns true if the peer is banned at the given time.
+func (p Peer) IsBanned(now time.Time) bool {
+	return now.Before(p.BannedUntil)
+}
+
+// ban bans the peer for BanDuration from now.
+func (p *Peer) ban(now time.Time) {
+	p.BannedUntil = now.Add(BanDuration)
+	note("peer", "Peer %s banned until %s with quality score %d", p.Address, p.BannedUntil, p.QualityScore)
+}
+
+// decayQuality halves the quality score for every QualityHalfLife since it was
+// last decayed, so old merits and demerits count for less.  The score of a
+// banned peer is left alone while the ban lasts, and reset once it is over.
+func (p *Peer) decayQuality(now time.Time) {
+	if !p.BannedUntil.IsZero() {
+		if p.IsBanned(now) {
+			return
+		}
+		p.BannedUntil = time.Time{}
+		p.QualityScore = 0
+		p.QualityDecayed = now
+	}
+	if p.QualityDecayed.IsZero() || now.Before(p.QualityDecayed) {
+		p.QualityDecayed = now
+		return
+	}
+	halvings := now.Sub(p.QualityDecayed) / QualityHalfLife
+	if 30 < halvings {
+		halvings = 30
+	}
+	p.QualityScore = p.QualityScore / (1 << uint(halvings))
+	p.QualityDecayed = p.QualityDecayed.Add(halvings * QualityHalfLife)
+}
+
 // sort.Sort interface implementation
 type PeerQualitySort []Peer
 
@@ -99,16 +170,3 @@ func (p PeerQualitySort) Swap(i, j int) {
 func (p PeerQualitySort) Less(i, j int) bool {
 	return p[i].QualityScore < p[j].QualityScore
 }
-
-// sort.Sort interface implementation
-type PeerDistanceSort []Peer
-
-func (p PeerDistanceSort) Len() int {
-	return len(p)
-}
-func (p PeerDistanceSort) Swap(i, j int) {
-	p[i], p[j] = p[j], p[i]
-}
-func (p PeerDistanceSort) Less(i, j int) bool {
-	return p[i].Location < p[j].Location
-}
@@ -0,0 +1,211 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDecayQuality(t *testing.T) {
+	start := time.Now()
+	peer := new(Peer).Init("10.0.0.1:8108", 0, RegularPeer)
+	peer.QualityScore = -100
+	peer.decayQuality(start)
+
+	peer.decayQuality(start.Add(QualityHalfLife / 2))
+	if peer.QualityScore != -100 {
+		t.Errorf("Score decayed to %d before a half life passed", peer.QualityScore)
+	}
+	peer.decayQuality(start.Add(QualityHalfLife))
+	if peer.QualityScore != -50 {
+		t.Errorf("Score decayed to %d after a half life, expected -50", peer.QualityScore)
+	}
+	peer.decayQuality(start.Add(3 * QualityHalfLife))
+	if peer.QualityScore != -12 {
+		t.Errorf("Score decayed to %d after three half lives, expected -12", peer.QualityScore)
+	}
+}
+
+func TestBanExpires(t *testing.T) {
+	now := time.Now()
+	peer := new(Peer).Init("10.0.0.1:8108", 0, RegularPeer)
+	peer.QualityScore = BannedQualityScore
+	peer.ban(now)
+
+	peer.decayQuality(now.Add(BanDuration / 2))
+	if !peer.IsBanned(now.Add(BanDuration/2)) || peer.QualityScore != BannedQualityScore {
+		t.Errorf("Ban should last BanDuration, with the score left alone")
+	}
+	later := now.Add(BanDuration + time.Second)
+	peer.decayQuality(later)
+	if peer.IsBanned(later) || peer.QualityScore != 0 {
+		t.Errorf("Ban should be lifted and the score reset after BanDuration, score is %d", peer.QualityScore)
+	}
+}
+
+func TestBansPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "peers.json")
+
+	now := time.Now()
+	d := new(Discovery).Init(file)
+	banned := d.GetPeerByAddress("10.0.0.1:8108")
+	d.GetPeerByAddress("10.0.0.2:8108")
+	d.banPeer(banned.Hash, now)
+	d.SavePeers()
+
+	d = new(Discovery).Init(file)
+	if !d.knownPeers[banned.Hash].IsBanned(now) {
+		t.Errorf("Ban was not saved in %s", file)
+	}
+	if !d.isBannedAddress("10.0.0.1:51234", now) {
+		t.Errorf("A banned peer dialing in from another port should be recognised")
+	}
+	for _, peer := range d.GetStartupPeers() {
+		if peer.Hash == banned.Hash {
+			t.Errorf("Banned peer offered as a startup peer")
+		}
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1:8108":                 "10.0.0.1:8108",
+		"[2001:DB8:0:0::1]:8108":        "[2001:db8::1]:8108",
+		"[::ffff:10.0.0.1]:8108":        "10.0.0.1:8108",
+		"Seed.Factom.ORG.:8108":         "seed.factom.org:8108",
+		" [2001:db8:1:2:3:4:5:6]:8108 ": "[2001:db8:1:2:3:4:5:6]:8108",
+	}
+	for address, expected := range cases {
+		normalized, err := NormalizeAddress(address)
+		if err != nil || normalized != expected {
+			t.Errorf("NormalizeAddress(%q) = %q, %v, expected %q", address, normalized, err, expected)
+		}
+	}
+	for _, address := range []string{"10.0.0.1", "2001:db8::1:8108", ":8108", "10.0.0.1:"} {
+		if _, err := NormalizeAddress(address); err == nil {
+			t.Errorf("NormalizeAddress(%q) should fail", address)
+		}
+	}
+	if PeerHashFromAddress("[2001:db8::1]:8108") != new(Peer).Init("[2001:DB8::0:1]:8108", 0, RegularPeer).Hash {
+		t.Errorf("Two ways of writing one IPv6 address should hash the same")
+	}
+}
+
+func TestNetworkGroup(t *testing.T) {
+	cases := map[string]string{
+		"10.1.2.3:8108":             "10.1.0.0/16",
+		"[2001:db8:1:2::1]:8108":    "2001:db8:1::/48",
+		"[2001:db8:1:ffff::9]:8108": "2001:db8:1::/48",
+		"a.seed.factom.org:8108":    "factom.org",
+		"localhost:8108":            "localhost",
+	}
+	for address, expected := range cases {
+		if group := networkGroup(address); group != expected {
+			t.Errorf("networkGroup(%q) = %q, expected %q", address, group, expected)
+		}
+	}
+}
+
+func TestDiversePeers(t *testing.T) {
+	d := new(Discovery).Init(filepath.Join(os.TempDir(), "no-such-peers.json"))
+	for i := 0; i < 10; i++ {
+		d.GetPeerByAddress(fmt.Sprintf("10.0.0.%d:8108", i))
+	}
+	d.GetPeerByAddress("192.168.0.1:8108")
+	d.GetPeerByAddress("[2001:db8::1]:8108")
+	d.GetPeerByAddress("seed.factom.org:8108")
+
+	peers := []Peer{}
+	for _, peer := range d.knownPeers {
+		peers = append(peers, peer)
+	}
+	groups := map[string]bool{}
+	for _, peer := range d.diversePeers(peers, 4) {
+		groups[peer.Group] = true
+	}
+	if len(groups) != 4 {
+		t.Errorf("Four peers should come from four groups, got %v", groups)
+	}
+	if len(d.diversePeers(peers, 100)) != len(peers) {
+		t.Errorf("Asking for more peers than we know should return them all")
+	}
+}
+
+func TestLearnPeersNormalizes(t *testing.T) {
+	d := new(Discovery).Init(filepath.Join(os.TempDir(), "no-such-peers.json"))
+	if err := d.LearnPeers([]byte(`[{"Address":"[2001:DB8::1]:8108","Hash":"forged","Type":1}]`)); err != nil {
+		t.Fatal(err)
+	}
+	hash := PeerHashFromAddress("[2001:db8::1]:8108")
+	peer, present := d.knownPeers[hash]
+	if !present || peer.Group != "2001:db8::/48" || peer.Type != RegularPeer {
+		t.Errorf("Learned peer should be rehashed, grouped and regular, got %+v", d.knownPeers)
+	}
+}
+
+func TestPoisonedPeerResponse(t *testing.T) {
+	d := new(Discovery).Init(filepath.Join(os.TempDir(), "no-such-peers.json"))
+	bad := []string{
+		`[{"Address":"10.0.0.1:8108"},{"Address":"not an address"}]`,
+		`[{"Address":"10.0.0.1:99999"}]`,
+		`not json`,
+	}
+	for _, response := range bad {
+		if err := d.LearnPeers([]byte(response)); err == nil {
+			t.Errorf("Poisoned response %s was accepted", response)
+		}
+	}
+	peers := []Peer{}
+	for i := 0; i <= MaxPeersLearned; i++ {
+		peers = append(peers, Peer{Address: fmt.Sprintf("10.0.%d.%d:8108", i/256, i%256)})
+	}
+	tooMany, _ := json.Marshal(peers)
+	if err := d.LearnPeers(tooMany); err == nil {
+		t.Errorf("A response with more than MaxPeersLearned peers was accepted")
+	}
+	if len(d.knownPeers) != 0 {
+		t.Errorf("Nothing of a poisoned response should be learned, got %d peers", len(d.knownPeers))
+	}
+}
+
+func TestPeerSelection(t *testing.T) {
+	d := new(Discovery).Init(filepath.Join(os.TempDir(), "no-such-peers.json"))
+	now := time.Now()
+	add := func(address string, peerType uint8, lastContact time.Time) {
+		peer := new(Peer).Init(address, 0, peerType)
+		peer.LastContact = lastContact
+		d.UpdatePeer(*peer)
+	}
+	add("10.0.0.1:8108", SpecialPeer, now)
+	add("10.1.0.1:8108", RegularPeer, now.Add(-2*PeerShareMaxAge))
+	add("10.2.0.1:8108", RegularPeer, time.Time{})
+	for i := 0; i < 2*MaxPeersShared; i++ {
+		add(fmt.Sprintf("10.3.%d.1:8108", i), RegularPeer, now)
+	}
+
+	var shared []Peer
+	if err := json.Unmarshal(d.SharePeers(), &shared); err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != MaxPeersShared {
+		t.Errorf("Shared %d peers, expected MaxPeersShared", len(shared))
+	}
+	for _, peer := range shared {
+		if peer.Type == SpecialPeer || now.Sub(peer.LastContact) > PeerShareMaxAge {
+			t.Errorf("Shared special or stale peer %s", peer.Address)
+		}
+	}
+}
@@ -15,21 +15,38 @@ import (
 // Global variables for the p2p protocol
 var (
 	CurrentLoggingLevel                     = Verbose // Start at verbose because it takes a few seconds for the controller to adjust to what you set.
-	CurrentNetwork                          = TestNet
+	CurrentNetwork                          = TestNet // Set from Network in factomd.conf.  Parcels and peers from other networks are rejected.
 	NetworkStatusInterval     time.Duration = time.Second * 22
 	PingInterval              time.Duration = time.Second * 15
 	TimeBetweenRedials        time.Duration = time.Second * 20
 	MaxNumberOfRedialAttempts int           = 15
 	PeerSaveInterval          time.Duration = time.Second * 30
 	PeerRequestInterval       time.Duration = time.Second * 180
+	HandshakeTimeout          time.Duration = time.Second * 10
+	ManagementTimeout         time.Duration = time.Second * 5 // How long management calls like GetConnections wait on the controller
+	BanDuration               time.Duration = time.Hour * 24  // How long a peer stays banned.  Set from Peer.BanDuration in factomd.conf
+	QualityHalfLife           time.Duration = time.Hour       // Quality scores are halved this often, so peers can redeem themselves
 
-	MinumumQualityScore int32        = -200        // if a peer's score is less than this we ignore them.
+	MinumumQualityScore int32        = -200        // if a peer's score is less than this we ban them for BanDuration.
 	BannedQualityScore  int32        = -2147000000 // Used to ban a peer
 	CRCKoopmanTable     *crc32.Table = crc32.MakeTable(crc32.Koopman)
 
-	OnlySpecialPeers     bool   = false
-	NumberPeersToConnect int    = 12
-	NodeID               uint64 = 0 // Random number used for loopback protection
+	OnlySpecialPeers        bool          = false
+	NumberPeersToConnect    int           = 12
+	MaxPeersShared          int           = 32                 // Most peers we send in a peer response
+	MaxPeersLearned         int           = 64                 // A peer response with more peers than this is poisoned, and ignored
+	PeerShareMaxAge         time.Duration = time.Hour * 24 * 7 // We only share peers we have heard from this recently
+	PoisonedResponseDemerit int32         = 50                 // Taken off the quality score of a peer that sends a poisoned peer response
+	GossipFanout            int           = 4                  // Connections a broadcast is sent to in full, the rest get its hash.  0 floods every connection.
+	NodeID                  uint64        = 0                  // Random number used for loopback protection
+
+	// Send queues, see queues.go.  Upload limits are in bytes per second, 0 for no limit.
+	SendQueueDepth    int             = 5000 // Parcels each connection queues in each class before dropping them
+	PeerUploadLimit   int             = 0    // For each connection, over all classes
+	ClassUploadLimits [classCount]int        // For each class, over all connections
+
+	NodeKey           *[64]byte // ed25519 key this node authenticates with.  A random one is made if nil at Init.
+	NodeIdentityChain string    // Hex identity chain this node claims in the handshake, "" if none.
 	// Testing metrics
 	TotalMessagesRecieved uint64 = 0
 	TotalMessagesSent     uint64 = 0
@@ -37,9 +54,21 @@ var (
 
 const (
 	// ProtocolVersion is the latest version this package supports
-	ProtocolVersion uint16 = 01
+	// Version 1 sent gobs, version 2 the binary framing documented in wire.go,
+	// version 3 added the gossip inventory and get data parcels, version 4 the
+	// network to the handshake, as an extension of the hello, and version 5
+	// peers take the application's block digests.
+	ProtocolVersion uint16 = 05
 	// ProtocolVersionMinimum is the earliest version this package supports
-	ProtocolVersionMinimum uint16 = 01
+	ProtocolVersionMinimum uint16 = 02
+	// GossipVersion is the first version with the inventory and get data
+	// parcels.  Peers on an earlier version get every broadcast in full.
+	GossipVersion uint16 = 03
+	// NetworkVersion is the first version to send the network in the handshake.
+	NetworkVersion uint16 = 04
+	// DigestVersion is the first version whose peers take block digest
+	// messages.  Earlier peers don't know them, so aren't sent them.
+	DigestVersion uint16 = 05
 	// Don't think we need this.
 	// ProtocolCookie         uint32 = uint32([]bytes("Fact"))
 	// Used in generating message CRC values
@@ -52,27 +81,30 @@ const (
 // NetworkIdentifier represents the P2P network we are participating in (eg: test, nmain, etc.)
 type NetworkID uint32
 
-// Network indicators.
-// TODO JAYJAY - this should go to a higher level, like the application levle?
+// Network indicators.  Custom networks take theirs from CustomNetworkID in factomd.conf.
 const (
 	// MainNet represents the production network
 	MainNet NetworkID = 0xfeedbeef
 
 	// TestNet represents a testing network
 	TestNet NetworkID = 0xdeadbeef
+
+	// LocalNet represents a network of nodes on one machine, or one simulator
+	LocalNet NetworkID = 0xbeaded
 )
 
 // Map of network ids to strings for easy printing of network ID
 var NetworkIDStrings = map[NetworkID]string{
-	MainNet: "MainNet",
-	TestNet: "TestNet",
+	MainNet:  "MainNet",
+	TestNet:  "TestNet",
+	LocalNet: "LocalNet",
 }
 
 func (n *NetworkID) String() string {
 	if net, ok := NetworkIDStrings[*n]; ok {
 		return net
 	}
-	return fmt.Sprintf("Unknown NetworkID: %x", *n)
+	return fmt.Sprintf("CustomNet(%08x)", uint32(*n))
 }
 
 const ( // iota is reset to 0
@@ -94,6 +126,16 @@ var LoggingLevels = map[uint8]string{
 	Verbose:   "Verbose",   // Log everything
 }
 
+// LoggingLevelFromString returns the level with the given name, ignoring case.
+func LoggingLevelFromString(name string) (uint8, bool) {
+	for level, levelName := range LoggingLevels {
+		if strings.EqualFold(levelName, name) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
 func silence(component string, format string, v ...interface{}) {
 	log(Silence, component, format, v...)
 }
@@ -0,0 +1,186 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Send queues
+//
+// Each connection queues the parcels it is to send by MessageClass, and sends
+// from the highest priority queue that has a parcel and is within its upload
+// limits, so a backlog of catch-up replies doesn't hold up the acks and EOMs
+// behind it.  Upload limits are in bytes per second, and are kept for each
+// class across all connections (ClassUploadLimits) and for each connection
+// across all classes (PeerUploadLimit).  A parcel may go out as long as its
+// limits are not already spent, so a parcel larger than a second's allowance
+// still goes, and the next waits until the debt is paid off.
+
+// MessageClass is the priority class of a parcel.  Lower classes go first.  The
+// zero class is ClassConsensus, so parcels nobody classified are not held back.
+type MessageClass uint8
+
+const (
+	ClassConsensus   MessageClass = iota // Acks, EOMs, signatures, and the network's own pings and announcements
+	ClassTransaction                     // Commits, reveals and factoid transactions
+	ClassSync                            // Catch-up: block states and missing data
+	ClassDiscovery                       // Peer requests and responses
+	classCount
+)
+
+// MessageClassStrings is a map of message classes to strings for easy printing
+var MessageClassStrings = map[MessageClass]string{
+	ClassConsensus:   "Consensus",
+	ClassTransaction: "Transaction",
+	ClassSync:        "Sync",
+	ClassDiscovery:   "Discovery",
+}
+
+// ClassMetrics is what has gone through the send queues of one class, over all
+// connections.
+type ClassMetrics struct {
+	Class     string `json:"class"`
+	Queued    int64  `json:"queued"` // Parcels waiting to be sent now
+	Sent      uint64 `json:"sent"`
+	SentBytes uint64 `json:"sentbytes"`
+	Dropped   uint64 `json:"dropped"` // Parcels dropped because the queue was full, or the connection shut down
+}
+
+type classCounters struct {
+	queued    int64
+	sent      uint64
+	sentBytes uint64
+	dropped   uint64
+}
+
+var (
+	classTotals   [classCount]classCounters // Updated atomically, from every connection's goroutine
+	classLimiters [classCount]rateLimiter
+)
+
+// GetQueueMetrics returns the metrics of each class, in priority order.
+func GetQueueMetrics() []ClassMetrics {
+	answer := make([]ClassMetrics, classCount)
+	for class := range answer {
+		counters := &classTotals[class]
+		answer[class] = ClassMetrics{
+			Class:     MessageClassStrings[MessageClass(class)],
+			Queued:    atomic.LoadInt64(&counters.queued),
+			Sent:      atomic.LoadUint64(&counters.sent),
+			SentBytes: atomic.LoadUint64(&counters.sentBytes),
+			Dropped:   atomic.LoadUint64(&counters.dropped),
+		}
+	}
+	return answer
+}
+
+// parcelClass returns the class a parcel is sent in.  Only application messages
+// carry a class of their own.
+func parcelClass(parcel Parcel) MessageClass {
+	switch parcel.Header.Type {
+	case TypeMessage:
+		if parcel.Header.Class < classCount {
+			return parcel.Header.Class
+		}
+	case TypePeerRequest, TypePeerResponse:
+		return ClassDiscovery
+	}
+	return ClassConsensus
+}
+
+// rateLimiter is a token bucket holding up to a second's worth of bytes.  The
+// rate is passed in on each call, so changes to the limits take effect at once.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// ready returns true if there is anything left to spend at the given rate in
+// bytes per second, 0 meaning no limit.
+func (r *rateLimiter) ready(rate int, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tokens += now.Sub(r.last).Seconds() * float64(rate)
+	if r.last.IsZero() || float64(rate) < r.tokens {
+		r.tokens = float64(rate)
+	}
+	r.last = now
+	return 0 < r.tokens
+}
+
+// spend takes bytes sent off what is left, which may leave it in debt.
+func (r *rateLimiter) spend(rate int, bytes int) {
+	if rate <= 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tokens -= float64(bytes)
+}
+
+// sendQueues holds the parcels a connection has yet to send, by class.  It is
+// only used from the connection's runloop goroutine.
+type sendQueues struct {
+	queues  [classCount][]Parcel
+	limiter rateLimiter // For the connection as a whole, see PeerUploadLimit
+}
+
+// push queues a parcel, or drops it if its queue already holds SendQueueDepth
+// parcels.
+func (q *sendQueues) push(parcel Parcel) bool {
+	class := parcelClass(parcel)
+	if SendQueueDepth <= len(q.queues[class]) {
+		atomic.AddUint64(&classTotals[class].dropped, 1)
+		return false
+	}
+	q.queues[class] = append(q.queues[class], parcel)
+	atomic.AddInt64(&classTotals[class].queued, 1)
+	return true
+}
+
+// next takes the next parcel to send off its queue: the first one of the
+// highest priority class that is within its limits.  It returns false if there
+// is nothing to send, or nothing may be sent yet.
+func (q *sendQueues) next(now time.Time) (Parcel, bool) {
+	if !q.limiter.ready(PeerUploadLimit, now) {
+		return Parcel{}, false
+	}
+	for class := range q.queues {
+		queue := q.queues[class]
+		if 0 == len(queue) || !classLimiters[class].ready(ClassUploadLimits[class], now) {
+			continue
+		}
+		parcel := queue[0]
+		queue[0] = Parcel{} // Let the payload be collected
+		q.queues[class] = queue[1:]
+
+		bytes := ParcelHeaderSize + len(parcel.Payload)
+		q.limiter.spend(PeerUploadLimit, bytes)
+		classLimiters[class].spend(ClassUploadLimits[class], bytes)
+		counters := &classTotals[class]
+		atomic.AddInt64(&counters.queued, -1)
+		atomic.AddUint64(&counters.sent, 1)
+		atomic.AddUint64(&counters.sentBytes, uint64(bytes))
+		return parcel, true
+	}
+	return Parcel{}, false
+}
+
+// clear drops everything queued, when the connection shuts down.
+func (q *sendQueues) clear() {
+	for class := range q.queues {
+		dropped := len(q.queues[class])
+		atomic.AddInt64(&classTotals[class].queued, -int64(dropped))
+		atomic.AddUint64(&classTotals[class].dropped, uint64(dropped))
+		q.queues[class] = nil
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func classParcel(class MessageClass, size int) Parcel {
+	parcel := NewParcel(CurrentNetwork, make([]byte, size))
+	parcel.Header.Class = class
+	return *parcel
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	q := new(sendQueues)
+	q.push(classParcel(ClassSync, 10))
+	q.push(classParcel(ClassTransaction, 10))
+	q.push(classParcel(ClassConsensus, 10))
+	peerRequest := NewParcel(CurrentNetwork, []byte("Peer Request"))
+	peerRequest.Header.Type = TypePeerRequest
+	q.push(*peerRequest)
+
+	now := time.Now()
+	expected := []MessageClass{ClassConsensus, ClassTransaction, ClassSync, ClassDiscovery}
+	for _, class := range expected {
+		parcel, ok := q.next(now)
+		if !ok || parcelClass(parcel) != class {
+			t.Errorf("Sent %s, expected %s", MessageClassStrings[parcelClass(parcel)], MessageClassStrings[class])
+		}
+	}
+	if _, ok := q.next(now); ok {
+		t.Errorf("Nothing should be left to send")
+	}
+}
+
+func TestSendQueueClassLimit(t *testing.T) {
+	defer func(limit int) { ClassUploadLimits[ClassSync] = limit }(ClassUploadLimits[ClassSync])
+	ClassUploadLimits[ClassSync] = 1000
+	classLimiters[ClassSync] = rateLimiter{}
+
+	q := new(sendQueues)
+	q.push(classParcel(ClassSync, 2000))
+	q.push(classParcel(ClassSync, 10))
+	q.push(classParcel(ClassConsensus, 10))
+
+	now := time.Now()
+	if parcel, ok := q.next(now); !ok || parcelClass(parcel) != ClassConsensus {
+		t.Fatalf("Consensus should go first")
+	}
+	if parcel, ok := q.next(now); !ok || len(parcel.Payload) != 2000 {
+		t.Fatalf("A parcel over a second's allowance should still go")
+	}
+	if _, ok := q.next(now.Add(time.Second)); ok {
+		t.Errorf("Sync should wait until its debt is paid off")
+	}
+	if _, ok := q.next(now.Add(3 * time.Second)); !ok {
+		t.Errorf("Sync should go again once its debt is paid off")
+	}
+}
+
+func TestSendQueueDepth(t *testing.T) {
+	defer func(depth int) { SendQueueDepth = depth }(SendQueueDepth)
+	SendQueueDepth = 2
+
+	before := GetQueueMetrics()[ClassTransaction]
+	q := new(sendQueues)
+	for i := 0; i < 3; i++ {
+		q.push(classParcel(ClassTransaction, 10))
+	}
+	after := GetQueueMetrics()[ClassTransaction]
+	if after.Queued-before.Queued != 2 || after.Dropped-before.Dropped != 1 {
+		t.Errorf("Expected 2 queued and 1 dropped, got %d and %d", after.Queued-before.Queued, after.Dropped-before.Dropped)
+	}
+	q.clear()
+	if GetQueueMetrics()[ClassTransaction].Queued != before.Queued {
+		t.Errorf("Clearing the queues should leave nothing queued")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Wire format of a parcel, as sent over the encrypted link set up by the
+// handshake.  All integers are big endian.
+//
+//   Offset  Size  Field
+//        0     4  Network ID, which doubles as the magic number (eg: 0xfeedbeef for MainNet)
+//        4     2  Protocol version, as negotiated in the handshake
+//        6     2  Parcel type (TypePing, TypeMessage, ...)
+//        8     4  Payload length, at most MaxPayloadSize
+//       12     4  CRC32 of the payload, Koopman polynomial (CRCKoopmanTable)
+//       16     n  Payload
+//
+// The rest of the ParcelHeader (TargetPeer, Timestamp, NodeID and the peer's key
+// and identity) is local to a node and never sent.
+
+// ParcelHeaderSize is the number of bytes in a parcel header on the wire
+const ParcelHeaderSize = 16
+
+// MarshalBinary returns the parcel in the wire format.
+func (p *Parcel) MarshalBinary() ([]byte, error) {
+	if len(p.Payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("Payload of %d bytes is larger than the maximum %d", len(p.Payload), MaxPayloadSize)
+	}
+	data := make([]byte, ParcelHeaderSize, ParcelHeaderSize+len(p.Payload))
+	binary.BigEndian.PutUint32(data[0:], uint32(p.Header.Network))
+	binary.BigEndian.PutUint16(data[4:], p.Header.Version)
+	binary.BigEndian.PutUint16(data[6:], uint16(p.Header.Type))
+	binary.BigEndian.PutUint32(data[8:], p.Header.Length)
+	binary.BigEndian.PutUint32(data[12:], p.Header.Crc32)
+	return append(data, p.Payload...), nil
+}
+
+// UnmarshalBinary reads a parcel in the wire format.  The payload length must
+// match the data; the CRC is left for the caller to check.
+func (p *Parcel) UnmarshalBinary(data []byte) error {
+	if len(data) < ParcelHeaderSize {
+		return fmt.Errorf("Parcel of %d bytes is shorter than its header", len(data))
+	}
+	p.unmarshalHeader(data)
+	if int(p.Header.Length) != len(data)-ParcelHeaderSize {
+		return fmt.Errorf("Parcel says its payload is %d bytes, but it is %d", p.Header.Length, len(data)-ParcelHeaderSize)
+	}
+	p.Payload = append([]byte{}, data[ParcelHeaderSize:]...)
+	return nil
+}
+
+func (p *Parcel) unmarshalHeader(data []byte) {
+	p.Header.Network = NetworkID(binary.BigEndian.Uint32(data[0:]))
+	p.Header.Version = binary.BigEndian.Uint16(data[4:])
+	p.Header.Type = ParcelCommandType(binary.BigEndian.Uint16(data[6:]))
+	p.Header.Length = binary.BigEndian.Uint32(data[8:])
+	p.Header.Crc32 = binary.BigEndian.Uint32(data[12:])
+}
+
+// writeParcel writes the parcel to w in a single Write, so it goes out as one
+// encrypted frame where possible.
+func writeParcel(w io.Writer, p *Parcel) error {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readParcel reads the next parcel from r.  It fails on a payload larger than
+// MaxPayloadSize without reading it, as the stream can't be trusted after that.
+func readParcel(r io.Reader) (*Parcel, error) {
+	header := make([]byte, ParcelHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	p := new(Parcel)
+	p.unmarshalHeader(header)
+	if p.Header.Length > MaxPayloadSize {
+		return nil, fmt.Errorf("Parcel payload of %d bytes is larger than the maximum %d", p.Header.Length, MaxPayloadSize)
+	}
+	p.Payload = make([]byte, p.Header.Length)
+	if _, err := io.ReadFull(r, p.Payload); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestParcelWireFormat(t *testing.T) {
+	parcel := NewParcel(MainNet, []byte("Hello"))
+	parcel.Header.Type = TypePing
+	parcel.Header.TargetPeer = "not sent"
+	data, err := parcel.MarshalBinary()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []byte{
+		0xfe, 0xed, 0xbe, 0xef, // Network
+		0x00, byte(ProtocolVersion), // Version
+		0x00, byte(TypePing), // Type
+		0x00, 0x00, 0x00, 0x05, // Length
+	}
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, parcel.Header.Crc32)
+	expected = append(expected, crc...)
+	expected = append(expected, []byte("Hello")...)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Wire format is\n%x\nexpected\n%x", data, expected)
+	}
+
+	parcel2, err := readParcel(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if parcel2.Header.Network != MainNet || parcel2.Header.Type != TypePing || parcel2.Header.TargetPeer != "" ||
+		parcel2.Header.Crc32 != parcel.Header.Crc32 || string(parcel2.Payload) != "Hello" {
+		t.Errorf("Parcel did not decode: %+v", parcel2)
+	}
+
+	parcel3 := new(Parcel)
+	if err = parcel3.UnmarshalBinary(data); err != nil || string(parcel3.Payload) != "Hello" {
+		t.Errorf("Parcel did not unmarshal: %v", err)
+	}
+	if parcel3.UnmarshalBinary(data[:len(data)-1]) == nil {
+		t.Errorf("A truncated parcel should not unmarshal")
+	}
+}
+
+func TestParcelTooLarge(t *testing.T) {
+	parcel := NewParcel(MainNet, make([]byte, MaxPayloadSize+1))
+	if _, err := parcel.MarshalBinary(); err == nil {
+		t.Errorf("A payload over MaxPayloadSize should not encode")
+	}
+
+	header := make([]byte, ParcelHeaderSize)
+	binary.BigEndian.PutUint32(header[8:], MaxPayloadSize+1)
+	if _, err := readParcel(bytes.NewReader(header)); err == nil {
+		t.Errorf("A payload over MaxPayloadSize should not decode")
+	}
+}
+
+// TestParcelDecoderFuzz runs the decoder over random and mutated input, the
+// way Fuzz (fuzz.go) does under go-fuzz.  Anything it accepts has to encode
+// back to the bytes it came from.
+func TestParcelDecoderFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	valid, _ := NewParcel(TestNet, []byte("A valid parcel to mutate")).MarshalBinary()
+
+	for i := 0; i < 20000; i++ {
+		var data []byte
+		if i%2 == 0 {
+			data = make([]byte, r.Intn(64))
+			r.Read(data)
+		} else {
+			data = append([]byte{}, valid...)
+			for j := r.Intn(4); j >= 0; j-- {
+				data[r.Intn(len(data))] = byte(r.Intn(256))
+			}
+			data = data[:r.Intn(len(data)+1)]
+		}
+
+		parcel, err := readParcel(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		again, err := parcel.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Decoded parcel does not encode: %v", err)
+		}
+		if !bytes.Equal(again, data[:len(again)]) {
+			t.Fatalf("Parcel does not encode back to\n%x\ngot\n%x", data, again)
+		}
+	}
+}
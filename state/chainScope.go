@@ -0,0 +1,140 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
+)
+
+// A chain scoped node still validates and saves every Directory Block, but only
+// requests and stores the EBlocks and entries of the chains it was configured with.
+
+// SetChainScope parses a comma separated list of chain IDs and limits the EBlocks
+// and entries this node syncs to those chains.  An empty list syncs every chain.
+func (s *State) SetChainScope(chainIDs string) error {
+	scope := make(map[[32]byte]uint32)
+	for _, str := range strings.Split(chainIDs, ",") {
+		str = strings.TrimSpace(str)
+		if len(str) == 0 {
+			continue
+		}
+		chainID, err := primitives.HexToHash(str)
+		if err != nil {
+			return fmt.Errorf("Invalid chain ID %s: %v", str, err)
+		}
+		scope[chainID.Fixed()] = 0
+	}
+	s.ChainScope = chainIDs
+	s.scopeMutex.Lock()
+	s.ScopedChains = scope
+	s.scopeMutex.Unlock()
+	return nil
+}
+
+// Returns true if this node only syncs a subset of the entry chains.
+func (s *State) IsChainScoped() bool {
+	s.scopeMutex.RLock()
+	defer s.scopeMutex.RUnlock()
+	return len(s.ScopedChains) > 0
+}
+
+// Returns true if this node syncs the EBlocks and entries of the given chain.
+func (s *State) IsChainInScope(chainID interfaces.IHash) bool {
+	if !s.IsChainScoped() {
+		return true
+	}
+	if chainID == nil {
+		return false
+	}
+	s.scopeMutex.RLock()
+	defer s.scopeMutex.RUnlock()
+	_, ok := s.ScopedChains[chainID.Fixed()]
+	return ok
+}
+
+// scopedChainHeights returns a copy of ScopedChains, to range over without
+// holding the lock.
+func (s *State) scopedChainHeights() map[[32]byte]uint32 {
+	s.scopeMutex.RLock()
+	defer s.scopeMutex.RUnlock()
+	heights := make(map[[32]byte]uint32, len(s.ScopedChains))
+	for chain, height := range s.ScopedChains {
+		heights[chain] = height
+	}
+	return heights
+}
+
+// setChainEBDBHeightComplete records the DBlock Height at which a scoped chain
+// has a complete set of eblocks+entries, and saves it, so a restart goes on
+// from there.
+func (s *State) setChainEBDBHeightComplete(chain [32]byte, height uint32) {
+	s.scopeMutex.Lock()
+	s.ScopedChains[chain] = height
+	s.scopeMutex.Unlock()
+
+	s.DBMutex.Lock()
+	defer s.DBMutex.Unlock()
+	if err := s.DB.SaveChainScopeHeight(primitives.NewHash(chain[:]), height); err != nil {
+		panic(err.Error())
+	}
+}
+
+// loadChainScopeHeights sets how far each scoped chain has synced to what was
+// saved, so a restarted node doesn't scan every Directory Block from 0 again.
+func (s *State) loadChainScopeHeights() {
+	if s.DB == nil {
+		return
+	}
+	for chain := range s.scopedChainHeights() {
+		s.DBMutex.Lock()
+		height, err := s.DB.FetchChainScopeHeight(primitives.NewHash(chain[:]))
+		s.DBMutex.Unlock()
+		if err == nil {
+			s.scopeMutex.Lock()
+			s.ScopedChains[chain] = height
+			s.scopeMutex.Unlock()
+		}
+	}
+}
+
+// Each scoped chain catches up on its own, one Directory Block at a time.  The node
+// wide EBDBHeightComplete is the height of the chain furthest behind.
+func (s *State) catchupScopedEBlocks() {
+	dbheight := s.GetDBHeightComplete()
+	lowest := dbheight
+	for chain, height := range s.scopedChainHeights() {
+		if height < dbheight {
+			if s.catchupChainEBlock(primitives.NewHash(chain[:]), height) {
+				height++
+				s.setChainEBDBHeightComplete(chain, height)
+			}
+		}
+		if height < lowest {
+			lowest = height
+		}
+	}
+	if s.GetEBDBHeightComplete() < lowest {
+		s.SetEBDBHeightComplete(lowest)
+	}
+}
+
+// Returns true if we have the EBlock (and its entries) for the given chain at the
+// given height, or the Directory Block at that height holds no EBlock for the chain.
+func (s *State) catchupChainEBlock(chainID interfaces.IHash, dbheight uint32) bool {
+	dblock := s.GetDirectoryBlockByHeight(dbheight)
+	if dblock == nil {
+		return false
+	}
+	for idx, dbEntry := range dblock.GetDBEntries() {
+		if idx > 2 && dbEntry.GetChainID().IsSameAs(chainID) {
+			return s.catchupEBlock(dbEntry.GetKeyMR())
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FactomProject/factomd/common/primitives"
+	. "github.com/FactomProject/factomd/state"
+	"github.com/FactomProject/factomd/testHelper"
+)
+
+func TestChainScope(t *testing.T) {
+	s := testHelper.CreateEmptyTestState()
+
+	chain1 := testHelper.NewRepeatingHash(1)
+	chain2 := testHelper.NewRepeatingHash(2)
+
+	if s.IsChainScoped() {
+		t.Errorf("New state should not be chain scoped")
+	}
+	if !s.IsChainInScope(chain1) || !s.IsChainInScope(chain2) {
+		t.Errorf("Unscoped state should sync every chain")
+	}
+
+	err := s.SetChainScope(" " + chain1.String() + ", ")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if !s.IsChainScoped() {
+		t.Errorf("State should be chain scoped")
+	}
+	if !s.IsChainInScope(chain1) {
+		t.Errorf("Chain %v should be in scope", chain1.String())
+	}
+	if s.IsChainInScope(chain2) {
+		t.Errorf("Chain %v should not be in scope", chain2.String())
+	}
+	if s.IsChainInScope(primitives.NewZeroHash()) {
+		t.Errorf("Zero hash should not be in scope")
+	}
+	if s.ScopedChains[chain1.Fixed()] != 0 {
+		t.Errorf("Invalid EBDBHeightComplete for %v", chain1.String())
+	}
+
+	err = s.SetChainScope("not a chain id")
+	if err == nil {
+		t.Errorf("Invalid chain ID should not be accepted")
+	}
+
+	err = s.SetChainScope("")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if s.IsChainScoped() {
+		t.Errorf("Empty scope should sync every chain")
+	}
+}
+
+func TestChainScopeHeightsSaved(t *testing.T) {
+	chain := testHelper.GetChainID()
+	s := new(State)
+	s.DB = testHelper.CreateAndPopulateTestDatabaseOverlay()
+	s.LoadConfig("", "")
+	s.ChainScope = chain.String()
+	s.Init()
+	LoadDatabase(s)
+	go s.ValidatorLoop()
+
+	var height uint32
+	for i := 0; i < 100 && height == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		s.DBMutex.Lock()
+		height, _ = s.DB.FetchChainScopeHeight(chain)
+		s.DBMutex.Unlock()
+	}
+	if height == 0 {
+		t.Fatalf("How far %v synced wasn't saved", chain)
+	}
+
+	// Restarted, the node goes on from the height it saved.
+	fresh := s.Fresh()
+	fresh.Init()
+	if h := fresh.ScopedChains[chain.Fixed()]; h < height {
+		t.Errorf("The restarted node starts %v at %d, below the %d saved", chain, h, height)
+	}
+}
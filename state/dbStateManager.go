@@ -188,6 +188,7 @@ func (list *DBStateList) UpdateState() (progress bool) {
 		list.State.DBMutex.Lock()
 		dblk, _ := list.State.DB.FetchDBlockByKeyMR(d.DirectoryBlock.GetKeyMR())
 		list.State.DBMutex.Unlock()
+		written := dblk == nil // Not just loaded from the database
 		if dblk == nil {
 			if i > 0 {
 				p := list.DBStates[i-1]
@@ -245,13 +246,18 @@ func (list *DBStateList) UpdateState() (progress bool) {
 					}
 					d.DirectoryBlock.AddEntry(eb.GetChainID(), key)
 				}
-				d.DirectoryBlock.GetKeyMR()
 				_, err = d.DirectoryBlock.BuildBodyMR()
 				if err != nil {
 					panic(err.Error())
 				}
 
 			}
+			// The KeyMR may have been cached before the block was finished.
+			// Build it again from the block as saved, as the next block
+			// links to it.
+			if _, err := d.DirectoryBlock.BuildKeyMerkleRoot(); err != nil {
+				panic(err.Error())
+			}
 			list.State.DBMutex.Lock()
 			if err := list.State.DB.ProcessDBlockMultiBatch(d.DirectoryBlock); err != nil {
 				list.State.DBMutex.Unlock()
@@ -275,6 +281,10 @@ func (list *DBStateList) UpdateState() (progress bool) {
 
 			pl := list.State.ProcessLists.Get(d.DirectoryBlock.GetHeader().GetDBHeight())
 			for _, eb := range pl.NewEBlocks {
+				// The DBlock still holds every EBlock, but a chain scoped node only stores its own chains.
+				if !list.State.IsChainInScope(eb.GetChainID()) {
+					continue
+				}
 				if err := list.State.DB.ProcessEBlockMultiBatch(eb, false); err != nil {
 					list.State.DBMutex.Unlock()
 					panic(err.Error())
@@ -314,6 +324,12 @@ func (list *DBStateList) UpdateState() (progress bool) {
 		fs.AddTransactionBlock(d.FactoidBlock)
 		fs.AddECBlock(d.EntryCreditBlock)
 		fs.ProcessEndOfBlock(list.State)
+		// Tell peers what was saved, so they can check they saved the same.
+		if written {
+			list.State.savedDigest(d.DirectoryBlock.GetHeader().GetDBHeight())
+		}
+		list.State.traceBlock(d.DirectoryBlock.GetHeader().GetDBHeight())
+
 		// Step my counter of Complete blocks
 		if uint32(i) > list.Complete {
 			list.Complete = uint32(i)
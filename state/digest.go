@@ -0,0 +1,265 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/common/primitives"
+)
+
+// A BlockDigest is what a node saved at a height, in a few hashes: nodes that
+// agree on the blocks have the same digest, so comparing digests finds the
+// first height where nodes diverge without comparing whole blocks.
+type BlockDigest struct {
+	Node   string             // Name of the node, or its identity if it is a peer
+	Height uint32             // Of the directory block
+	KeyMR  interfaces.IHash   // Directory block
+	ABHash interfaces.IHash   // Admin block
+	FBHash interfaces.IHash   // Factoid block
+	ECHash interfaces.IHash   // Entry credit block
+	VMs    []interfaces.IHash // Of the messages in each VM; empty if the process list is gone or empty
+}
+
+// A Divergence is a height where two nodes saved different blocks.
+type Divergence struct {
+	Height      uint32
+	A, B        *BlockDigest
+	Differences []string
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("%s and %s diverge at height %d: %v", d.A.Node, d.B.Node, d.Height, d.Differences)
+}
+
+// Diff lists how the digests differ, or is empty if they don't.  VMs are only
+// compared if both nodes still held their process lists.
+func (d *BlockDigest) Diff(other *BlockDigest) []string {
+	var diffs []string
+	same := func(a, b interfaces.IHash) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.IsSameAs(b)
+	}
+	for _, h := range []struct {
+		name string
+		a, b interfaces.IHash
+	}{
+		{"DBlock KeyMR", d.KeyMR, other.KeyMR},
+		{"ABlock", d.ABHash, other.ABHash},
+		{"FBlock", d.FBHash, other.FBHash},
+		{"ECBlock", d.ECHash, other.ECHash},
+	} {
+		if !same(h.a, h.b) {
+			diffs = append(diffs, fmt.Sprintf("%s %s != %s", h.name, shortHash(h.a), shortHash(h.b)))
+		}
+	}
+	if len(d.VMs) == 0 || len(other.VMs) == 0 {
+		return diffs
+	}
+	if len(d.VMs) != len(other.VMs) {
+		return append(diffs, fmt.Sprintf("%d VMs != %d VMs", len(d.VMs), len(other.VMs)))
+	}
+	for i := range d.VMs {
+		if !same(d.VMs[i], other.VMs[i]) {
+			diffs = append(diffs, fmt.Sprintf("VM %d %s != %s", i, shortHash(d.VMs[i]), shortHash(other.VMs[i])))
+		}
+	}
+	return diffs
+}
+
+// UnmarshalJSON reads a digest as it marshals, its hashes as hex, so a
+// digest another process served can be compared with Diff.
+func (d *BlockDigest) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Node                          string
+		Height                        uint32
+		KeyMR, ABHash, FBHash, ECHash *primitives.Hash
+		VMs                           []*primitives.Hash
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	hash := func(h *primitives.Hash) interfaces.IHash {
+		if h == nil {
+			return nil // Not a nil *Hash, which Diff would take for a hash
+		}
+		return h
+	}
+	d.Node, d.Height = v.Node, v.Height
+	d.KeyMR, d.ABHash, d.FBHash, d.ECHash = hash(v.KeyMR), hash(v.ABHash), hash(v.FBHash), hash(v.ECHash)
+	d.VMs = nil
+	for _, vm := range v.VMs {
+		d.VMs = append(d.VMs, hash(vm))
+	}
+	return nil
+}
+
+func shortHash(h interfaces.IHash) string {
+	if h == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%x", h.Bytes()[:4])
+}
+
+// GetDigest returns the digest of the blocks s saved at height, or nil if it
+// hasn't saved them.  The blocks are read back from the database, so the
+// digest is of what was saved rather than of the copies held in memory.
+func (s *State) GetDigest(height uint32) *BlockDigest {
+	if height > s.GetHighestRecordedBlock() {
+		return nil
+	}
+	msg, err := s.LoadDBState(height)
+	if err != nil || msg == nil {
+		return nil
+	}
+	dbstate := msg.(*messages.DBStateMsg)
+	d := &BlockDigest{Node: s.FactomNodeName, Height: height}
+	d.KeyMR = dbstate.DirectoryBlock.GetKeyMR()
+	d.ABHash = dbstate.AdminBlock.GetHash()
+	d.FBHash = dbstate.FactoidBlock.GetHash()
+	d.ECHash = dbstate.EntryCreditBlock.GetHash()
+
+	// A node that took the blocks from a peer's DBState has an empty process
+	// list, which has nothing to compare.
+	pl := s.ProcessLists.Find(height)
+	if pl == nil {
+		return d
+	}
+	empty := true
+	for i := range pl.FedServers {
+		var data []byte
+		for _, msg := range pl.VMs[i].List {
+			if msg == nil {
+				data = append(data, make([]byte, 32)...)
+			} else {
+				data = append(data, msg.GetMsgHash().Bytes()...)
+			}
+		}
+		empty = empty && len(data) == 0
+		d.VMs = append(d.VMs, primitives.Sha(data))
+	}
+	if empty {
+		d.VMs = nil
+	}
+	return d
+}
+
+// Find returns the process list at dbheight, or nil if there isn't one.  Unlike
+// Get, it doesn't make one.
+func (lists *ProcessLists) Find(dbheight uint32) *ProcessList {
+	i := int(dbheight) - int(lists.DBHeightBase)
+	if i < 0 || i >= len(lists.Lists) {
+		return nil
+	}
+	return lists.Lists[i]
+}
+
+// BlockDigestMsg makes the message that publishes the digest to peers, signed
+// with the server key.
+func (s *State) BlockDigestMsg(d *BlockDigest) interfaces.IMsg {
+	msg := new(messages.BlockDigestMsg)
+	msg.Timestamp = s.GetTimestamp()
+	msg.DBHeight = d.Height
+	msg.Identity = s.GetIdentityChainID()
+	msg.KeyMR = d.KeyMR
+	msg.ABHash = d.ABHash
+	msg.FBHash = d.FBHash
+	msg.ECHash = d.ECHash
+	msg.VMs = d.VMs
+	msg.Sign(s)
+	return msg
+}
+
+// maxPeerDigests is the most digests held for a height until it is saved.
+const maxPeerDigests = 64
+
+// savedDigest notes that the blocks at height were saved, for publishDigests.
+// Saving happens in UpdateState, which reading the blocks back and sending the
+// digest would hold up.
+func (s *State) savedDigest(height uint32) {
+	s.unpublishedDigests = append(s.unpublishedDigests, height)
+}
+
+// publishDigests sends peers the digests of the blocks saved since the last
+// call, if s is a federated server, as only theirs are taken.  Each is checked
+// against the digests peers sent before s saved its blocks, and the digests
+// held for heights already saved are dropped.
+func (s *State) publishDigests() {
+	if len(s.unpublishedDigests) == 0 {
+		return
+	}
+	heights := s.unpublishedDigests
+	s.unpublishedDigests = nil
+	leader, _ := s.GetVirtualServers(s.GetLeaderHeight(), 9, s.GetIdentityChainID())
+	for _, height := range heights {
+		d := s.GetDigest(height)
+		if d == nil {
+			continue
+		}
+		if leader {
+			s.networkOutMsgQueue <- s.BlockDigestMsg(d)
+		}
+		for _, peer := range s.peerDigests[height] {
+			s.compareDigest(d, peer)
+		}
+	}
+	saved := s.GetHighestRecordedBlock()
+	for height := range s.peerDigests {
+		if height <= saved {
+			delete(s.peerDigests, height)
+		}
+	}
+}
+
+// compareDigest records the first divergence from a peer.
+func (s *State) compareDigest(ours, peer *BlockDigest) {
+	diffs := ours.Diff(peer)
+	if len(diffs) == 0 {
+		return
+	}
+	if s.Divergence == nil || peer.Height < s.Divergence.Height {
+		s.Divergence = &Divergence{Height: peer.Height, A: ours, B: peer, Differences: diffs}
+		s.Logger.Warning(s.Divergence.String())
+	}
+}
+
+// FollowerExecuteBlockDigest compares a peer's digest with ours, or holds it
+// until this node saves the same height.
+func (s *State) FollowerExecuteBlockDigest(m interfaces.IMsg) error {
+	msg, ok := m.(*messages.BlockDigestMsg)
+	if !ok || msg.Identity.IsSameAs(s.GetIdentityChainID()) {
+		return nil
+	}
+	peer := &BlockDigest{
+		Node:   fmt.Sprintf("%x", msg.Identity.Bytes()[:4]),
+		Height: msg.DBHeight,
+		KeyMR:  msg.KeyMR,
+		ABHash: msg.ABHash,
+		FBHash: msg.FBHash,
+		ECHash: msg.ECHash,
+		VMs:    msg.VMs,
+	}
+	if ours := s.GetDigest(msg.DBHeight); ours != nil {
+		s.compareDigest(ours, peer)
+		return nil
+	}
+	// Don't hold digests for heights too far ahead to be saved soon.
+	if msg.DBHeight > s.GetHighestRecordedBlock()+10 {
+		return nil
+	}
+	if s.peerDigests == nil {
+		s.peerDigests = make(map[uint32][]*BlockDigest)
+	}
+	if len(s.peerDigests[msg.DBHeight]) >= maxPeerDigests {
+		return nil
+	}
+	s.peerDigests[msg.DBHeight] = append(s.peerDigests[msg.DBHeight], peer)
+	return nil
+}
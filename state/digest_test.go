@@ -0,0 +1,43 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state_test
+
+import (
+	"testing"
+
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/testHelper"
+)
+
+func TestBlockDigestMsg(t *testing.T) {
+	s := testHelper.CreateAndPopulateTestState()
+	d := s.GetDigest(1)
+	if d == nil {
+		t.Fatal("No digest of a saved block")
+	}
+	msg := s.BlockDigestMsg(d).(*messages.BlockDigestMsg)
+	if v := msg.Validate(s); v != 1 {
+		t.Errorf("A federated server's digest validates as %d", v)
+	}
+
+	other := *msg
+	other.Identity = primitives.Sha([]byte("not a server"))
+	if v := other.Validate(s); v != -1 {
+		t.Errorf("A digest from a node that isn't a federated server validates as %d", v)
+	}
+	other = *msg
+	other.KeyMR = primitives.Sha([]byte("changed"))
+	if v := other.Validate(s); v != -1 {
+		t.Errorf("A digest changed after it was signed validates as %d", v)
+	}
+
+	// A peer's digest that disagrees with ours is recorded.
+	other.Identity = primitives.Sha([]byte("peer"))
+	s.FollowerExecuteBlockDigest(&other)
+	if s.Divergence == nil || s.Divergence.Height != 1 {
+		t.Errorf("Expected a divergence at height 1, got %v", s.Divergence)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+)
+
+// The journal is every message a node takes from its timer and input queues,
+// one JSON JournalEntry to a line, so a run can be replayed (see
+// engine.ReplayJournal).  Next to it, in JournalFile + JournalIndexSuffix, is
+// an index: a JournalIndex each time the node moves to a new minute, giving
+// the offset of its first entry, so a replay can seek to a minute rather than
+// read the journal from the start.  A node restarted on the same files adds to
+// both.
+
+const JournalIndexSuffix = ".index"
+
+// A JournalPoint is a minute of a block.
+type JournalPoint struct {
+	Height uint32 `json:"height"`
+	Minute int    `json:"minute"`
+}
+
+// Before is true if p comes before q.
+func (p JournalPoint) Before(q JournalPoint) bool {
+	return p.Height < q.Height || (p.Height == q.Height && p.Minute < q.Minute)
+}
+
+// ParseJournalPoint reads a point given as "height" or "height:minute".
+func ParseJournalPoint(str string) (JournalPoint, error) {
+	var p JournalPoint
+	if str == "" {
+		return p, nil
+	}
+	parts := strings.SplitN(str, ":", 2)
+	height, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return p, fmt.Errorf("Bad height in %q, expected height or height:minute", str)
+	}
+	p.Height = uint32(height)
+	if len(parts) == 2 {
+		if p.Minute, err = strconv.Atoi(parts[1]); err != nil || p.Minute < 0 || 9 < p.Minute {
+			return p, fmt.Errorf("Bad minute in %q, expected height or height:minute", str)
+		}
+	}
+	return p, nil
+}
+
+// A JournalEntry is a message as a node received it.
+type JournalEntry struct {
+	Time          uint64 `json:"time"`   // Milliseconds, when the message was received
+	Node          string `json:"node"`   // Name of the node that received it
+	Origin        int    `json:"origin"` // Peer it came from, 0 if from the node itself
+	NetworkOrigin string `json:"networkorigin,omitempty"`
+	JournalPoint
+	Type  string `json:"type"`  // As messages.MessageName
+	Hash  string `json:"hash"`  // Message hash
+	Valid int    `json:"valid"` // Validate when taken: 1 valid, 0 can't tell yet, -1 invalid
+	Msg   string `json:"msg"`   // The message, marshalled, in hex
+}
+
+// A JournalIndex is where in the journal a minute starts, and the last
+// directory block the node had saved by then.
+type JournalIndex struct {
+	JournalPoint
+	Offset int64  `json:"offset"` // Of the first entry of the minute
+	Entry  int    `json:"entry"`  // Number of that entry, from 0
+	Saved  uint32 `json:"saved"`  // Highest saved directory block
+	KeyMR  string `json:"keymr"`  // Its KeyMR
+}
+
+// Message returns the message the entry holds.
+func (e *JournalEntry) Message() (interfaces.IMsg, error) {
+	data, err := hex.DecodeString(e.Msg)
+	if err != nil {
+		return nil, err
+	}
+	return messages.UnmarshalMessage(data)
+}
+
+type journal struct {
+	mutex   sync.Mutex
+	file    *os.File
+	index   *os.File
+	offset  int64
+	entries int
+	last    *JournalPoint
+	check   func(msg interfaces.IMsg)
+}
+
+// JournalMessage writes msg to the journal, if Journaling is on, with whether
+// it validates.  It is called on the validator's goroutine, as the node takes
+// the message, so it is validated against the state the node has then.
+func (s *State) JournalMessage(msg interfaces.IMsg) {
+	if !s.Journaling || len(s.JournalFile) == 0 {
+		return
+	}
+	j := &s.journal
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		var err error
+		if j.file, err = os.OpenFile(s.JournalFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666); err != nil {
+			s.JournalFile = ""
+			return
+		}
+		if j.index, err = os.OpenFile(s.JournalFile+JournalIndexSuffix, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666); err != nil {
+			j.file.Close()
+			j.file = nil
+			s.JournalFile = ""
+			return
+		}
+		info, _ := j.file.Stat()
+		j.offset = info.Size()
+		j.entries = countLines(s.JournalFile)
+	}
+
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return
+	}
+	entry := JournalEntry{
+		Time:          interfaces.GetTimeMilli(),
+		Node:          s.FactomNodeName,
+		Origin:        msg.GetOrigin(),
+		NetworkOrigin: msg.GetNetworkOrigin(),
+		JournalPoint:  JournalPoint{s.LLeaderHeight, s.LeaderMinute},
+		Type:          messages.MessageName(msg.Type()),
+		Valid:         msg.Validate(s),
+		Msg:           hex.EncodeToString(data),
+	}
+	if hash := msg.GetMsgHash(); hash != nil {
+		entry.Hash = hash.String()
+	}
+
+	if j.last == nil || *j.last != entry.JournalPoint {
+		index := JournalIndex{JournalPoint: entry.JournalPoint, Offset: j.offset, Entry: j.entries}
+		index.Saved = s.GetHighestRecordedBlock()
+		if msg, err := s.LoadDBState(index.Saved); err == nil && msg != nil {
+			index.KeyMR = msg.(*messages.DBStateMsg).DirectoryBlock.GetKeyMR().String()
+		}
+		line, _ := json.Marshal(index)
+		j.index.Write(append(line, '\n'))
+		j.last = &entry.JournalPoint
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	n, _ := j.file.Write(append(line, '\n'))
+	j.offset += int64(n)
+	j.entries++
+}
+
+// countLines returns the number of entries already in a journal.
+func countLines(file string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	lines := 0
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if err != nil {
+			return lines
+		}
+	}
+}
+
+// SetReplayCheck has check called with each message the node takes from its
+// input queue, on the node's own goroutine, before the message is processed.
+// A replay uses it to compare the node with its journal.  nil removes it.
+func (s *State) SetReplayCheck(check func(msg interfaces.IMsg)) {
+	s.journal.mutex.Lock()
+	s.journal.check = check
+	s.journal.mutex.Unlock()
+}
+
+func (s *State) replayCheck(msg interfaces.IMsg) {
+	s.journal.mutex.Lock()
+	check := s.journal.check
+	s.journal.mutex.Unlock()
+	if check != nil {
+		check(msg)
+	}
+}
+
+// ReadJournalIndex reads the index of a journal.
+func ReadJournalIndex(journalFile string) ([]JournalIndex, error) {
+	f, err := os.Open(journalFile + JournalIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var index []JournalIndex
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var i JournalIndex
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, err
+		}
+		index = append(index, i)
+	}
+	return index, scanner.Err()
+}
@@ -1,31 +1,146 @@
 package state_test
 
-/*
 import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/FactomProject/factomd/common/interfaces"
-	"github.com/FactomProject/factomd/common/messages"
-	"github.com/FactomProject/factomd/common/primitives"
 	. "github.com/FactomProject/factomd/state"
+	"github.com/FactomProject/factomd/testHelper"
 )
 
 func TestJournal(t *testing.T) {
-	s := new(State)
-	filename := "journaltest.log"
-	s.JournalFile = filename
-	_, err := os.Create(s.JournalFile)
+	dir, err := ioutil.TempDir("", "journal")
 	if err != nil {
-		t.Errorf("%v", err)
+		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
 
-	msg := new(messages.Ack)
-	msg.MsgHash = primitives.NewZeroHash()
-	msg.MessageHash = primitives.NewZeroHash()
-	msg.SerialHash = primitives.NewZeroHash()
-	msg.Timestamp = interfaces.Timestamp(0x112233)
+	s := testHelper.CreateEmptyTestState()
+	s.JournalFile = filepath.Join(dir, "journal.log")
+	dbstates := testHelper.CreateTestDBStateList()
 
-	s.JournalMessage(msg)
+	s.JournalMessage(dbstates[0])
+	if _, err := os.Stat(s.JournalFile); err == nil {
+		t.Errorf("Nothing should be journaled unless Journaling is on")
+	}
+
+	s.Journaling = true
+	for i, msg := range dbstates {
+		s.LLeaderHeight = uint32(i / 2)
+		s.JournalMessage(msg)
+	}
+
+	f, err := os.Open(s.JournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != len(dbstates) {
+		t.Fatalf("Expected %d entries, got %d", len(dbstates), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Type != "DBState" || entry.Node != s.FactomNodeName || entry.Height != uint32(i/2) {
+			t.Errorf("Unexpected entry %d: %+v", i, entry)
+		}
+		if valid := dbstates[i].Validate(s); entry.Valid != valid {
+			t.Errorf("Entry %d was journaled with validity %d, not %d", i, entry.Valid, valid)
+		}
+		msg, err := entry.Message()
+		if err != nil || !msg.GetMsgHash().IsSameAs(dbstates[i].GetMsgHash()) {
+			t.Errorf("Entry %d doesn't hold the message journaled", i)
+		}
+	}
+
+	index, err := ReadJournalIndex(s.JournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != (len(dbstates)+1)/2 {
+		t.Fatalf("Expected an index entry for each height, got %d", len(index))
+	}
+	for i, minute := range index {
+		if minute.Height != uint32(i) || minute.Entry != 2*i {
+			t.Errorf("Unexpected index %+v", minute)
+		}
+		if _, err := f.Seek(minute.Offset, os.SEEK_SET); err != nil {
+			t.Fatal(err)
+		}
+		var entry JournalEntry
+		if err := json.NewDecoder(f).Decode(&entry); err != nil || entry.Hash != entries[2*i].Hash {
+			t.Errorf("Index %d doesn't give the offset of its first entry", i)
+		}
+	}
+}
+
+func TestJournalReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A node restarted on the same journal adds to it, and to its index.
+	dbstates := testHelper.CreateTestDBStateList()
+	file := filepath.Join(dir, "journal.log")
+	for _, msgs := range [][]interfaces.IMsg{dbstates[:3], dbstates[3:]} {
+		s := testHelper.CreateEmptyTestState()
+		s.Journaling = true
+		s.JournalFile = file
+		s.LLeaderHeight = uint32(len(msgs))
+		for _, msg := range msgs {
+			s.JournalMessage(msg)
+		}
+	}
+
+	index, err := ReadJournalIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 || index[0].Entry != 0 || index[1].Entry != 3 {
+		t.Fatalf("Expected an index entry for each run, counting on from the first, got %+v", index)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(index[1].Offset, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	var entry JournalEntry
+	if err := json.NewDecoder(f).Decode(&entry); err != nil || entry.Hash != dbstates[3].GetMsgHash().String() {
+		t.Errorf("The second run's index doesn't give the offset of its first entry")
+	}
+}
+
+func TestParseJournalPoint(t *testing.T) {
+	for str, want := range map[string]JournalPoint{
+		"":     {},
+		"12":   {Height: 12},
+		"12:3": {Height: 12, Minute: 3},
+	} {
+		if p, err := ParseJournalPoint(str); err != nil || p != want {
+			t.Errorf("%q: expected %+v, got %+v %v", str, want, p, err)
+		}
+	}
+	for _, bad := range []string{"x", "12:", "12:10", "-1"} {
+		if _, err := ParseJournalPoint(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
 }
-*/
@@ -5,11 +5,13 @@
 package state
 
 import (
+	"encoding/binary"
 	"fmt"
 	"github.com/FactomProject/factomd/common/constants"
 	"github.com/FactomProject/factomd/common/directoryBlock"
 	"github.com/FactomProject/factomd/common/entryCreditBlock"
 	"github.com/FactomProject/factomd/common/factoid"
+	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/messages"
 	"github.com/FactomProject/factomd/common/primitives"
 	"time"
@@ -18,6 +20,13 @@ import (
 var _ = fmt.Print
 
 func LoadDatabase(s *State) {
+	LoadDatabaseUntil(s, nil)
+}
+
+// LoadDatabaseUntil is LoadDatabase, but stops once quit is closed, so a node
+// stopped while it loads doesn't wait on a queue no one drains, or read its
+// database once it is closed.  A nil quit is never closed.
+func LoadDatabaseUntil(s *State, quit <-chan struct{}) {
 
 	var blkCnt uint32
 
@@ -41,20 +50,33 @@ func LoadDatabase(s *State) {
 			if msg != nil {
 				if len(s.InMsgQueue()) > 100 {
 					for len(s.InMsgQueue()) > 30 {
-						time.Sleep(100 * time.Millisecond)
+						select {
+						case <-quit:
+							return
+						case <-time.After(100 * time.Millisecond):
+						}
 					}
 				}
-				s.InMsgQueue() <- msg
+				select {
+				case <-quit:
+					return
+				case s.InMsgQueue() <- msg:
+				}
 			} else {
 				break
 			}
 		}
+		select {
+		case <-quit:
+			return
+		default:
+		}
 		msg, err = s.LoadDBState(uint32(i))
 
 		s.Print("\r", "\\|/-"[i%4:i%4+1])
 	}
 
-	if blkCnt == 0 && s.NetworkNumber == constants.NETWORK_LOCAL {
+	if blkCnt == 0 && (s.NetworkNumber == constants.NETWORK_LOCAL || s.NetworkNumber == constants.NETWORK_CUSTOM) {
 		s.Println("\n***********************************")
 		s.Println("******* New Database **************")
 		s.Println("***********************************\n")
@@ -64,11 +86,37 @@ func LoadDatabase(s *State) {
 		fblk := factoid.GetGenesisFBlock()
 		ecblk := entryCreditBlock.NewECBlock()
 
-		ablk.AddFedServer(primitives.Sha([]byte("FNode0")))
+		// A custom network's genesis block carries its magic number, so no two
+		// custom networks share a chain, and its own bootstrap server.
+		if s.NetworkNumber == constants.NETWORK_CUSTOM {
+			dblk.GetHeader().SetNetworkID(binary.BigEndian.Uint32(s.CustomNetworkID))
+		}
+		bootstrap, err := s.bootstrapIdentity()
+		if err != nil {
+			panic(err.Error())
+		}
+		ablk.AddFedServer(bootstrap)
 
 		msg := messages.NewDBStateMsg(s.GetTimestamp(), dblk, ablk, fblk, ecblk)
-		s.InMsgQueue() <- msg
+		select {
+		case <-quit:
+			return
+		case s.InMsgQueue() <- msg:
+		}
 	}
 	s.Println(fmt.Sprintf("Loaded %d directory blocks on %s", blkCnt, s.FactomNodeName))
 
 }
+
+// Returns the identity of the federated server in the genesis block of this
+// node's network.
+func (s *State) bootstrapIdentity() (interfaces.IHash, error) {
+	if s.NetworkNumber == constants.NETWORK_CUSTOM && s.CustomBootstrapIdentity != "" {
+		identity, err := primitives.HexToHash(s.CustomBootstrapIdentity)
+		if err != nil {
+			return nil, fmt.Errorf("Bad CustomBootstrapIdentity in factomd.conf: %s", err.Error())
+		}
+		return identity, nil
+	}
+	return primitives.Sha([]byte("FNode0")), nil
+}
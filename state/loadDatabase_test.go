@@ -0,0 +1,39 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FactomProject/factomd/common/messages"
+	. "github.com/FactomProject/factomd/state"
+	"github.com/FactomProject/factomd/testHelper"
+)
+
+func TestLoadDatabaseUntil(t *testing.T) {
+	s := new(State)
+	s.DB = testHelper.CreateAndPopulateTestDatabaseOverlay()
+	s.LoadConfig("", "")
+	s.Init()
+
+	// The queue is full and no validator drains it, as when a node is stopped
+	// while it loads.
+	for len(s.InMsgQueue()) < 200 {
+		s.InMsgQueue() <- new(messages.EOM)
+	}
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		LoadDatabaseUntil(s, quit)
+		close(done)
+	}()
+	close(quit)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The load didn't stop when it was told to")
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/messages"
 	"github.com/FactomProject/factomd/common/primitives"
-	"time"
 )
 
 var _ = fmt.Print
@@ -385,7 +384,7 @@ func (p *ProcessList) FinishedEOM() bool {
 // Process messages and update our state.
 func (p *ProcessList) Process(state *State) (progress bool) {
 
-	now := time.Now().Unix()
+	now := interfaces.Now().Unix()
 	ask := func(vm *VM, thetime int64, j int) int64 {
 		if thetime == 0 {
 			thetime = now
@@ -469,6 +468,7 @@ func (p *ProcessList) Process(state *State) (progress bool) {
 			if plist[j].Process(p.DBHeight, state) { // Try and Process this entry
 				vm.Height = j + 1 // Don't process it again if the process worked.
 				progress = true
+				state.traceMsg(TraceProcessed, plist[j])
 			} else {
 				break // Don't process further in this list, go to the next.
 			}
@@ -551,6 +551,7 @@ func (p *ProcessList) AddToProcessList(ack *messages.Ack, m interfaces.IMsg) boo
 
 	p.State.NetworkOutMsgQueue() <- ack
 	p.State.NetworkOutMsgQueue() <- m
+	p.State.(*State).traceMsg(TraceAcked, m)
 
 	eom, ok := m.(*messages.EOM)
 	if ok {
@@ -77,7 +77,7 @@ func (r *Replay) Valid(hash [32]byte, timestamp int64, now int64) (index int, va
 // this code remembers hashes tested in the past, and rejects the
 // second submission of the same hash.
 func (r *Replay) IsTSValid(hash interfaces.IHash, timestamp int64) bool {
-	return r.IsTSValid_(hash.Fixed(), timestamp, time.Now().Unix())
+	return r.IsTSValid_(hash.Fixed(), timestamp, interfaces.Now().Unix())
 }
 
 // To make the function testable, the logic accepts the current time
@@ -0,0 +1,61 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"github.com/FactomProject/factomd/common/interfaces"
+)
+
+// The p2p network authenticates every peer with its node key, which factomd
+// sets to the server key.  A peer whose node key is the signing key the Admin
+// Blocks give a federated server is that server, so its messages can be
+// trusted or prioritised as the server's.
+
+// SetServerSigningKey records the signing key an Admin Block gives a server
+// identity.
+func (s *State) SetServerSigningKey(identityChainID interfaces.IHash, key []byte) {
+	if s.signingKeys == nil {
+		s.signingKeys = make(map[[32]byte][32]byte)
+	}
+	var k [32]byte
+	copy(k[:], key)
+	s.signingKeys[identityChainID.Fixed()] = k
+	s.updateFederatedKeys(s.GetHighestKnownBlock())
+}
+
+// GetFederatedServerByKey returns the identity of the federated server whose
+// signing key is key, or nil if no federated server signs with it.
+func (s *State) GetFederatedServerByKey(key []byte) interfaces.IHash {
+	if len(key) != 32 {
+		return nil
+	}
+	var k [32]byte
+	copy(k[:], key)
+	s.federatedMutex.RLock()
+	defer s.federatedMutex.RUnlock()
+	return s.federatedKeys[k]
+}
+
+// Rebuilds the signing keys of the servers federated at dbheight, whenever a
+// server is federated or given a key.
+func (s *State) updateFederatedKeys(dbheight uint32) {
+	if s.ProcessLists == nil {
+		return
+	}
+	pl := s.ProcessLists.Get(dbheight)
+	if pl == nil {
+		return
+	}
+	keys := make(map[[32]byte]interfaces.IHash)
+	for _, fed := range pl.FedServers {
+		identity := fed.GetChainID()
+		if key, ok := s.signingKeys[identity.Fixed()]; ok {
+			keys[key] = identity
+		}
+	}
+	s.federatedMutex.Lock()
+	s.federatedKeys = keys
+	s.federatedMutex.Unlock()
+}
@@ -0,0 +1,42 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state_test
+
+import (
+	"testing"
+
+	"github.com/FactomProject/factomd/common/adminBlock"
+	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/testHelper"
+)
+
+func TestFederatedServerByKey(t *testing.T) {
+	s := testHelper.CreateEmptyTestState()
+
+	federated := primitives.Sha([]byte("FNode1"))
+	federatedKey := testHelper.NewPrimitivesPrivateKey(1).Pub
+	audit := primitives.Sha([]byte("FNode2"))
+	auditKey := testHelper.NewPrimitivesPrivateKey(2).Pub
+
+	if s.GetFederatedServerByKey(federatedKey[:]) != nil {
+		t.Errorf("A key no Admin Block gives a server should not be federated")
+	}
+
+	ablock := s.NewAdminBlock(0)
+	ablock.AddFedServer(federated)
+	ablock.AddABEntry(adminBlock.NewAddFederatedServerSigningKey(federated, 0, *federatedKey))
+	ablock.AddABEntry(adminBlock.NewAddFederatedServerSigningKey(audit, 0, *auditKey))
+	ablock.UpdateState(s)
+
+	if identity := s.GetFederatedServerByKey(federatedKey[:]); identity == nil || !identity.IsSameAs(federated) {
+		t.Errorf("The signing key of a federated server should be that server's")
+	}
+	if s.GetFederatedServerByKey(auditKey[:]) != nil {
+		t.Errorf("The signing key of a server that is not federated should not be federated")
+	}
+	if s.GetFederatedServerByKey(federatedKey[:16]) != nil {
+		t.Errorf("A short key should not be federated")
+	}
+}
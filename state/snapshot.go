@@ -0,0 +1,678 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/FactomProject/factomd/common/adminBlock"
+	"github.com/FactomProject/factomd/common/constants"
+	"github.com/FactomProject/factomd/common/entryBlock"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/messages"
+	"github.com/FactomProject/factomd/common/primitives"
+)
+
+// A snapshot lets a new node skip replaying the chain through DBStateMissing
+// and DBStateMsg.  It holds every block up to Height, a summary of the balances
+// and chain heads at that height, and the signatures of the federated servers
+// that vouch for it.  Nothing in a snapshot is trusted until Verify has
+// rebuilt the summary from the blocks and checked the signatures.
+
+const SnapshotVersion byte = 1
+
+type Snapshot struct {
+	Version       byte
+	NetworkNumber int
+	Height        uint32
+
+	DBStates []*messages.DBStateMsg
+	EBlocks  []interfaces.IEntryBlock
+	Entries  []interfaces.IEBEntry
+
+	// Summary of the chain at Height
+	FactoidBalances map[[32]byte]int64
+	ECBalances      map[[32]byte]int64
+	ChainHeads      map[[32]byte]interfaces.IHash
+
+	// Signatures over the Sha of everything above
+	Signatures []interfaces.IFullSignature
+}
+
+// ExportSnapshot writes every block up to and including the given height to w,
+// signed with this server's key.
+func (s *State) ExportSnapshot(height uint32, w io.Writer) error {
+	snap := new(Snapshot)
+	snap.Version = SnapshotVersion
+	snap.NetworkNumber = s.NetworkNumber
+	snap.Height = height
+
+	for i := uint32(0); i <= height; i++ {
+		msg, err := s.LoadDBState(i)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			return fmt.Errorf("Directory Block %d not found", i)
+		}
+		dbstate := msg.(*messages.DBStateMsg)
+		snap.DBStates = append(snap.DBStates, dbstate)
+
+		for idx, dbEntry := range dbstate.DirectoryBlock.GetDBEntries() {
+			if idx <= 2 {
+				continue
+			}
+			s.DBMutex.Lock()
+			eblock, err := s.DB.FetchEBlockByKeyMR(dbEntry.GetKeyMR())
+			s.DBMutex.Unlock()
+			if err != nil {
+				return err
+			}
+			if eblock == nil {
+				return fmt.Errorf("EBlock %x not found", dbEntry.GetKeyMR().Bytes()[:5])
+			}
+			snap.EBlocks = append(snap.EBlocks, eblock)
+
+			for _, entryHash := range eblock.GetEntryHashes() {
+				if entryHash.IsMinuteMarker() {
+					continue
+				}
+				s.DBMutex.Lock()
+				entry, err := s.DB.FetchEntryByHash(entryHash)
+				s.DBMutex.Unlock()
+				if err != nil {
+					return err
+				}
+				if entry == nil {
+					return fmt.Errorf("Entry %x not found", entryHash.Bytes()[:5])
+				}
+				snap.Entries = append(snap.Entries, entry)
+			}
+		}
+	}
+
+	var err error
+	snap.FactoidBalances, snap.ECBalances, err = snap.replayBalances()
+	if err != nil {
+		return err
+	}
+	snap.ChainHeads = snap.replayChainHeads()
+
+	body, err := snap.MarshalBody()
+	if err != nil {
+		return err
+	}
+	snap.Signatures = append(snap.Signatures, s.Sign(primitives.Sha(body).Bytes()))
+
+	data, err := snap.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ImportSnapshot verifies the snapshot read from r, then loads its blocks
+// straight into the database and the DBStateList.  Only an empty database can
+// be bootstrapped from a snapshot.
+func (s *State) ImportSnapshot(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	snap := new(Snapshot)
+	if err := snap.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if err := snap.Verify(s); err != nil {
+		return err
+	}
+
+	s.DBMutex.Lock()
+	head, err := s.DB.FetchDirectoryBlockHead()
+	if err != nil {
+		s.DBMutex.Unlock()
+		return err
+	}
+	if head != nil {
+		s.DBMutex.Unlock()
+		return fmt.Errorf("Cannot import a snapshot into a database that already holds Directory Blocks")
+	}
+
+	s.DB.StartMultiBatch()
+	for _, d := range snap.DBStates {
+		if err := s.DB.ProcessDBlockMultiBatch(d.DirectoryBlock); err != nil {
+			s.DBMutex.Unlock()
+			return err
+		}
+		if err := s.DB.ProcessABlockMultiBatch(d.AdminBlock); err != nil {
+			s.DBMutex.Unlock()
+			return err
+		}
+		if err := s.DB.ProcessFBlockMultiBatch(d.FactoidBlock); err != nil {
+			s.DBMutex.Unlock()
+			return err
+		}
+		if err := s.DB.ProcessECBlockMultiBatch(d.EntryCreditBlock, false); err != nil {
+			s.DBMutex.Unlock()
+			return err
+		}
+	}
+	for _, eblock := range snap.EBlocks {
+		if !s.IsChainInScope(eblock.GetChainID()) {
+			continue
+		}
+		if err := s.DB.ProcessEBlockMultiBatch(eblock, false); err != nil {
+			s.DBMutex.Unlock()
+			return err
+		}
+	}
+	for _, entry := range snap.Entries {
+		if !s.IsChainInScope(entry.GetChainID()) {
+			continue
+		}
+		if err := s.DB.InsertEntry(entry); err != nil {
+			s.DBMutex.Unlock()
+			return err
+		}
+	}
+	if err := s.DB.ExecuteMultiBatch(); err != nil {
+		s.DBMutex.Unlock()
+		return err
+	}
+	s.DBMutex.Unlock()
+
+	s.FactoidBalancesPMutex.Lock()
+	s.FactoidBalancesP = snap.FactoidBalances
+	s.FactoidBalancesPMutex.Unlock()
+	s.ECBalancesPMutex.Lock()
+	s.ECBalancesP = snap.ECBalances
+	s.ECBalancesPMutex.Unlock()
+
+	// The Admin Blocks are applied in height order, as LoadDatabase does, so the
+	// servers federated below the snapshot height carry up to it.  Building
+	// then starts at the block above the snapshot.
+	s.ProcessLists.DBHeightBase = 0
+	s.ProcessLists.Lists = make([]*ProcessList, 0)
+	for _, d := range snap.DBStates {
+		d.AdminBlock.UpdateState(s)
+	}
+	s.ProcessLists.Get(snap.Height + 1)
+	s.ProcessLists.Lists = s.ProcessLists.Lists[snap.Height:]
+	s.ProcessLists.DBHeightBase = snap.Height
+
+	// Only now that everything checks out is the last block marked saved, which
+	// marks the node as synced up to the snapshot height.
+	last := snap.DBStates[len(snap.DBStates)-1]
+	s.DBStates.Base = snap.Height
+	s.DBStates.Complete = 0
+	s.DBStates.DBStates = make([]*DBState, 0)
+	dbstate := s.DBStates.NewDBState(false, last.DirectoryBlock, last.AdminBlock, last.FactoidBlock, last.EntryCreditBlock)
+	dbstate.Saved = true
+
+	fs := s.FactoidState.(*FactoidState)
+	fs.DBHeight = snap.Height
+	fs.CurrentBlock = last.FactoidBlock
+	fs.ProcessEndOfBlock(s)
+
+	s.SetEBDBHeightComplete(snap.Height)
+	for chain := range s.scopedChainHeights() {
+		s.setChainEBDBHeightComplete(chain, snap.Height)
+	}
+
+	return nil
+}
+
+// Verify checks that the blocks form one chain of Directory Blocks from the
+// genesis block of this node's network, that every EBlock and entry they reference is present,
+// that the summary matches the one rebuilt from the blocks, and that the
+// snapshot is signed by a server federated at its height, with the signing key
+// the Admin Blocks give it.
+func (snap *Snapshot) Verify(s *State) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("Unsupported snapshot version %d", snap.Version)
+	}
+	if snap.NetworkNumber != s.NetworkNumber {
+		return fmt.Errorf("Snapshot is for network %d, not %d", snap.NetworkNumber, s.NetworkNumber)
+	}
+	if len(snap.DBStates) != int(snap.Height)+1 {
+		return fmt.Errorf("Snapshot holds %d Directory Blocks, expected %d", len(snap.DBStates), snap.Height+1)
+	}
+
+	eblocks := make(map[[32]byte]interfaces.IEntryBlock)
+	for _, eblock := range snap.EBlocks {
+		keyMR, err := eblock.KeyMR()
+		if err != nil {
+			return err
+		}
+		eblocks[keyMR.Fixed()] = eblock
+	}
+	entries := make(map[[32]byte]interfaces.IEBEntry)
+	for _, entry := range snap.Entries {
+		entries[entry.GetHash().Fixed()] = entry
+	}
+
+	eblockCnt := 0
+	entryCnt := 0
+	for i, d := range snap.DBStates {
+		dblock := d.DirectoryBlock
+		if dblock.GetHeader().GetDBHeight() != uint32(i) {
+			return fmt.Errorf("Directory Block %d found at height %d", dblock.GetHeader().GetDBHeight(), i)
+		}
+		if i == 0 {
+			if err := snap.verifyGenesis(s); err != nil {
+				return err
+			}
+		} else {
+			prev := snap.DBStates[i-1].DirectoryBlock
+			if !dblock.GetHeader().GetPrevKeyMR().IsSameAs(prev.GetKeyMR()) {
+				return fmt.Errorf("Directory Block %d does not follow Directory Block %d", i, i-1)
+			}
+		}
+
+		dbEntries := dblock.GetDBEntries()
+		if len(dbEntries) < 3 {
+			return fmt.Errorf("Directory Block %d is missing its admin, entry credit, or factoid block", i)
+		}
+		if !dbEntries[0].GetKeyMR().IsSameAs(d.AdminBlock.GetHash()) {
+			return fmt.Errorf("Admin Block does not match Directory Block %d", i)
+		}
+		if !dbEntries[1].GetKeyMR().IsSameAs(d.EntryCreditBlock.GetHash()) {
+			return fmt.Errorf("Entry Credit Block does not match Directory Block %d", i)
+		}
+		if !dbEntries[2].GetKeyMR().IsSameAs(d.FactoidBlock.GetHash()) {
+			return fmt.Errorf("Factoid Block does not match Directory Block %d", i)
+		}
+
+		for _, dbEntry := range dbEntries[3:] {
+			eblock, ok := eblocks[dbEntry.GetKeyMR().Fixed()]
+			if !ok || !eblock.GetChainID().IsSameAs(dbEntry.GetChainID()) {
+				return fmt.Errorf("EBlock %x of Directory Block %d is missing", dbEntry.GetKeyMR().Bytes()[:5], i)
+			}
+			eblockCnt++
+			for _, entryHash := range eblock.GetEntryHashes() {
+				if entryHash.IsMinuteMarker() {
+					continue
+				}
+				if _, ok := entries[entryHash.Fixed()]; !ok {
+					return fmt.Errorf("Entry %x of Directory Block %d is missing", entryHash.Bytes()[:5], i)
+				}
+				entryCnt++
+			}
+		}
+	}
+	if eblockCnt != len(snap.EBlocks) || entryCnt != len(snap.Entries) {
+		return fmt.Errorf("Snapshot holds EBlocks or entries not found in its Directory Blocks")
+	}
+
+	fct, ec, err := snap.replayBalances()
+	if err != nil {
+		return err
+	}
+	if !sameBalances(fct, snap.FactoidBalances) {
+		return fmt.Errorf("Factoid balances do not match the blocks")
+	}
+	if !sameBalances(ec, snap.ECBalances) {
+		return fmt.Errorf("Entry Credit balances do not match the blocks")
+	}
+	heads := snap.replayChainHeads()
+	if len(heads) != len(snap.ChainHeads) {
+		return fmt.Errorf("Chain heads do not match the blocks")
+	}
+	for chain, head := range heads {
+		if h, ok := snap.ChainHeads[chain]; !ok || !h.IsSameAs(head) {
+			return fmt.Errorf("Chain heads do not match the blocks")
+		}
+	}
+
+	body, err := snap.MarshalBody()
+	if err != nil {
+		return err
+	}
+	msg := primitives.Sha(body).Bytes()
+	federated := false
+	authority := snap.authorityKeys()
+	for _, sig := range snap.Signatures {
+		if !sig.Verify(msg) {
+			return fmt.Errorf("Invalid snapshot signature from %x", sig.GetKey())
+		}
+		var key [32]byte
+		copy(key[:], sig.GetKey())
+		if authority[key] {
+			federated = true
+		}
+	}
+	if !federated {
+		return fmt.Errorf("Snapshot is not signed by a federated server")
+	}
+
+	return nil
+}
+
+// Checks that the first Directory Block is the genesis block this node would
+// start its network with: on a CUSTOM network it carries the network's magic
+// number, and its Admin Block federates the same bootstrap server LoadDatabase
+// does, and no other.
+func (snap *Snapshot) verifyGenesis(s *State) error {
+	genesis := snap.DBStates[0]
+	if s.NetworkNumber == constants.NETWORK_CUSTOM {
+		if genesis.DirectoryBlock.GetHeader().GetNetworkID() != binary.BigEndian.Uint32(s.CustomNetworkID) {
+			return fmt.Errorf("Directory Block 0 is not the genesis block of this network")
+		}
+	}
+	bootstrap, err := s.bootstrapIdentity()
+	if err != nil {
+		return err
+	}
+	federated := 0
+	for _, entry := range genesis.AdminBlock.GetABEntries() {
+		switch e := entry.(type) {
+		case *adminBlock.AddFederatedServer:
+			if !e.IdentityChainID.IsSameAs(bootstrap) {
+				return fmt.Errorf("Genesis block federates %x, not the bootstrap server", e.IdentityChainID.Bytes()[:5])
+			}
+			federated++
+		case *adminBlock.RemoveFederatedServer:
+			return fmt.Errorf("Genesis block removes a federated server")
+		case *adminBlock.AddFederatedServerSigningKey:
+			if !e.IdentityChainID.IsSameAs(bootstrap) {
+				return fmt.Errorf("Genesis block gives a signing key to %x, not the bootstrap server", e.IdentityChainID.Bytes()[:5])
+			}
+		}
+	}
+	if federated != 1 {
+		return fmt.Errorf("Genesis block does not federate the bootstrap server")
+	}
+	return nil
+}
+
+// Returns the signing keys of the servers federated at the snapshot height, as
+// its Admin Blocks add and remove them and give them keys.
+func (snap *Snapshot) authorityKeys() map[[32]byte]bool {
+	federated := make(map[[32]byte]bool)
+	keys := make(map[[32]byte]primitives.PublicKey)
+	for _, d := range snap.DBStates {
+		for _, entry := range d.AdminBlock.GetABEntries() {
+			switch e := entry.(type) {
+			case *adminBlock.AddFederatedServer:
+				federated[e.IdentityChainID.Fixed()] = true
+			case *adminBlock.RemoveFederatedServer:
+				delete(federated, e.IdentityChainID.Fixed())
+			case *adminBlock.AddFederatedServerSigningKey:
+				keys[e.IdentityChainID.Fixed()] = e.PublicKey
+			}
+		}
+	}
+	authority := make(map[[32]byte]bool)
+	for identity := range federated {
+		if key, ok := keys[identity]; ok {
+			authority[key] = true
+		}
+	}
+	return authority
+}
+
+// Rebuilds the balances at the snapshot height from its Factoid and Entry Credit
+// blocks, each at the exchange rate of its own Factoid Block.
+func (snap *Snapshot) replayBalances() (fct map[[32]byte]int64, ec map[[32]byte]int64, err error) {
+	s := new(State)
+	s.FactoidBalancesP = map[[32]byte]int64{}
+	s.ECBalancesP = map[[32]byte]int64{}
+	s.FactoidBalancesT = map[[32]byte]int64{}
+	s.ECBalancesT = map[[32]byte]int64{}
+
+	fs := new(FactoidState)
+	fs.State = s
+	for _, d := range snap.DBStates {
+		s.FactoshisPerEC = d.FactoidBlock.GetExchRate()
+		if err = fs.AddTransactionBlock(d.FactoidBlock); err != nil {
+			return
+		}
+		if err = fs.AddECBlock(d.EntryCreditBlock); err != nil {
+			return
+		}
+	}
+	return s.FactoidBalancesP, s.ECBalancesP, nil
+}
+
+// Returns the KeyMR of the last EBlock of every chain in the snapshot.
+func (snap *Snapshot) replayChainHeads() map[[32]byte]interfaces.IHash {
+	heads := make(map[[32]byte]interfaces.IHash)
+	for _, eblock := range snap.EBlocks {
+		keyMR, err := eblock.KeyMR()
+		if err != nil {
+			continue
+		}
+		heads[eblock.GetChainID().Fixed()] = keyMR
+	}
+	return heads
+}
+
+func sameBalances(a, b map[[32]byte]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Map keys are written in order, so that the same snapshot always has the same signature.
+type snapshotKeys [][32]byte
+
+func (k snapshotKeys) Len() int           { return len(k) }
+func (k snapshotKeys) Less(i, j int) bool { return bytes.Compare(k[i][:], k[j][:]) < 0 }
+func (k snapshotKeys) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+func sortedBalanceKeys(m map[[32]byte]int64) snapshotKeys {
+	keys := make(snapshotKeys, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+	return keys
+}
+
+func writeSnapshotData(buf *primitives.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+func readSnapshotData(data []byte) (record []byte, newData []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("Snapshot is truncated")
+	}
+	l, data := binary.BigEndian.Uint32(data[0:4]), data[4:]
+	if uint32(len(data)) < l {
+		return nil, nil, fmt.Errorf("Snapshot is truncated")
+	}
+	return data[:l], data[l:], nil
+}
+
+// MarshalBody marshals everything but the signatures.  The Sha of the body is
+// what the federated servers sign.
+func (snap *Snapshot) MarshalBody() ([]byte, error) {
+	var buf primitives.Buffer
+
+	binary.Write(&buf, binary.BigEndian, snap.Version)
+	binary.Write(&buf, binary.BigEndian, uint32(snap.NetworkNumber))
+	binary.Write(&buf, binary.BigEndian, snap.Height)
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(snap.DBStates)))
+	for _, d := range snap.DBStates {
+		data, err := d.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		writeSnapshotData(&buf, data)
+	}
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(snap.EBlocks)))
+	for _, eblock := range snap.EBlocks {
+		data, err := eblock.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		writeSnapshotData(&buf, data)
+	}
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(snap.Entries)))
+	for _, entry := range snap.Entries {
+		data, err := entry.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		writeSnapshotData(&buf, data)
+	}
+
+	for _, balances := range []map[[32]byte]int64{snap.FactoidBalances, snap.ECBalances} {
+		keys := sortedBalanceKeys(balances)
+		binary.Write(&buf, binary.BigEndian, uint32(len(keys)))
+		for _, k := range keys {
+			buf.Write(k[:])
+			binary.Write(&buf, binary.BigEndian, balances[k])
+		}
+	}
+
+	keys := make(snapshotKeys, 0, len(snap.ChainHeads))
+	for k := range snap.ChainHeads {
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+	binary.Write(&buf, binary.BigEndian, uint32(len(keys)))
+	for _, k := range keys {
+		buf.Write(k[:])
+		buf.Write(snap.ChainHeads[k].Bytes())
+	}
+
+	return buf.DeepCopyBytes(), nil
+}
+
+func (snap *Snapshot) MarshalBinary() ([]byte, error) {
+	var buf primitives.Buffer
+
+	body, err := snap.MarshalBody()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(body)
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(snap.Signatures)))
+	for _, sig := range snap.Signatures {
+		data, err := sig.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+
+	return buf.DeepCopyBytes(), nil
+}
+
+func (snap *Snapshot) UnmarshalBinaryData(data []byte) (newData []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Error unmarshalling Snapshot: %v", r)
+		}
+	}()
+	newData = data
+
+	snap.Version, newData = newData[0], newData[1:]
+	if snap.Version != SnapshotVersion {
+		return nil, fmt.Errorf("Unsupported snapshot version %d", snap.Version)
+	}
+	snap.NetworkNumber, newData = int(binary.BigEndian.Uint32(newData[0:4])), newData[4:]
+	snap.Height, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+
+	var record []byte
+	cnt, newData := binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+	snap.DBStates = nil
+	for i := uint32(0); i < cnt; i++ {
+		record, newData, err = readSnapshotData(newData)
+		if err != nil {
+			return nil, err
+		}
+		d := new(messages.DBStateMsg)
+		if err = d.UnmarshalBinary(record); err != nil {
+			return nil, err
+		}
+		snap.DBStates = append(snap.DBStates, d)
+	}
+
+	cnt, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+	snap.EBlocks = nil
+	for i := uint32(0); i < cnt; i++ {
+		record, newData, err = readSnapshotData(newData)
+		if err != nil {
+			return nil, err
+		}
+		eblock := entryBlock.NewEBlock()
+		if err = eblock.UnmarshalBinary(record); err != nil {
+			return nil, err
+		}
+		snap.EBlocks = append(snap.EBlocks, eblock)
+	}
+
+	cnt, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+	snap.Entries = nil
+	for i := uint32(0); i < cnt; i++ {
+		record, newData, err = readSnapshotData(newData)
+		if err != nil {
+			return nil, err
+		}
+		entry := entryBlock.NewEntry()
+		if err = entry.UnmarshalBinary(record); err != nil {
+			return nil, err
+		}
+		snap.Entries = append(snap.Entries, entry)
+	}
+
+	snap.FactoidBalances = map[[32]byte]int64{}
+	snap.ECBalances = map[[32]byte]int64{}
+	for _, balances := range []map[[32]byte]int64{snap.FactoidBalances, snap.ECBalances} {
+		cnt, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+		for i := uint32(0); i < cnt; i++ {
+			var k [32]byte
+			copy(k[:], newData[:32])
+			newData = newData[32:]
+			balances[k], newData = int64(binary.BigEndian.Uint64(newData[0:8])), newData[8:]
+		}
+	}
+
+	snap.ChainHeads = make(map[[32]byte]interfaces.IHash)
+	cnt, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+	for i := uint32(0); i < cnt; i++ {
+		var k [32]byte
+		copy(k[:], newData[:32])
+		newData = newData[32:]
+		snap.ChainHeads[k], newData = primitives.NewHash(newData[:32]), newData[32:]
+	}
+
+	cnt, newData = binary.BigEndian.Uint32(newData[0:4]), newData[4:]
+	snap.Signatures = nil
+	for i := uint32(0); i < cnt; i++ {
+		sig := new(primitives.Signature)
+		newData, err = sig.UnmarshalBinaryData(newData)
+		if err != nil {
+			return nil, err
+		}
+		snap.Signatures = append(snap.Signatures, sig)
+	}
+
+	return
+}
+
+func (snap *Snapshot) UnmarshalBinary(data []byte) error {
+	_, err := snap.UnmarshalBinaryData(data)
+	return err
+}
@@ -0,0 +1,212 @@
+// Copyright 2016 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/FactomProject/factomd/common/adminBlock"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
+	. "github.com/FactomProject/factomd/state"
+	"github.com/FactomProject/factomd/testHelper"
+)
+
+func TestSnapshotExportImport(t *testing.T) {
+	s := testHelper.CreateAndPopulateTestState()
+	height := uint32(testHelper.BlockCount - 1)
+
+	var buf bytes.Buffer
+	err := s.ExportSnapshot(height, &buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	data := buf.Bytes()
+
+	snap := new(Snapshot)
+	err = snap.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if snap.Height != height || len(snap.DBStates) != int(height)+1 {
+		t.Errorf("Invalid snapshot height %d", snap.Height)
+	}
+	data2, err := snap.MarshalBinary()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if bytes.Compare(data, data2) != 0 {
+		t.Errorf("Snapshot does not marshal back to the same bytes")
+	}
+
+	// Any change to a block has to break the snapshot.
+	bad := new(Snapshot)
+	bad.UnmarshalBinary(data)
+	bad.DBStates[1] = bad.DBStates[2]
+	if bad.Verify(s) == nil {
+		t.Errorf("Snapshot with a broken Directory Block chain should not verify")
+	}
+
+	// And so does any change to the summary.
+	bad = new(Snapshot)
+	bad.UnmarshalBinary(data)
+	for k := range bad.FactoidBalances {
+		bad.FactoidBalances[k]++
+		break
+	}
+	if bad.Verify(s) == nil {
+		t.Errorf("Snapshot with a wrong balance should not verify")
+	}
+
+	// Only a server federated at the snapshot height can vouch for it.
+	bad = new(Snapshot)
+	bad.UnmarshalBinary(data)
+	body, err := bad.MarshalBody()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	key, err := primitives.NewPrivateKeyFromHex(hex.EncodeToString(testHelper.NewPrivKey(1)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	bad.Signatures = []interfaces.IFullSignature{key.Sign(primitives.Sha(body).Bytes())}
+	if bad.Verify(s) == nil {
+		t.Errorf("Snapshot signed by a server that is not federated should not verify")
+	}
+
+	// A populated database cannot be bootstrapped.
+	err = s.ImportSnapshot(bytes.NewReader(data))
+	if err == nil {
+		t.Errorf("Importing a snapshot into a populated database should fail")
+	}
+
+	s2 := new(State)
+	s2.DB = testHelper.CreateEmptyTestDatabaseOverlay()
+	s2.LoadConfig("", "")
+	s2.Init()
+	err = s2.ImportSnapshot(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if s2.GetDBHeightComplete() != height {
+		t.Errorf("Imported state is at height %d, expected %d", s2.GetDBHeightComplete(), height)
+	}
+	if !s2.GetDirectoryBlock().GetKeyMR().IsSameAs(snap.DBStates[height].DirectoryBlock.GetKeyMR()) {
+		t.Errorf("Imported Directory Block does not match")
+	}
+	for k, v := range snap.FactoidBalances {
+		if s2.GetF(k) != v {
+			t.Errorf("Invalid imported balance for %x", k)
+		}
+	}
+}
+
+// Relinks the Directory Blocks of a snapshot whose Admin Blocks were changed,
+// and signs it with key, so the chain is consistent again but for its genesis.
+func relinkSnapshot(t *testing.T, snap *Snapshot, key *primitives.PrivateKey) {
+	for i, d := range snap.DBStates {
+		d.AdminBlock.GetHeader().SetMessageCount(uint32(len(d.AdminBlock.GetABEntries())))
+		d.DirectoryBlock.GetDBEntries()[0].SetKeyMR(d.AdminBlock.GetHash())
+		if _, err := d.DirectoryBlock.BuildBodyMR(); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if i > 0 {
+			d.DirectoryBlock.GetHeader().SetPrevKeyMR(snap.DBStates[i-1].DirectoryBlock.GetKeyMR())
+		}
+		if _, err := d.DirectoryBlock.BuildKeyMerkleRoot(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	body, err := snap.MarshalBody()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	snap.Signatures = []interfaces.IFullSignature{key.Sign(primitives.Sha(body).Bytes())}
+}
+
+func TestSnapshotForgedGenesis(t *testing.T) {
+	s := testHelper.CreateAndPopulateTestState()
+	var buf bytes.Buffer
+	if err := s.ExportSnapshot(uint32(testHelper.BlockCount-1), &buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// A chain whose genesis block federates someone else's identity and key
+	// holds together, and is signed by that key, but is not this network's.
+	snap := new(Snapshot)
+	if err := snap.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	key, err := primitives.NewPrivateKeyFromHex(hex.EncodeToString(testHelper.NewPrivKey(1)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	identity := primitives.Sha([]byte("Forger"))
+	genesis := snap.DBStates[0].AdminBlock.(*adminBlock.AdminBlock)
+	genesis.ABEntries = nil
+	genesis.AddFedServer(identity)
+	genesis.AddABEntry(adminBlock.NewAddFederatedServerSigningKey(identity, 0, *key.Pub))
+	relinkSnapshot(t, snap, &key)
+	if snap.Verify(s) == nil {
+		t.Errorf("Snapshot from a forged genesis block should not verify")
+	}
+
+	// Nor may the genesis block federate another server beside the bootstrap one.
+	snap = new(Snapshot)
+	if err := snap.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	genesis = snap.DBStates[0].AdminBlock.(*adminBlock.AdminBlock)
+	genesis.AddFedServer(identity)
+	genesis.AddABEntry(adminBlock.NewAddFederatedServerSigningKey(identity, 0, *key.Pub))
+	relinkSnapshot(t, snap, &key)
+	if snap.Verify(s) == nil {
+		t.Errorf("Snapshot whose genesis block federates a second server should not verify")
+	}
+}
+
+func TestSnapshotImportFederatedServers(t *testing.T) {
+	s := testHelper.CreateAndPopulateTestState()
+	height := uint32(testHelper.BlockCount - 1)
+	var buf bytes.Buffer
+	if err := s.ExportSnapshot(height, &buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// A second server is federated well below the snapshot height.
+	snap := new(Snapshot)
+	if err := snap.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	identity := primitives.Sha([]byte("FNode1"))
+	snap.DBStates[2].AdminBlock.AddFedServer(identity)
+	key, err := primitives.NewPrivateKeyFromHex(testHelper.DefaultServerPrivKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	relinkSnapshot(t, snap, &key)
+	if err := snap.Verify(s); err != nil {
+		t.Fatalf("%v", err)
+	}
+	data, err := snap.MarshalBinary()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	s2 := new(State)
+	s2.DB = testHelper.CreateEmptyTestDatabaseOverlay()
+	s2.LoadConfig("", "")
+	s2.Init()
+	if err := s2.ImportSnapshot(bytes.NewReader(data)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, id := range []interfaces.IHash{primitives.Sha([]byte("FNode0")), identity} {
+		if found, _ := s2.GetVirtualServers(height+1, 0, id); !found {
+			t.Errorf("Server %x is not federated above the snapshot height", id.Bytes()[:5])
+		}
+	}
+}
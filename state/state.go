@@ -15,6 +15,7 @@ import (
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/messages"
 	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/database/badgerdb"
 	"github.com/FactomProject/factomd/database/databaseOverlay"
 	"github.com/FactomProject/factomd/database/hybridDB"
 	"github.com/FactomProject/factomd/database/mapdb"
@@ -39,6 +40,8 @@ type State struct {
 	LogPath                 string
 	LdbPath                 string
 	BoltDBPath              string
+	BadgerPath              string
+	BadgerOptions           badgerdb.Options
 	LogLevel                string
 	ConsoleLogLevel         string
 	NodeMode                string
@@ -47,6 +50,8 @@ type State struct {
 	ExportData              bool
 	ExportDataSubpath       string
 	Network                 string
+	CustomNetworkID         []byte // Magic number of a CUSTOM network, from the hash of its name
+	CustomBootstrapIdentity string // Federated server in a CUSTOM network's genesis block, "" for the LOCAL one
 	LocalServerPrivKey      string
 	DirectoryBlockInSeconds int
 	PortNumber              int
@@ -55,6 +60,9 @@ type State struct {
 	GreenFlg                bool
 	GreenCnt                int
 	DropRate                int
+	ChainScope              string // Comma separated chain IDs to sync.  Empty syncs all chains.
+	BroadcastMode           string // "gossip" or "flood"
+	GossipFanout            int    // Peers a gossiped message is sent to in full
 
 	IdentityChainID interfaces.IHash // If this node has an identity, this is it
 
@@ -70,9 +78,13 @@ type State struct {
 	leaderMsgQueue         chan interfaces.IMsg
 	followerMsgQueue       chan interfaces.IMsg
 	stallQueue             chan interfaces.IMsg
+	stallTurn              bool // Stalled messages go first in the next ProcessQueues
 	undo                   interfaces.IMsg
 	ShutdownChan           chan int // For gracefully halting Factom
 	JournalFile            string
+	Journaling             bool // If true, write a journal of the messages received to JournalFile
+	journal                journal
+	MsgTrace               func(stage string, msg interfaces.IMsg) // If not nil, told as messages pass each stage of consensus
 
 	serverPrivKey primitives.PrivateKey
 	serverPubKey  primitives.PublicKey
@@ -153,35 +165,69 @@ type State struct {
 	// DBlock Height at which node has a complete set of eblocks+entries
 	EBDBHeightComplete uint32
 
+	// If not empty, only EBlocks and entries for these chains are requested and stored.
+	// Each scoped chain tracks the DBlock Height at which it has a complete set of eblocks+entries
+	ScopedChains map[[32]byte]uint32
+	scopeMutex   sync.RWMutex // Guards ScopedChains, read by the API while the validator writes it
+
 	// For dataRequests made by this node, which it's awaiting dataResponses for
 	DataRequests map[[32]byte]interfaces.IHash
 
+	// Digests of peers' blocks, held until this node saves their height, and
+	// the first height where a peer's digest disagreed with this node's.
+	peerDigests        map[uint32][]*BlockDigest
+	unpublishedDigests []uint32 // Heights saved whose digests publishDigests is yet to send
+	Divergence         *Divergence
+
+	// The signing key the Admin Blocks give each server identity, and the
+	// identity of each federated server by its signing key, which the network
+	// looks peers up in.
+	signingKeys    map[[32]byte][32]byte
+	federatedKeys  map[[32]byte]interfaces.IHash
+	federatedMutex sync.RWMutex // Guards federatedKeys, read by the network while the validator writes it
+
 	LastPrint    string
 	LastPrintCnt int
 }
 
 var _ interfaces.IState = (*State)(nil)
 
+// copyConfig copies to the configuration that every node of the network of s
+// shares.  Clone and Fresh both start from it.
+func (s *State) copyConfig(to *State) {
+	to.FactomdVersion = s.FactomdVersion
+	to.Journaling = s.Journaling
+	to.BadgerOptions = s.BadgerOptions
+	to.LogLevel = s.LogLevel
+	to.ConsoleLogLevel = s.ConsoleLogLevel
+	to.CloneDBType = s.CloneDBType
+	to.ExportData = s.ExportData
+	to.Network = s.Network
+	to.CustomNetworkID = s.CustomNetworkID
+	to.CustomBootstrapIdentity = s.CustomBootstrapIdentity
+	to.DirectoryBlockInSeconds = s.DirectoryBlockInSeconds
+	to.PortNumber = s.PortNumber
+	to.ChainScope = s.ChainScope
+	to.BroadcastMode = s.BroadcastMode
+	to.GossipFanout = s.GossipFanout
+	to.FactoshisPerEC = s.FactoshisPerEC
+	to.Port = s.Port
+}
+
 func (s *State) Clone(number string) interfaces.IState {
 
 	clone := new(State)
+	s.copyConfig(clone)
 
 	clone.FactomNodeName = s.Prefix + "FNode" + number
-	clone.FactomdVersion = s.FactomdVersion
 	clone.LogPath = s.LogPath + "Sim" + number
 	clone.LdbPath = s.LdbPath + "Sim" + number
 	clone.JournalFile = s.LogPath + "journal" + number + ".log"
 	clone.BoltDBPath = s.BoltDBPath + "Sim" + number
-	clone.LogLevel = s.LogLevel
-	clone.ConsoleLogLevel = s.ConsoleLogLevel
+	clone.BadgerPath = s.BadgerPath + "Sim" + number
 	clone.NodeMode = "FULL"
-	clone.CloneDBType = s.CloneDBType
 	clone.DBType = s.CloneDBType
-	clone.ExportData = s.ExportData
 	clone.ExportDataSubpath = s.ExportDataSubpath + "sim-" + number
-	clone.Network = s.Network
-	clone.DirectoryBlockInSeconds = s.DirectoryBlockInSeconds
-	clone.PortNumber = s.PortNumber
 
 	clone.IdentityChainID = primitives.Sha([]byte(clone.FactomNodeName))
 
@@ -193,11 +239,44 @@ func (s *State) Clone(number string) interfaces.IState {
 	//serverPrivKey primitives.PrivateKey
 	//serverPubKey  primitives.PublicKey
 
-	clone.FactoshisPerEC = s.FactoshisPerEC
+	return clone
+}
 
-	clone.Port = s.Port
+// Fresh returns a new State for the node s is, as it was before Init: the
+// same name, identity, keys, paths and configuration, but nothing it learned
+// while running.  Once Init is called on it, it opens the node's database
+// again, so a node restarted on it loads what it saved, and catches up on the
+// rest from its peers.  A Map database is only in memory, so it is carried
+// over, as a database on disk would be.
+func (s *State) Fresh() *State {
+
+	fresh := new(State)
+	s.copyConfig(fresh)
+
+	fresh.filename = s.filename
+	fresh.Cfg = s.Cfg
+	fresh.Prefix = s.Prefix
+	fresh.FactomNodeName = s.FactomNodeName
+	fresh.LogPath = s.LogPath
+	fresh.LdbPath = s.LdbPath
+	fresh.JournalFile = s.JournalFile
+	fresh.BoltDBPath = s.BoltDBPath
+	fresh.BadgerPath = s.BadgerPath
+	fresh.NodeMode = s.NodeMode
+	fresh.DBType = s.DBType
+	fresh.ExportDataSubpath = s.ExportDataSubpath
+	fresh.IdentityChainID = s.IdentityChainID
+	fresh.LocalServerPrivKey = s.LocalServerPrivKey
+	fresh.DropRate = s.DropRate
+	fresh.OutputAllowed = s.OutputAllowed
+	fresh.DebugConsensus = s.DebugConsensus
+	fresh.MsgTrace = s.MsgTrace
+
+	if s.DBType == "Map" {
+		fresh.DB = s.DB
+	}
 
-	return clone
+	return fresh
 }
 
 func (s *State) AddPrefix(prefix string) {
@@ -238,6 +317,16 @@ func (s *State) LoadConfig(filename string, folder string) {
 		s.LogPath = cfg.Log.LogPath + s.Prefix
 		s.LdbPath = cfg.App.LdbPath + s.Prefix
 		s.BoltDBPath = cfg.App.BoltDBPath + s.Prefix
+		s.BadgerPath = cfg.App.BadgerPath + s.Prefix
+		s.BadgerOptions = badgerdb.Options{
+			Compression:        cfg.App.BadgerCompression,
+			BlockCacheMB:       cfg.App.BadgerBlockCacheMB,
+			BloomFalsePositive: cfg.App.BadgerBloomFalsePositive,
+			MemTableMB:         cfg.App.BadgerMemTableMB,
+			NumMemtables:       cfg.App.BadgerNumMemtables,
+			ValueLogFileMB:     cfg.App.BadgerValueLogFileMB,
+			SyncWrites:         cfg.App.BadgerSyncWrites,
+		}
 		s.LogLevel = cfg.Log.LogLevel
 		s.ConsoleLogLevel = cfg.Log.ConsoleLogLevel
 		s.NodeMode = cfg.App.NodeMode
@@ -245,10 +334,17 @@ func (s *State) LoadConfig(filename string, folder string) {
 		s.ExportData = cfg.App.ExportData // bool
 		s.ExportDataSubpath = cfg.App.ExportDataSubpath
 		s.Network = cfg.App.Network
+		if 0 < len(cfg.App.CustomNetworkID) {
+			s.CustomNetworkID = primitives.Sha([]byte(cfg.App.CustomNetworkID)).Bytes()[:4]
+		}
+		s.CustomBootstrapIdentity = cfg.App.CustomBootstrapIdentity
 		s.LocalServerPrivKey = cfg.App.LocalServerPrivKey
 		s.FactoshisPerEC = cfg.App.ExchangeRate
 		s.DirectoryBlockInSeconds = cfg.App.DirectoryBlockInSeconds
 		s.PortNumber = cfg.Wsapi.PortNumber
+		s.ChainScope = cfg.App.ChainScope
+		s.BroadcastMode = cfg.App.BroadcastMode
+		s.GossipFanout = cfg.App.GossipFanout
 
 		// TODO:  Actually load the IdentityChainID from the config file
 		s.IdentityChainID = primitives.Sha([]byte(s.FactomNodeName))
@@ -256,6 +352,8 @@ func (s *State) LoadConfig(filename string, folder string) {
 		s.LogPath = "database/"
 		s.LdbPath = "database/ldb"
 		s.BoltDBPath = "database/bolt"
+		s.BadgerPath = "database/badger"
+		s.BadgerOptions = badgerdb.DefaultOptions()
 		s.LogLevel = "none"
 		s.ConsoleLogLevel = "standard"
 		s.NodeMode = "SERVER"
@@ -267,6 +365,8 @@ func (s *State) LoadConfig(filename string, folder string) {
 		s.FactoshisPerEC = 006666
 		s.DirectoryBlockInSeconds = 6
 		s.PortNumber = 8088
+		s.BroadcastMode = "gossip"
+		s.GossipFanout = 4
 
 		// TODO:  Actually load the IdentityChainID from the config file
 		s.IdentityChainID = primitives.Sha([]byte(s.FactomNodeName))
@@ -331,6 +431,9 @@ func (s *State) Init() {
 
 	s.EBDBHeightComplete = 0
 	s.DataRequests = make(map[[32]byte]interfaces.IHash)
+	if err := s.SetChainScope(s.ChainScope); err != nil {
+		panic("Bad value for ChainScope in factomd.conf: " + err.Error())
+	}
 
 	switch s.NodeMode {
 	case "FULL":
@@ -356,6 +459,10 @@ func (s *State) Init() {
 		if err := s.InitBoltDB(); err != nil {
 			log.Printfln("Error initializing the database: %v", err)
 		}
+	case "Badger":
+		if err := s.InitBadgerDB(); err != nil {
+			log.Printfln("Error initializing the database: %v", err)
+		}
 	case "Map":
 		if err := s.InitMapDB(); err != nil {
 			log.Printfln("Error initializing the database: %v", err)
@@ -369,8 +476,21 @@ func (s *State) Init() {
 		s.DB.SetExportData(s.ExportDataSubpath)
 		s.DBMutex.Unlock()
 	}
+	s.loadChainScopeHeights()
 
 	//Network
+	s.initNetwork()
+
+	s.Println("\nRunning on the ", s.Network, "Network")
+
+	s.AuditHeartBeats = make([]interfaces.IMsg, 0)
+	s.FedServerFaults = make([][]interfaces.IMsg, 0)
+
+	s.initServerKeys()
+
+}
+
+func (s *State) initNetwork() {
 	switch s.Network {
 	case "MAIN":
 		s.NetworkNumber = constants.NETWORK_MAIN
@@ -380,17 +500,12 @@ func (s *State) Init() {
 		s.NetworkNumber = constants.NETWORK_LOCAL
 	case "CUSTOM":
 		s.NetworkNumber = constants.NETWORK_CUSTOM
+		if len(s.CustomNetworkID) == 0 {
+			panic("CustomNetworkID must be set in factomd.conf to run a CUSTOM network")
+		}
 	default:
 		panic("Bad value for Network in factomd.conf")
 	}
-
-	s.Println("\nRunning on the ", s.Network, "Network")
-
-	s.AuditHeartBeats = make([]interfaces.IMsg, 0)
-	s.FedServerFaults = make([][]interfaces.IMsg, 0)
-
-	s.initServerKeys()
-
 }
 
 func (s *State) AddDataRequest(requestedHash, missingDataHash interfaces.IHash) {
@@ -638,19 +753,6 @@ func (s *State) MessageToLogString(msg interfaces.IMsg) string {
 	return answer
 }
 
-func (s *State) JournalMessage(msg interfaces.IMsg) {
-	if len(s.JournalFile) == 0 {
-		f, err := os.OpenFile(s.JournalFile, os.O_APPEND+os.O_WRONLY, 0666)
-		if err != nil {
-			s.JournalFile = ""
-			return
-		}
-		str := s.MessageToLogString(msg)
-		f.WriteString(str)
-		f.Close()
-	}
-}
-
 func (s *State) GetLeaderVM() int {
 	return s.LeaderVMIndex
 }
@@ -685,7 +787,11 @@ func (s *State) UpdateState() (progress bool) {
 	p2 := s.DBStates.UpdateState()
 	progress = progress || p2
 
-	s.catchupEBlocks()
+	if s.IsChainScoped() {
+		s.catchupScopedEBlocks()
+	} else {
+		s.catchupEBlocks()
+	}
 
 	return
 }
@@ -696,16 +802,8 @@ func (s *State) catchupEBlocks() {
 		dblockGathering := s.GetDirectoryBlockByHeight(s.GetEBDBHeightComplete())
 		for idx, ebKeyMR := range dblockGathering.GetEntryHashes() {
 			if idx > 2 {
-				if s.DatabaseContains(ebKeyMR) {
-					if !s.GetAllEntries(ebKeyMR) {
-						isComplete = false
-					}
-				} else {
+				if !s.catchupEBlock(ebKeyMR) {
 					isComplete = false
-					if !s.HasDataRequest(ebKeyMR) {
-						eBlockRequest := messages.NewMissingData(s, ebKeyMR)
-						s.NetworkOutMsgQueue() <- eBlockRequest
-					}
 				}
 			}
 		}
@@ -715,12 +813,27 @@ func (s *State) catchupEBlocks() {
 	}
 }
 
+// Requests the given EBlock, or its missing entries, if we don't have them yet.
+// Returns true if the EBlock and all its entries are in the database.
+func (s *State) catchupEBlock(ebKeyMR interfaces.IHash) bool {
+	if s.DatabaseContains(ebKeyMR) {
+		return s.GetAllEntries(ebKeyMR)
+	}
+	if !s.HasDataRequest(ebKeyMR) {
+		eBlockRequest := messages.NewMissingData(s, ebKeyMR)
+		s.NetworkOutMsgQueue() <- eBlockRequest
+	}
+	return false
+}
+
 func (s *State) GetEOM() int {
 	return s.EOM
 }
 
 func (s *State) AddFedServer(dbheight uint32, hash interfaces.IHash) int {
-	return s.ProcessLists.Get(dbheight).AddFedServer(hash)
+	index := s.ProcessLists.Get(dbheight).AddFedServer(hash)
+	s.updateFederatedKeys(dbheight)
+	return index
 }
 
 func (s *State) AddAuditServer(dbheight uint32, hash interfaces.IHash) int {
@@ -925,7 +1038,7 @@ func (s *State) InitLevelDB() error {
 		}
 	}
 
-	s.DB = databaseOverlay.NewOverlay(dbase)
+	s.DB = databaseOverlay.NewMeteredOverlay(dbase)
 	return nil
 }
 
@@ -941,10 +1054,93 @@ func (s *State) InitBoltDB() error {
 	s.Println("Database Path for", s.FactomNodeName, "is", path)
 	os.MkdirAll(path, 0777)
 	dbase := hybridDB.NewBoltMapHybridDB(nil, path+"FactomBolt.db")
-	s.DB = databaseOverlay.NewOverlay(dbase)
+	s.DB = databaseOverlay.NewMeteredOverlay(dbase)
 	return nil
 }
 
+func (s *State) InitBadgerDB() error {
+	s.DBMutex.Lock()
+	defer s.DBMutex.Unlock()
+
+	if s.DB != nil {
+		return nil
+	}
+
+	path := s.BadgerPath + "/" + s.Network + "/" + "factoid_badger.db"
+
+	s.Println("Database:", path)
+
+	dbase, err := hybridDB.NewBadgerMapHybridDB(path, false, s.BadgerOptions)
+
+	if err != nil || dbase == nil {
+		dbase, err = hybridDB.NewBadgerMapHybridDB(path, true, s.BadgerOptions)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.DB = databaseOverlay.NewMeteredOverlay(dbase)
+	return nil
+}
+
+// InitReadOnly sets up what a tool run against a factomd's database needs of the
+// State, rather than all that Init starts: the network and server keys of the
+// configuration, and a read only snapshot of the database.
+func (s *State) InitReadOnly() error {
+	s.initNetwork()
+	s.initServerKeys()
+	return s.InitReadOnlyDB()
+}
+
+// InitReadOnlyDB opens a snapshot of the database of the configured DBType.  The
+// database may belong to a running factomd, so tools can read it without
+// stopping the node.
+func (s *State) InitReadOnlyDB() error {
+	s.DBMutex.Lock()
+	defer s.DBMutex.Unlock()
+
+	if s.DB != nil {
+		return nil
+	}
+
+	path, err := s.dbPath()
+	if err != nil {
+		return err
+	}
+
+	s.Println("Database (read only):", path)
+
+	dbo, err := databaseOverlay.NewReadOnlyOverlay(s.DBType, path)
+	if err != nil {
+		return err
+	}
+	s.DB = dbo
+	return nil
+}
+
+// OpenReadOnlyDB opens a snapshot of this node's own database, to read at
+// length without holding up the node's writes.  The caller closes it.
+func (s *State) OpenReadOnlyDB() (interfaces.DBOverlay, error) {
+	path, err := s.dbPath()
+	if err != nil {
+		return nil, err
+	}
+	return databaseOverlay.NewReadOnlyOverlay(s.DBType, path)
+}
+
+// dbPath returns where the database of the configured DBType is kept.
+func (s *State) dbPath() (string, error) {
+	switch s.DBType {
+	case "LDB":
+		return s.LdbPath + "/" + s.Network + "/" + "factoid_level.db", nil
+	case "Bolt":
+		return s.BoltDBPath + "/" + s.Network + "/" + "FactomBolt.db", nil
+	case "Badger":
+		return s.BadgerPath + "/" + s.Network + "/" + "factoid_badger.db", nil
+	}
+	return "", fmt.Errorf("Cannot open a %s database read only", s.DBType)
+}
+
 func (s *State) InitMapDB() error {
 	s.DBMutex.Lock()
 	defer s.DBMutex.Unlock()
@@ -955,7 +1151,7 @@ func (s *State) InitMapDB() error {
 
 	dbase := new(mapdb.MapDB)
 	dbase.Init(nil)
-	s.DB = databaseOverlay.NewOverlay(dbase)
+	s.DB = databaseOverlay.NewMeteredOverlay(dbase)
 	return nil
 }
 
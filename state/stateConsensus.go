@@ -5,7 +5,9 @@
 package state
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
 
 	"github.com/FactomProject/factomd/common/adminBlock"
 	"github.com/FactomProject/factomd/common/constants"
@@ -14,7 +16,6 @@ import (
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/messages"
 	"github.com/FactomProject/factomd/common/primitives"
-	"github.com/FactomProject/factomd/util"
 	"os"
 )
 
@@ -28,8 +29,14 @@ var _ = fmt.Print
 func (s *State) NewMinute() {
 	s.LeaderPL.Unseal(s.EOM)
 	s.Review = make([]interfaces.IMsg, 0, len(s.Holding))
-	// Anything we are holding, we need to reprocess.
+	// Anything we are holding, we need to reprocess.  In the order of their
+	// hashes, so a simulation run twice on the same seed runs the same way.
+	keys := make([][32]byte, 0, len(s.Holding))
 	for k := range s.Holding {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	for _, k := range keys {
 		if v := s.Holding[k]; v != nil {
 			s.Review = append(s.Review, v)
 			s.Holding[k] = nil
@@ -168,21 +175,29 @@ func (s *State) ProcessQueues() (progress bool) {
 		}
 	}
 
-	// If all my messages are empy, see if I can process a stalled message
+	// If all my messages are empy, see if I can process a stalled message.
+	// The stalled and follower messages take turns, rather than leaving it
+	// to select, which picks at random, so a simulation repeats itself.
 	if msg == nil {
-		select {
-		case msg = <-s.stallQueue:
-			_, ok := s.InternalReplay.Valid(msg.GetHash().Fixed(), int64(msg.GetTimestamp()), int64(s.GetTimestamp()))
-			if !ok {
-				msg = nil
-			}
-		case msg = <-s.followerMsgQueue:
-			_, ok := s.InternalReplay.Valid(msg.GetHash().Fixed(), int64(msg.GetTimestamp()), int64(s.GetTimestamp()))
-			if !ok {
-				msg = nil
+		s.stallTurn = !s.stallTurn
+		queues := []chan interfaces.IMsg{s.stallQueue, s.followerMsgQueue}
+		if !s.stallTurn {
+			queues[0], queues[1] = queues[1], queues[0]
+		}
+		for _, queue := range queues {
+			select {
+			case msg = <-queue:
+				_, ok := s.InternalReplay.Valid(msg.GetHash().Fixed(), int64(msg.GetTimestamp()), int64(s.GetTimestamp()))
+				if !ok {
+					msg = nil
+				}
+				if queue == s.followerMsgQueue {
+					progress = true
+				}
+			default:
+				continue
 			}
-			progress = true
-		default:
+			break
 		}
 	}
 
@@ -244,14 +259,15 @@ func (s *State) addEBlock(eblock interfaces.IEntryBlock) {
 	hash, err := eblock.KeyMR()
 
 	if err == nil {
-		if s.HasDataRequest(hash) {
+		if s.HasDataRequest(hash) && s.IsChainInScope(eblock.GetChainID()) {
 			s.DBMutex.Lock()
 			defer s.DBMutex.Unlock()
 
 			s.DB.ProcessEBlockBatch(eblock, true)
 			delete(s.DataRequests, hash.Fixed())
 
-			if s.GetAllEntries(hash) {
+			// A chain scoped node tracks completeness per chain in catchupScopedEBlocks()
+			if s.GetAllEntries(hash) && !s.IsChainScoped() {
 				if s.GetEBDBHeightComplete() < eblock.GetDatabaseHeight() {
 					s.SetEBDBHeightComplete(eblock.GetDatabaseHeight())
 				}
@@ -337,6 +353,10 @@ func (s *State) FollowerExecuteAddData(msg interfaces.IMsg) error {
 	case 0: // DataType = entry
 		entry := dataResponseMsg.DataObject.(interfaces.IEBEntry)
 
+		if !s.IsChainInScope(entry.GetChainID()) {
+			return nil
+		}
+
 		if entry.GetHash().IsSameAs(dataResponseMsg.DataHash) {
 			s.DBMutex.Lock()
 			defer s.DBMutex.Unlock()
@@ -811,8 +831,7 @@ func (s *State) NewAdminBlockHeader(dbheight uint32) interfaces.IABlockHeader {
 }
 
 func (s *State) GetNetworkName() string {
-	return (s.Cfg.(util.FactomdConfig)).App.Network
-
+	return s.Network
 }
 
 func (s *State) GetDBHeightComplete() uint32 {
@@ -872,6 +891,7 @@ func (s *State) NewAck(dbheight uint32, msg interfaces.IMsg) (iack interfaces.IM
 	}
 
 	ack.Sign(s)
+	s.traceMsg(TraceAck, msg)
 
 	return ack, nil
 }
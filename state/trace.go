@@ -0,0 +1,44 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"github.com/FactomProject/factomd/common/interfaces"
+)
+
+// The stages of consensus a node passes a message through, as told to
+// MsgTrace.  The network stages, from the API to the peers, are traced by the
+// engine.
+const (
+	TraceAck       = "ack"       // This node, a leader, acknowledged the message
+	TraceAcked     = "acked"     // The message and its ack were added to the process list
+	TraceProcessed = "processed" // The process list processed the message
+	TraceBlock     = "block"     // The message was in the process list of a block saved
+)
+
+func (s *State) traceMsg(stage string, msg interfaces.IMsg) {
+	if s.MsgTrace != nil {
+		s.MsgTrace(stage, msg)
+	}
+}
+
+// traceBlock traces every message in the process list of the block saved at
+// height.
+func (s *State) traceBlock(height uint32) {
+	if s.MsgTrace == nil {
+		return
+	}
+	pl := s.ProcessLists.Find(height)
+	if pl == nil {
+		return
+	}
+	for i := range pl.FedServers {
+		for _, msg := range pl.VMs[i].List {
+			if msg != nil {
+				s.MsgTrace(TraceBlock, msg)
+			}
+		}
+	}
+}
@@ -33,47 +33,80 @@ func (state *State) ValidatorLoop() {
 		for state.Process() {
 			state.UpdateState()
 		}
+		state.publishDigests()
 
 		// Look for pending messages, and get one if there is one.
 		var msg interfaces.IMsg
-	loop:
 		for i := 0; i < 100; i++ {
-			state.UpdateState()
-
-			select {
-			case min := <-state.tickerQueue:
-				timeStruct.timer(state, min)
-			default:
+			msg = state.nextMessage(timeStruct)
+			if msg != nil {
+				break
 			}
+			// No messages? Sleep for a bit.
+			state.SetString()
+			time.Sleep(10 * time.Millisecond)
+		}
+		state.sortMessage(msg)
+	}
+}
 
-			select {
-			case msg = <-state.TimerMsgQueue():
-				state.JournalMessage(msg)
-				break loop
-			default:
-			}
+// ValidatorStep is one pass of the ValidatorLoop that never waits, for the
+// simulator to drive on its virtual clock.  It returns true if anything was
+// processed.
+func (state *State) ValidatorStep(timeStruct *Timer) (progress bool) {
+	state.SetString()
+	for state.Process() {
+		state.UpdateState()
+		progress = true
+	}
+	state.publishDigests()
+	if msg := state.nextMessage(timeStruct); msg != nil {
+		state.sortMessage(msg)
+		progress = true
+	}
+	return
+}
 
-			select {
-			case msg = <-state.InMsgQueue(): // Get message from the timer or input queue
-				state.JournalMessage(msg)
-				break loop
-			default: // No messages? Sleep for a bit.
-				state.SetString()
-				time.Sleep(10 * time.Millisecond)
-			}
-		}
+// nextMessage takes a minute tick if there is one, and returns the next
+// message from the timer or input queue, or nil if there is none.
+func (state *State) nextMessage(timeStruct *Timer) interfaces.IMsg {
+	state.UpdateState()
 
-		// Sort the messages.
-		if msg != nil {
-			if state.IsReplaying == true {
-				state.ReplayTimestamp = msg.GetTimestamp()
-			}
-			if _, ok := msg.(*messages.EOM); ok {
-				state.leaderMsgQueue <- msg
-			} else {
-				state.FollowerMsgQueue() <- msg
-			}
-		}
+	select {
+	case min := <-state.tickerQueue:
+		timeStruct.timer(state, min)
+	default:
+	}
+
+	select {
+	case msg := <-state.TimerMsgQueue():
+		state.JournalMessage(msg)
+		return msg
+	default:
+	}
+
+	select {
+	case msg := <-state.InMsgQueue(): // Get message from the timer or input queue
+		state.JournalMessage(msg)
+		state.replayCheck(msg)
+		return msg
+	default:
+	}
+	return nil
+}
+
+// sortMessage puts a message on the leader or follower queue.
+func (state *State) sortMessage(msg interfaces.IMsg) {
+	if msg == nil {
+		return
+	}
+	if state.IsReplaying == true {
+		state.ReplayTimestamp = msg.GetTimestamp()
+	}
+	if _, ok := msg.(*messages.EOM); ok {
+		state.leaderMsgQueue <- msg
+	} else {
+		state.FollowerMsgQueue() <- msg
 	}
 }
 
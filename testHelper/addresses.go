@@ -1,33 +1,19 @@
 package testHelper
 
 import (
-	"encoding/binary"
-	"encoding/hex"
-	"fmt"
-	"github.com/FactomProject/ed25519"
 	"github.com/FactomProject/factomd/common/factoid"
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/wallet"
 )
 
 func NewPrivKeyString(n uint64) string {
-	buf := new(primitives.Buffer)
-	if err := binary.Write(buf, binary.BigEndian, n); err != nil {
-		panic(err)
-	}
-
-	priv := fmt.Sprintf("000000000000000000000000000000000000000000000000%x", buf.DeepCopyBytes())
-	return priv
+	return wallet.NewPrivKeyString(n)
 }
 
 //Create 32 bit private key (without the public key part)
 func NewPrivKey(n uint64) []byte {
-	priv := NewPrivKeyString(n)
-	p, err := hex.DecodeString(priv)
-	if err != nil {
-		panic(err)
-	}
-	return p
+	return wallet.NewPrivKey(n)
 }
 
 //Create a full 64 bit key holding both private and public key
@@ -85,8 +71,5 @@ func NewECAddress(n uint64) interfaces.IAddress {
 }
 
 func PrivateKeyToEDPub(priv []byte) []byte {
-	priv2 := new([ed25519.PrivateKeySize]byte)
-	copy(priv2[:], priv)
-	pub := ed25519.GetPublicKey(priv2)
-	return pub[:]
+	return wallet.PrivateKeyToEDPub(priv)
 }
@@ -267,9 +267,23 @@ func CreateEmptyTestDatabaseOverlay() *databaseOverlay.Overlay {
 	return databaseOverlay.NewOverlay(new(mapdb.MapDB))
 }
 
+// The LocalServerPrivKey of the default configuration.  The first test Admin
+// Block makes FNode0 the federated server with this as its signing key, as a
+// LOCAL network starts, so what a test state signs is signed by the authority.
+const DefaultServerPrivKey string = "4c38c72fc5cdad68f13b74674d3ffb1f3d63a112710868c9b08946553448d26d"
+
 func CreateTestAdminBlock(prev *adminBlock.AdminBlock) *adminBlock.AdminBlock {
 	block := new(adminBlock.AdminBlock)
 	block.SetHeader(CreateTestAdminHeader(prev))
+	if prev == nil {
+		identity := primitives.Sha([]byte("FNode0"))
+		key, err := primitives.NewPrivateKeyFromHex(DefaultServerPrivKey)
+		if err != nil {
+			panic(err)
+		}
+		block.AddFedServer(identity)
+		block.AddABEntry(adminBlock.NewAddFederatedServerSigningKey(identity, 0, *key.Pub))
+	}
 	block.GetHeader().SetMessageCount(uint32(len(block.GetABEntries())))
 	return block
 }
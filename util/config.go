@@ -21,15 +21,40 @@ type FactomdConfig struct {
 		DBType                  string
 		LdbPath                 string
 		BoltDBPath              string
+		BadgerPath              string
 		DataStorePath           string
 		DirectoryBlockInSeconds int
 		ExportData              bool
 		ExportDataSubpath       string
 		Network                 string
+		MainNetworkPort         string
+		TestNetworkPort         string
+		LocalNetworkPort        string
+		CustomNetworkPort       string
+		CustomNetworkID         string
+		CustomBootstrapIdentity string
 		NodeMode                string
 		LocalServerPrivKey      string
 		LocalServerPublicKey    string
 		ExchangeRate            uint64
+		ChainScope              string
+
+		BadgerCompression        string
+		BadgerBlockCacheMB       int64
+		BadgerBloomFalsePositive float64
+		BadgerMemTableMB         int64
+		BadgerNumMemtables       int
+		BadgerValueLogFileMB     int64
+		BadgerSyncWrites         bool
+
+		BroadcastMode string
+		GossipFanout  int
+
+		PeerUploadLimitKB        int
+		ConsensusUploadLimitKB   int
+		TransactionUploadLimitKB int
+		SyncUploadLimitKB        int
+		DiscoveryUploadLimitKB   int
 	}
 	Peer struct {
 		AddPeers     []string      `short:"a" long:"addpeer" description:"Add a peer to connect with at startup"`
@@ -92,21 +117,55 @@ const defaultConfig = `
 [app]
 PortNumber                            = 8088
 HomeDir                               = ""
-; --------------- DBType: LDB | Bolt | Map
+; --------------- DBType: LDB | Bolt | Badger | Map
 DBType                                = "Map"
 LdbPath                               = "database/ldb"
 BoltDBPath                            = "database/bolt"
+BadgerPath                            = "database/badger"
 DataStorePath                         = "data/export"
 DirectoryBlockInSeconds               = 6
 ExportData                            = true
 ExportDataSubpath                     = "database/export/"
-; --------------- Network: MAIN | TEST | LOCAL
+; --------------- Network: MAIN | TEST | LOCAL | CUSTOM
 Network                               = LOCAL
+; --------------- Port each network listens for peers on, unless -p2pPort is given.  LOCAL keeps 8108, the port it always
+; --------------- used, so it shares it with MAIN; nodes on other networks are turned away at the handshake.
+MainNetworkPort                       = 8108
+TestNetworkPort                       = 8109
+LocalNetworkPort                      = 8108
+CustomNetworkPort                     = 8111
+; --------------- CUSTOM networks: the network's magic number comes from the hash of CustomNetworkID, so private
+; --------------- networks with different names can run side by side.  CustomBootstrapIdentity is the identity chain
+; --------------- of the federated server in the genesis block, empty for the same one as LOCAL.
+CustomNetworkID                       = ""
+CustomBootstrapIdentity               = ""
 ; --------------- NodeMode: FULL | SERVER | LIGHT ----------------
 NodeMode                              = FULL
 LocalServerPrivKey                    = 4c38c72fc5cdad68f13b74674d3ffb1f3d63a112710868c9b08946553448d26d
 LocalServerPublicKey                  = cc1985cdfae4e32b5a454dfda8ce5e1361558482684f3367649c3ad852c8e31a
 ExchangeRate                          = 00100000
+; --------------- ChainScope: comma separated chain IDs to sync.  Empty syncs every chain.
+ChainScope                            = ""
+; --------------- Badger tunables.  BadgerCompression: None | Snappy | ZSTD.  Sizes in MB.
+BadgerCompression                     = "Snappy"
+BadgerBlockCacheMB                    = 256
+BadgerBloomFalsePositive              = 0.01
+BadgerMemTableMB                      = 64
+BadgerNumMemtables                    = 5
+BadgerValueLogFileMB                  = 1024
+BadgerSyncWrites                      = true
+; --------------- BroadcastMode: gossip | flood.  Gossip sends a message in full to GossipFanout peers
+; --------------- and announces it to the others, flood sends it in full to every peer.
+BroadcastMode                         = "gossip"
+GossipFanout                          = 4
+; --------------- Upload limits in KB per second, 0 for no limit.  PeerUploadLimitKB is for each peer, the others
+; --------------- for each class of message over all peers.  Consensus messages are sent first, then transactions,
+; --------------- then sync (catch-up) replies, then peer discovery.
+PeerUploadLimitKB                     = 0
+ConsensusUploadLimitKB                = 0
+TransactionUploadLimitKB              = 0
+SyncUploadLimitKB                     = 0
+DiscoveryUploadLimitKB                = 0
 
 [anchor]
 ServerECPrivKey                       = 397c49e182caa97737c6b394591c614156fbe7998d7bf5d76273961e9fa1edd4
@@ -159,15 +218,37 @@ func (s *FactomdConfig) String() string {
 	out.WriteString(fmt.Sprintf("\n    DBType                 %v", s.App.DBType))
 	out.WriteString(fmt.Sprintf("\n    LdbPath                 %v", s.App.LdbPath))
 	out.WriteString(fmt.Sprintf("\n    BoltDBPath              %v", s.App.BoltDBPath))
+	out.WriteString(fmt.Sprintf("\n    BadgerPath              %v", s.App.BadgerPath))
 	out.WriteString(fmt.Sprintf("\n    DataStorePath           %v", s.App.DataStorePath))
 	out.WriteString(fmt.Sprintf("\n    DirectoryBlockInSeconds %v", s.App.DirectoryBlockInSeconds))
 	out.WriteString(fmt.Sprintf("\n    ExportData              %v", s.App.ExportData))
 	out.WriteString(fmt.Sprintf("\n    ExportDataSubpath       %v", s.App.ExportDataSubpath))
 	out.WriteString(fmt.Sprintf("\n    Network                 %v", s.App.Network))
+	out.WriteString(fmt.Sprintf("\n    MainNetworkPort         %v", s.App.MainNetworkPort))
+	out.WriteString(fmt.Sprintf("\n    TestNetworkPort         %v", s.App.TestNetworkPort))
+	out.WriteString(fmt.Sprintf("\n    LocalNetworkPort        %v", s.App.LocalNetworkPort))
+	out.WriteString(fmt.Sprintf("\n    CustomNetworkPort       %v", s.App.CustomNetworkPort))
+	out.WriteString(fmt.Sprintf("\n    CustomNetworkID         %v", s.App.CustomNetworkID))
+	out.WriteString(fmt.Sprintf("\n    CustomBootstrapIdentity %v", s.App.CustomBootstrapIdentity))
 	out.WriteString(fmt.Sprintf("\n    NodeMode                %v", s.App.NodeMode))
 	out.WriteString(fmt.Sprintf("\n    LocalServerPrivKey      %v", s.App.LocalServerPrivKey))
 	out.WriteString(fmt.Sprintf("\n    LocalServerPublicKey    %v", s.App.LocalServerPublicKey))
 	out.WriteString(fmt.Sprintf("\n    ExchangeRate            %v", s.App.ExchangeRate))
+	out.WriteString(fmt.Sprintf("\n    ChainScope              %v", s.App.ChainScope))
+	out.WriteString(fmt.Sprintf("\n    BadgerCompression       %v", s.App.BadgerCompression))
+	out.WriteString(fmt.Sprintf("\n    BadgerBlockCacheMB      %v", s.App.BadgerBlockCacheMB))
+	out.WriteString(fmt.Sprintf("\n    BadgerBloomFalsePositive %v", s.App.BadgerBloomFalsePositive))
+	out.WriteString(fmt.Sprintf("\n    BadgerMemTableMB        %v", s.App.BadgerMemTableMB))
+	out.WriteString(fmt.Sprintf("\n    BadgerNumMemtables      %v", s.App.BadgerNumMemtables))
+	out.WriteString(fmt.Sprintf("\n    BadgerValueLogFileMB    %v", s.App.BadgerValueLogFileMB))
+	out.WriteString(fmt.Sprintf("\n    BadgerSyncWrites        %v", s.App.BadgerSyncWrites))
+	out.WriteString(fmt.Sprintf("\n    BroadcastMode           %v", s.App.BroadcastMode))
+	out.WriteString(fmt.Sprintf("\n    GossipFanout            %v", s.App.GossipFanout))
+	out.WriteString(fmt.Sprintf("\n    PeerUploadLimitKB       %v", s.App.PeerUploadLimitKB))
+	out.WriteString(fmt.Sprintf("\n    ConsensusUploadLimitKB  %v", s.App.ConsensusUploadLimitKB))
+	out.WriteString(fmt.Sprintf("\n    TransactionUploadLimitKB %v", s.App.TransactionUploadLimitKB))
+	out.WriteString(fmt.Sprintf("\n    SyncUploadLimitKB       %v", s.App.SyncUploadLimitKB))
+	out.WriteString(fmt.Sprintf("\n    DiscoveryUploadLimitKB  %v", s.App.DiscoveryUploadLimitKB))
 
 	out.WriteString(fmt.Sprintf("\n  Anchor"))
 	out.WriteString(fmt.Sprintf("\n    ServerECPrivKey         %v", s.Anchor.ServerECPrivKey))
@@ -251,6 +332,7 @@ func ReadConfig(filename string, folder string) *FactomdConfig {
 	// TODO: improve the paths after milestone 1
 	cfg.App.LdbPath = cfg.App.HomeDir + folder + cfg.App.LdbPath
 	cfg.App.BoltDBPath = cfg.App.HomeDir + folder + cfg.App.BoltDBPath
+	cfg.App.BadgerPath = cfg.App.HomeDir + folder + cfg.App.BadgerPath
 	cfg.App.DataStorePath = cfg.App.HomeDir + folder + cfg.App.DataStorePath
 	cfg.Log.LogPath = cfg.App.HomeDir + folder + cfg.Log.LogPath
 	cfg.Wallet.BoltDBPath = cfg.App.HomeDir + folder + cfg.Wallet.BoltDBPath
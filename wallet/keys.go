@@ -0,0 +1,77 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package wallet makes keys, and signs commits and factoid transactions with
+// them, as a wallet would, for tools that submit to a network from known keys.
+// Keys are numbered: key n is the private key n, so the same numbers give the
+// same addresses everywhere.
+package wallet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factomd/common/factoid"
+	"github.com/FactomProject/factomd/common/interfaces"
+)
+
+// The private key of FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q, the
+// address the genesis block of a LOCAL network funds
+const LocalGenesisPrivKeyString = "fb3b471b1dcdadfeb856bd0b02d8bf49ace0edd372a3d9f2a95b78ec12a324d6"
+
+// NewPrivKeyString returns private key n, in hex.
+func NewPrivKeyString(n uint64) string {
+	return hex.EncodeToString(NewPrivKey(n))
+}
+
+// NewPrivKey returns private key n: 32 bytes, without the public key.
+func NewPrivKey(n uint64) []byte {
+	priv := make([]byte, 32)
+	binary.BigEndian.PutUint64(priv[24:], n)
+	return priv
+}
+
+// PrivateKeyToEDPub returns the ed25519 public key of priv.
+func PrivateKeyToEDPub(priv []byte) []byte {
+	priv2 := new([ed25519.PrivateKeySize]byte)
+	copy(priv2[:], priv)
+	pub := ed25519.GetPublicKey(priv2)
+	return pub[:]
+}
+
+// NewFactoidAddress returns the factoid address of key n.
+func NewFactoidAddress(n uint64) interfaces.IAddress {
+	add, err := factoid.PublicKeyToFactoidAddress(PrivateKeyToEDPub(NewPrivKey(n)))
+	if err != nil {
+		panic(err)
+	}
+	return add
+}
+
+// NewECPublicKey returns the entry credit address of key n: its public key,
+// which is what a factoid transaction pays and what the key's commits spend.
+func NewECPublicKey(n uint64) interfaces.IAddress {
+	return factoid.NewAddress(PrivateKeyToEDPub(NewPrivKey(n)))
+}
+
+// NewLocalGenesisPrivKey returns the private key of the address the genesis
+// block of a LOCAL network funds.
+func NewLocalGenesisPrivKey() []byte {
+	p, err := hex.DecodeString(LocalGenesisPrivKeyString)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// NewLocalGenesisAddress returns the address the genesis block of a LOCAL
+// network funds.
+func NewLocalGenesisAddress() interfaces.IAddress {
+	add, err := factoid.PublicKeyToFactoidAddress(PrivateKeyToEDPub(NewLocalGenesisPrivKey()))
+	if err != nil {
+		panic(err)
+	}
+	return add
+}
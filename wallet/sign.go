@@ -0,0 +1,86 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package wallet
+
+import (
+	"encoding/binary"
+
+	"github.com/FactomProject/factomd/common/entryBlock"
+	"github.com/FactomProject/factomd/common/entryCreditBlock"
+	"github.com/FactomProject/factomd/common/factoid"
+	"github.com/FactomProject/factomd/common/interfaces"
+	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/util"
+)
+
+// NewSignedCommitEntry returns a commit of entry: timestamped now, paying what
+// the entry costs, and signed with key n.
+func NewSignedCommitEntry(n uint64, entry *entryBlock.Entry) (*entryCreditBlock.CommitEntry, error) {
+	commit := entryCreditBlock.NewCommitEntry()
+	if err := commit.MilliTime.UnmarshalBinary(milliTimeNow()); err != nil {
+		return nil, err
+	}
+	commit.EntryHash = entry.GetHash()
+	credits, err := EntryCredits(entry)
+	if err != nil {
+		return nil, err
+	}
+	commit.Credits = credits
+	if err := commit.Sign(NewPrivKey(n)); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// NewSignedCommitChain returns a commit of the chain entry starts, as
+// NewSignedCommitEntry, paying for the chain as well.
+func NewSignedCommitChain(n uint64, entry *entryBlock.Entry) (*entryCreditBlock.CommitChain, error) {
+	commit := entryCreditBlock.NewCommitChain()
+	if err := commit.MilliTime.UnmarshalBinary(milliTimeNow()); err != nil {
+		return nil, err
+	}
+	commit.ChainIDHash = primitives.NewHash(primitives.DoubleSha(entry.GetChainID().Bytes()))
+	commit.Weld = entry.GetWeldHash()
+	commit.EntryHash = entry.GetHash()
+	credits, err := EntryCredits(entry)
+	if err != nil {
+		return nil, err
+	}
+	commit.Credits = credits + 10
+	if err := commit.Sign(NewPrivKey(n)); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// EntryCredits returns the entry credits it costs to record entry.
+func EntryCredits(entry *entryBlock.Entry) (uint8, error) {
+	bin, err := entry.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	return util.EntryCost(bin)
+}
+
+// SignTransaction signs a transaction with a single input, from the address
+// of priv, and checks it is then valid.
+func SignTransaction(priv []byte, tx interfaces.ITransaction) error {
+	tx.AddAuthorization(factoid.NewRCD_1(PrivateKeyToEDPub(priv)))
+	data, err := tx.MarshalBinarySig()
+	if err != nil {
+		return err
+	}
+	tx.SetSignatureBlock(0, factoid.NewSingleSignatureBlock(priv, data))
+	if err := tx.Validate(1); err != nil {
+		return err
+	}
+	return tx.ValidateSignatures()
+}
+
+func milliTimeNow() []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], interfaces.GetTimeMilli())
+	return buf[2:]
+}
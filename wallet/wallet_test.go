@@ -0,0 +1,71 @@
+// Copyright 2015 Factom Foundation
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package wallet_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/FactomProject/factomd/common/entryBlock"
+	"github.com/FactomProject/factomd/common/factoid"
+	. "github.com/FactomProject/factomd/wallet"
+)
+
+func TestKeys(t *testing.T) {
+	if key := NewPrivKeyString(0x0102); key != "0000000000000000000000000000000000000000000000000000000000000102" {
+		t.Errorf("Key 0x0102 is %s", key)
+	}
+	funded := false
+	for _, tx := range factoid.GetGenesisFBlock().GetTransactions() {
+		for _, out := range tx.GetOutputs() {
+			funded = funded || out.GetAddress().IsSameAs(NewLocalGenesisAddress())
+		}
+	}
+	if !funded {
+		t.Errorf("The genesis block doesn't fund the LOCAL genesis address")
+	}
+	if !bytes.Equal(NewECPublicKey(3).Bytes(), PrivateKeyToEDPub(NewPrivKey(3))) {
+		t.Errorf("Key 3's entry credit address isn't its public key")
+	}
+	if NewFactoidAddress(1).IsSameAs(NewFactoidAddress(2)) {
+		t.Errorf("Keys 1 and 2 have the same address")
+	}
+}
+
+func TestSignedCommits(t *testing.T) {
+	entry := entryBlock.NewEntry()
+	entry.ExtIDs = [][]byte{[]byte("wallet test")}
+	entry.ChainID = entryBlock.NewChainID(entry)
+	entry.Content = []byte("Content")
+
+	commitEntry, err := NewSignedCommitEntry(1, entry)
+	if err != nil || !commitEntry.IsValid() || !commitEntry.EntryHash.IsSameAs(entry.GetHash()) {
+		t.Errorf("Bad entry commit %v %v", commitEntry, err)
+	}
+	commitChain, err := NewSignedCommitChain(1, entry)
+	if err != nil || !commitChain.IsValid() || commitChain.Credits != commitEntry.Credits+10 {
+		t.Errorf("Bad chain commit %v %v", commitChain, err)
+	}
+	if !bytes.Equal(commitEntry.ECPubKey[:], NewECPublicKey(1).Bytes()) {
+		t.Errorf("The commit doesn't spend key 1's entry credits")
+	}
+}
+
+func TestSignTransaction(t *testing.T) {
+	tx := new(factoid.Transaction)
+	tx.AddInput(NewFactoidAddress(1), 2000000)
+	tx.AddOutput(NewFactoidAddress(2), 1000000)
+	tx.SetMilliTimestamp(1000)
+	if err := SignTransaction(NewPrivKey(1), tx); err != nil {
+		t.Errorf("Signing from key 1's address: %v", err)
+	}
+
+	tx = new(factoid.Transaction)
+	tx.AddInput(NewFactoidAddress(1), 2000000)
+	tx.AddOutput(NewFactoidAddress(2), 1000000)
+	if err := SignTransaction(NewPrivKey(2), tx); err == nil {
+		t.Errorf("Signed key 1's input with key 2")
+	}
+}
@@ -17,8 +17,8 @@ import (
 )
 
 func HandleV2FactoidACK(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
-	ackReq, ok := params.(AckRequest)
-	if !ok {
+	ackReq := new(AckRequest)
+	if !paramsToObject(params, ackReq) {
 		return nil, NewInvalidParamsError()
 	}
 
@@ -82,8 +82,8 @@ func HandleV2FactoidACK(state interfaces.IState, params interface{}) (interface{
 }
 
 func HandleV2EntryACK(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
-	ackReq, ok := params.(AckRequest)
-	if !ok {
+	ackReq := new(AckRequest)
+	if !paramsToObject(params, ackReq) {
 		return nil, NewInvalidParamsError()
 	}
 
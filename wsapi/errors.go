@@ -91,3 +91,15 @@ func NewMissingChainHeadError() *primitives.JSONError {
 func NewReceiptError() *primitives.JSONError {
 	return primitives.NewJSONError(-32010, "Receipt creation error", nil)
 }
+func NewChainNotInScopeError() *primitives.JSONError {
+	return primitives.NewJSONError(-32011, "Chain not in scope", "This node does not sync the requested chain")
+}
+func NewNotFoundInScopeError() *primitives.JSONError {
+	return primitives.NewJSONError(-32011, "Chain not in scope", "This node only syncs some chains, and has nothing for the requested hash in them")
+}
+func NewNetworkNotRunningError() *primitives.JSONError {
+	return primitives.NewJSONError(-32012, "Network not running", "This node is not connected to the p2p network")
+}
+func NewLocalOnlyError() *primitives.JSONError {
+	return primitives.NewJSONError(-32013, "Local only", "This call is only served to clients on the node's machine")
+}
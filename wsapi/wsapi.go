@@ -15,6 +15,7 @@ import (
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/primitives"
 	"github.com/FactomProject/factomd/log"
+	"github.com/FactomProject/factomd/p2p"
 	"github.com/FactomProject/web"
 )
 
@@ -24,6 +25,15 @@ const (
 
 var Servers map[int]*web.Server
 
+// network is the p2p network the peer management calls work on, nil if the
+// node isn't connected to one.
+var network *p2p.Controller
+
+// SetNetwork gives the API the p2p network to manage.
+func SetNetwork(n *p2p.Controller) {
+	network = n
+}
+
 func Start(state interfaces.IState) {
 	var server *web.Server
 
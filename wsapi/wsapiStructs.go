@@ -6,6 +6,9 @@ package wsapi
 
 import (
 	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/database/databaseOverlay"
+	"github.com/FactomProject/factomd/database/metricsdb"
+	"github.com/FactomProject/factomd/p2p"
 	"github.com/FactomProject/factomd/receipts"
 )
 
@@ -97,6 +100,28 @@ type PropertiesResponse struct {
 	ApiVersion     string `json:"apiversion"`
 }
 
+type DatabaseMetricsResponse struct {
+	Buckets []metricsdb.BucketMetrics `json:"buckets"`
+}
+
+type NetworkQueuesResponse struct {
+	Classes []p2p.ClassMetrics `json:"classes"`
+}
+
+type PeersResponse struct {
+	Connections []p2p.ConnectionMetrics `json:"connections"`
+}
+
+type NetworkCommandResponse struct {
+	Message string `json:"message"`
+}
+
+type StorageUsageResponse struct {
+	Keys       int                           `json:"keys"`
+	ValueBytes int64                         `json:"valuebytes"`
+	Buckets    []databaseOverlay.BucketUsage `json:"buckets"`
+}
+
 /*********************************************************************/
 
 type DBHead struct {
@@ -182,6 +207,14 @@ type HashRequest struct {
 	Hash string `json:"hash"`
 }
 
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type PeerRequest struct {
+	Peer string `json:"peer"` // Hash or address of the peer
+}
+
 type KeyMRRequest struct {
 	KeyMR string `json:"keymr"`
 }
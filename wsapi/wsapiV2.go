@@ -6,6 +6,7 @@ package wsapi
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/FactomProject/factomd/common/constants"
 	"github.com/FactomProject/factomd/common/entryBlock"
@@ -14,9 +15,12 @@ import (
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/messages"
 	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/database/databaseOverlay"
+	"github.com/FactomProject/factomd/p2p"
 	"github.com/FactomProject/factomd/receipts"
 	"github.com/FactomProject/web"
 	"io/ioutil"
+	"net"
 )
 
 const API_VERSION string = "2.0"
@@ -34,6 +38,11 @@ func HandleV2(ctx *web.Context) {
 		return
 	}
 
+	if adminMethods[j.Method] && !isLocal(ctx.Request.RemoteAddr) {
+		HandleV2Error(ctx, j, NewLocalOnlyError())
+		return
+	}
+
 	state := ctx.Server.Env["state"].(interfaces.IState)
 
 	jsonResp, jsonError := HandleV2Request(state, j)
@@ -46,6 +55,28 @@ func HandleV2(ctx *web.Context) {
 	ctx.Write([]byte(jsonResp.String()))
 }
 
+// adminMethods change how the node runs, or cost it much disk and I/O, as
+// storage-usage copies the whole database.  The API listens on every
+// interface, so they are only served to clients on the node's own machine.
+var adminMethods = map[string]bool{
+	"peer-dial":         true,
+	"peer-disconnect":   true,
+	"peer-ban":          true,
+	"peer-unban":        true,
+	"network-log-level": true,
+	"storage-usage":     true,
+}
+
+// isLocal is true if the request came in over the loopback interface.
+func isLocal(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func HandleV2Request(state interfaces.IState, j *primitives.JSON2Request) (*primitives.JSON2Response, *primitives.JSONError) {
 	var resp interface{}
 	var jsonError *primitives.JSONError
@@ -108,6 +139,33 @@ func HandleV2Request(state interfaces.IState, j *primitives.JSON2Request) (*prim
 	case "entry-ack":
 		resp, jsonError = HandleV2EntryACK(state, params)
 		break
+	case "database-metrics":
+		resp, jsonError = HandleV2DatabaseMetrics(state, params)
+		break
+	case "storage-usage":
+		resp, jsonError = HandleV2StorageUsage(state, params)
+		break
+	case "network-queues":
+		resp, jsonError = HandleV2NetworkQueues(state, params)
+		break
+	case "peers":
+		resp, jsonError = HandleV2Peers(state, params)
+		break
+	case "peer-dial":
+		resp, jsonError = HandleV2PeerDial(state, params)
+		break
+	case "peer-disconnect":
+		resp, jsonError = HandleV2PeerDisconnect(state, params)
+		break
+	case "peer-ban":
+		resp, jsonError = HandleV2PeerBan(state, params)
+		break
+	case "peer-unban":
+		resp, jsonError = HandleV2PeerUnban(state, params)
+		break
+	case "network-log-level":
+		resp, jsonError = HandleV2NetworkLogLevel(state, params)
+		break
 	default:
 		jsonError = NewMethodNotFoundError()
 		break
@@ -137,8 +195,8 @@ func HandleV2Error(ctx *web.Context, j *primitives.JSON2Request, err *primitives
 }
 
 func HandleV2CommitChain(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
-	commitChainMsg, ok := params.(MessageRequest)
-	if !ok {
+	commitChainMsg := new(MessageRequest)
+	if !paramsToObject(params, commitChainMsg) {
 		return nil, NewInvalidParamsError()
 	}
 
@@ -168,8 +226,8 @@ func HandleV2RevealChain(state interfaces.IState, params interface{}) (interface
 }
 
 func HandleV2CommitEntry(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
-	commitEntryMsg, ok := params.(EntryRequest)
-	if !ok {
+	commitEntryMsg := new(EntryRequest)
+	if !paramsToObject(params, commitEntryMsg) {
 		return nil, NewInvalidParamsError()
 	}
 
@@ -195,8 +253,8 @@ func HandleV2CommitEntry(state interfaces.IState, params interface{}) (interface
 }
 
 func HandleV2RevealEntry(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
-	e, ok := params.(EntryRequest)
-	if ok == false {
+	e := new(EntryRequest)
+	if !paramsToObject(params, e) {
 		return nil, NewInvalidParamsError()
 	}
 
@@ -270,6 +328,8 @@ func HandleV2RawData(state interfaces.IState, params interface{}) (interface{},
 		b, _ = block.MarshalBinary()
 	} else if block, _ = dbase.FetchECBlockByHash(h); block != nil {
 		b, _ = block.MarshalBinary()
+	} else if jErr := scopeError(state, dbase, h); jErr != nil {
+		return nil, jErr
 	} else {
 		return nil, NewEntryNotFoundError()
 	}
@@ -293,6 +353,9 @@ func HandleV2Receipt(state interfaces.IState, params interface{}) (interface{},
 	dbase := state.GetAndLockDB()
 	defer state.UnlockDB()
 
+	if jErr := scopeError(state, dbase, h); jErr != nil {
+		return nil, jErr
+	}
 	receipt, err := receipts.CreateFullReceipt(dbase, h)
 	if err != nil {
 		return nil, NewReceiptError()
@@ -369,9 +432,15 @@ func HandleV2EntryBlock(state interfaces.IState, params interface{}) (interface{
 			return nil, NewInvalidHashError()
 		}
 		if block == nil {
+			if jErr := scopeError(state, dbase, h); jErr != nil {
+				return nil, jErr
+			}
 			return nil, NewBlockNotFoundError()
 		}
 	}
+	if !state.IsChainInScope(block.GetHeader().GetChainID()) {
+		return nil, NewChainNotInScopeError()
+	}
 
 	e.Header.BlockSequenceNumber = int64(block.GetHeader().GetEBSequence())
 	e.Header.ChainID = block.GetHeader().GetChainID().String()
@@ -432,8 +501,14 @@ func HandleV2Entry(state interfaces.IState, params interface{}) (interface{}, *p
 		return nil, NewInvalidHashError()
 	}
 	if entry == nil {
+		if jErr := scopeError(state, dbase, h); jErr != nil {
+			return nil, jErr
+		}
 		return nil, NewEntryNotFoundError()
 	}
+	if !state.IsChainInScope(entry.GetChainID()) {
+		return nil, NewChainNotInScopeError()
+	}
 
 	e.ChainID = entry.GetChainIDHash().String()
 	e.Content = hex.EncodeToString(entry.GetContent())
@@ -444,6 +519,41 @@ func HandleV2Entry(state interfaces.IState, params interface{}) (interface{}, *p
 	return e, nil
 }
 
+// scopeError is the error for the entry or EBlock hash on a chain scoped node:
+// ChainNotInScope if it is of a chain the node doesn't sync, and NotFoundInScope
+// if the node can't tell its chain, having neither it nor, for an EBlock, the
+// Directory Block that lists it.  It is nil if the node syncs every chain, or
+// the chain of hash is in scope.
+func scopeError(state interfaces.IState, dbase interfaces.DBOverlay, hash interfaces.IHash) *primitives.JSONError {
+	if !state.IsChainScoped() {
+		return nil
+	}
+	var chainID interfaces.IHash
+	if entry, _ := dbase.FetchEntryByHash(hash); entry != nil {
+		chainID = entry.GetChainID()
+	} else if eblock, _ := dbase.FetchEBlockByKeyMR(hash); eblock != nil {
+		chainID = eblock.GetHeader().GetChainID()
+	} else if eblock, _ := dbase.FetchEBlockByHash(hash); eblock != nil {
+		chainID = eblock.GetHeader().GetChainID()
+	} else if keyMR, _ := dbase.FetchIncludedIn(hash); keyMR != nil {
+		// Every Directory Block is saved, with the chain of each EBlock in it.
+		if dblock, _ := dbase.FetchDBlockByKeyMR(keyMR); dblock != nil {
+			for _, dbEntry := range dblock.GetDBEntries() {
+				if dbEntry.GetKeyMR().IsSameAs(hash) {
+					chainID = dbEntry.GetChainID()
+				}
+			}
+		}
+	}
+	if chainID == nil {
+		return NewNotFoundInScopeError()
+	}
+	if !state.IsChainInScope(chainID) {
+		return NewChainNotInScopeError()
+	}
+	return nil
+}
+
 func HandleV2ChainHead(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
 	chainid, ok := params.(ChainIDRequest)
 	if !ok {
@@ -453,6 +563,9 @@ func HandleV2ChainHead(state interfaces.IState, params interface{}) (interface{}
 	if err != nil {
 		return nil, NewInvalidHashError()
 	}
+	if !state.IsChainInScope(h) {
+		return nil, NewChainNotInScopeError()
+	}
 
 	dbase := state.GetAndLockDB()
 	defer state.UnlockDB()
@@ -511,8 +624,8 @@ func HandleV2FactoidFee(state interfaces.IState, params interface{}) (interface{
 }
 
 func HandleV2FactoidSubmit(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
-	t, ok := params.(TransactionRequest)
-	if !ok {
+	t := new(TransactionRequest)
+	if !paramsToObject(params, t) {
 		return nil, NewInvalidParamsError()
 	}
 
@@ -593,3 +706,151 @@ func HandleV2Properties(state interfaces.IState, params interface{}) (interface{
 	p.ApiVersion = API_VERSION
 	return p, nil
 }
+
+func HandleV2DatabaseMetrics(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	dbase := state.GetAndLockDB()
+	defer state.UnlockDB()
+
+	overlay, ok := dbase.(*databaseOverlay.Overlay)
+	if !ok {
+		return nil, NewInternalDatabaseError()
+	}
+
+	resp := new(DatabaseMetricsResponse)
+	resp.Buckets = overlay.GetBucketMetrics()
+	return resp, nil
+}
+
+// HandleV2StorageUsage walks the whole database, so it is meant for diagnostics
+// rather than for regular polling, and is only served locally.  It walks a
+// snapshot of the database, so the node keeps writing meanwhile.  A map
+// database has no files to snapshot; it is walked as it is, as it guards its
+// own tables.
+func HandleV2StorageUsage(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	dbase, err := state.OpenReadOnlyDB()
+	if err != nil {
+		dbase = state.GetAndLockDB()
+		state.UnlockDB()
+	} else {
+		defer dbase.Close()
+	}
+
+	overlay, ok := dbase.(*databaseOverlay.Overlay)
+	if !ok {
+		return nil, NewInternalDatabaseError()
+	}
+
+	buckets, err := overlay.GetStorageReport()
+	if err != nil {
+		return nil, NewInternalDatabaseError()
+	}
+
+	resp := new(StorageUsageResponse)
+	resp.Buckets = buckets
+	for _, b := range buckets {
+		resp.Keys += b.Keys
+		resp.ValueBytes += b.ValueBytes
+	}
+	return resp, nil
+}
+
+func HandleV2NetworkQueues(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	resp := new(NetworkQueuesResponse)
+	resp.Classes = p2p.GetQueueMetrics()
+	return resp, nil
+}
+
+// The peer management calls work on the p2p network given to SetNetwork.  The
+// ones that change something only queue a command for the network, which
+// carries it out within a few milliseconds.
+
+func HandleV2Peers(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	if network == nil {
+		return nil, NewNetworkNotRunningError()
+	}
+	connections := network.GetConnections()
+	if connections == nil {
+		return nil, NewCustomInternalError("The network did not answer")
+	}
+	resp := new(PeersResponse)
+	resp.Connections = connections
+	return resp, nil
+}
+
+func HandleV2PeerDial(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	if network == nil {
+		return nil, NewNetworkNotRunningError()
+	}
+	peer := new(PeerRequest)
+	if !paramsToObject(params, peer) {
+		return nil, NewInvalidParamsError()
+	}
+	address, err := p2p.NormalizeAddress(peer.Peer)
+	if err != nil {
+		return nil, NewCustomInvalidParamsError(err.Error())
+	}
+	network.DialPeer(address)
+	return &NetworkCommandResponse{Message: "Dialing " + address}, nil
+}
+
+func HandleV2PeerDisconnect(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	if network == nil {
+		return nil, NewNetworkNotRunningError()
+	}
+	peer := new(PeerRequest)
+	if !paramsToObject(params, peer) || peer.Peer == "" {
+		return nil, NewInvalidParamsError()
+	}
+	network.Disconnect(p2p.PeerHashFor(peer.Peer))
+	return &NetworkCommandResponse{Message: "Disconnecting " + peer.Peer}, nil
+}
+
+func HandleV2PeerBan(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	if network == nil {
+		return nil, NewNetworkNotRunningError()
+	}
+	peer := new(PeerRequest)
+	if !paramsToObject(params, peer) || peer.Peer == "" {
+		return nil, NewInvalidParamsError()
+	}
+	network.Ban(p2p.PeerHashFor(peer.Peer))
+	return &NetworkCommandResponse{Message: "Banning " + peer.Peer}, nil
+}
+
+func HandleV2PeerUnban(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	if network == nil {
+		return nil, NewNetworkNotRunningError()
+	}
+	peer := new(PeerRequest)
+	if !paramsToObject(params, peer) || peer.Peer == "" {
+		return nil, NewInvalidParamsError()
+	}
+	network.Unban(p2p.PeerHashFor(peer.Peer))
+	return &NetworkCommandResponse{Message: "Unbanning " + peer.Peer}, nil
+}
+
+func HandleV2NetworkLogLevel(state interfaces.IState, params interface{}) (interface{}, *primitives.JSONError) {
+	if network == nil {
+		return nil, NewNetworkNotRunningError()
+	}
+	level := new(LogLevelRequest)
+	if !paramsToObject(params, level) {
+		return nil, NewInvalidParamsError()
+	}
+	l, ok := p2p.LoggingLevelFromString(level.Level)
+	if !ok {
+		return nil, NewCustomInvalidParamsError("Unknown log level " + level.Level)
+	}
+	network.ChangeLogLevel(l)
+	return &NetworkCommandResponse{Message: "Network log level set to " + p2p.LoggingLevels[l]}, nil
+}
+
+// paramsToObject reads JSON-RPC params, which arrive as generic JSON, into the
+// request struct dst.
+func paramsToObject(params interface{}, dst interface{}) bool {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, dst) == nil
+}
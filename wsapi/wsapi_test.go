@@ -6,6 +6,7 @@ import (
 	"github.com/FactomProject/factomd/common/entryBlock"
 	"github.com/FactomProject/factomd/common/interfaces"
 	"github.com/FactomProject/factomd/common/primitives"
+	"github.com/FactomProject/factomd/database/databaseOverlay"
 	"github.com/FactomProject/factomd/receipts"
 	"github.com/FactomProject/factomd/testHelper"
 	. "github.com/FactomProject/factomd/wsapi"
@@ -425,3 +426,92 @@ func TestHandleGetReceipt(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// Params reach the V2 handlers as the JSON decoder leaves them, in maps.
+func TestHandleV2ParamsFromJSON(t *testing.T) {
+	state := testHelper.CreateAndPopulateTestState()
+	params := func(str string) interface{} {
+		var p interface{}
+		if err := json.Unmarshal([]byte(str), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	invalidParams := NewInvalidParamsError().Error()
+
+	for name, handler := range map[string]func(interfaces.IState, interface{}) (interface{}, *primitives.JSONError){
+		"commit-chain":   HandleV2CommitChain,
+		"commit-entry":   HandleV2CommitEntry,
+		"reveal-entry":   HandleV2RevealEntry,
+		"factoid-submit": HandleV2FactoidSubmit,
+	} {
+		// The params decode, so the handler gets as far as the bad message.
+		_, jErr := handler(state, params(`{"message": "00", "entry": "00", "transaction": "00"}`))
+		if jErr == nil || jErr.Error() == invalidParams {
+			t.Errorf("%s: params from JSON weren't decoded: %v", name, jErr)
+		}
+		if _, jErr := handler(state, params(`[1, 2]`)); jErr == nil || jErr.Error() != invalidParams {
+			t.Errorf("%s: expected invalid params, got %v", name, jErr)
+		}
+	}
+
+	tx := testHelper.CreateFullTestBlockSet()[0].FBlock.GetTransactions()[0]
+	r, jErr := HandleV2FactoidACK(state, params(fmt.Sprintf(`{"txid": %q}`, tx.GetHash().String())))
+	if resp, ok := r.(*FactoidTxStatus); jErr != nil || !ok || resp.Status != AckStatusDBlockConfirmed {
+		t.Errorf("factoid-ack: params from JSON gave %v %v", r, jErr)
+	}
+	entry := testHelper.CreateFullTestBlockSet()[0].Entries[0]
+	r, jErr = HandleV2EntryACK(state, params(fmt.Sprintf(`{"txid": %q}`, entry.GetHash().String())))
+	if resp, ok := r.(*EntryStatus); jErr != nil || !ok || resp.EntryHash != entry.GetHash().String() {
+		t.Errorf("entry-ack: params from JSON gave %v %v", r, jErr)
+	}
+}
+
+func TestHandleV2ChainScope(t *testing.T) {
+	state := testHelper.CreateAndPopulateTestState()
+	set := testHelper.CreateFullTestBlockSet()[1]
+	entry, eblock := set.Entries[0].GetHash(), set.EBlock.DatabasePrimaryIndex()
+	notInScope, notFound := NewChainNotInScopeError().Error(), NewNotFoundInScopeError().Error()
+
+	if _, jErr := HandleV2Entry(state, HashRequest{Hash: entry.String()}); jErr != nil {
+		t.Errorf("An unscoped node should serve the entry: %v", jErr)
+	}
+
+	// Scoped to the anchor chain, the node doesn't serve the test chain, even
+	// what it has of it, and can't tell the chain of what it hasn't got.
+	if err := state.SetChainScope(testHelper.GetAnchorChainID().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer state.SetChainScope("")
+	for name, jErr := range map[string]*primitives.JSONError{
+		"entry":       second(HandleV2Entry(state, HashRequest{Hash: entry.String()})),
+		"entry-block": second(HandleV2EntryBlock(state, KeyMRRequest{KeyMR: eblock.String()})),
+		"receipt":     second(HandleV2Receipt(state, HashRequest{Hash: entry.String()})),
+	} {
+		if jErr == nil || jErr.Error() != notInScope {
+			t.Errorf("%s: expected the chain not to be in scope, got %v", name, jErr)
+		}
+	}
+	unknown := testHelper.NewRepeatingHash(7).String()
+	if _, jErr := HandleV2Entry(state, HashRequest{Hash: unknown}); jErr == nil || jErr.Error() != notFound {
+		t.Errorf("entry: expected nothing found in scope, got %v", jErr)
+	}
+	if _, jErr := HandleV2RawData(state, HashRequest{Hash: unknown}); jErr == nil || jErr.Error() != notFound {
+		t.Errorf("raw-data: expected nothing found in scope, got %v", jErr)
+	}
+
+	// An EBlock the node didn't sync is known by the Directory Block it is in.
+	dbase := state.GetAndLockDB()
+	err := dbase.Delete([]byte{databaseOverlay.ENTRYBLOCK}, eblock.Bytes())
+	state.UnlockDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, jErr := HandleV2RawData(state, HashRequest{Hash: eblock.String()}); jErr == nil || jErr.Error() != notInScope {
+		t.Errorf("raw-data: expected the chain of the missing EBlock not to be in scope, got %v", jErr)
+	}
+}
+
+func second(_ interface{}, jErr *primitives.JSONError) *primitives.JSONError {
+	return jErr
+}